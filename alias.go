@@ -0,0 +1,60 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"sync/atomic"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// AliasWarnings makes the endpoint log a warning each time it receives
+// a request addressed to an alias of a function (see RegisterAlias), so
+// that the callers still using the old name can be found.
+func AliasWarnings() Option {
+	return optionFunc(func(d *Dispatch) { d.aliasWarnings = true })
+}
+
+// RegisterAlias registers a function under another name, typically the
+// name it had before it was renamed.
+//
+// Calls in flight keep the name of the function they were made to, so
+// suspended calls resume with requests addressed to the old name after
+// a rename. Registering the old name as an alias keeps serving them, as
+// well as calls made by callers that haven't been updated yet.
+//
+// The number of requests received for each alias is reported by
+// AliasCalls. The alias can be removed once it stops receiving
+// requests.
+func (d *Dispatch) RegisterAlias(alias string, fn AnyFunction) {
+	name, primitive := fn.Register(d)
+	d.RegisterPrimitive(alias, d.alias(alias, name, primitive))
+}
+
+// AliasCalls returns the number of requests received for each alias
+// registered with RegisterAlias.
+func (d *Dispatch) AliasCalls() map[string]int64 {
+	calls := make(map[string]int64)
+	d.aliases.Range(func(alias, count any) bool {
+		calls[alias.(string)] = count.(*atomic.Int64).Load()
+		return true
+	})
+	return calls
+}
+
+func (d *Dispatch) alias(alias, name string, fn dispatchproto.Function) dispatchproto.Function {
+	count, _ := d.aliases.LoadOrStore(alias, new(atomic.Int64))
+	return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		count.(*atomic.Int64).Add(1)
+		if d.aliasWarnings {
+			d.log().WarnContext(ctx, "Dispatch function called through an alias", "alias", alias, "function", name, "dispatch_id", req.DispatchID())
+		}
+		// Functions only accept requests addressed to their name.
+		r := proto.Clone(requestProto(req)).(*sdkv1.RunRequest)
+		r.Function = name
+		return fn(ctx, newProtoRequest(r))
+	}
+}
@@ -0,0 +1,104 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Chunk is a chunk of a large object, such as a file.
+type Chunk struct {
+	// Source identifies the object, e.g. a file path or URL.
+	Source string
+
+	// Index is the index of the chunk within the object.
+	Index int
+
+	// Offset is the offset of the chunk within the object.
+	Offset int64
+
+	// Length is the length of the chunk.
+	Length int64
+}
+
+// Read reads the chunk from r.
+func (c Chunk) Read(r io.ReaderAt) ([]byte, error) {
+	b := make([]byte, c.Length)
+	n, err := r.ReadAt(b, c.Offset)
+	if err == io.EOF && int64(n) == c.Length {
+		err = nil
+	}
+	return b[:n], err
+}
+
+func (c Chunk) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonChunk(c))
+}
+
+func (c *Chunk) UnmarshalJSON(b []byte) error {
+	var jc jsonChunk
+	if err := json.Unmarshal(b, &jc); err != nil {
+		return err
+	}
+	*c = Chunk(jc)
+	return nil
+}
+
+type jsonChunk struct {
+	Source string `json:"source,omitempty"`
+	Index  int    `json:"index"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// Chunks splits an object of the specified size into chunks of at most
+// chunkSize bytes.
+func Chunks(source string, size, chunkSize int64) []Chunk {
+	if chunkSize <= 0 {
+		panic("chunk size must be positive")
+	}
+	chunks := make([]Chunk, 0, (size+chunkSize-1)/chunkSize)
+	for offset := int64(0); offset < size; offset += chunkSize {
+		chunks = append(chunks, Chunk{
+			Source: source,
+			Index:  len(chunks),
+			Offset: offset,
+			Length: min(chunkSize, size-offset),
+		})
+	}
+	return chunks
+}
+
+// ProcessChunks processes an object of the specified size by calling
+// a function for each chunk of at most chunkSize bytes.
+//
+// Up to parallelism chunks are processed concurrently. Chunks are
+// processed in order, in batches, and the progress (the offset of the
+// next chunk) is recorded in the coroutine state each time a batch
+// completes. If the function is interrupted (e.g. if the process
+// crashes), it resumes from the last completed batch rather than from
+// the start of the object.
+//
+// The outputs of each call are returned in chunk order.
+//
+// ProcessChunks should only be called within a Dispatch Function
+// (created via Func).
+func ProcessChunks[O any](fn *Function[Chunk, O], source string, size, chunkSize int64, parallelism int) ([]O, error) {
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	chunks := Chunks(source, size, chunkSize)
+	outputs := make([]O, 0, len(chunks))
+	for len(outputs) < len(chunks) {
+		batch := chunks[len(outputs):min(len(outputs)+parallelism, len(chunks))]
+		results, err := fn.Gather(batch)
+		if err != nil {
+			return outputs, fmt.Errorf("chunk %d at offset %d: %w", batch[0].Index, batch[0].Offset, err)
+		}
+		outputs = append(outputs, results...)
+	}
+	return outputs, nil
+}
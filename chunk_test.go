@@ -0,0 +1,118 @@
+package dispatch_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestChunks(t *testing.T) {
+	chunks := dispatch.Chunks("file", 10, 4)
+	want := []dispatch.Chunk{
+		{Source: "file", Index: 0, Offset: 0, Length: 4},
+		{Source: "file", Index: 1, Offset: 4, Length: 4},
+		{Source: "file", Index: 2, Offset: 8, Length: 2},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("unexpected chunks: got %v, want %v", chunks, want)
+	}
+
+	r := strings.NewReader("0123456789")
+	for i, want := range []string{"0123", "4567", "89"} {
+		b, err := chunks[i].Read(r)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(b) != want {
+			t.Errorf("unexpected chunk %d: got %q, want %q", i, b, want)
+		}
+	}
+
+	if chunks := dispatch.Chunks("file", 0, 4); len(chunks) != 0 {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+}
+
+func TestProcessChunks(t *testing.T) {
+	logMode(t)
+
+	length := dispatch.Func("length", func(ctx context.Context, c dispatch.Chunk) (int64, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	process := dispatch.Func("process", func(ctx context.Context, size int64) (int64, error) {
+		lengths, err := dispatch.ProcessChunks(length, "file", size, 3, 2)
+		if err != nil {
+			return 0, err
+		}
+		var total int64
+		for _, n := range lengths {
+			total += n
+		}
+		return total, nil
+	})
+
+	runner := dispatchtest.NewRunner(process)
+
+	// Four chunks of a 10 byte file, processed two at a time.
+	req := dispatchproto.NewRequest("process", dispatchproto.Int(10))
+	res := runner.RoundTrip(req)
+
+	var offsets []int64
+	for {
+		if res.Status() != dispatchproto.OKStatus {
+			t.Fatalf("unexpected status: %s", res.Status())
+		}
+		poll, ok := res.Poll()
+		if !ok {
+			break
+		}
+		calls := poll.Calls()
+		if len(calls) != 2 {
+			t.Fatalf("expected 2 poll calls, got %s", poll)
+		}
+		callResults := make([]dispatchproto.CallResult, len(calls))
+		for i, call := range calls {
+			var c dispatch.Chunk
+			if err := call.Input().Unmarshal(&c); err != nil {
+				t.Fatal(err)
+			}
+			offsets = append(offsets, c.Offset)
+			callResults[i] = dispatchproto.NewCallResult(
+				dispatchproto.Int(c.Length),
+				dispatchproto.CorrelationID(call.CorrelationID()))
+		}
+		pollResult := dispatchproto.NewPollResult(
+			dispatchproto.CoroutineState(poll.CoroutineState()),
+			dispatchproto.CallResults(callResults...))
+
+		req = dispatchproto.NewRequest("process", pollResult)
+		res = runner.RoundTrip(req)
+	}
+
+	if want := []int64{0, 3, 6, 9}; !reflect.DeepEqual(offsets, want) {
+		t.Errorf("unexpected chunk offsets: got %v, want %v", offsets, want)
+	}
+
+	exit, ok := res.Exit()
+	if !ok {
+		t.Fatalf("unexpected response, got %s", res)
+	}
+	if err, ok := exit.Error(); ok {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var total int64
+	output, ok := exit.Output()
+	if !ok {
+		t.Fatalf("unexpected result, got %s", exit)
+	} else if err := output.Unmarshal(&total); err != nil {
+		t.Fatal(err)
+	}
+	if total != 10 {
+		t.Errorf("unexpected total: got %d, want 10", total)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build !durable
+
+// Command dispatch is a command line tool for Dispatch programs.
+//
+// Usage:
+//
+//	dispatch build [-o output] [-coroc path] [-v] [package [-- build flags]]
+//
+// The build command compiles a main package in durable mode (see
+// dispatchbuild.Build) without modifying its sources. The package
+// defaults to the current directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/dispatchrun/dispatch-go/dispatchbuild"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "dispatch:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: dispatch build [-o output] [-coroc path] [-v] [package [-- build flags]]")
+	}
+	switch args[0] {
+	case "build":
+		return build(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func build(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	output := flags.String("o", "", "output file (defaults to the base name of the package)")
+	coroc := flags.String("coroc", "", "path of coroc (defaults to coroc in the PATH)")
+	verbose := flags.Bool("v", false, "print the output of coroc and go build")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Flags after the package and "--" are passed to go build.
+	pkg := "."
+	rest := flags.Args()
+	if len(rest) > 0 {
+		pkg, rest = rest[0], rest[1:]
+	}
+	var buildFlags []string
+	if len(rest) > 0 {
+		if rest[0] != "--" {
+			return fmt.Errorf("unexpected arguments: %q", rest)
+		}
+		buildFlags = rest[1:]
+	}
+	if *output == "" {
+		dir, err := filepath.Abs(pkg)
+		if err != nil {
+			return err
+		}
+		*output = filepath.Base(dir)
+	}
+
+	opts := []dispatchbuild.Option{
+		dispatchbuild.Coroc(*coroc),
+		dispatchbuild.BuildFlags(buildFlags...),
+	}
+	if *verbose {
+		opts = append(opts, dispatchbuild.Output(os.Stdout, os.Stderr))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return dispatchbuild.Build(ctx, pkg, *output, opts...)
+}
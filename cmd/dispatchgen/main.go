@@ -0,0 +1,67 @@
+//go:build !durable
+
+// Command dispatchgen generates the manifest of the Dispatch functions
+// of a package (see dispatchgen.Scan).
+//
+// Usage:
+//
+//	dispatchgen [-o output] [package directory]
+//
+// The manifest is written as JSON to the output file, which defaults to
+// dispatch_manifest.json in the directory of the package. The directory
+// defaults to the current directory, so the command can be run from a
+// go:generate directive:
+//
+//	//go:generate go run github.com/dispatchrun/dispatch-go/cmd/dispatchgen
+//
+// The command fails if functions of the package have the same name.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dispatchrun/dispatch-go/dispatchgen"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "dispatchgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("dispatchgen", flag.ExitOnError)
+	output := flags.String("o", "", "output file (defaults to "+dispatchgen.DefaultOutput+" in the package directory)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	dir := "."
+	switch rest := flags.Args(); len(rest) {
+	case 0:
+	case 1:
+		dir = rest[0]
+	default:
+		return fmt.Errorf("unexpected arguments: %q", rest[1:])
+	}
+	if *output == "" {
+		*output = filepath.Join(dir, dispatchgen.DefaultOutput)
+	}
+
+	manifest, err := dispatchgen.Scan(dir)
+	if err != nil {
+		return err
+	}
+	if err := manifest.Check(); err != nil {
+		return err
+	}
+	b, err := manifest.MarshalIndent()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, b, 0644)
+}
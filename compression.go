@@ -0,0 +1,86 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"sync/atomic"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+)
+
+// StateCompression compresses the state of durable coroutines that is
+// at least minSize bytes long before it's sent to Dispatch (see
+// dispatchcoro.Compress), e.g. to keep the state of functions that fan
+// out to many calls small. Compressed state is decompressed
+// transparently when coroutines resume, whether compression is enabled
+// or not.
+//
+// The sizes of states before and after compression can be queried with
+// Dispatch.StateStats.
+//
+// By default the state of coroutines isn't compressed.
+func StateCompression(minSize int) Option {
+	return optionFunc(func(d *Dispatch) {
+		d.stateCompression = true
+		d.stateMinSize = minSize
+	})
+}
+
+// StateStats are statistics about the state of the durable coroutines
+// serialized by the functions of a Dispatch endpoint.
+type StateStats struct {
+	// States is the number of states serialized.
+	States int64
+
+	// Compressed is the number of states that were compressed (see
+	// StateCompression).
+	Compressed int64
+
+	// RawSize is the total size of the states before compression, in
+	// bytes.
+	RawSize int64
+
+	// Size is the total size of the states sent to Dispatch, in bytes.
+	// The compression ratio is RawSize / Size.
+	Size int64
+}
+
+type stateStats struct {
+	states, compressed, rawSize, size atomic.Int64
+}
+
+func (s *stateStats) observe(raw, size int, compressed bool) {
+	s.states.Add(1)
+	if compressed {
+		s.compressed.Add(1)
+	}
+	s.rawSize.Add(int64(raw))
+	s.size.Add(int64(size))
+}
+
+// StateStats returns statistics about the state of the durable
+// coroutines serialized by the functions of the endpoint. They are
+// always zero in volatile mode, where the state of coroutines stays in
+// memory.
+func (d *Dispatch) StateStats() StateStats {
+	return StateStats{
+		States:     d.stateStats.states.Load(),
+		Compressed: d.stateStats.compressed.Load(),
+		RawSize:    d.stateStats.rawSize.Load(),
+		Size:       d.stateStats.size.Load(),
+	}
+}
+
+// serializeOptions returns the options used to serialize the state of
+// coroutines. It's safe to call on a nil endpoint, e.g. from functions
+// that aren't registered on one.
+func (d *Dispatch) serializeOptions() []dispatchcoro.SerializeOption {
+	if d == nil {
+		return nil
+	}
+	opts := []dispatchcoro.SerializeOption{dispatchcoro.ObserveSize(d.stateStats.observe)}
+	if d.stateCompression {
+		opts = append(opts, dispatchcoro.Compress(d.stateMinSize))
+	}
+	return opts
+}
@@ -0,0 +1,46 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/internal/calltree"
+)
+
+// MaxCallDepth limits the depth of trees of function calls, to stop
+// runaway recursive workflows. Calls that would exceed the depth fail
+// with a permanent error that reports the chain of calls, and whether
+// they're recursive.
+//
+// The depth of a call is derived from its parent (see
+// dispatchproto.Request.ParentID), which is only known if the parent
+// is in flight on the same endpoint process. Calls made by functions
+// served elsewhere count as the root of a new tree. Suspended calls
+// that are not resumed on the process within an hour are forgotten,
+// since they may be resumed elsewhere or not at all.
+func MaxCallDepth(depth int) Option {
+	return optionFunc(func(d *Dispatch) {
+		tracker := new(calltree.Tracker)
+		d.interceptors = append(d.interceptors, func(next dispatchproto.Function) dispatchproto.Function {
+			return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+				if _, ok := req.Input(); ok {
+					chain := tracker.Enter(req.DispatchID(), req.ParentID(), req.Function(), time.Now())
+					if err := calltree.Check(chain, depth); err != nil {
+						tracker.Exit(req.DispatchID())
+						return dispatchproto.NewResponseErrorf("%w: %v", ErrPermanent, err)
+					}
+				} else if _, ok := req.PollResult(); ok {
+					tracker.Enter(req.DispatchID(), req.ParentID(), req.Function(), time.Now())
+				}
+				res := next(ctx, req)
+				if _, ok := res.Exit(); ok {
+					tracker.Exit(req.DispatchID())
+				}
+				return res
+			}
+		})
+	})
+}
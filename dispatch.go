@@ -5,23 +5,33 @@ package dispatch
 import (
 	"context"
 	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	_ "unsafe"
 
 	"buf.build/gen/go/stealthrocket/dispatch-proto/connectrpc/go/dispatch/sdk/v1/sdkv1connect"
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	"connectrpc.com/connect"
-	"connectrpc.com/validate"
 	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
 	"github.com/dispatchrun/dispatch-go/internal/auth"
 	"github.com/dispatchrun/dispatch-go/internal/env"
+	"github.com/dispatchrun/dispatch-go/internal/validator"
 )
 
 // Dispatch is a Dispatch endpoint.
@@ -35,31 +45,146 @@ type Dispatch struct {
 	client    *dispatchclient.Client
 	clientErr error
 
-	path    string
-	handler http.Handler
+	path        string
+	handler     http.Handler
+	protocols   []Protocol
+	connectOpts []connect.HandlerOption
+	h2c         bool
 
-	functions dispatchproto.FunctionMap
-	mu        sync.Mutex
+	listener        net.Listener
+	unixSocket      string
+	tlsConfig       *tls.Config
+	serverConfig    func(*http.Server)
+	serveMux        *http.ServeMux
+	middleware      []func(http.Handler) http.Handler
+	baseContext     func(net.Listener) context.Context
+	server          *http.Server
+	shutdownTimeout time.Duration
+
+	principals            map[string]string
+	keyIDs                map[string]string
+	extraKeys             []string
+	keysUrl               string
+	keysRefresh           time.Duration
+	keysTimeout           time.Duration
+	keyPoller             *keyPoller
+	verificationMaxAge    time.Duration
+	verificationTolerance time.Duration
+	verifier              *auth.Verifier
+
+	errorSizeLimit int
+	errorOffload   func(context.Context, string, []byte) (string, error)
+
+	payloadEncodings []string
+
+	inputLimits dispatchproto.UnmarshalOptions
+
+	outputOffloadLimit    int
+	outputOffloadEncoding string
+
+	quota *quotas
+
+	slo *sloTracker
+
+	profiler *profiler
+
+	strictValidation bool
+
+	secretResolver SecretResolver
+	secretValues   *sync.Map // function name => map[string]string
+
+	endpointResolver dispatchclient.EndpointResolver
+
+	interceptors []Interceptor
+
+	logger *slog.Logger
+
+	stateKeys [][]byte
+
+	stateStore dispatchcoro.StateStore
+
+	stateCompression bool
+	stateMinSize     int
+	stateStats       *stateStats
+
+	// The set of functions is frozen once the endpoint starts serving
+	// requests, after which functions can only be added with
+	// HotRegisterPrimitive. Lookups are lock-free.
+	functions *dispatchproto.AtomicFunctionMap
+	serving   *atomic.Bool
+
+	// addr is the address of the listener that the endpoint is served
+	// on, once it's listening (see Addr).
+	addr *atomic.Pointer[net.Addr]
+
+	// infos holds a FunctionInfo per function (see Functions).
+	infos *sync.Map
+
+	// zeroInputs holds a func() (dispatchproto.Any, error) per function,
+	// returning its zero input (see SelfTest).
+	zeroInputs *sync.Map
+
+	// closers holds an io.Closer per function, closed when the endpoint
+	// shuts down (see Shutdown).
+	closers *sync.Map
+
+	// aliases holds an *atomic.Int64 per alias, counting the requests
+	// received for it (see RegisterAlias).
+	aliases       *sync.Map
+	aliasWarnings bool
+
+	// shutdownScope identifies the endpoint in the context passed to
+	// functions, so that they can check whether it's shutting down (see
+	// dispatchcoro.ShuttingDown). It's unique to each endpoint, so that
+	// functions resumed elsewhere only see their current endpoint.
+	shutdownScope string
 }
 
 // New creates a Dispatch endpoint.
 func New(opts ...Option) (*Dispatch, error) {
 	d := &Dispatch{
-		env:       os.Environ(),
-		opts:      opts,
-		functions: map[string]dispatchproto.Function{},
+		env:             os.Environ(),
+		opts:            opts,
+		errorSizeLimit:  dispatchproto.DefaultErrorSizeLimit,
+		shutdownTimeout: 30 * time.Second,
+		functions:       new(dispatchproto.AtomicFunctionMap),
+		serving:         new(atomic.Bool),
+		addr:            new(atomic.Pointer[net.Addr]),
+		infos:           new(sync.Map),
+		zeroInputs:      new(sync.Map),
+		closers:         new(sync.Map),
+		aliases:         new(sync.Map),
+		stateStats:      new(stateStats),
+		shutdownScope:   newShutdownScope(),
+		secretValues:    new(sync.Map),
 	}
+	// Functions are registered once the endpoint is configured, so that
+	// they can resolve their secrets (see Function.WithSecrets).
+	var functions []AnyFunction
 	for _, opt := range opts {
+		if fn, ok := opt.(AnyFunction); ok {
+			functions = append(functions, fn)
+			continue
+		}
 		opt.configureDispatch(d)
 	}
 
-	// Prepare the endpoint URL.
+	// Prepare the address to serve on.
+	if d.serveAddr == "" {
+		d.serveAddr = env.Get(d.env, "DISPATCH_ENDPOINT_ADDR")
+		if d.serveAddr == "" {
+			d.serveAddr = "127.0.0.1:8000"
+		}
+	}
+
+	// Prepare the endpoint URL. When serving on an ephemeral port, it
+	// defaults to the address of the endpoint once it's served.
 	var endpointUrlFromEnv bool
 	if d.endpointUrl == "" {
 		d.endpointUrl = env.Get(d.env, "DISPATCH_ENDPOINT_URL")
 		endpointUrlFromEnv = true
 	}
-	if d.endpointUrl == "" {
+	if d.endpointUrl == "" && !d.ephemeral() {
 		return nil, fmt.Errorf("Dispatch endpoint URL has not been set. Use EndpointUrl(..), or set the DISPATCH_ENDPOINT_URL environment variable")
 	}
 	_, err := url.Parse(d.endpointUrl)
@@ -70,13 +195,40 @@ func New(opts ...Option) (*Dispatch, error) {
 		return nil, fmt.Errorf("invalid endpoint URL provided via EndpointUrl(..): %v", d.endpointUrl)
 	}
 
-	// Prepare the address to serve on.
-	if d.serveAddr == "" {
-		d.serveAddr = env.Get(d.env, "DISPATCH_ENDPOINT_ADDR")
-		if d.serveAddr == "" {
-			d.serveAddr = "127.0.0.1:8000"
+	for _, name := range d.payloadEncodings {
+		if _, ok := dispatchproto.LookupEncoding(name); !ok && name != dispatchproto.Identity {
+			return nil, fmt.Errorf("encoding %q provided via PayloadEncodings(..) is not registered", name)
 		}
 	}
+	d.inputLimits.Encodings = append([]string{}, d.payloadEncodings...)
+
+	if name := d.outputOffloadEncoding; name != "" {
+		if _, ok := dispatchproto.LookupEncoding(name); !ok {
+			return nil, fmt.Errorf("encoding %q provided via OutputOffload(..) is not registered", name)
+		}
+	}
+
+	if d.errorSizeLimit <= 0 {
+		return nil, fmt.Errorf("invalid limit provided via ErrorSizeLimit(..): %d", d.errorSizeLimit)
+	}
+
+	if d.stateMinSize < 0 {
+		return nil, fmt.Errorf("invalid minimum size provided via StateCompression(..): %d", d.stateMinSize)
+	}
+
+	// State is stored after it's encrypted, and loaded before it's
+	// decrypted.
+	if d.stateStore != nil {
+		d.interceptors = append(d.interceptors, stateStorage{d.stateStore, d.log()}.interceptor)
+	}
+
+	if d.stateKeys != nil {
+		cipher, err := newStateCipher(d.stateKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key provided via StateEncryption(..): %v", err)
+		}
+		d.interceptors = append(d.interceptors, cipher.interceptor)
+	}
 
 	// Prepare the verification key.
 	var verificationKeyFromEnv bool
@@ -97,26 +249,113 @@ func New(opts ...Option) (*Dispatch, error) {
 	}
 
 	// Setup the gRPC handler.
-	validator, err := validate.NewInterceptor()
+	validators, err := validator.Interceptors()
 	if err != nil {
 		return nil, err
 	}
-	d.path, d.handler = sdkv1connect.NewFunctionServiceHandler(dispatchHandler{d}, connect.WithInterceptors(validator))
+	handlerOpts := append(slices.Clip(d.connectOpts), connect.WithInterceptors(validators...))
+	d.path, d.handler = sdkv1connect.NewFunctionServiceHandler(dispatchHandler{d}, handlerOpts...)
+	if len(d.protocols) > 0 {
+		if err := d.checkProtocols(); err != nil {
+			return nil, err
+		}
+		d.handler = protocolHandler(d.protocols, d.handler)
+	}
+
+	// Prepare the verification keys, with IDs and of principals.
+	var verificationKeys []auth.VerificationKey
+	if verificationKey != nil {
+		verificationKeys = append(verificationKeys, auth.VerificationKey{Key: verificationKey})
+	}
+	for _, encodedKey := range d.extraKeys {
+		key, err := auth.ParsePublicKey(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key provided via VerificationKeys(..): %v", encodedKey)
+		}
+		verificationKeys = append(verificationKeys, auth.VerificationKey{Key: key})
+	}
+	for _, keyID := range sortedKeys(d.keyIDs) {
+		if keyID == "" {
+			return nil, fmt.Errorf("invalid key ID provided via VerificationKeyID(..): the key ID is empty")
+		}
+		key, err := auth.ParsePublicKey(d.keyIDs[keyID])
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key for key ID %q provided via VerificationKeyID(..): %v", keyID, d.keyIDs[keyID])
+		}
+		verificationKeys = append(verificationKeys, auth.VerificationKey{KeyID: keyID, Key: key})
+	}
+	for _, principal := range sortedKeys(d.principals) {
+		if principal == "" {
+			return nil, fmt.Errorf("invalid principal provided via VerificationPrincipal(..): the name is empty")
+		}
+		key, err := auth.ParsePublicKey(d.principals[principal])
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key for principal %q provided via VerificationPrincipal(..): %v", principal, d.principals[principal])
+		}
+		verificationKeys = append(verificationKeys, auth.VerificationKey{Principal: principal, Key: key})
+	}
+
+	// The verification keys published at a URL are fetched when the
+	// endpoint starts serving (see VerificationKeysURL).
+	if d.keysUrl == "" {
+		d.keysUrl = env.Get(d.env, "DISPATCH_VERIFICATION_KEYS_URL")
+	}
 
 	// Setup request signature validation.
-	if verificationKey == nil {
+	if len(verificationKeys) == 0 && d.keysUrl == "" {
 		if !strings.HasPrefix(d.endpointUrl, "bridge://") {
 			// Don't print this warning when running under the CLI.
-			slog.Warn("Dispatch request signature validation is disabled")
+			d.log().Warn("Dispatch request signature validation is disabled")
 		}
 	} else {
-		verifier := auth.NewVerifier(verificationKey)
-		d.handler = verifier.Middleware(d.handler)
+		var verifierOpts []auth.VerifierOption
+		if d.verificationMaxAge > 0 {
+			verifierOpts = append(verifierOpts, auth.MaxAge(d.verificationMaxAge))
+		}
+		if d.verificationTolerance > 0 {
+			verifierOpts = append(verifierOpts, auth.Tolerance(d.verificationTolerance))
+		}
+		if d.logger != nil {
+			verifierOpts = append(verifierOpts, auth.Logger(d.logger))
+		}
+		d.verifier = auth.NewKeysVerifier(verificationKeys, verifierOpts...)
+		d.handler = d.verifier.Middleware(d.handler)
+		if d.keysUrl != "" {
+			d.keyPoller = d.newKeyPoller(verificationKeys)
+			d.closers.Store(keyPollerKey{}, d.keyPoller)
+			d.handler = d.keysMiddleware(d.handler)
+		}
 	}
 
 	// Optionally attach a client.
 	if d.client == nil {
-		d.client, d.clientErr = dispatchclient.New(dispatchclient.Env(d.env...))
+		clientOpts := []dispatchclient.Option{dispatchclient.Env(d.env...)}
+		if d.logger != nil {
+			clientOpts = append(clientOpts, dispatchclient.Logger(d.logger))
+		}
+		d.client, d.clientErr = dispatchclient.New(clientOpts...)
+	}
+
+	for _, fn := range functions {
+		if err := d.registerSecrets(fn); err != nil {
+			return nil, err
+		}
+		d.register(fn)
+	}
+
+	// Prepare the HTTP server used by ListenAndServe.
+	mux := d.serveMux
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	mux.Handle(d.Handler())
+	handler := d.wrapMiddleware(mux)
+	if d.h2c {
+		handler = h2cHandler(handler)
+	}
+	d.server = &http.Server{Addr: d.serveAddr, Handler: handler, BaseContext: d.baseContext, TLSConfig: d.tlsConfig}
+	if d.serverConfig != nil {
+		d.serverConfig(d.server)
 	}
 
 	return d, nil
@@ -153,6 +392,41 @@ func VerificationKey(verificationKey string) Option {
 	return optionFunc(func(d *Dispatch) { d.verificationKey = verificationKey })
 }
 
+// VerificationKeyID adds a verification key that requests signed with
+// the key ID are verified with. The option can be repeated to add
+// multiple keys, e.g. to rotate keys without downtime: the endpoint
+// accepts requests signed with either the old or the new key, until the
+// old key is removed.
+//
+// The key should be a PEM or base64-encoded ed25519 public key. Requests
+// signed by Dispatch carry the key ID "default", and are verified with
+// the key set with VerificationKey.
+func VerificationKeyID(keyID, verificationKey string) Option {
+	return optionFunc(func(d *Dispatch) {
+		if d.keyIDs == nil {
+			d.keyIDs = map[string]string{}
+		}
+		d.keyIDs[keyID] = verificationKey
+	})
+}
+
+// VerificationMaxAge sets the maximum age of request signatures.
+// Requests with older signatures are rejected.
+//
+// It defaults to 5 minutes.
+func VerificationMaxAge(maxAge time.Duration) Option {
+	return optionFunc(func(d *Dispatch) { d.verificationMaxAge = maxAge })
+}
+
+// VerificationTolerance sets the clock skew that is tolerated when
+// checking the creation time of request signatures, i.e. how far in
+// the future a signature can appear to have been created.
+//
+// It defaults to 5 seconds.
+func VerificationTolerance(tolerance time.Duration) Option {
+	return optionFunc(func(d *Dispatch) { d.verificationTolerance = tolerance })
+}
+
 // ServeAddress sets the address that the Dispatch endpoint
 // is served on (see Dispatch.Serve).
 //
@@ -162,10 +436,93 @@ func VerificationKey(verificationKey string) Option {
 // It defaults to the value of the DISPATCH_ENDPOINT_ADDR environment
 // variable, which is automatically set by the Dispatch CLI. If this
 // is unset, it defaults to 127.0.0.1:8000.
+//
+// If the port of the address is 0 (e.g. "127.0.0.1:0"), the endpoint
+// listens on an ephemeral port, bound when the endpoint is served so
+// that parallel tests don't collide. The bound address is available
+// via Dispatch.Addr once serving has started, and the endpoint URL
+// defaults to it.
 func ServeAddress(addr string) Option {
 	return optionFunc(func(d *Dispatch) { d.serveAddr = addr })
 }
 
+// Listener sets the listener that the Dispatch endpoint is served on by
+// ListenAndServe, instead of listening on the ServeAddress, e.g. a
+// listener inherited through systemd socket activation (see
+// net.FileListener). The listener is closed when the endpoint shuts
+// down.
+func Listener(listener net.Listener) Option {
+	return optionFunc(func(d *Dispatch) { d.listener = listener })
+}
+
+// ServeUnix sets the path of a Unix domain socket that the Dispatch
+// endpoint is served on by ListenAndServe, instead of the ServeAddress,
+// e.g. to run the endpoint behind a local reverse proxy. The socket is
+// removed when the endpoint shuts down.
+func ServeUnix(path string) Option {
+	return optionFunc(func(d *Dispatch) { d.unixSocket = path })
+}
+
+// TLSConfig sets the TLS configuration of the HTTP server used by
+// ListenAndServe, which then serves the endpoint over TLS. The
+// configuration must provide a certificate (see tls.Config.Certificates
+// and tls.Config.GetCertificate). Setting tls.Config.ClientAuth enables
+// mutual TLS.
+//
+// By default the endpoint is served over plain HTTP.
+func TLSConfig(config *tls.Config) Option {
+	return optionFunc(func(d *Dispatch) { d.tlsConfig = config })
+}
+
+// ServerConfig sets a function that configures the HTTP server used by
+// ListenAndServe, e.g. to set timeouts (such as IdleTimeout), limits
+// (such as MaxHeaderBytes), or HTTP/2 settings (with
+// golang.org/x/net/http2.ConfigureServer).
+//
+// The function is called once, when the endpoint is created. The
+// address and handler of the server are set before it's called.
+func ServerConfig(configure func(*http.Server)) Option {
+	return optionFunc(func(d *Dispatch) { d.serverConfig = configure })
+}
+
+// ServeMux sets the HTTP request multiplexer used by ListenAndServe, so
+// that the endpoint can be served alongside other routes of the
+// application (e.g. health checks) by the same server. The handler of
+// the endpoint is registered on the mux when the endpoint is created.
+//
+// By default the server only serves the endpoint.
+func ServeMux(mux *http.ServeMux) Option {
+	return optionFunc(func(d *Dispatch) { d.serveMux = mux })
+}
+
+// ServeMiddleware adds middleware that wraps the handler of the HTTP
+// server used by ListenAndServe, e.g. to log or trace requests. The
+// middleware wraps the mux (see ServeMux), so it applies to all routes.
+// It also wraps the handler returned by Dispatch.Mux. The first
+// middleware is the outermost.
+func ServeMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return optionFunc(func(d *Dispatch) { d.middleware = append(d.middleware, middleware...) })
+}
+
+// BaseContext sets a function that returns the base context of the
+// requests served by ListenAndServe (see http.Server.BaseContext), so
+// that functions inherit application-wide context values, e.g. loggers
+// or database handles.
+//
+// When it isn't set, ServeContext uses the context it's called with,
+// without its cancellation, as the base context.
+func BaseContext(baseContext func(net.Listener) context.Context) Option {
+	return optionFunc(func(d *Dispatch) { d.baseContext = baseContext })
+}
+
+// ShutdownTimeout sets how long ServeContext waits for in-flight
+// requests to complete when shutting down the endpoint.
+//
+// It defaults to 30 seconds.
+func ShutdownTimeout(timeout time.Duration) Option {
+	return optionFunc(func(d *Dispatch) { d.shutdownTimeout = timeout })
+}
+
 // Env sets the environment variables that a Dispatch endpoint
 // parses its default configuration from.
 //
@@ -186,28 +543,221 @@ func Client(client *dispatchclient.Client) Option {
 	return optionFunc(func(d *Dispatch) { d.client = client })
 }
 
+// ResolveEndpoints sets a resolver for the endpoint URL of calls to the
+// functions registered on the endpoint, e.g. to route calls to shards
+// of a multi-endpoint deployment.
+//
+// The resolver is called when building calls (see Function.BuildCall),
+// with calls that carry the URL of this endpoint by default.
+func ResolveEndpoints(resolver dispatchclient.EndpointResolver) Option {
+	return optionFunc(func(d *Dispatch) { d.endpointResolver = resolver })
+}
+
+// ErrorSizeLimit sets the maximum size, in bytes, of the message,
+// traceback and value of errors returned by functions.
+//
+// Oversized errors are truncated before they're sent to Dispatch,
+// so that large stack traces don't balloon responses. The limit must
+// be positive, and defaults to dispatchproto.DefaultErrorSizeLimit.
+func ErrorSizeLimit(limit int) Option {
+	return optionFunc(func(d *Dispatch) { d.errorSizeLimit = limit })
+}
+
+// ErrorOffload sets a function that is called with the full contents
+// of each field ("message", "traceback" or "value") of an error that
+// exceeds the size limit (see ErrorSizeLimit), before it's truncated.
+//
+// The function can store the contents elsewhere (e.g. in a blob store),
+// and return a reference to the contents that is included in the
+// truncated error.
+func ErrorOffload(offload func(ctx context.Context, field string, data []byte) (string, error)) Option {
+	return optionFunc(func(d *Dispatch) { d.errorOffload = offload })
+}
+
+// PayloadEncodings sets encodings (e.g. "gzip") that are applied to the
+// inputs of calls to the functions registered on the endpoint, and to
+// their outputs. Encodings are applied in order, e.g. to compress and then
+// encrypt values (see dispatchproto.Encoding).
+//
+// Inputs are decoded transparently, as long as the encodings are
+// registered with dispatchproto.RegisterEncoding. Only the encodings set
+// here are decoded: requests with inputs encoded otherwise fail with
+// ErrInvalidArgument, so that the endpoint doesn't decompress or decrypt
+// payloads it didn't opt into. By default, inputs must not be encoded.
+func PayloadEncodings(encodings ...string) Option {
+	return optionFunc(func(d *Dispatch) { d.payloadEncodings = encodings })
+}
+
+// InputLimits sets the maximum depth of the lists and objects nested in
+// the inputs of requests, and the maximum number of elements of the
+// inputs (see dispatchproto.UnmarshalOptions). Requests with inputs
+// that exceed the limits fail with ErrInvalidArgument before the
+// function runs, to guard the endpoint against payloads that would
+// consume unbounded memory and CPU.
+//
+// The limits default to dispatchproto.DefaultMaxDepth and
+// dispatchproto.DefaultMaxElements. Negative values disable them.
+func InputLimits(maxDepth, maxElements int) Option {
+	return optionFunc(func(d *Dispatch) {
+		d.inputLimits.MaxDepth = maxDepth
+		d.inputLimits.MaxElements = maxElements
+	})
+}
+
+// OutputOffload offloads the outputs of the functions registered on the
+// endpoint that are larger than limit bytes once serialized, e.g. to
+// keep the outputs of data-heavy functions under the payload size limit
+// of Dispatch. Large outputs are encoded with the encoding, which must
+// be registered with dispatchproto.RegisterEncoding and is typically a
+// dispatchproto.Offload encoding that stores them in chunks in a blob
+// store.
+//
+// Outputs are reassembled transparently when they're unmarshaled (e.g.
+// by Await or Gather), by processes that register the encoding too.
+//
+// By default outputs aren't offloaded.
+func OutputOffload(limit int, encoding string) Option {
+	return optionFunc(func(d *Dispatch) {
+		d.outputOffloadLimit = limit
+		d.outputOffloadEncoding = encoding
+	})
+}
+
 // Register registers a function.
+//
+// Functions must be registered before the endpoint starts serving
+// requests. Register panics if it's called after that; use HotRegister
+// to add functions to an endpoint that is serving requests.
+//
+// Register also panics if the secrets bound to the function cannot be
+// resolved (see Function.WithSecrets).
 func (d *Dispatch) Register(fn AnyFunction) {
-	d.RegisterPrimitive(fn.Register(d))
+	if err := d.registerSecrets(fn); err != nil {
+		panic(fmt.Sprintf("dispatch: %v", err))
+	}
+	d.register(fn)
+}
+
+func (d *Dispatch) register(fn AnyFunction) {
+	name, primitive := fn.Register(d)
+	d.checkName(name, fn)
+	d.RegisterPrimitive(name, primitive)
+	d.registerInfo(name, fn)
+	d.registerZeroInput(name, fn)
+	d.registerCloser(name, fn)
+	if comparator := shadowComparator(fn); comparator != nil {
+		d.Register(comparator)
+	}
 }
 
 // RegisterPrimitive registers a primitive function.
+//
+// Functions must be registered before the endpoint starts serving
+// requests. RegisterPrimitive panics if it's called after that; use
+// HotRegisterPrimitive to add functions to an endpoint that is serving
+// requests.
 func (d *Dispatch) RegisterPrimitive(name string, fn dispatchproto.Function) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+	if d.serving.Load() {
+		panic(fmt.Sprintf("dispatch: cannot register function %q after the endpoint started serving requests (use HotRegister instead)", name))
+	}
+	d.functions.Add(name, intercept(fn, d.interceptors))
+	d.registerInfo(name, nil)
+	d.zeroInputs.Delete(name)
+	d.closers.Delete(name)
+}
+
+// HotRegister registers a function on an endpoint that may already be
+// serving requests.
+//
+// Requests that are in flight continue to see the functions that were
+// registered when they started. Requests received after HotRegister
+// returns see the new function.
+//
+// HotRegister panics if the secrets bound to the function cannot be
+// resolved (see Function.WithSecrets).
+func (d *Dispatch) HotRegister(fn AnyFunction) {
+	if err := d.registerSecrets(fn); err != nil {
+		panic(fmt.Sprintf("dispatch: %v", err))
+	}
+	name, primitive := fn.Register(d)
+	d.checkName(name, fn)
+	d.HotRegisterPrimitive(name, primitive)
+	d.registerInfo(name, fn)
+	d.registerZeroInput(name, fn)
+	d.registerCloser(name, fn)
+	if comparator := shadowComparator(fn); comparator != nil {
+		d.HotRegister(comparator)
+	}
+}
 
-	d.functions[name] = fn
+// HotRegisterPrimitive registers a primitive function on an endpoint
+// that may already be serving requests.
+//
+// See HotRegister for details.
+func (d *Dispatch) HotRegisterPrimitive(name string, fn dispatchproto.Function) {
+	d.functions.Add(name, intercept(fn, d.interceptors))
+	d.registerInfo(name, nil)
+	d.zeroInputs.Delete(name)
+	d.closers.Delete(name)
+}
+
+func (d *Dispatch) registerZeroInput(name string, fn AnyFunction) {
+	if f, ok := fn.(interface {
+		zeroInput() (dispatchproto.Any, error)
+	}); ok {
+		d.zeroInputs.Store(name, f.zeroInput)
+	}
+}
+
+func (d *Dispatch) registerCloser(name string, fn AnyFunction) {
+	if c, ok := fn.(io.Closer); ok {
+		d.closers.Store(name, c)
+	}
+}
+
+func shadowComparator(fn AnyFunction) AnyFunction {
+	if f, ok := fn.(interface{ shadowComparator() AnyFunction }); ok {
+		return f.shadowComparator()
+	}
+	return nil
+}
+
+// serve returns the functions to serve a request with, and freezes
+// the set of functions registered with Register and RegisterPrimitive.
+func (d *Dispatch) serve() dispatchproto.FunctionMap {
+	d.serving.Store(true)
+	return d.functions.Load()
 }
 
 // URL is the URL of the Dispatch endpoint.
+//
+// When the endpoint is served on an ephemeral port and the URL is not
+// set, it is empty until serving has started (see Addr).
 func (d *Dispatch) URL() string {
+	if d.endpointUrl == "" && d.ephemeral() {
+		if addr := d.Addr(); addr != nil {
+			scheme := "http"
+			if d.tlsConfig != nil {
+				scheme = "https"
+			}
+			return scheme + "://" + addr.String()
+		}
+	}
 	return d.endpointUrl
 }
 
-// Handler returns an HTTP handler for Dispatch, along with the path
-// that the handler should be registered at.
-func (d *Dispatch) Handler() (string, http.Handler) {
-	return d.path, d.handler
+// SignatureRejections returns the number of requests that were
+// rejected because their signature could not be verified, for each
+// reason. Reasons are: "unreadable_body", "missing_digest",
+// "invalid_digest", "missing_signature", "malformed_signature",
+// "expired_signature", "unknown_key" and "invalid_signature".
+//
+// It returns nil if request signature verification is disabled.
+func (d *Dispatch) SignatureRejections() map[string]int64 {
+	if d.verifier == nil {
+		return nil
+	}
+	return d.verifier.Rejections()
 }
 
 // Client returns the Client attached to this endpoint.
@@ -216,14 +766,142 @@ func (d *Dispatch) Client() (*dispatchclient.Client, error) {
 }
 
 // ListenAndServe serves the Dispatch endpoint.
+//
+// The endpoint is served on the ServeAddress, unless a Listener or a
+// Unix domain socket (see ServeUnix) is set, and over TLS if the HTTP
+// server has a TLS configuration (see TLSConfig). The HTTP server can be
+// configured with ServerConfig. It returns http.ErrServerClosed once
+// Shutdown is called, and an error if the verification keys published
+// at the VerificationKeysURL cannot be fetched.
 func (d *Dispatch) ListenAndServe() error {
-	mux := http.NewServeMux()
-	mux.Handle(d.Handler())
+	if d.keyPoller != nil {
+		if err := d.keyPoller.start(); err != nil {
+			return err
+		}
+	}
+	listener, err := d.listen()
+	if err != nil {
+		return err
+	}
+	d.setAddr(listener.Addr())
+	d.log().Info("serving Dispatch endpoint", "addr", listener.Addr().String())
+
+	if d.server.TLSConfig != nil {
+		return d.server.ServeTLS(listener, "", "")
+	}
+	return d.server.Serve(listener)
+}
+
+// Addr returns the address that the endpoint is served on, e.g. to
+// find the port bound when the ServeAddress has port 0. It returns nil
+// until serving has started (see ListenAndServe).
+func (d *Dispatch) Addr() net.Addr {
+	if addr := d.addr.Load(); addr != nil {
+		return *addr
+	}
+	return nil
+}
+
+func (d *Dispatch) setAddr(addr net.Addr) {
+	d.addr.Store(&addr)
+}
+
+// ephemeral is true if the endpoint is served on an ephemeral port of
+// the ServeAddress.
+func (d *Dispatch) ephemeral() bool {
+	if d.listener != nil || d.unixSocket != "" {
+		return false
+	}
+	_, port, err := net.SplitHostPort(d.serveAddr)
+	return err == nil && port == "0"
+}
+
+func (d *Dispatch) listen() (net.Listener, error) {
+	if d.listener != nil {
+		return d.listener, nil
+	}
+	if d.unixSocket != "" {
+		return net.Listen("unix", d.unixSocket)
+	}
+	addr := d.server.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ServeContext is like ListenAndServe, but gracefully shuts down the
+// endpoint when ctx is done, e.g. when used with signal.NotifyContext
+// to stop serving on Ctrl+C. It waits up to ShutdownTimeout for the
+// shutdown to complete (see Shutdown). Requests inherit the values of
+// ctx, unless BaseContext is set.
+//
+// ServeContext returns nil if the endpoint was shut down cleanly.
+func (d *Dispatch) ServeContext(ctx context.Context) error {
+	if d.server.BaseContext == nil {
+		baseContext := context.WithoutCancel(ctx)
+		d.server.BaseContext = func(net.Listener) context.Context { return baseContext }
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- d.ListenAndServe() }()
 
-	slog.Info("serving Dispatch endpoint", "addr", d.serveAddr)
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d.shutdownTimeout)
+	defer cancel()
+
+	d.log().Info("shutting down Dispatch endpoint", "addr", d.server.Addr)
+	err := d.Shutdown(shutdownCtx)
+	if serveErr := <-errs; serveErr != http.ErrServerClosed {
+		return serveErr
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the HTTP server started by
+// ListenAndServe. See http.Server.Shutdown for details.
+//
+// Shutdown waits for in-flight requests to complete, and then stops
+// the volatile coroutine instances of registered functions. Functions
+// suspended in volatile mode cannot be resumed afterwards.
+//
+// Functions that are running can check whether the endpoint is shutting
+// down with dispatchcoro.ShuttingDown, and wrap up early so that the
+// endpoint drains quickly. In durable mode, they are suspended at the
+// next dispatchcoro.Checkpoint, and resumed on another endpoint.
+func (d *Dispatch) Shutdown(ctx context.Context) error {
+	dispatchcoro.BeginShutdown(d.shutdownScope)
+	if err := d.server.Shutdown(ctx); err != nil {
+		return err
+	}
+	var errs []error
+	d.closers.Range(func(_, c any) bool {
+		if err := c.(io.Closer).Close(); err != nil {
+			errs = append(errs, err)
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
 
-	server := &http.Server{Addr: d.serveAddr, Handler: mux}
-	return server.ListenAndServe()
+func newShutdownScope() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
 
 // The gRPC handler is deliberately unexported. This forces
@@ -232,12 +910,54 @@ func (d *Dispatch) ListenAndServe() error {
 type dispatchHandler struct{ dispatch *Dispatch }
 
 func (d dispatchHandler) Run(ctx context.Context, req *connect.Request[sdkv1.RunRequest]) (*connect.Response[sdkv1.RunResponse], error) {
-	res := d.dispatch.functions.Run(ctx, newProtoRequest(req.Msg))
+	if quota := d.dispatch.quota; quota != nil {
+		tenant := quota.tenant(ctx)
+		release, ok := quota.acquire(tenant, time.Now())
+		if !ok {
+			res := dispatchproto.NewResponseErrorf("%w: tenant %q exceeded its execution quota", ErrThrottled, tenant)
+			return connect.NewResponse(responseProto(res)), nil
+		}
+		defer release()
+	}
+
+	request := newProtoRequest(req.Msg)
+	if d.dispatch.strictValidation {
+		if err := validateRequest(request, time.Now()); err != nil {
+			return connect.NewResponse(responseProto(dispatchproto.NewResponseError(err))), nil
+		}
+	}
+
+	start := time.Now()
+	d.dispatch.logRequest(ctx, request)
+	res := d.dispatch.serve().Run(ctx, request)
+	res = d.dispatch.truncateError(ctx, res)
+	d.dispatch.logResponse(ctx, request, res, time.Since(start))
 	return connect.NewResponse(responseProto(res)), nil
 }
 
+func (d *Dispatch) truncateError(ctx context.Context, res dispatchproto.Response) dispatchproto.Response {
+	err, ok := res.Error()
+	if !ok {
+		return res
+	}
+	var offload func(string, []byte) (string, error)
+	if d.errorOffload != nil {
+		offload = func(field string, data []byte) (string, error) {
+			return d.errorOffload(ctx, field, data)
+		}
+	}
+	truncated := err.TruncateFunc(d.errorSizeLimit, offload)
+	if truncated.Equal(err) {
+		return res
+	}
+	return res.With(truncated)
+}
+
 //go:linkname newProtoRequest github.com/dispatchrun/dispatch-go/dispatchproto.newProtoRequest
 func newProtoRequest(r *sdkv1.RunRequest) dispatchproto.Request
 
+//go:linkname requestProto github.com/dispatchrun/dispatch-go/dispatchproto.requestProto
+func requestProto(r dispatchproto.Request) *sdkv1.RunRequest
+
 //go:linkname responseProto github.com/dispatchrun/dispatch-go/dispatchproto.responseProto
 func responseProto(r dispatchproto.Response) *sdkv1.RunResponse
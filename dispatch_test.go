@@ -1,16 +1,39 @@
 package dispatch_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"connectrpc.com/connect"
 	"github.com/dispatchrun/dispatch-go"
 	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchhttp"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
 	"github.com/dispatchrun/dispatch-go/dispatchtest"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 func TestDispatchEndpoint(t *testing.T) {
@@ -107,6 +130,45 @@ func TestDispatchCall(t *testing.T) {
 	})
 }
 
+func TestDispatchBatch(t *testing.T) {
+	recorder := &dispatchtest.CallRecorder{}
+	server := dispatchtest.NewServer(recorder)
+
+	client, err := dispatchclient.New(dispatchclient.APIKey("foobar"), dispatchclient.APIUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint, err := dispatch.New(dispatch.EndpointUrl("http://example.com"), dispatch.Client(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := dispatch.Func("function1", func(ctx context.Context, x int) (string, error) {
+		panic("not implemented")
+	})
+	endpoint.Register(fn)
+
+	ids, err := fn.DispatchBatch(context.Background(), []int{11, 22}, dispatchproto.Expiration(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 2 {
+		t.Fatalf("unexpected dispatch IDs: %v", ids)
+	}
+
+	recorder.Assert(t, dispatchtest.DispatchRequest{
+		Header: http.Header{"Authorization": []string{"Bearer foobar"}},
+		Calls: []dispatchproto.Call{
+			dispatchproto.NewCall("http://example.com", "function1",
+				dispatchproto.Int(11),
+				dispatchproto.Expiration(10*time.Second)),
+			dispatchproto.NewCall("http://example.com", "function1",
+				dispatchproto.Int(22),
+				dispatchproto.Expiration(10*time.Second)),
+		},
+	})
+}
+
 func TestDispatchCallEnvConfig(t *testing.T) {
 	recorder := &dispatchtest.CallRecorder{}
 	server := dispatchtest.NewServer(recorder)
@@ -243,3 +305,1846 @@ func TestDispatchVerificationKey(t *testing.T) {
 		}
 	})
 }
+
+func TestDispatchSignatureRejections(t *testing.T) {
+	signingKey, verificationKey := dispatchtest.KeyPair()
+
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.VerificationKey(verificationKey),
+		dispatch.VerificationMaxAge(time.Minute),
+		dispatch.VerificationTolerance(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Identity("identity"))
+
+	signedClient, err := server.Client(dispatchtest.SigningKey(signingKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsignedClient, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := dispatchproto.NewRequest("identity", dispatchproto.Int(11))
+	if _, err := signedClient.Run(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := unsignedClient.Run(context.Background(), req); err == nil {
+			t.Fatal("expected unsigned request to be rejected")
+		}
+	}
+
+	// Unsigned requests don't carry a Content-Digest header either,
+	// which is checked first.
+	want := map[string]int64{"missing_digest": 2}
+	if got := endpoint.SignatureRejections(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected rejections: got %v, want %v", got, want)
+	}
+}
+
+func TestDispatchPrincipals(t *testing.T) {
+	sandboxSigningKey, sandboxVerificationKey := dispatchtest.KeyPair()
+	productionSigningKey, productionVerificationKey := dispatchtest.KeyPair()
+	otherSigningKey, _ := dispatchtest.KeyPair()
+
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.VerificationPrincipal("sandbox", sandboxVerificationKey),
+		dispatch.VerificationPrincipal("production", productionVerificationKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.PrimitiveFunc("principal", func(ctx context.Context, _ dispatchproto.Any) (dispatchproto.Any, error) {
+		principal, ok := dispatch.Principal(ctx)
+		if !ok {
+			return dispatchproto.Any{}, errors.New("missing principal")
+		}
+		return dispatchproto.String(principal), nil
+	}))
+
+	for _, test := range []struct {
+		signingKey string
+		principal  string
+	}{
+		{sandboxSigningKey, "sandbox"},
+		{productionSigningKey, "production"},
+	} {
+		client, err := server.Client(dispatchtest.SigningKey(test.signingKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Run(context.Background(), dispatchproto.NewRequest("principal", dispatchproto.Nil()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, ok := res.Output()
+		if !ok {
+			t.Fatalf("unexpected response: %s", res)
+		}
+		var principal string
+		if err := output.Unmarshal(&principal); err != nil {
+			t.Fatal(err)
+		} else if principal != test.principal {
+			t.Errorf("unexpected principal: got %q, want %q", principal, test.principal)
+		}
+	}
+
+	otherClient, err := server.Client(dispatchtest.SigningKey(otherSigningKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherClient.Run(context.Background(), dispatchproto.NewRequest("principal", dispatchproto.Nil())); err == nil {
+		t.Fatal("expected request signed with an unknown key to be rejected")
+	}
+}
+
+func TestDispatchVerificationKeyID(t *testing.T) {
+	oldSigningKey, oldVerificationKey := dispatchtest.KeyPair()
+	newSigningKey, newVerificationKey := dispatchtest.KeyPair()
+
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.VerificationKey(oldVerificationKey),
+		dispatch.VerificationKeyID("key-2", newVerificationKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Identity("identity"))
+
+	for _, test := range []struct {
+		opts   []dispatchserver.EndpointClientOption
+		reject bool
+	}{
+		{opts: []dispatchserver.EndpointClientOption{dispatchtest.SigningKey(oldSigningKey)}},
+		{opts: []dispatchserver.EndpointClientOption{dispatchtest.SigningKey(newSigningKey), dispatchserver.SigningKeyID("key-2")}},
+		{opts: []dispatchserver.EndpointClientOption{dispatchtest.SigningKey(newSigningKey)}, reject: true},
+		{opts: []dispatchserver.EndpointClientOption{dispatchtest.SigningKey(oldSigningKey), dispatchserver.SigningKeyID("key-2")}, reject: true},
+	} {
+		client, err := server.Client(test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+		if test.reject && err == nil {
+			t.Error("expected request to be rejected")
+		} else if !test.reject && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	want := map[string]int64{"invalid_signature": 2}
+	if got := endpoint.SignatureRejections(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected rejections: got %v, want %v", got, want)
+	}
+}
+
+func TestDispatchVerificationKeys(t *testing.T) {
+	oldSigningKey, oldVerificationKey := dispatchtest.KeyPair()
+	newSigningKey, newVerificationKey := dispatchtest.KeyPair()
+	otherSigningKey, _ := dispatchtest.KeyPair()
+
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.VerificationKey(oldVerificationKey),
+		dispatch.VerificationKeys(newVerificationKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Identity("identity"))
+
+	for signingKey, accepted := range map[string]bool{oldSigningKey: true, newSigningKey: true, otherSigningKey: false} {
+		client, err := server.Client(dispatchtest.SigningKey(signingKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+		if accepted && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if !accepted && err == nil {
+			t.Error("expected request signed with an unknown key to be rejected")
+		}
+	}
+}
+
+func TestDispatchVerificationKeysURL(t *testing.T) {
+	oldSigningKey, oldVerificationKey := dispatchtest.KeyPair()
+	newSigningKey, newVerificationKey := dispatchtest.KeyPair()
+
+	jwk := func(keyID, verificationKey string) string {
+		key, err := base64.StdEncoding.DecodeString(verificationKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf(`{"kty":"OKP","crv":"Ed25519","kid":%q,"x":%q}`, keyID, base64.RawURLEncoding.EncodeToString(key))
+	}
+	var jwks atomic.Value
+	jwks.Store(`{"keys":[` + jwk("key-1", oldVerificationKey) + `]}`)
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, jwks.Load().(string))
+	}))
+	defer keyServer.Close()
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.VerificationKeysURL(keyServer.URL, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	defer endpoint.Shutdown(context.Background())
+
+	endpoint.Register(dispatch.Identity("identity"))
+
+	run := func(signingKey, keyID string) error {
+		client, err := server.Client(dispatchtest.SigningKey(signingKey), dispatchserver.SigningKeyID(keyID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+		return err
+	}
+	if err := run(oldSigningKey, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(newSigningKey, "key-2"); err == nil {
+		t.Fatal("expected request signed with an unpublished key to be rejected")
+	}
+
+	// Rotate the keys.
+	jwks.Store(`{"keys":[` + jwk("key-2", newVerificationKey) + `]}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for run(newSigningKey, "key-2") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the new key was not fetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := run(oldSigningKey, "key-1"); err == nil {
+		t.Error("expected request signed with a revoked key to be rejected")
+	}
+
+	// The keys are only fetched once the endpoint is served, which fails
+	// if they can't be fetched.
+	var fetches atomic.Int64
+	missingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer missingServer.Close()
+
+	missing, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ServeAddress("127.0.0.1:0"),
+		dispatch.VerificationKeysURL(missingServer.URL, 0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches.Load(); n != 0 {
+		t.Errorf("keys were fetched %d times before serving", n)
+	}
+	if err := missing.ListenAndServe(); err == nil || err == http.ErrServerClosed {
+		t.Errorf("expected an error when the keys can't be fetched, got %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("keys were fetched %d times when serving", n)
+	}
+
+	// Fetching the keys is bounded by the timeout.
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slowServer.Close()
+
+	slow, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ServeAddress("127.0.0.1:0"),
+		dispatch.VerificationKeysURL(slowServer.URL, 0),
+		dispatch.VerificationKeysTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := slow.ListenAndServe(); err == nil || err == http.ErrServerClosed {
+		t.Errorf("expected an error when the keys can't be fetched in time, got %v", err)
+	} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetching the keys took %v", elapsed)
+	}
+}
+
+func TestDispatchServerConfig(t *testing.T) {
+	var configured *http.Server
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ServeAddress("127.0.0.1:0"),
+		dispatch.ServerConfig(func(server *http.Server) {
+			server.IdleTimeout = time.Minute
+			configured = server
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured == nil {
+		t.Fatal("server was not configured")
+	} else if configured.Addr != "127.0.0.1:0" || configured.Handler == nil {
+		t.Errorf("unexpected server: addr %q, handler %v", configured.Addr, configured.Handler)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- endpoint.ListenAndServe() }()
+
+	if err := endpoint.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDispatchServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dispatch.sock")
+	endpoint, err := dispatch.New(dispatch.EndpointUrl("http://example.com"), dispatch.ServeUnix(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Register(dispatch.Identity("identity"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- endpoint.ServeContext(ctx) }()
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	client, err := dispatchserver.NewEndpointClient("http://unix", dispatchserver.HTTPClient(httpClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := dispatchproto.NewRequest("identity", dispatchproto.Int(11))
+	deadline := time.Now().Add(5 * time.Second)
+	res, err := client.Run(context.Background(), req)
+	for err != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		res, err = client.Run(context.Background(), req)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 11)
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket was not removed: %v", err)
+	}
+}
+
+func TestDispatchEphemeralPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var endpoints []*dispatch.Dispatch
+	var errs []chan error
+	for range 2 {
+		endpoint, err := dispatch.New(dispatch.ServeAddress("127.0.0.1:0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint.Register(dispatch.Identity("identity"))
+
+		// The port is only bound once the endpoint is served.
+		if addr := endpoint.Addr(); addr != nil {
+			t.Errorf("unexpected address before serving: %v", addr)
+		}
+		if url := endpoint.URL(); url != "" {
+			t.Errorf("unexpected endpoint URL before serving: %q", url)
+		}
+
+		served := make(chan error, 1)
+		go func() { served <- endpoint.ServeContext(ctx) }()
+		endpoints = append(endpoints, endpoint)
+		errs = append(errs, served)
+	}
+
+	// Each endpoint binds its own port, and its URL defaults to it.
+	deadline := time.Now().Add(5 * time.Second)
+	for (endpoints[0].Addr() == nil || endpoints[1].Addr() == nil) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	addr := endpoints[0].Addr()
+	if addr == nil {
+		t.Fatal("endpoint has no address")
+	}
+	if other := endpoints[1].Addr(); other == nil || other.String() == addr.String() {
+		t.Fatalf("unexpected address of other endpoint: %v", other)
+	}
+	if got, want := endpoints[0].URL(), "http://"+addr.String(); got != want {
+		t.Errorf("unexpected endpoint URL: got %q, want %q", got, want)
+	}
+
+	client, err := dispatchserver.NewEndpointClient(endpoints[0].URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 11)
+
+	cancel()
+	for _, served := range errs {
+		if err := <-served; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Endpoints on a fixed port only have an address once listening.
+	endpoint, err := dispatch.New(dispatch.EndpointUrl("http://example.com"), dispatch.ServeAddress("127.0.0.1:8000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := endpoint.Addr(); addr != nil {
+		t.Errorf("unexpected address: %v", addr)
+	}
+}
+
+func TestDispatchListenerTLS(t *testing.T) {
+	// Borrow the certificate of a test server, and its client which
+	// trusts it.
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	defer certServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.Listener(listener),
+		dispatch.TLSConfig(&tls.Config{Certificates: certServer.TLS.Certificates}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Register(dispatch.Identity("identity"))
+
+	errs := make(chan error, 1)
+	go func() { errs <- endpoint.ListenAndServe() }()
+
+	client, err := dispatchserver.NewEndpointClient("https://"+listener.Addr().String(), dispatchserver.HTTPClient(certServer.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 11)
+
+	if err := endpoint.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDispatchProtocols(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.Listener(listener),
+		dispatch.H2C(),
+		dispatch.Protocols(dispatch.ProtocolGRPC),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Register(dispatch.Identity("identity"))
+
+	errs := make(chan error, 1)
+	go func() { errs <- endpoint.ListenAndServe() }()
+
+	// gRPC over cleartext HTTP/2.
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	grpcClient, err := dispatchserver.NewEndpointClient("http://"+listener.Addr().String(),
+		dispatchserver.HTTPClient(h2cClient),
+		dispatchserver.ClientOptions(connect.WithGRPC()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := grpcClient.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 11)
+
+	// The Connect protocol isn't served.
+	connectClient, err := dispatchserver.NewEndpointClient("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connectClient.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11))); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if err := endpoint.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := dispatch.New(dispatch.EndpointUrl("http://example.com"), dispatch.Protocols("http3")); err == nil || err.Error() != `invalid protocol provided via Protocols(..): "http3"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDispatchMux(t *testing.T) {
+	var calls []string
+	middleware := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ServeMiddleware(middleware("request-id"), middleware("rate-limit")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.Register(dispatch.Identity("identity"))
+
+	server := httptest.NewServer(endpoint.Mux())
+	defer server.Close()
+
+	client, err := dispatchserver.NewEndpointClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(11)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 11)
+	if want := []string{"request-id", "rate-limit"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("unexpected middleware calls: got %v, want %v", calls, want)
+	}
+
+	health := func() (int, string) {
+		t.Helper()
+		res, err := http.Get(server.URL + dispatch.DefaultHealthPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+	if status, body := health(); status != http.StatusOK || body != "ok\n" {
+		t.Errorf("unexpected health response: %d %q", status, body)
+	}
+
+	if err := endpoint.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := health(); status != http.StatusServiceUnavailable {
+		t.Errorf("unexpected health status after shutdown: %d", status)
+	}
+}
+
+func TestDispatchMuxHealthPath(t *testing.T) {
+	endpoint, err := dispatch.New(dispatch.EndpointUrl("http://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		opts []dispatch.MuxOption
+		path string
+	}{
+		{path: dispatch.DefaultHealthPath},
+		{opts: []dispatch.MuxOption{dispatch.HealthPath("/ready")}, path: "/ready"},
+		{opts: []dispatch.MuxOption{dispatch.HealthPath("")}},
+	} {
+		mux := endpoint.Mux(test.opts...)
+		for _, path := range []string{dispatch.DefaultHealthPath, "/ready"} {
+			want := http.StatusNotFound
+			if path == test.path {
+				want = http.StatusOK
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			if w.Code != want {
+				t.Errorf("unexpected status for %s with health path %q: got %d, want %d", path, test.path, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestDispatchServeMux(t *testing.T) {
+	type key struct{}
+	baseContext := context.WithValue(context.Background(), key{}, "app")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Context().Value(key{}).(string)))
+	})
+
+	var configured *http.Server
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ServeMux(mux),
+		dispatch.ServeMiddleware(
+			func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Add("X-Middleware", "outer")
+					next.ServeHTTP(w, r)
+				})
+			},
+			func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Add("X-Middleware", "inner")
+					next.ServeHTTP(w, r)
+				})
+			},
+		),
+		dispatch.BaseContext(func(net.Listener) context.Context { return baseContext }),
+		dispatch.ServerConfig(func(server *http.Server) { configured = server }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configured.BaseContext(nil) != baseContext {
+		t.Error("unexpected base context")
+	}
+
+	// The endpoint is registered on the mux.
+	path, _ := endpoint.Handler()
+	if _, pattern := mux.Handler(httptest.NewRequest("POST", path+"Run", nil)); pattern != path {
+		t.Errorf("endpoint is not registered on the mux: %q", pattern)
+	}
+
+	// Other routes are served, through the middleware.
+	req := httptest.NewRequest("GET", "/healthz", nil).WithContext(configured.BaseContext(nil))
+	w := httptest.NewRecorder()
+	configured.Handler.ServeHTTP(w, req)
+	if body := w.Body.String(); body != "app" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if got := w.Header().Values("X-Middleware"); !reflect.DeepEqual(got, []string{"outer", "inner"}) {
+		t.Errorf("unexpected middleware order: %v", got)
+	}
+}
+
+func TestDispatchServeContext(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.ServeAddress("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	identity := dispatch.Identity("identity")
+	wait := dispatch.Func("wait", func(ctx context.Context, n int) (int, error) {
+		if _, err := identity.Await(dispatchproto.Int(int64(n))); err != nil {
+			return 0, err
+		}
+		return n, nil
+	})
+	endpoint.Register(identity)
+	endpoint.Register(wait)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Suspend a volatile coroutine instance.
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("wait", dispatchproto.Int(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- endpoint.ServeContext(ctx) }()
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The coroutine instance was stopped when the endpoint shut down.
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("wait", poll.Result()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.PermanentErrorStatus, "not found")
+}
+
+func TestDispatchShuttingDown(t *testing.T) {
+	var server *http.Server
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ServerConfig(func(s *http.Server) { server = s }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	drain := dispatch.Func("drain", func(ctx context.Context, _ int) (string, error) {
+		if dispatchcoro.ShuttingDown(ctx) {
+			return "", errors.New("endpoint is already shutting down")
+		}
+		close(started)
+		for !dispatchcoro.ShuttingDown(ctx) {
+			time.Sleep(time.Millisecond)
+		}
+		// Volatile coroutines are never suspended at checkpoints.
+		if err := dispatchcoro.Checkpoint(ctx); err != nil {
+			return "", err
+		}
+		return "drained", nil
+	})
+	endpoint.Register(drain)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(listener) }()
+
+	client, err := dispatchserver.NewEndpointClient("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make(chan dispatchproto.Response, 1)
+	go func() {
+		res, err := client.Run(context.Background(), dispatchproto.NewRequest("drain", dispatchproto.Int(1)))
+		if err != nil {
+			res = dispatchproto.NewResponseError(err)
+		}
+		responses <- res
+	}()
+	<-started
+
+	// Shutdown waits for the in-flight call, which returns as soon as it
+	// observes that the endpoint is shutting down.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := endpoint.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		t.Errorf("unexpected error: %v", err)
+	}
+	dispatchtest.AssertExit(t, <-responses, "drained")
+}
+
+func TestDispatchResolveEndpoints(t *testing.T) {
+	endpoint, err := dispatch.New(
+		dispatch.EndpointUrl("http://example.com"),
+		dispatch.ResolveEndpoints(dispatchclient.EndpointResolverFunc(func(call dispatchproto.Call) (string, error) {
+			var tenant string
+			if err := call.Input().Unmarshal(&tenant); err != nil {
+				return "", err
+			}
+			return call.Endpoint() + "/" + tenant, nil
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := dispatch.Func("greet", func(ctx context.Context, tenant string) (string, error) {
+		return "hello " + tenant, nil
+	})
+	endpoint.Register(fn)
+
+	call, err := fn.BuildCall("acme")
+	if err != nil {
+		t.Fatal(err)
+	} else if got, want := call.Endpoint(), "http://example.com/acme"; got != want {
+		t.Errorf("unexpected endpoint: got %q, want %q", got, want)
+	}
+}
+
+func TestDispatchMaxCallDepth(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.MaxCallDepth(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var recurse *dispatch.Function[int, int]
+	recurse = dispatch.Func("recurse", func(ctx context.Context, n int) (int, error) {
+		return recurse.Await(n)
+	})
+	endpoint.Register(recurse)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each call suspends while awaiting the next one, so the chain of
+	// calls is in flight on the endpoint.
+	run := func(id, parent dispatchproto.ID) dispatchproto.Response {
+		req := dispatchproto.NewRequest("recurse", dispatchproto.Int(1),
+			dispatchproto.DispatchID(id),
+			dispatchproto.ParentDispatchID(parent),
+			dispatchproto.RootDispatchID("a"))
+		res, err := client.Run(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := run("a", ""); !res.OK() {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	if res := run("b", "a"); !res.OK() {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	res := run("c", "b")
+	if res.Status() != dispatchproto.PermanentErrorStatus {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	err, _ = res.Error()
+	if want := "maximum call depth of 2 exceeded by recursive calls to recurse: recurse -> recurse -> recurse"; !strings.Contains(err.Error(), want) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Calls with unknown parents are the root of a new tree.
+	if res := run("d", "unknown"); !res.OK() {
+		t.Fatalf("unexpected response: %s", res)
+	}
+}
+
+func TestDispatchServiceLevelObjective(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.ServiceLevelObjective(dispatch.SLO{
+		Objective:  0.5,
+		MinCalls:   2,
+		KillSwitch: true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Func("flaky", func(ctx context.Context, fail bool) (bool, error) {
+		if fail {
+			return false, errors.New("oops")
+		}
+		return true, nil
+	}))
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(fail bool) dispatchproto.Response {
+		res, err := client.Run(context.Background(), dispatchproto.NewRequest("flaky", dispatchproto.Bool(fail)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if res := run(false); !res.OK() {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	if res := run(true); res.OK() {
+		t.Fatalf("unexpected response: %s", res)
+	}
+
+	stats := endpoint.SLOStats()["flaky"]
+	if want := (dispatch.SLOStats{Succeeded: 1, Failed: 1, SuccessRatio: 0.5, Exhausted: true}); stats != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", stats, want)
+	}
+
+	// The kill switch rejects calls while the budget is exhausted.
+	if res := run(false); res.Status() != dispatchproto.ThrottledStatus {
+		t.Fatalf("unexpected response: %s", res)
+	}
+}
+
+func TestDispatchProfiling(t *testing.T) {
+	var profiles []dispatch.Profile
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.Profiling(func(ctx context.Context, p dispatch.Profile) {
+		profiles = append(profiles, p)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		time.Sleep(10 * time.Millisecond)
+		return double.Await(n)
+	})
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(dispatchproto.Int(4), dispatchproto.CorrelationID(poll.Calls()[0].CorrelationID())),
+	))
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 4)
+
+	if len(profiles) != 2 {
+		t.Fatalf("unexpected profiles: %+v", profiles)
+	}
+	if p := profiles[0]; p.Function != "workflow" || p.Step != "await: double" || p.Run < 10*time.Millisecond || p.Total < p.Deserialize+p.Run+p.Serialize {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+	if p := profiles[1]; p.Function != "workflow" || p.Step != "exit" || p.Total < p.Deserialize+p.Run+p.Serialize {
+		t.Errorf("unexpected profile: %+v", p)
+	}
+
+	stats := endpoint.ProfileStats()
+	if len(stats) != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	step := stats[dispatch.ProfileStep{Function: "workflow", Step: "await: double"}]
+	if want := (dispatch.ProfileStats{
+		Count:       1,
+		Deserialize: profiles[0].Deserialize,
+		Run:         profiles[0].Run,
+		Serialize:   profiles[0].Serialize,
+		Total:       profiles[0].Total,
+		MaxTotal:    profiles[0].Total,
+	}); step != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", step, want)
+	}
+}
+
+func TestDispatchLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.Logger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		return double.Await(n)
+	})
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2), dispatchproto.DispatchID("id-1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchFunction("double"))
+
+	var events []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var event map[string]any
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["dispatch_id"] == "id-1" {
+			events = append(events, event)
+		}
+	}
+	if len(events) != 3 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	for i, want := range []string{"Dispatch request received", "coroutine suspended", "Dispatch response sent"} {
+		if msg := events[i]["msg"]; msg != want || events[i]["function"] != "workflow" {
+			t.Errorf("unexpected event %d: got %v, want %q", i, events[i], want)
+		}
+	}
+	if size, _ := events[1]["state_size"].(float64); size <= 0 {
+		t.Errorf("unexpected state size: %v", events[1])
+	}
+	if events[2]["directive"] != "poll" || events[2]["calls"] != 1.0 {
+		t.Errorf("unexpected response event: %v", events[2])
+	}
+}
+
+func TestDispatchRegisterAlias(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.Logger(logger), dispatch.AliasWarnings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow-v2", func(ctx context.Context, n int) (int, error) {
+		return double.Await(n)
+	})
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+	endpoint.RegisterAlias("workflow", workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Suspended calls resume through the alias.
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+	calls := poll.Calls()
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(dispatchproto.Int(4), dispatchproto.CorrelationID(calls[0].CorrelationID())),
+	))
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 4)
+
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow-v2", dispatchproto.Int(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 1))
+
+	if calls := endpoint.AliasCalls(); !reflect.DeepEqual(calls, map[string]int64{"workflow": 2}) {
+		t.Errorf("unexpected alias calls: %v", calls)
+	}
+	warnings := bytes.Count(logs.Bytes(), []byte(`"msg":"Dispatch function called through an alias","alias":"workflow","function":"workflow-v2"`))
+	if warnings != 2 {
+		t.Errorf("unexpected warnings: %s", logs.Bytes())
+	}
+}
+
+func TestDispatchStateCompression(t *testing.T) {
+	if _, _, err := dispatchtest.NewEndpoint(dispatch.StateCompression(-1)); err == nil {
+		t.Fatal("expected an error for an invalid minimum size")
+	}
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.StateCompression(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		return double.Await(n)
+	})
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+
+	// The state of volatile coroutines stays in memory.
+	if stats := endpoint.StateStats(); stats != (dispatch.StateStats{}) {
+		t.Errorf("unexpected state stats: %+v", stats)
+	}
+}
+
+func TestDispatchStateStorage(t *testing.T) {
+	store := dispatchcoro.MemoryStateStore()
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.StateStorage(store),
+		dispatch.StateEncryption(bytes.Repeat([]byte{1}, 32)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		n, err := double.Await(n)
+		if err != nil {
+			return 0, err
+		}
+		return double.Await(n)
+	})
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+	ref := poll.CoroutineState()
+	if ref.TypeURL() != dispatch.StoredStateTypeURL {
+		t.Fatalf("unexpected coroutine state type: %s", ref.TypeURL())
+	}
+
+	// The state is encrypted before it's stored.
+	key := string(ref.Value())
+	b, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored anypb.Any
+	if err := proto.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	} else if stored.TypeUrl != dispatch.EncryptedStateTypeURL {
+		t.Errorf("unexpected stored state type: %s", stored.TypeUrl)
+	}
+
+	// The previous state is deleted once the next one is stored, and the
+	// reference only holds the key of the current state.
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(dispatchproto.Int(4), dispatchproto.CorrelationID(poll.Calls()[0].CorrelationID())),
+	))
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll = dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 4))
+	nextKey := string(poll.CoroutineState().Value())
+	if strings.Contains(nextKey, key) {
+		t.Errorf("unexpected reference: %q", nextKey)
+	}
+	if _, err := store.Get(context.Background(), key); !errors.Is(err, dispatchcoro.ErrStateNotFound) {
+		t.Errorf("expected the previous state to be deleted, got %v", err)
+	}
+
+	pollResult = poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(dispatchproto.Int(8), dispatchproto.CorrelationID(poll.Calls()[0].CorrelationID())),
+	))
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 8)
+
+	// The state is deleted once the call exits.
+	if _, err := store.Get(context.Background(), nextKey); !errors.Is(err, dispatchcoro.ErrStateNotFound) {
+		t.Errorf("expected the state to be deleted, got %v", err)
+	}
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.IncompatibleStateStatus, "cannot load state")
+}
+
+func TestDispatchStateEncryption(t *testing.T) {
+	if _, _, err := dispatchtest.NewEndpoint(dispatch.StateEncryption([]byte("short"))); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.StateEncryption(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		return double.Await(n)
+	})
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+	if typeURL := poll.CoroutineState().TypeURL(); typeURL != dispatch.EncryptedStateTypeURL {
+		t.Fatalf("unexpected coroutine state type: %s", typeURL)
+	}
+
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(dispatchproto.Int(4), dispatchproto.CorrelationID(poll.Calls()[0].CorrelationID())),
+	))
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 4)
+
+	// Encrypted state can't be resumed by another function.
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("double", pollResult))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.IncompatibleStateStatus, "cannot decrypt state")
+}
+
+func TestDispatchOutputOffload(t *testing.T) {
+	if _, _, err := dispatchtest.NewEndpoint(dispatch.OutputOffload(100, "not-registered")); err == nil {
+		t.Fatal("expected an error for an encoding that isn't registered")
+	}
+
+	store, err := dispatchhttp.DirBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchhttp.RegisterBlobStore("file", store)
+	dispatchproto.RegisterEncoding(dispatchproto.Offload("test-output-offload", store, 64))
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.OutputOffload(100, "test-output-offload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	repeat := dispatch.Func("repeat", func(ctx context.Context, n int) (string, error) {
+		return strings.Repeat("x", n), nil
+	})
+	endpoint.Register(repeat)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{10, 1000} {
+		res, err := client.Run(context.Background(), dispatchproto.NewRequest("repeat", dispatchproto.Int(int64(n))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, ok := res.Output()
+		if !ok {
+			t.Fatalf("unexpected response: %s", res)
+		}
+		if offloaded := strings.HasSuffix(output.TypeURL(), "+test-output-offload"); offloaded != (n > 100) {
+			t.Errorf("unexpected type URL for output of %d bytes: %s", n, output.TypeURL())
+		}
+		var s string
+		if err := output.Unmarshal(&s); err != nil {
+			t.Fatal(err)
+		} else if len(s) != n {
+			t.Errorf("unexpected output of %d bytes, want %d", len(s), n)
+		}
+	}
+}
+
+func TestDispatchPayloadEncodings(t *testing.T) {
+	if _, _, err := dispatchtest.NewEndpoint(dispatch.PayloadEncodings("unknown")); err == nil {
+		t.Fatal("expected an error for an unregistered encoding")
+	}
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.PayloadEncodings("gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	fn := dispatch.Func("repeat", func(ctx context.Context, s string) (string, error) {
+		return strings.Repeat(s, 3), nil
+	})
+	endpoint.Register(fn)
+
+	call, err := fn.BuildCall("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typeURL := call.Input().TypeURL(); !strings.HasSuffix(typeURL, "+gzip") {
+		t.Errorf("unexpected input type URL: %q", typeURL)
+	}
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), call.Request())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, ok := res.Output()
+	if !ok {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	if typeURL := output.TypeURL(); !strings.HasSuffix(typeURL, "+gzip") {
+		t.Errorf("unexpected output type URL: %q", typeURL)
+	}
+	var got string
+	if err := output.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if got != "foofoofoo" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestDispatchPayloadEncodingsNotAllowed(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Func("length", func(ctx context.Context, s string) (int, error) {
+		return len(s), nil
+	}))
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := dispatchproto.String("foo").Encode("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("length", input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.InvalidArgumentStatus, "cannot decode value: encoding \"gzip\" is not allowed")
+}
+
+func TestDispatchStrictValidation(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.StrictValidation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Identity("identity"))
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, test := range []struct {
+		name    string
+		req     dispatchproto.Request
+		problem string
+	}{
+		{
+			name: "valid",
+			req: dispatchproto.NewRequest("identity", dispatchproto.Int(1),
+				dispatchproto.CreationTime(now),
+				dispatchproto.ExpirationTime(now.Add(time.Hour))),
+		},
+		{
+			name:    "no directive",
+			req:     dispatchproto.NewRequest("identity"),
+			problem: "request has no input or poll result",
+		},
+		{
+			name:    "creation time in the future",
+			req:     dispatchproto.NewRequest("identity", dispatchproto.Int(1), dispatchproto.CreationTime(now.Add(time.Hour))),
+			problem: "is in the future",
+		},
+		{
+			name: "expiration before creation",
+			req: dispatchproto.NewRequest("identity", dispatchproto.Int(1),
+				dispatchproto.CreationTime(now),
+				dispatchproto.ExpirationTime(now.Add(-time.Hour))),
+			problem: "is before creation time",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := client.Run(context.Background(), test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.problem == "" {
+				if !res.OK() {
+					t.Fatalf("unexpected response: %s", res)
+				}
+				return
+			}
+			if res.Status() != dispatchproto.InvalidArgumentStatus {
+				t.Fatalf("unexpected response: %s", res)
+			}
+			if err, _ := res.Error(); !strings.Contains(err.Message(), test.problem) {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestDispatchStrictValidationStateWrappers(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		opts    []dispatch.Option
+		version string
+		typeURL string
+	}{
+		{
+			name:    "encryption",
+			opts:    []dispatch.Option{dispatch.StateEncryption(bytes.Repeat([]byte{1}, 32))},
+			typeURL: dispatch.EncryptedStateTypeURL,
+		},
+		{
+			name:    "storage",
+			opts:    []dispatch.Option{dispatch.StateStorage(dispatchcoro.MemoryStateStore())},
+			typeURL: dispatch.StoredStateTypeURL,
+		},
+		{
+			name:    "version",
+			version: "v1",
+			typeURL: dispatch.VersionedStateTypeURL,
+		},
+		{
+			name: "all",
+			opts: []dispatch.Option{
+				dispatch.StateCompression(0),
+				dispatch.StateEncryption(bytes.Repeat([]byte{1}, 32)),
+				dispatch.StateStorage(dispatchcoro.MemoryStateStore()),
+			},
+			version: "v1",
+			typeURL: dispatch.StoredStateTypeURL,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint, server, err := dispatchtest.NewEndpoint(append(test.opts, dispatch.StrictValidation())...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+				return n * 2, nil
+			})
+			workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+				return double.Await(n)
+			})
+			if test.version != "" {
+				workflow = workflow.WithStateVersion(test.version)
+			}
+			endpoint.Register(double)
+			endpoint.Register(workflow)
+
+			client, err := server.Client()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := client.Run(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			poll := dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+			if typeURL := poll.CoroutineState().TypeURL(); typeURL != test.typeURL {
+				t.Fatalf("unexpected coroutine state type: %s", typeURL)
+			}
+
+			pollResult := poll.Result().With(dispatchproto.CallResults(
+				dispatchproto.NewCallResult(dispatchproto.Int(4), dispatchproto.CorrelationID(poll.Calls()[0].CorrelationID())),
+			))
+			res, err = client.Run(context.Background(), dispatchproto.NewRequest("workflow", pollResult))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dispatchtest.AssertExit(t, res, 4)
+		})
+	}
+}
+
+func TestDispatchErrorSizeLimit(t *testing.T) {
+	for _, limit := range []int{0, -1} {
+		if _, _, err := dispatchtest.NewEndpoint(dispatch.ErrorSizeLimit(limit)); err == nil || err.Error() != fmt.Sprintf("invalid limit provided via ErrorSizeLimit(..): %d", limit) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	var offloaded string
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.ErrorSizeLimit(64),
+		dispatch.ErrorOffload(func(ctx context.Context, field string, data []byte) (string, error) {
+			offloaded = string(data)
+			return "blob://" + field, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := strings.Repeat("x", 100)
+	endpoint.RegisterPrimitive("fail", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		return dispatchproto.NewResponse(dispatchproto.PermanentErrorStatus, dispatchproto.NewErrorMessage("Oops", message))
+	})
+
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("fail", dispatchproto.Int(1)))
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Status() != dispatchproto.PermanentErrorStatus {
+		t.Fatalf("unexpected response status: %v", res.Status())
+	}
+	resErr, ok := res.Error()
+	if !ok {
+		t.Fatalf("expected an error: %v", res)
+	}
+	if want := strings.Repeat("x", 20) + "... [truncated 80 bytes, see blob://message]"; resErr.Message() != want {
+		t.Errorf("unexpected error message: %q", resErr.Message())
+	}
+	if offloaded != message {
+		t.Errorf("unexpected offloaded message: %q", offloaded)
+	}
+}
+
+func TestDispatchExecutionQuota(t *testing.T) {
+	signingKeyA, verificationKeyA := dispatchtest.KeyPair()
+	signingKeyB, verificationKeyB := dispatchtest.KeyPair()
+
+	endpoint, server, err := dispatchtest.NewEndpoint(
+		dispatch.VerificationPrincipal("a", verificationKeyA),
+		dispatch.VerificationPrincipal("b", verificationKeyB),
+		dispatch.ExecutionQuota(dispatch.Quota{MaxConcurrent: 1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	endpoint.RegisterPrimitive("block", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		close(started)
+		<-unblock
+		return dispatchproto.NewResponse(dispatchproto.Int(1))
+	})
+	endpoint.Register(dispatch.Identity("identity"))
+
+	// Quotas are enforced for each principal that signs requests.
+	tenantClient := func(signingKey string) *dispatchserver.EndpointClient {
+		client, err := server.Client(dispatchtest.SigningKey(signingKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	clientA := tenantClient(signingKeyA)
+	clientB := tenantClient(signingKeyB)
+
+	done := make(chan dispatchproto.Response)
+	go func() {
+		res, err := clientA.Run(context.Background(), dispatchproto.NewRequest("block", dispatchproto.Int(1)))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	<-started
+
+	// Tenant a is at its concurrency limit.
+	res, err := clientA.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(2)))
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Status() != dispatchproto.ThrottledStatus {
+		t.Errorf("unexpected response status: %v", res.Status())
+	}
+
+	// Tenant b is not.
+	res, err = clientB.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(3)))
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.OK() {
+		t.Errorf("unexpected response status: %v", res.Status())
+	}
+
+	close(unblock)
+	if res := <-done; !res.OK() {
+		t.Errorf("unexpected response status: %v", res.Status())
+	}
+
+	stats := endpoint.QuotaStats()
+	if got, want := stats["a"], (dispatch.QuotaStats{Accepted: 1, Throttled: 1}); got != want {
+		t.Errorf("unexpected stats for tenant a: got %+v, want %+v", got, want)
+	}
+	if got, want := stats["b"], (dispatch.QuotaStats{Accepted: 1}); got != want {
+		t.Errorf("unexpected stats for tenant b: got %+v, want %+v", got, want)
+	}
+}
+
+func TestDispatchHotRegister(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		input, _ := req.Input()
+		return dispatchproto.NewResponse(input)
+	}
+	endpoint.RegisterPrimitive("identity", identity)
+
+	// Send requests concurrently while functions are being added.
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Run(context.Background(), dispatchproto.NewRequest("identity", dispatchproto.Int(1)))
+			if err != nil {
+				t.Error(err)
+			} else if !res.OK() {
+				t.Errorf("unexpected response status: %v", res.Status())
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		endpoint.HotRegisterPrimitive("identity"+strconv.Itoa(i), identity)
+	}
+	wg.Wait()
+
+	// Functions added with HotRegister are served.
+	for i := 0; i < n; i++ {
+		res, err := client.Run(context.Background(), dispatchproto.NewRequest("identity"+strconv.Itoa(i), dispatchproto.Int(2)))
+		if err != nil {
+			t.Fatal(err)
+		} else if !res.OK() {
+			t.Errorf("unexpected response status for function %d: %v", i, res.Status())
+		}
+	}
+
+	// The set of functions is frozen once the endpoint is serving.
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "use HotRegister instead") {
+			t.Errorf("unexpected panic: %v", r)
+		}
+	}()
+	endpoint.RegisterPrimitive("late", identity)
+}
+
+func TestDispatchHedgedRequests(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// The first request is slow, and is cancelled once the hedged
+	// request succeeds.
+	var mu sync.Mutex
+	var requests []dispatchproto.ID
+	cancelled := make(chan struct{})
+	endpoint.RegisterPrimitive("slow", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		mu.Lock()
+		requests = append(requests, req.DispatchID())
+		first := len(requests) == 1
+		mu.Unlock()
+		if first {
+			<-ctx.Done()
+			close(cancelled)
+		}
+		input, _ := req.Input()
+		return dispatchproto.NewResponse(input)
+	})
+
+	client, err := server.Client(dispatchserver.HedgeRequests(10*time.Millisecond, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := dispatchproto.NewRequest("slow", dispatchproto.Int(1), dispatchproto.DispatchID("d1"))
+	res, err := client.Run(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.OK() {
+		t.Fatalf("unexpected response status: %v", res.Status())
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow request was not cancelled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(requests, []dispatchproto.ID{"d1", "d1"}) {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+}
+
+func TestDispatchRequestsNotHedged(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var mu sync.Mutex
+	var count int
+	endpoint.RegisterPrimitive("slow", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		input, _ := req.Input()
+		return dispatchproto.NewResponse(input)
+	})
+
+	client, err := server.Client(dispatchserver.HedgeRequests(time.Millisecond, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests without a dispatch ID are not hedged.
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("slow", dispatchproto.Int(1)))
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.OK() {
+		t.Fatalf("unexpected response status: %v", res.Status())
+	}
+
+	// Requests that resume a call are not hedged either.
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("slow", dispatchproto.NewPollResult(), dispatchproto.DispatchID("d1")))
+	if err != nil {
+		t.Fatal(err)
+	} else if !res.OK() {
+		t.Fatalf("unexpected response status: %v", res.Status())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if count != 2 {
+		t.Errorf("unexpected number of requests: got %d, want 2", count)
+	}
+}
+
+func TestDispatchInputLimits(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.InputLimits(2, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Func("count", func(ctx context.Context, lists [][]int) (int, error) {
+		return len(lists), nil
+	}))
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := dispatchproto.Marshal([][]int{{1}, {2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("count", input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 2)
+
+	input, err = dispatchproto.Marshal([][][]int{{{1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = client.Run(context.Background(), dispatchproto.NewRequest("count", input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.InvalidArgumentStatus, "maximum depth (2)")
+
+	// The input is described by its type and size, rather than formatted.
+	if err, ok := res.Error(); !ok {
+		t.Fatalf("unexpected response: %s", res)
+	} else if msg := err.Message(); !strings.Contains(msg, "invalid input of type type.googleapis.com/google.protobuf.Value (") || strings.Contains(msg, "list_value") {
+		t.Errorf("unexpected error message: %q", msg)
+	}
+}
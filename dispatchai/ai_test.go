@@ -0,0 +1,377 @@
+package dispatchai_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchai"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer foobar" {
+			t.Errorf("unexpected authorization header: %s", got)
+		}
+		var req struct {
+			Model    string               `json:"model"`
+			Messages []dispatchai.Message `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(`{
+			"id": "chatcmpl-1",
+			"model": "` + req.Model + `",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": "` + req.Messages[0].Content + `"}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 3, "completion_tokens": 5, "total_tokens": 8}
+		}`))
+	}))
+	defer server.Close()
+
+	var usage dispatchai.Usage
+	client, err := dispatchai.New(
+		dispatchai.APIKey("foobar"),
+		dispatchai.BaseURL(server.URL),
+		dispatchai.UsageHook(func(ctx context.Context, model string, u dispatchai.Usage) {
+			if model != "gpt-test" {
+				t.Errorf("unexpected model: %s", model)
+			}
+			usage = usage.Add(u)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Chat(context.Background(), &dispatchai.ChatRequest{
+			Model:    "gpt-test",
+			Messages: []dispatchai.Message{dispatchai.User("echo")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Content(); got != "echo" {
+			t.Errorf("unexpected content: %q", got)
+		}
+	}
+
+	want := dispatchai.Usage{PromptTokens: 6, CompletionTokens: 10, TotalTokens: 16}
+	if usage != want {
+		t.Errorf("unexpected usage: got %+v, want %+v", usage, want)
+	}
+}
+
+func TestChatErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		code int
+		want dispatchproto.Status
+	}{
+		{http.StatusTooManyRequests, dispatchproto.ThrottledStatus},
+		{http.StatusInternalServerError, dispatchproto.TemporaryErrorStatus},
+		{http.StatusServiceUnavailable, dispatchproto.TemporaryErrorStatus},
+		{http.StatusBadRequest, dispatchproto.InvalidArgumentStatus},
+		{http.StatusUnauthorized, dispatchproto.UnauthenticatedStatus},
+	} {
+		t.Run(http.StatusText(test.code), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.code)
+				w.Write([]byte(`{"error": {"type": "test_error", "message": "oops"}}`))
+			}))
+			defer server.Close()
+
+			client, err := dispatchai.New(dispatchai.APIKey("foobar"), dispatchai.BaseURL(server.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.Chat(context.Background(), &dispatchai.ChatRequest{Model: "gpt-test"})
+
+			var apiErr *dispatchai.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("unexpected error: %v", err)
+			} else if apiErr.Message != "oops" || apiErr.Type != "test_error" {
+				t.Errorf("unexpected error: %v", apiErr)
+			}
+			if got := dispatchproto.ErrorStatus(err); got != test.want {
+				t.Errorf("unexpected status: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChatSerializable(t *testing.T) {
+	temperature := 0.5
+	req := &dispatchai.ChatRequest{
+		Model:       "gpt-test",
+		Messages:    []dispatchai.Message{dispatchai.System("be brief"), dispatchai.User("hi")},
+		Temperature: &temperature,
+		MaxTokens:   10,
+	}
+	boxed, err := dispatchproto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req2 *dispatchai.ChatRequest
+	if err := boxed.Unmarshal(&req2); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(req, req2); diff != "" {
+		t.Errorf("invalid request: %v", diff)
+	}
+
+	res := &dispatchai.ChatResponse{
+		ID:      "chatcmpl-1",
+		Model:   "gpt-test",
+		Choices: []dispatchai.Choice{{Message: dispatchai.Assistant("hello"), FinishReason: "stop"}},
+		Usage:   dispatchai.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3},
+	}
+	boxed, err = dispatchproto.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res2 *dispatchai.ChatResponse
+	if err := boxed.Unmarshal(&res2); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(res, res2); diff != "" {
+		t.Errorf("invalid response: %v", diff)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		// Return embeddings out of order, to check that they're
+		// sorted by index.
+		w.Write([]byte(`{
+			"model": "embed-test",
+			"data": [{"index": 1, "embedding": [3, 4]}, {"index": 0, "embedding": [1, 2]}],
+			"usage": {"prompt_tokens": 2, "total_tokens": 2}
+		}`))
+	}))
+	defer server.Close()
+
+	client, err := dispatchai.New(dispatchai.APIKey("foobar"), dispatchai.BaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Embed(context.Background(), &dispatchai.EmbeddingRequest{
+		Model: "embed-test",
+		Input: []string{"a", "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{1, 2}, {3, 4}}
+	if diff := cmp.Diff(want, res.Embeddings); diff != "" {
+		t.Errorf("unexpected embeddings: %v", diff)
+	}
+	if res.Usage.TotalTokens != 2 {
+		t.Errorf("unexpected usage: %+v", res.Usage)
+	}
+}
+
+func TestChunk(t *testing.T) {
+	for _, test := range []struct {
+		text    string
+		size    int
+		overlap int
+		want    []string
+	}{
+		{"", 3, 0, nil},
+		{"abc", 3, 0, []string{"abc"}},
+		{"abcdefg", 3, 0, []string{"abc", "def", "g"}},
+		{"abcdefg", 3, 1, []string{"abc", "cde", "efg"}},
+		{"héllo wörld", 4, 2, []string{"héll", "llo ", "o wö", "wörl", "rld"}},
+	} {
+		got := dispatchai.Chunk(test.text, test.size, test.overlap)
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("unexpected chunks for %q: %v", test.text, diff)
+		}
+	}
+}
+
+func TestPinecone(t *testing.T) {
+	var got []dispatchai.Vector
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vectors/upsert" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if key := r.Header.Get("Api-Key"); key != "foobar" {
+			t.Errorf("unexpected API key: %s", key)
+		}
+		var req struct {
+			Vectors   []dispatchai.Vector `json:"vectors"`
+			Namespace string              `json:"namespace"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Namespace != "ns" {
+			t.Errorf("unexpected namespace: %s", req.Namespace)
+		}
+		got = req.Vectors
+		w.Write([]byte(`{"upsertedCount": 1}`))
+	}))
+	defer server.Close()
+
+	store := &dispatchai.Pinecone{IndexHost: server.URL, APIKey: "foobar", Namespace: "ns"}
+
+	vectors := []dispatchai.Vector{{ID: "a", Values: []float64{1, 2}, Metadata: map[string]string{"k": "v"}}}
+	if err := store.Upsert(context.Background(), vectors); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(vectors, got); diff != "" {
+		t.Errorf("unexpected vectors: %v", diff)
+	}
+}
+
+type execRecorder struct {
+	query string
+	args  []any
+}
+
+func (e *execRecorder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	e.query = query
+	e.args = args
+	return nil, nil
+}
+
+func TestPGVector(t *testing.T) {
+	db := &execRecorder{}
+	store := &dispatchai.PGVector{DB: db, Table: "items"}
+
+	err := store.Upsert(context.Background(), []dispatchai.Vector{
+		{ID: "a", Values: []float64{1, 0.5}},
+		{ID: "b", Values: []float64{-2}, Metadata: map[string]string{"k": "v"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantQuery := `INSERT INTO "items" (id, embedding, metadata) VALUES ($1, $2, $3), ($4, $5, $6) ON CONFLICT (id) DO UPDATE SET embedding = EXCLUDED.embedding, metadata = EXCLUDED.metadata`
+	if db.query != wantQuery {
+		t.Errorf("unexpected query: %s", db.query)
+	}
+	wantArgs := []any{"a", "[1,0.5]", "null", "b", "[-2]", `{"k":"v"}`}
+	if diff := cmp.Diff(wantArgs, db.args); diff != "" {
+		t.Errorf("unexpected args: %v", diff)
+	}
+}
+
+func TestPGVectorQuotesTable(t *testing.T) {
+	db := &execRecorder{}
+	store := &dispatchai.PGVector{DB: db, Table: `public.items"; DROP TABLE users; --`}
+
+	if err := store.Upsert(context.Background(), []dispatchai.Vector{{ID: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	wantPrefix := `INSERT INTO "public"."items""; DROP TABLE users; --" (id`
+	if !strings.HasPrefix(db.query, wantPrefix) {
+		t.Errorf("unexpected query: %s", db.query)
+	}
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	vectors map[string]dispatchai.Vector
+}
+
+func (s *memoryStore) Upsert(ctx context.Context, vectors []dispatchai.Vector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range vectors {
+		s.vectors[v.ID] = v
+	}
+	return nil
+}
+
+func TestIndexFunc(t *testing.T) {
+	embed := dispatch.Func("embed", func(ctx context.Context, req *dispatchai.EmbeddingRequest) (*dispatchai.EmbeddingResponse, error) {
+		if req.Model != "embed-test" {
+			return nil, fmt.Errorf("unexpected model: %s", req.Model)
+		}
+		if len(req.Input) > 2 {
+			return nil, fmt.Errorf("batch too large: %d", len(req.Input))
+		}
+		res := &dispatchai.EmbeddingResponse{Model: req.Model}
+		for _, input := range req.Input {
+			res.Embeddings = append(res.Embeddings, []float64{float64(len(input))})
+		}
+		return res, nil
+	})
+
+	store := &memoryStore{vectors: map[string]dispatchai.Vector{}}
+	upsert := dispatchai.UpsertFunc("upsert", store)
+
+	index, err := dispatchai.IndexFunc("index", dispatchai.IndexConfig{
+		Model:     "embed-test",
+		ChunkSize: 4,
+		BatchSize: 2,
+	}, embed, upsert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := dispatchtest.NewRunner(embed, upsert, index)
+
+	n, err := dispatchtest.Call(runner, index, &dispatchai.IndexRequest{
+		Documents: []dispatchai.Document{
+			{ID: "doc1", Text: "abcdefghij", Metadata: map[string]string{"source": "test"}},
+			{ID: "doc2", Text: "xyz"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Errorf("unexpected number of vectors: %d", n)
+	}
+
+	want := map[string]dispatchai.Vector{
+		"doc1#0": {ID: "doc1#0", Values: []float64{4}, Metadata: map[string]string{"source": "test", "document_id": "doc1", "chunk": "0", "text": "abcd"}},
+		"doc1#1": {ID: "doc1#1", Values: []float64{4}, Metadata: map[string]string{"source": "test", "document_id": "doc1", "chunk": "1", "text": "efgh"}},
+		"doc1#2": {ID: "doc1#2", Values: []float64{2}, Metadata: map[string]string{"source": "test", "document_id": "doc1", "chunk": "2", "text": "ij"}},
+		"doc2#0": {ID: "doc2#0", Values: []float64{3}, Metadata: map[string]string{"document_id": "doc2", "chunk": "0", "text": "xyz"}},
+	}
+	if diff := cmp.Diff(want, store.vectors); diff != "" {
+		t.Errorf("unexpected vectors: %v", diff)
+	}
+}
+
+func TestIndexFuncInvalidChunkOverlap(t *testing.T) {
+	embed := dispatch.Func("embed", func(ctx context.Context, req *dispatchai.EmbeddingRequest) (*dispatchai.EmbeddingResponse, error) {
+		return nil, nil
+	})
+	upsert := dispatchai.UpsertFunc("upsert", &memoryStore{})
+
+	for _, config := range []dispatchai.IndexConfig{
+		{ChunkSize: 4, ChunkOverlap: 4},
+		{ChunkSize: 4, ChunkOverlap: 5},
+		{ChunkOverlap: 1000}, // default chunk size
+		{ChunkOverlap: -1},
+	} {
+		if _, err := dispatchai.IndexFunc("index", config, embed, upsert); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
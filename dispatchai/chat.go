@@ -0,0 +1,166 @@
+//go:build !durable
+
+package dispatchai
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dispatchrun/dispatch-go"
+)
+
+// Message is a message in a chat conversation.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// System creates a system Message.
+func System(content string) Message {
+	return Message{Role: "system", Content: content}
+}
+
+// User creates a user Message.
+func User(content string) Message {
+	return Message{Role: "user", Content: content}
+}
+
+// Assistant creates an assistant Message.
+func Assistant(content string) Message {
+	return Message{Role: "assistant", Content: content}
+}
+
+// ChatRequest is a request for a chat completion.
+type ChatRequest struct {
+	Model       string
+	Messages    []Message
+	Temperature *float64
+	MaxTokens   int
+	Stop        []string
+	User        string
+}
+
+func (r *ChatRequest) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonChatRequest{
+		Model:       r.Model,
+		Messages:    r.Messages,
+		Temperature: r.Temperature,
+		MaxTokens:   r.MaxTokens,
+		Stop:        r.Stop,
+		User:        r.User,
+	})
+}
+
+func (r *ChatRequest) UnmarshalJSON(b []byte) error {
+	var jr jsonChatRequest
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	r.Model = jr.Model
+	r.Messages = jr.Messages
+	r.Temperature = jr.Temperature
+	r.MaxTokens = jr.MaxTokens
+	r.Stop = jr.Stop
+	r.User = jr.User
+	return nil
+}
+
+type jsonChatRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Stop        []string  `json:"stop,omitempty"`
+	User        string    `json:"user,omitempty"`
+}
+
+// ChatResponse is a chat completion.
+type ChatResponse struct {
+	ID      string
+	Model   string
+	Choices []Choice
+	Usage   Usage
+}
+
+// Choice is a chat completion choice.
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+}
+
+// Content is the content of the message from the first choice,
+// or an empty string if the response has no choices.
+func (r *ChatResponse) Content() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	return r.Choices[0].Message.Content
+}
+
+func (r *ChatResponse) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonChatResponse{
+		ID:      r.ID,
+		Model:   r.Model,
+		Choices: r.Choices,
+		Usage:   r.Usage,
+	})
+}
+
+func (r *ChatResponse) UnmarshalJSON(b []byte) error {
+	var jr jsonChatResponse
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	r.ID = jr.ID
+	r.Model = jr.Model
+	r.Choices = jr.Choices
+	r.Usage = jr.Usage
+	return nil
+}
+
+type jsonChatResponse struct {
+	ID      string   `json:"id,omitempty"`
+	Model   string   `json:"model,omitempty"`
+	Choices []Choice `json:"choices,omitempty"`
+	Usage   Usage    `json:"usage"`
+}
+
+// Usage is the number of tokens consumed by a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Add adds token usage from another request, and returns
+// the sum.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+	}
+}
+
+// Chat requests a chat completion.
+func (c *Client) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	res := new(ChatResponse)
+	if err := c.post(ctx, "/chat/completions", req, res); err != nil {
+		return nil, err
+	}
+	c.recordUsage(ctx, res.Model, res.Usage)
+	return res, nil
+}
+
+// ChatFunc creates a Dispatch function that requests chat
+// completions using the Client.
+//
+// The function can be registered on a Dispatch endpoint and then
+// awaited from other functions, so that each completion becomes a
+// durable step that is retried on throttling or transient errors.
+func (c *Client) ChatFunc(name string) *dispatch.Function[*ChatRequest, *ChatResponse] {
+	return dispatch.Func(name, c.Chat)
+}
@@ -0,0 +1,139 @@
+//go:build !durable
+
+package dispatchai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dispatchrun/dispatch-go/dispatchhttp"
+	"github.com/dispatchrun/dispatch-go/internal/env"
+)
+
+const defaultBaseUrl = "https://api.openai.com/v1"
+
+// Client is a client for an OpenAI-compatible chat completion API.
+//
+// Responses from the API are mapped to errors that carry a
+// dispatchproto.Status, so that functions using the Client are
+// retried by Dispatch when the API is throttling requests (429)
+// or is temporarily unavailable (5xx).
+type Client struct {
+	apiKey     string
+	baseUrl    string
+	env        []string
+	httpClient *dispatchhttp.Client
+	usageHook  func(context.Context, string, Usage)
+	opts       []Option
+}
+
+// New creates a Client.
+func New(opts ...Option) (*Client, error) {
+	c := &Client{
+		env:  os.Environ(),
+		opts: opts,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.apiKey == "" {
+		c.apiKey = env.Get(c.env, "OPENAI_API_KEY")
+	}
+	if c.apiKey == "" {
+		return nil, fmt.Errorf("API key has not been set. Use APIKey(..), or set the OPENAI_API_KEY environment variable")
+	}
+
+	if c.baseUrl == "" {
+		c.baseUrl = env.Get(c.env, "OPENAI_BASE_URL")
+	}
+	if c.baseUrl == "" {
+		c.baseUrl = defaultBaseUrl
+	}
+	c.baseUrl = strings.TrimSuffix(c.baseUrl, "/")
+
+	if c.httpClient == nil {
+		c.httpClient = dispatchhttp.DefaultClient
+	}
+	return c, nil
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// APIKey sets the API key used to authenticate requests.
+//
+// It defaults to the value of the OPENAI_API_KEY environment variable.
+func APIKey(apiKey string) Option {
+	return func(c *Client) { c.apiKey = apiKey }
+}
+
+// BaseURL sets the base URL of the API.
+//
+// It defaults to the value of the OPENAI_BASE_URL environment variable,
+// or the OpenAI API (https://api.openai.com/v1) if OPENAI_BASE_URL
+// is unset. Any API that is compatible with the OpenAI chat completion
+// API can be used.
+func BaseURL(baseUrl string) Option {
+	return func(c *Client) { c.baseUrl = baseUrl }
+}
+
+// Env sets the environment variables that a Client parses its
+// default configuration from.
+//
+// It defaults to os.Environ().
+func Env(env ...string) Option {
+	return func(c *Client) { c.env = env }
+}
+
+// HTTPClient sets the HTTP client used to make requests to the API.
+//
+// It defaults to dispatchhttp.DefaultClient.
+func HTTPClient(client *dispatchhttp.Client) Option {
+	return func(c *Client) { c.httpClient = client }
+}
+
+// UsageHook sets a function that is called with the token usage
+// reported by the API after each successful request, along with
+// the name of the model that served the request.
+//
+// The hook can be used to meter token consumption.
+func UsageHook(hook func(ctx context.Context, model string, usage Usage)) Option {
+	return func(c *Client) { c.usageHook = hook }
+}
+
+func (c *Client) post(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(ctx, &dispatchhttp.Request{
+		Method: "POST",
+		URL:    c.baseUrl + path,
+		Header: http.Header{
+			"Authorization": []string{"Bearer " + c.apiKey},
+			"Content-Type":  []string{"application/json"},
+		},
+		Body: body,
+	})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newAPIError(res)
+	}
+	if err := json.Unmarshal(res.Body, out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Client) recordUsage(ctx context.Context, model string, usage Usage) {
+	if c.usageHook != nil {
+		c.usageHook(ctx, model, usage)
+	}
+}
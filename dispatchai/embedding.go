@@ -0,0 +1,110 @@
+//go:build !durable
+
+package dispatchai
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/dispatchrun/dispatch-go"
+)
+
+// EmbeddingRequest is a request to generate embeddings for a batch
+// of inputs.
+type EmbeddingRequest struct {
+	Model string
+	Input []string
+}
+
+func (r *EmbeddingRequest) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonEmbeddingRequest{
+		Model: r.Model,
+		Input: r.Input,
+	})
+}
+
+func (r *EmbeddingRequest) UnmarshalJSON(b []byte) error {
+	var jr jsonEmbeddingRequest
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	r.Model = jr.Model
+	r.Input = jr.Input
+	return nil
+}
+
+type jsonEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponse carries the embeddings generated for a batch
+// of inputs.
+type EmbeddingResponse struct {
+	Model string
+
+	// Embeddings are the generated embeddings, in the same order
+	// as the inputs of the request.
+	Embeddings [][]float64
+
+	Usage Usage
+}
+
+func (r *EmbeddingResponse) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	data := make([]jsonEmbedding, len(r.Embeddings))
+	for i, embedding := range r.Embeddings {
+		data[i] = jsonEmbedding{Index: i, Embedding: embedding}
+	}
+	return json.Marshal(jsonEmbeddingResponse{
+		Model: r.Model,
+		Data:  data,
+		Usage: r.Usage,
+	})
+}
+
+func (r *EmbeddingResponse) UnmarshalJSON(b []byte) error {
+	var jr jsonEmbeddingResponse
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	sort.SliceStable(jr.Data, func(i, j int) bool {
+		return jr.Data[i].Index < jr.Data[j].Index
+	})
+	r.Model = jr.Model
+	r.Embeddings = make([][]float64, len(jr.Data))
+	for i, d := range jr.Data {
+		r.Embeddings[i] = d.Embedding
+	}
+	r.Usage = jr.Usage
+	return nil
+}
+
+type jsonEmbeddingResponse struct {
+	Model string          `json:"model,omitempty"`
+	Data  []jsonEmbedding `json:"data"`
+	Usage Usage           `json:"usage"`
+}
+
+type jsonEmbedding struct {
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// Embed generates embeddings for a batch of inputs.
+func (c *Client) Embed(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
+	res := new(EmbeddingResponse)
+	if err := c.post(ctx, "/embeddings", req, res); err != nil {
+		return nil, err
+	}
+	c.recordUsage(ctx, res.Model, res.Usage)
+	return res, nil
+}
+
+// EmbedFunc creates a Dispatch function that generates embeddings
+// using the Client.
+func (c *Client) EmbedFunc(name string) *dispatch.Function[*EmbeddingRequest, *EmbeddingResponse] {
+	return dispatch.Func(name, c.Embed)
+}
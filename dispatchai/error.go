@@ -0,0 +1,60 @@
+//go:build !durable
+
+package dispatchai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dispatchrun/dispatch-go/dispatchhttp"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// APIError is an error returned by the API.
+//
+// The error carries a dispatchproto.Status derived from the
+// HTTP status code of the response, which means that rate limit
+// errors (429) are categorized as ThrottledStatus and server
+// errors (5xx) as TemporaryErrorStatus.
+type APIError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+}
+
+func newAPIError(res *dispatchhttp.Response) *APIError {
+	e := &APIError{StatusCode: res.StatusCode}
+
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Code    any    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(res.Body, &body) == nil {
+		e.Type = body.Error.Type
+		e.Message = body.Error.Message
+		if body.Error.Code != nil {
+			e.Code = fmt.Sprint(body.Error.Code)
+		}
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("API error %d (%s): %s", e.StatusCode, e.Type, e.Message)
+	}
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Message)
+}
+
+// Status is the status for the error.
+func (e *APIError) Status() dispatchproto.Status {
+	return (&dispatchhttp.Response{StatusCode: e.StatusCode}).Status()
+}
@@ -0,0 +1,177 @@
+//go:build !durable
+
+package dispatchai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+
+	"github.com/dispatchrun/dispatch-go"
+)
+
+// Document is a document to index.
+type Document struct {
+	ID       string            `json:"id"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// IndexRequest is a request to index a set of documents.
+type IndexRequest struct {
+	Documents []Document
+}
+
+func (r *IndexRequest) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonIndexRequest{Documents: r.Documents})
+}
+
+func (r *IndexRequest) UnmarshalJSON(b []byte) error {
+	var jr jsonIndexRequest
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	r.Documents = jr.Documents
+	return nil
+}
+
+type jsonIndexRequest struct {
+	Documents []Document `json:"documents"`
+}
+
+// IndexConfig configures an indexing pipeline created with IndexFunc.
+type IndexConfig struct {
+	// Model is the embedding model.
+	Model string
+
+	// ChunkSize is the maximum number of characters in each chunk
+	// of a document. It defaults to 1000.
+	ChunkSize int
+
+	// ChunkOverlap is the number of characters shared between
+	// consecutive chunks of a document. It must be less than the
+	// chunk size.
+	ChunkOverlap int
+
+	// BatchSize is the maximum number of chunks embedded, or vectors
+	// upserted, by each call. It defaults to 100.
+	BatchSize int
+}
+
+// IndexFunc creates a Dispatch function that indexes documents.
+//
+// Documents are split into chunks (see Chunk), and the chunks are
+// embedded in batches by concurrent calls to the embed function. The
+// resulting vectors are then upserted in batches by concurrent calls
+// to the upsert function. Each chunk is stored as a vector with ID
+// "<document ID>#<chunk index>", and with the document metadata plus
+// "document_id", "chunk" and "text" keys.
+//
+// The function returns the number of vectors upserted. IndexFunc returns
+// an error if the config is invalid.
+func IndexFunc(name string, config IndexConfig, embed *dispatch.Function[*EmbeddingRequest, *EmbeddingResponse], upsert *dispatch.Function[*UpsertRequest, int]) (*dispatch.Function[*IndexRequest, int], error) {
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = 1000
+	}
+	if config.ChunkOverlap < 0 || config.ChunkOverlap >= config.ChunkSize {
+		return nil, fmt.Errorf("invalid chunk overlap provided via IndexConfig: %d (must be non-negative and less than the chunk size of %d)", config.ChunkOverlap, config.ChunkSize)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+
+	return dispatch.Func(name, func(ctx context.Context, req *IndexRequest) (int, error) {
+		var vectors []Vector
+		for _, doc := range req.Documents {
+			for i, chunk := range Chunk(doc.Text, config.ChunkSize, config.ChunkOverlap) {
+				metadata := maps.Clone(doc.Metadata)
+				if metadata == nil {
+					metadata = map[string]string{}
+				}
+				metadata["document_id"] = doc.ID
+				metadata["chunk"] = strconv.Itoa(i)
+				metadata["text"] = chunk
+				vectors = append(vectors, Vector{
+					ID:       doc.ID + "#" + strconv.Itoa(i),
+					Metadata: metadata,
+				})
+			}
+		}
+		if len(vectors) == 0 {
+			return 0, nil
+		}
+
+		batches := batch(vectors, config.BatchSize)
+
+		embeddingRequests := make([]*EmbeddingRequest, len(batches))
+		for i, vectors := range batches {
+			input := make([]string, len(vectors))
+			for j, v := range vectors {
+				input[j] = v.Metadata["text"]
+			}
+			embeddingRequests[i] = &EmbeddingRequest{Model: config.Model, Input: input}
+		}
+		embeddings, err := embed.Gather(embeddingRequests)
+		if err != nil {
+			return 0, err
+		}
+
+		upsertRequests := make([]*UpsertRequest, len(batches))
+		for i, vectors := range batches {
+			if len(embeddings[i].Embeddings) != len(vectors) {
+				return 0, fmt.Errorf("%w: expected %d embeddings, got %d", dispatch.ErrInvalidResponse, len(vectors), len(embeddings[i].Embeddings))
+			}
+			for j := range vectors {
+				vectors[j].Values = embeddings[i].Embeddings[j]
+			}
+			upsertRequests[i] = &UpsertRequest{Vectors: vectors}
+		}
+		counts, err := upsert.Gather(upsertRequests)
+		if err != nil {
+			return 0, err
+		}
+
+		var total int
+		for _, n := range counts {
+			total += n
+		}
+		return total, nil
+	}), nil
+}
+
+// Chunk splits text into chunks of at most size characters, with
+// overlap characters shared between consecutive chunks.
+func Chunk(text string, size, overlap int) []string {
+	if size <= 0 {
+		panic("chunk size must be positive")
+	}
+	if overlap < 0 || overlap >= size {
+		panic("chunk overlap must be non-negative and less than the chunk size")
+	}
+	runes := []rune(text)
+	if len(runes) == 0 {
+		return nil
+	}
+	var chunks []string
+	for start := 0; ; start += size - overlap {
+		end := min(start+size, len(runes))
+		chunks = append(chunks, string(runes[start:end]))
+		if end == len(runes) {
+			break
+		}
+	}
+	return chunks
+}
+
+func batch[T any](items []T, size int) [][]T {
+	batches := make([][]T, 0, (len(items)+size-1)/size)
+	for len(items) > 0 {
+		n := min(size, len(items))
+		batches = append(batches, items[:n:n])
+		items = items[n:]
+	}
+	return batches
+}
@@ -0,0 +1,181 @@
+//go:build !durable
+
+package dispatchai
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchhttp"
+)
+
+// Vector is an embedding, along with an identifier and metadata,
+// that is stored in a VectorStore.
+type Vector struct {
+	ID       string            `json:"id"`
+	Values   []float64         `json:"values"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// VectorStore stores vectors.
+type VectorStore interface {
+	// Upsert inserts vectors, or updates vectors with the same ID.
+	Upsert(ctx context.Context, vectors []Vector) error
+}
+
+// UpsertRequest is a request to upsert vectors into a VectorStore.
+type UpsertRequest struct {
+	Vectors []Vector
+}
+
+func (r *UpsertRequest) MarshalJSON() ([]byte, error) {
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonUpsertRequest{Vectors: r.Vectors})
+}
+
+func (r *UpsertRequest) UnmarshalJSON(b []byte) error {
+	var jr jsonUpsertRequest
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return err
+	}
+	r.Vectors = jr.Vectors
+	return nil
+}
+
+type jsonUpsertRequest struct {
+	Vectors []Vector `json:"vectors"`
+}
+
+// UpsertFunc creates a Dispatch function that upserts vectors into
+// a VectorStore, and returns the number of vectors upserted.
+func UpsertFunc(name string, store VectorStore) *dispatch.Function[*UpsertRequest, int] {
+	return dispatch.Func(name, func(ctx context.Context, req *UpsertRequest) (int, error) {
+		if err := store.Upsert(ctx, req.Vectors); err != nil {
+			return 0, err
+		}
+		return len(req.Vectors), nil
+	})
+}
+
+// Pinecone is a VectorStore backed by a Pinecone index.
+type Pinecone struct {
+	// IndexHost is the host of the Pinecone index,
+	// e.g. https://my-index-abc123.svc.pinecone.io.
+	IndexHost string
+
+	// APIKey is the Pinecone API key.
+	APIKey string
+
+	// Namespace is an optional namespace within the index.
+	Namespace string
+
+	// Client is the HTTP client to use. If nil,
+	// dispatchhttp.DefaultClient is used.
+	Client *dispatchhttp.Client
+}
+
+// Upsert upserts vectors into the Pinecone index.
+func (p *Pinecone) Upsert(ctx context.Context, vectors []Vector) error {
+	body, err := json.Marshal(struct {
+		Vectors   []Vector `json:"vectors"`
+		Namespace string   `json:"namespace,omitempty"`
+	}{vectors, p.Namespace})
+	if err != nil {
+		return err
+	}
+	client := p.Client
+	if client == nil {
+		client = dispatchhttp.DefaultClient
+	}
+	res, err := client.Do(ctx, &dispatchhttp.Request{
+		Method: "POST",
+		URL:    strings.TrimSuffix(p.IndexHost, "/") + "/vectors/upsert",
+		Header: http.Header{
+			"Api-Key":      []string{p.APIKey},
+			"Content-Type": []string{"application/json"},
+		},
+		Body: body,
+	})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newAPIError(res)
+	}
+	return nil
+}
+
+// Execer executes SQL statements. It is implemented by *sql.DB,
+// *sql.Conn and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// PGVector is a VectorStore backed by a PostgreSQL table using
+// the pgvector extension.
+//
+// The table must have an id column (text, primary key), an embedding
+// column (vector) and a metadata column (jsonb). Its name may be
+// qualified with a schema (e.g. "public.items"), and is quoted in
+// queries.
+type PGVector struct {
+	DB    Execer
+	Table string
+}
+
+// Upsert upserts vectors into the table.
+func (p *PGVector) Upsert(ctx context.Context, vectors []Vector) error {
+	if len(vectors) == 0 {
+		return nil
+	}
+	var query strings.Builder
+	fmt.Fprintf(&query, "INSERT INTO %s (id, embedding, metadata) VALUES ", quoteIdentifier(p.Table))
+	args := make([]any, 0, 3*len(vectors))
+	for i, v := range vectors {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d, $%d, $%d)", n+1, n+2, n+3)
+
+		metadata, err := json.Marshal(v.Metadata)
+		if err != nil {
+			return err
+		}
+		args = append(args, v.ID, pgvectorLiteral(v.Values), string(metadata))
+	}
+	query.WriteString(" ON CONFLICT (id) DO UPDATE SET embedding = EXCLUDED.embedding, metadata = EXCLUDED.metadata")
+
+	_, err := p.DB.ExecContext(ctx, query.String(), args...)
+	return err
+}
+
+// quoteIdentifier quotes a (possibly schema-qualified) identifier, like
+// pgx.Identifier.Sanitize does with its parts, so that it can be
+// interpolated in SQL queries.
+func quoteIdentifier(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, "\x00", "")
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func pgvectorLiteral(values []float64) string {
+	b := make([]byte, 0, 2+8*len(values))
+	b = append(b, '[')
+	for i, v := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, v, 'g', -1, 32)
+	}
+	return string(append(b, ']'))
+}
@@ -0,0 +1,254 @@
+//go:build !durable
+
+// Package dispatchbackfill re-dispatches historical inputs to a function,
+// e.g. to reprocess records after a bug fix.
+package dispatchbackfill
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// DefaultBatchSize is the default number of calls dispatched per batch.
+const DefaultBatchSize = 100
+
+// Source is a source of inputs.
+type Source[I any] interface {
+	// Next returns the next input, or io.EOF if there are no more
+	// inputs.
+	Next() (I, error)
+}
+
+// SourceFunc is a Source implemented by a function.
+type SourceFunc[I any] func() (I, error)
+
+// Next calls f().
+func (f SourceFunc[I]) Next() (I, error) {
+	return f()
+}
+
+// JSONL creates a Source that decodes a stream of JSON values, such as
+// newline-delimited JSON, from a reader.
+func JSONL[I any](r io.Reader) Source[I] {
+	decoder := json.NewDecoder(r)
+	return SourceFunc[I](func() (I, error) {
+		var input I
+		err := decoder.Decode(&input)
+		return input, err
+	})
+}
+
+// CSV creates a Source that reads CSV records from a reader.
+//
+// If the input has a header row, the first input is the header.
+func CSV(r io.Reader) Source[[]string] {
+	reader := csv.NewReader(r)
+	return SourceFunc[[]string](reader.Read)
+}
+
+// Checkpoint records how far a backfill has progressed, so that it can
+// be resumed after an interruption.
+type Checkpoint interface {
+	// Load returns the number of inputs that were dispatched by prior
+	// runs, or zero if there were none.
+	Load() (int64, error)
+
+	// Save records the number of inputs that have been dispatched.
+	Save(n int64) error
+}
+
+// FileCheckpoint creates a Checkpoint that is stored in a file.
+//
+// The file doesn't need to exist before the backfill starts. It should
+// be deleted to restart a backfill from the beginning.
+func FileCheckpoint(path string) Checkpoint {
+	return fileCheckpoint(path)
+}
+
+type fileCheckpoint string
+
+func (path fileCheckpoint) Load() (int64, error) {
+	b, err := os.ReadFile(string(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	return n, nil
+}
+
+func (path fileCheckpoint) Save(n int64) error {
+	// Write to a temporary file first so that the checkpoint is never
+	// left truncated.
+	tmp := string(path) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(n, 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, string(path))
+}
+
+// Progress is the progress of a backfill.
+type Progress struct {
+	// Skipped is the number of inputs that were skipped because they
+	// were dispatched by prior runs (see WithCheckpoint).
+	Skipped int64
+
+	// Dispatched is the number of inputs that were dispatched by this
+	// run.
+	Dispatched int64
+
+	// Batches is the number of batches that were dispatched by this
+	// run.
+	Batches int
+
+	// Elapsed is the time since the backfill started.
+	Elapsed time.Duration
+}
+
+// Option configures a backfill.
+type Option func(*config)
+
+type config struct {
+	batchSize  int
+	interval   time.Duration
+	checkpoint Checkpoint
+	progress   func(Progress)
+	callOpts   []dispatchproto.CallOption
+}
+
+// BatchSize sets the maximum number of calls dispatched per batch. It
+// defaults to DefaultBatchSize.
+func BatchSize(n int) Option {
+	return func(c *config) { c.batchSize = n }
+}
+
+// BatchInterval sets the minimum interval between batches, which
+// limits the rate at which calls are dispatched. There's no limit by
+// default.
+func BatchInterval(interval time.Duration) Option {
+	return func(c *config) { c.interval = interval }
+}
+
+// WithCheckpoint sets the Checkpoint used to resume the backfill.
+//
+// Inputs that were dispatched by prior runs are skipped, and the
+// checkpoint is saved after each batch. Inputs must be read from the
+// source in the same order each time.
+func WithCheckpoint(checkpoint Checkpoint) Option {
+	return func(c *config) { c.checkpoint = checkpoint }
+}
+
+// OnProgress sets a function that is called with the progress of the
+// backfill after each batch.
+func OnProgress(fn func(Progress)) Option {
+	return func(c *config) { c.progress = fn }
+}
+
+// CallOptions sets options for each call that is dispatched.
+func CallOptions(opts ...dispatchproto.CallOption) Option {
+	return func(c *config) { c.callOpts = opts }
+}
+
+// Run dispatches a call to a function for each input from a source,
+// in batches. The function must be registered on an endpoint, so that
+// calls are routed to it.
+//
+// Run returns when the source has no more inputs, or at the first
+// error. The progress made so far is returned in both cases. If a
+// batch cannot be dispatched, the checkpoint (if any) isn't advanced,
+// so the batch is dispatched again when the backfill is resumed.
+func Run[I, O any](ctx context.Context, client *dispatchclient.Client, fn *dispatch.Function[I, O], source Source[I], opts ...Option) (Progress, error) {
+	c := config{batchSize: DefaultBatchSize}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.batchSize <= 0 {
+		return Progress{}, fmt.Errorf("invalid batch size: %d", c.batchSize)
+	}
+
+	start := time.Now()
+	var progress Progress
+
+	if c.checkpoint != nil {
+		n, err := c.checkpoint.Load()
+		if err != nil {
+			return progress, fmt.Errorf("cannot load checkpoint: %w", err)
+		}
+		for ; progress.Skipped < n; progress.Skipped++ {
+			if _, err := source.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return progress, fmt.Errorf("cannot read input %d: %w", progress.Skipped, err)
+			}
+		}
+	}
+
+	batch := client.Batch()
+	var lastBatch time.Time
+	for done := false; !done; {
+		batch.Reset()
+		size := 0
+		for size < c.batchSize {
+			input, err := source.Next()
+			if err == io.EOF {
+				done = true
+				break
+			} else if err != nil {
+				return progress, fmt.Errorf("cannot read input %d: %w", progress.Skipped+progress.Dispatched+int64(size), err)
+			}
+			call, err := fn.BuildCall(input, c.callOpts...)
+			if err != nil {
+				return progress, fmt.Errorf("input %d: %w", progress.Skipped+progress.Dispatched+int64(size), err)
+			}
+			batch.Add(call)
+			size++
+		}
+		if size == 0 {
+			break
+		}
+
+		if wait := c.interval - time.Since(lastBatch); !lastBatch.IsZero() && wait > 0 {
+			select {
+			case <-ctx.Done():
+				return progress, context.Cause(ctx)
+			case <-time.After(wait):
+			}
+		}
+		lastBatch = time.Now()
+
+		if _, err := batch.Dispatch(ctx); err != nil {
+			return progress, err
+		}
+		progress.Dispatched += int64(size)
+		progress.Batches++
+		progress.Elapsed = time.Since(start)
+
+		if c.checkpoint != nil {
+			if err := c.checkpoint.Save(progress.Skipped + progress.Dispatched); err != nil {
+				return progress, fmt.Errorf("cannot save checkpoint: %w", err)
+			}
+		}
+		if c.progress != nil {
+			c.progress(progress)
+		}
+	}
+
+	progress.Elapsed = time.Since(start)
+	return progress, nil
+}
@@ -0,0 +1,116 @@
+package dispatchbackfill_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchbackfill"
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestRun(t *testing.T) {
+	var batches [][]int
+	var fail bool
+	server := dispatchtest.NewServer(dispatchserver.HandlerFunc(func(ctx context.Context, header http.Header, calls []dispatchproto.Call) ([]dispatchproto.ID, error) {
+		if fail {
+			return nil, errors.New("unavailable")
+		}
+		var inputs []int
+		for _, call := range calls {
+			var n int
+			if err := call.Input().Unmarshal(&n); err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, n)
+		}
+		batches = append(batches, inputs)
+		fail = len(batches) == 2
+		return make([]dispatchproto.ID, len(calls)), nil
+	}))
+	defer server.Close()
+
+	client, err := dispatchclient.New(dispatchclient.APIKey("foobar"), dispatchclient.APIUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	if _, err := dispatch.New(dispatch.EndpointUrl("http://example.com"), dispatch.Client(client), fn); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint := dispatchbackfill.FileCheckpoint(filepath.Join(t.TempDir(), "checkpoint"))
+	const input = "1\n2\n3\n4\n5\n6\n7\n"
+
+	// The third batch fails, and the backfill stops.
+	var reported []dispatchbackfill.Progress
+	progress, err := dispatchbackfill.Run(context.Background(), client, fn,
+		dispatchbackfill.JSONL[int](strings.NewReader(input)),
+		dispatchbackfill.BatchSize(2),
+		dispatchbackfill.BatchInterval(time.Millisecond),
+		dispatchbackfill.WithCheckpoint(checkpoint),
+		dispatchbackfill.OnProgress(func(p dispatchbackfill.Progress) { reported = append(reported, p) }),
+	)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if progress.Dispatched != 4 || progress.Batches != 2 || progress.Skipped != 0 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	if len(reported) != 2 || reported[1].Dispatched != 4 {
+		t.Errorf("unexpected progress reports: %+v", reported)
+	}
+
+	// Resuming skips the inputs that were dispatched.
+	fail = false
+	progress, err = dispatchbackfill.Run(context.Background(), client, fn,
+		dispatchbackfill.JSONL[int](strings.NewReader(input)),
+		dispatchbackfill.BatchSize(2),
+		dispatchbackfill.WithCheckpoint(checkpoint),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Dispatched != 3 || progress.Batches != 2 || progress.Skipped != 4 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+
+	want := [][]int{{1, 2}, {3, 4}, {5, 6}, {7}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("unexpected batches: got %v, want %v", batches, want)
+	}
+	if n, err := checkpoint.Load(); err != nil {
+		t.Fatal(err)
+	} else if n != 7 {
+		t.Errorf("unexpected checkpoint: got %d, want 7", n)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	source := dispatchbackfill.CSV(strings.NewReader("a,b\n1,2\n"))
+
+	var records [][]string
+	for {
+		record, err := source.Next()
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	want := [][]string{{"a", "b"}, {"1", "2"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("unexpected records: got %v, want %v", records, want)
+	}
+}
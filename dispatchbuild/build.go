@@ -0,0 +1,272 @@
+//go:build !durable
+
+// Package dispatchbuild builds durable Dispatch programs.
+//
+// Running functions in durable mode requires compiling them with coroc,
+// which generates *_durable.go files next to the sources of the
+// program. Build runs the compilation in a copy of the module instead,
+// so the sources are left untouched, and builds a binary from the copy
+// with the durable build tag.
+package dispatchbuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CorocPackage is the package of coroc, the durable coroutine compiler.
+const CorocPackage = "github.com/dispatchrun/coroutine/compiler/cmd/coroc"
+
+// Option configures a build.
+type Option func(*config)
+
+type config struct {
+	dir        string
+	coroc      string
+	buildFlags []string
+	env        []string
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+// Dir sets the directory that the package path is relative to. It
+// defaults to the current working directory. The module of the package
+// is the module that contains the directory.
+func Dir(dir string) Option {
+	return func(c *config) { c.dir = dir }
+}
+
+// Coroc sets the path of the coroc binary. By default, coroc is looked
+// up in the PATH.
+func Coroc(path string) Option {
+	return func(c *config) { c.coroc = path }
+}
+
+// BuildFlags sets extra flags passed to go build, e.g. -trimpath or
+// -ldflags.
+func BuildFlags(flags ...string) Option {
+	return func(c *config) { c.buildFlags = flags }
+}
+
+// Env sets extra environment variables for coroc and go build, in the
+// form "key=value".
+func Env(env ...string) Option {
+	return func(c *config) { c.env = env }
+}
+
+// Output sets the writers that the output of coroc and go build are
+// written to. The output is discarded by default, and included in the
+// error if a step fails.
+func Output(stdout, stderr io.Writer) Option {
+	return func(c *config) { c.stdout, c.stderr = stdout, stderr }
+}
+
+// Build compiles the main package pkg in durable mode, and writes the
+// binary to output.
+//
+// The module that contains the package is copied to a temporary
+// directory, where coroc generates the durable version of the package
+// before it's built with the durable build tag. The temporary directory
+// is removed when Build returns.
+func Build(ctx context.Context, pkg, output string, opts ...Option) error {
+	c := config{dir: "."}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	coroc := c.coroc
+	if coroc == "" {
+		var err error
+		if coroc, err = exec.LookPath("coroc"); err != nil {
+			return fmt.Errorf("coroc not found (install it with go install %s@latest): %w", CorocPackage, err)
+		}
+	}
+
+	dir, err := filepath.Abs(c.dir)
+	if err != nil {
+		return err
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	moduleDir, err := findModule(dir)
+	if err != nil {
+		return err
+	}
+	pkgDir, err := filepath.Rel(moduleDir, filepath.Join(dir, pkg))
+	if err != nil {
+		return err
+	}
+	if pkgDir == ".." || strings.HasPrefix(pkgDir, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("package %s is outside of module %s", pkg, moduleDir)
+	}
+	pkgPath := "./" + filepath.ToSlash(pkgDir)
+
+	tmp, err := os.MkdirTemp("", "dispatchbuild-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := copyModule(tmp, moduleDir); err != nil {
+		return fmt.Errorf("cannot copy module %s: %w", moduleDir, err)
+	}
+	if err := c.fixReplacements(ctx, tmp, moduleDir); err != nil {
+		return fmt.Errorf("cannot rewrite replace directives: %w", err)
+	}
+	if err := c.run(ctx, tmp, coroc, pkgPath); err != nil {
+		return fmt.Errorf("coroc: %w", err)
+	}
+	args := append([]string{"build", "-tags", "durable", "-o", output}, c.buildFlags...)
+	if err := c.run(ctx, tmp, "go", append(args, pkgPath)...); err != nil {
+		return fmt.Errorf("go build: %w", err)
+	}
+	return nil
+}
+
+func (c *config) run(ctx context.Context, dir, name string, args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if c.stdout != nil {
+		cmd.Stdout = io.MultiWriter(&out, c.stdout)
+	}
+	if c.stderr != nil {
+		cmd.Stderr = io.MultiWriter(&out, c.stderr)
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("%w\n%s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// fixReplacements rewrites the replace directives of the copy of the
+// module that point to relative paths, so that they still point to the
+// same directories.
+func (c *config) fixReplacements(ctx context.Context, tmp, moduleDir string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "mod", "edit", "-json")
+	cmd.Dir = tmp
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	var mod struct {
+		Replace []struct {
+			Old struct{ Path, Version string }
+			New struct{ Path, Version string }
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &mod); err != nil {
+		return err
+	}
+	var args []string
+	for _, r := range mod.Replace {
+		if r.New.Version != "" || filepath.IsAbs(r.New.Path) {
+			continue
+		}
+		old := r.Old.Path
+		if r.Old.Version != "" {
+			old += "@" + r.Old.Version
+		}
+		args = append(args, "-replace", old+"="+filepath.Join(moduleDir, r.New.Path))
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return c.run(ctx, tmp, "go", append([]string{"mod", "edit"}, args...)...)
+}
+
+func findModule(dir string) (string, error) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// copyModule copies the files of a module, skipping version control
+// directories, nested modules and durable files generated previously.
+func copyModule(dst, src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			if rel != "." {
+				switch d.Name() {
+				case ".git", ".hg", ".svn":
+					return filepath.SkipDir
+				}
+				if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+			return os.MkdirAll(target, 0755)
+		case strings.HasSuffix(d.Name(), "_durable.go"):
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular():
+			return nil
+		}
+		return copyFile(target, path)
+	})
+}
+
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
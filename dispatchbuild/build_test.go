@@ -0,0 +1,126 @@
+package dispatchbuild_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchbuild"
+)
+
+func TestBuild(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("coroc stub requires a POSIX shell")
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"lib/go.mod": "module example.com/lib\n\ngo 1.22\n",
+		"lib/lib.go": "package lib\n\nconst Name = \"lib\"\n",
+
+		"app/go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/lib v0.0.0\n\nreplace example.com/lib => ../lib\n",
+		"app/cmd/app/main.go": `//go:build !durable
+
+package main
+
+func main() { panic("not durable") }
+`,
+		"app/cmd/app/durable.go": `//go:build durable
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/lib"
+)
+
+func main() {
+	b, _ := os.ReadFile("coroc.out")
+	fmt.Print(lib.Name, " ", string(b))
+}
+`,
+	})
+
+	// The coroc stub records the package it compiles.
+	coroc := filepath.Join(root, "coroc")
+	writeFiles(t, root, map[string]string{
+		"coroc": "#!/bin/sh\necho \"$@\" > \"$OUT\"\n",
+	})
+	if err := os.Chmod(coroc, 0755); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(root, "coroc.out")
+
+	bin := filepath.Join(root, "bin", "app")
+	err := dispatchbuild.Build(context.Background(), "./cmd/app", bin,
+		dispatchbuild.Dir(filepath.Join(root, "app")),
+		dispatchbuild.Coroc(coroc),
+		dispatchbuild.Env("OUT="+out),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(bin)
+	cmd.Dir = root
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	if got, want := strings.TrimSpace(string(output)), "lib ./cmd/app"; got != want {
+		t.Errorf("unexpected output: got %q, want %q", got, want)
+	}
+
+	// The sources of the module are left untouched.
+	b, err := os.ReadFile(filepath.Join(root, "app", "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(b), "=> ../lib") {
+		t.Errorf("go.mod was modified:\n%s", b)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"app/go.mod": "module example.com/app\n\ngo 1.22\n",
+	})
+
+	err := dispatchbuild.Build(context.Background(), "../other", filepath.Join(root, "bin"),
+		dispatchbuild.Dir(filepath.Join(root, "app")),
+		dispatchbuild.Coroc("coroc"),
+	)
+	if err == nil || !strings.Contains(err.Error(), "outside of module") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = dispatchbuild.Build(context.Background(), ".", filepath.Join(root, "bin"),
+		dispatchbuild.Dir(filepath.Join(root, "app")),
+		dispatchbuild.Coroc(filepath.Join(root, "missing")),
+	)
+	if err == nil || !strings.HasPrefix(err.Error(), "coroc:") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
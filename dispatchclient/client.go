@@ -4,17 +4,22 @@ package dispatchclient
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"time"
 	_ "unsafe"
 
 	"buf.build/gen/go/stealthrocket/dispatch-proto/connectrpc/go/dispatch/sdk/v1/sdkv1connect"
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	"connectrpc.com/connect"
-	"connectrpc.com/validate"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/internal/auth"
 	"github.com/dispatchrun/dispatch-go/internal/env"
+	"github.com/dispatchrun/dispatch-go/internal/validator"
 )
 
 const defaultApiUrl = "https://api.dispatch.run"
@@ -28,9 +33,24 @@ type Client struct {
 	apiUrl        string
 	env           []string
 	httpClient    *http.Client
+	faults        *Faults
+	resolver      EndpointResolver
 	opts          []Option
 
-	client sdkv1connect.DispatchServiceClient
+	fallbackUrls []string
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	regions      []*region
+
+	idempotencyWindow time.Duration
+	idempotencyKeys   *idempotencyKeys
+	now               func() time.Time
+
+	signingKey   ed25519.PrivateKey
+	signingKeyID string
+	signer       *auth.Signer
+
+	logger *slog.Logger
 }
 
 // New creates a Client.
@@ -62,21 +82,52 @@ func New(opts ...Option) (*Client, error) {
 		c.httpClient = http.DefaultClient
 	}
 
+	if c.minBackoff <= 0 {
+		c.minBackoff = defaultMinFailoverBackoff
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = defaultMaxFailoverBackoff
+	}
+	if c.maxBackoff < c.minBackoff {
+		return nil, fmt.Errorf("invalid failover backoff: max %v is less than min %v", c.maxBackoff, c.minBackoff)
+	}
+
+	if c.now == nil {
+		c.now = time.Now
+	}
+
+	if c.idempotencyWindow <= 0 {
+		c.idempotencyWindow = defaultIdempotencyWindow
+	}
+	c.idempotencyKeys = newIdempotencyKeys(c.idempotencyWindow)
+
 	authenticator := connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
 		authorization := "Bearer " + c.apiKey
 		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
-			req.Header().Add("Authorization", authorization)
+			req.Header().Set("Authorization", authorization)
 			return next(ctx, req)
 		}
 	})
 
-	validator, err := validate.NewInterceptor()
+	validators, err := validator.Interceptors()
 	if err != nil {
 		return nil, err
 	}
 
-	c.client = sdkv1connect.NewDispatchServiceClient(c.httpClient, c.apiUrl,
-		connect.WithInterceptors(validator, authenticator))
+	interceptors := append(validators, authenticator)
+	if c.faults != nil {
+		interceptors = append(interceptors, c.faults.interceptor())
+	}
+
+	httpClient := c.signingClient(c.httpClient)
+
+	for _, url := range append([]string{c.apiUrl}, c.fallbackUrls...) {
+		c.regions = append(c.regions, &region{
+			url: url,
+			client: sdkv1connect.NewDispatchServiceClient(httpClient, url,
+				connect.WithInterceptors(interceptors...)),
+		})
+	}
 
 	return c, nil
 }
@@ -109,6 +160,21 @@ func Env(env ...string) Option {
 	return func(c *Client) { c.env = env }
 }
 
+// Logger sets the logger of the Client. Dispatched calls are logged at
+// the Debug level, and failures at the Warn level.
+//
+// It defaults to slog.Default().
+func Logger(logger *slog.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+func (c *Client) log() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
 // Dispatch dispatches a function call.
 func (c *Client) Dispatch(ctx context.Context, call dispatchproto.Call) (dispatchproto.ID, error) {
 	batch := c.Batch()
@@ -129,19 +195,36 @@ func (c *Client) Batch() Batch {
 type Batch struct {
 	client *Client
 
-	calls []*sdkv1.Call
+	calls  []*sdkv1.Call
+	keys   []string
+	header http.Header
+	err    error
 }
 
 // Reset resets the batch.
 func (b *Batch) Reset() {
 	clear(b.calls)
 	b.calls = b.calls[:0]
+	b.keys = b.keys[:0]
+	clear(b.header)
+	b.err = nil
 }
 
 // Add adds calls to the batch.
+//
+// If the endpoint URL of a call cannot be resolved (see
+// ResolveEndpoints), the error is returned by Dispatch.
 func (b *Batch) Add(calls ...dispatchproto.Call) {
-	for i := range calls {
-		b.calls = append(b.calls, callProto(calls[i]))
+	for _, call := range calls {
+		if resolver := b.client.resolver; resolver != nil {
+			var err error
+			if call, err = ResolveEndpoint(resolver, call); err != nil {
+				b.err = errors.Join(b.err, err)
+				continue
+			}
+		}
+		b.calls = append(b.calls, callProto(call))
+		b.keys = append(b.keys, call.IdempotencyKey())
 	}
 }
 
@@ -149,25 +232,55 @@ func (b *Batch) Add(calls ...dispatchproto.Call) {
 func callProto(r dispatchproto.Call) *sdkv1.Call
 
 // Dispatch dispatches the batch of function calls.
+//
+// Calls with an idempotency key that was dispatched recently are not
+// dispatched again (see IdempotencyWindow).
 func (b *Batch) Dispatch(ctx context.Context) ([]dispatchproto.ID, error) {
-	req := connect.NewRequest(&sdkv1.DispatchRequest{Calls: b.calls})
-	res, err := b.client.client.Dispatch(ctx, req)
-	if err != nil {
-		if connect.CodeOf(err) == connect.CodeUnauthenticated {
-			if b.client.apiKeyFromEnv {
-				return nil, fmt.Errorf("invalid DISPATCH_API_KEY: %s", redactAPIKey(b.client.apiKey))
-			}
-			return nil, fmt.Errorf("invalid Dispatch API key provided with APIKey(..): %s", redactAPIKey(b.client.apiKey))
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.header) > 0 {
+		ctx = WithHeader(ctx, b.header)
+	}
+	for _, key := range b.keys {
+		if key != "" {
+			return b.dispatchIdempotent(ctx)
 		}
+	}
+	return b.client.dispatch(ctx, b.calls)
+}
+
+func (c *Client) dispatch(ctx context.Context, calls []*sdkv1.Call) ([]dispatchproto.ID, error) {
+	req := connect.NewRequest(&sdkv1.DispatchRequest{Calls: calls})
+	addHeader(ctx, req.Header())
+	var res *connect.Response[sdkv1.DispatchResponse]
+	err := c.failover(ctx, func(r *region) (err error) {
+		res, err = r.client.Dispatch(ctx, req)
+		return err
+	})
+	if err != nil {
+		err = c.apiError(err)
+		c.log().WarnContext(ctx, "cannot dispatch function calls", "calls", len(calls), "error", err)
 		return nil, err
 	}
 	ids := make([]dispatchproto.ID, len(res.Msg.DispatchIds))
 	for i, id := range res.Msg.DispatchIds {
 		ids[i] = dispatchproto.ID(id)
 	}
+	c.log().DebugContext(ctx, "dispatched function calls", "calls", len(calls), "dispatch_ids", ids)
 	return ids, nil
 }
 
+func (c *Client) apiError(err error) error {
+	if connect.CodeOf(err) == connect.CodeUnauthenticated {
+		if c.apiKeyFromEnv {
+			return fmt.Errorf("invalid DISPATCH_API_KEY: %s", redactAPIKey(c.apiKey))
+		}
+		return fmt.Errorf("invalid Dispatch API key provided with APIKey(..): %s", redactAPIKey(c.apiKey))
+	}
+	return err
+}
+
 func redactAPIKey(s string) string {
 	if len(s) <= 3 {
 		// Don't redact the string if it's this short. It's not a valid API
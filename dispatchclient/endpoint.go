@@ -0,0 +1,73 @@
+//go:build !durable
+
+package dispatchclient
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// EndpointResolver resolves the URL of the endpoint that a function
+// call is sent to.
+//
+// Resolvers allow calls to the same function to be routed to different
+// endpoints, e.g. to shard tenants across deployments. The call passed
+// to ResolveEndpoint carries its default endpoint URL, if any.
+type EndpointResolver interface {
+	ResolveEndpoint(call dispatchproto.Call) (string, error)
+}
+
+// EndpointResolverFunc is an EndpointResolver implemented by a function.
+type EndpointResolverFunc func(call dispatchproto.Call) (string, error)
+
+// ResolveEndpoint calls f(call).
+func (f EndpointResolverFunc) ResolveEndpoint(call dispatchproto.Call) (string, error) {
+	return f(call)
+}
+
+// EndpointTemplate returns an EndpointResolver that expands a URL
+// template, e.g. "https://{shard}.example.com/{tenant}". Each {name}
+// placeholder is replaced with the path-escaped value of the parameter
+// of the same name returned by params.
+func EndpointTemplate(template string, params func(call dispatchproto.Call) (map[string]string, error)) EndpointResolver {
+	return EndpointResolverFunc(func(call dispatchproto.Call) (string, error) {
+		values, err := params(call)
+		if err != nil {
+			return "", err
+		}
+		var missing string
+		endpoint := templateParam.ReplaceAllStringFunc(template, func(param string) string {
+			name := param[1 : len(param)-1]
+			value, ok := values[name]
+			if !ok && missing == "" {
+				missing = name
+			}
+			return url.PathEscape(value)
+		})
+		if missing != "" {
+			return "", fmt.Errorf("missing endpoint template parameter %q", missing)
+		}
+		return endpoint, nil
+	})
+}
+
+var templateParam = regexp.MustCompile(`\{[a-zA-Z0-9_]+\}`)
+
+// ResolveEndpoints sets a resolver for the endpoint URL of function
+// calls dispatched by the Client.
+func ResolveEndpoints(resolver EndpointResolver) Option {
+	return func(c *Client) { c.resolver = resolver }
+}
+
+// ResolveEndpoint resolves the endpoint URL of a call with resolver,
+// and returns the call with the resolved URL.
+func ResolveEndpoint(resolver EndpointResolver, call dispatchproto.Call) (dispatchproto.Call, error) {
+	endpoint, err := resolver.ResolveEndpoint(call)
+	if err != nil {
+		return call, fmt.Errorf("cannot resolve endpoint of function %s: %w", call.Function(), err)
+	}
+	return call.With(dispatchproto.Endpoint(endpoint)), nil
+}
@@ -0,0 +1,60 @@
+package dispatchclient_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestClientResolveEndpoints(t *testing.T) {
+	recorder := &dispatchtest.CallRecorder{}
+	server := dispatchtest.NewServer(recorder)
+
+	shards := map[string]string{"function1": "eu", "function2": "us"}
+	resolver := dispatchclient.EndpointTemplate("https://{shard}.example.com/{function}", func(call dispatchproto.Call) (map[string]string, error) {
+		return map[string]string{
+			"shard":    shards[call.Function()],
+			"function": call.Function(),
+		}, nil
+	})
+
+	client, err := dispatchclient.New(
+		dispatchclient.APIKey("foobar"),
+		dispatchclient.APIUrl(server.URL),
+		dispatchclient.ResolveEndpoints(resolver),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call1 := dispatchproto.NewCall("http://example.com", "function1", dispatchproto.Int(11))
+	call2 := dispatchproto.NewCall("", "function2", dispatchproto.Int(22))
+
+	batch := client.Batch()
+	batch.Add(call1, call2)
+	if _, err := batch.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.Assert(t, dispatchtest.DispatchRequest{
+		Header: http.Header{"Authorization": []string{"Bearer foobar"}},
+		Calls: []dispatchproto.Call{
+			call1.With(dispatchproto.Endpoint("https://eu.example.com/function1")),
+			call2.With(dispatchproto.Endpoint("https://us.example.com/function2")),
+		},
+	})
+}
+
+func TestEndpointTemplateMissingParam(t *testing.T) {
+	resolver := dispatchclient.EndpointTemplate("https://{shard}.example.com", func(call dispatchproto.Call) (map[string]string, error) {
+		return nil, nil
+	})
+	call := dispatchproto.NewCall("", "function1")
+	if _, err := dispatchclient.ResolveEndpoint(resolver, call); err == nil {
+		t.Fatal("expected error for missing template parameter")
+	}
+}
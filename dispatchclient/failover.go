@@ -0,0 +1,159 @@
+//go:build !durable
+
+package dispatchclient
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"buf.build/gen/go/stealthrocket/dispatch-proto/connectrpc/go/dispatch/sdk/v1/sdkv1connect"
+	"connectrpc.com/connect"
+)
+
+const (
+	defaultMinFailoverBackoff = time.Second
+	defaultMaxFailoverBackoff = time.Minute
+)
+
+// FallbackAPIUrls sets the URLs of Dispatch API regions that a Client
+// fails over to when the primary Dispatch API (see APIUrl) is unavailable,
+// e.g. during a regional outage. Regions are tried in order.
+//
+// A region is considered unavailable when requests to it fail with
+// connect.CodeUnavailable (e.g. connection failures, or HTTP 502, 503
+// and 504 responses). Requests aren't sent to an unavailable region
+// until a backoff elapses (see FailoverBackoff), unless all regions are
+// unavailable. Since a request may have been processed by a region
+// before it failed, failing over may dispatch function calls twice,
+// which Dispatch's at-least-once execution guarantees allow for.
+func FallbackAPIUrls(urls ...string) Option {
+	return func(c *Client) { c.fallbackUrls = urls }
+}
+
+// FailoverBackoff sets the range of durations during which a region
+// that is unavailable is avoided (see FallbackAPIUrls). The backoff
+// doubles each time a region fails, from min up to max, and is reset
+// when a request to the region succeeds.
+//
+// It defaults to a range of one second to one minute.
+func FailoverBackoff(min, max time.Duration) Option {
+	return func(c *Client) { c.minBackoff, c.maxBackoff = min, max }
+}
+
+// RegionStatus is the health of a region of the Dispatch API.
+type RegionStatus struct {
+	// URL is the URL of the region.
+	URL string
+
+	// Available is true if requests are sent to the region.
+	Available bool
+
+	// Failures is the number of consecutive failures of requests
+	// to the region.
+	Failures int
+
+	// RetryAt is the time after which requests are sent to a region
+	// that is unavailable.
+	RetryAt time.Time
+}
+
+// Regions returns the status of the regions of the Dispatch API, the
+// primary region first.
+func (c *Client) Regions() []RegionStatus {
+	now := time.Now()
+	statuses := make([]RegionStatus, len(c.regions))
+	for i, r := range c.regions {
+		statuses[i] = r.status(now)
+	}
+	return statuses
+}
+
+type region struct {
+	url    string
+	client sdkv1connect.DispatchServiceClient
+
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+}
+
+func (r *region) status(now time.Time) RegionStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return RegionStatus{
+		URL:       r.url,
+		Available: !now.Before(r.retryAt),
+		Failures:  r.failures,
+		RetryAt:   r.retryAt,
+	}
+}
+
+func (r *region) succeeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = 0
+	r.retryAt = time.Time{}
+}
+
+func (r *region) failed(now time.Time, minBackoff, maxBackoff time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	backoff := minBackoff
+	for i := 0; i < r.failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	r.failures++
+	r.retryAt = now.Add(backoff)
+}
+
+// failover sends a request to the regions of the Dispatch API, in order
+// of preference, until one of them is available.
+func (c *Client) failover(ctx context.Context, send func(*region) error) error {
+	regions := c.regions
+	if len(regions) > 1 {
+		// Prefer available regions, then the ones that will be
+		// available the soonest.
+		now := time.Now()
+		regions = slices.Clone(regions)
+		slices.SortStableFunc(regions, func(a, b *region) int {
+			sa, sb := a.status(now), b.status(now)
+			switch {
+			case sa.Available && sb.Available:
+				return 0
+			case sa.Available:
+				return -1
+			case sb.Available:
+				return 1
+			default:
+				return sa.RetryAt.Compare(sb.RetryAt)
+			}
+		})
+	}
+
+	var errs []error
+	for _, r := range regions {
+		err := send(r)
+		if ctx.Err() != nil {
+			return err
+		}
+		if connect.CodeOf(err) != connect.CodeUnavailable {
+			// The region is reachable, even if the request failed.
+			r.succeeded()
+			return err
+		}
+		r.failed(time.Now(), c.minBackoff, c.maxBackoff)
+		c.log().WarnContext(ctx, "Dispatch API region is unavailable", "url", r.url, "error", err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package dispatchclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestClientFailover(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	recorder := &dispatchtest.CallRecorder{}
+	fallback := dispatchtest.NewServer(recorder)
+	defer fallback.Close()
+
+	client, err := dispatchclient.New(
+		dispatchclient.APIKey("foobar"),
+		dispatchclient.APIUrl(primary.URL),
+		dispatchclient.FallbackAPIUrls(fallback.URL),
+		dispatchclient.FailoverBackoff(time.Hour, time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call1 := dispatchproto.NewCall("http://example.com", "function1", dispatchproto.Int(11))
+	call2 := dispatchproto.NewCall("http://example.com", "function2", dispatchproto.Int(22))
+
+	if _, err := client.Dispatch(context.Background(), call1); err != nil {
+		t.Fatal(err)
+	}
+
+	regions := client.Regions()
+	if len(regions) != 2 {
+		t.Fatalf("unexpected regions: %v", regions)
+	}
+	if r := regions[0]; r.URL != primary.URL || r.Available || r.Failures != 1 {
+		t.Errorf("unexpected primary region status: %+v", r)
+	}
+	if r := regions[1]; r.URL != fallback.URL || !r.Available || r.Failures != 0 {
+		t.Errorf("unexpected fallback region status: %+v", r)
+	}
+
+	// The primary region is avoided until the backoff elapses.
+	primary.Close()
+	if _, err := client.Dispatch(context.Background(), call2); err != nil {
+		t.Fatal(err)
+	}
+	if r := client.Regions()[0]; r.Failures != 1 {
+		t.Errorf("unexpected primary region status: %+v", r)
+	}
+
+	recorder.Assert(t,
+		dispatchtest.DispatchRequest{
+			Header: http.Header{"Authorization": []string{"Bearer foobar"}},
+			Calls:  []dispatchproto.Call{call1},
+		},
+		dispatchtest.DispatchRequest{
+			Header: http.Header{"Authorization": []string{"Bearer foobar"}},
+			Calls:  []dispatchproto.Call{call2},
+		},
+	)
+}
+
+func TestClientFailoverUnavailable(t *testing.T) {
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primary.Close()
+	fallback := httptest.NewServer(http.NotFoundHandler())
+	fallback.Close()
+
+	client, err := dispatchclient.New(
+		dispatchclient.APIKey("foobar"),
+		dispatchclient.APIUrl(primary.URL),
+		dispatchclient.FallbackAPIUrls(fallback.URL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := dispatchproto.NewCall("http://example.com", "function1", dispatchproto.Int(11))
+	if _, err := client.Dispatch(context.Background(), call); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, r := range client.Regions() {
+		if r.Available || r.Failures != 1 {
+			t.Errorf("unexpected region status: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+//go:build !durable
+
+package dispatchclient
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+)
+
+// Faults configures the faults injected by a Client created with the
+// FaultyTransport option.
+type Faults struct {
+	// ErrorRate is the probability, between 0 and 1, that a request to
+	// the Dispatch API fails. The request is not sent when it fails.
+	ErrorRate float64
+
+	// Codes are the codes of the errors returned for failed requests.
+	// A code is picked at random for each failure. It defaults to
+	// connect.CodeUnavailable.
+	Codes []connect.Code
+
+	// Latency returns the latency added to each request, e.g.
+	// UniformLatency(10*time.Millisecond, 100*time.Millisecond).
+	// No latency is added if it's nil.
+	Latency func() time.Duration
+
+	// Seed seeds the random number generator used to inject faults,
+	// which makes the sequence of faults reproducible. A random seed
+	// is used if it's zero.
+	Seed uint64
+}
+
+// ErrFaultInjected is wrapped by the errors injected by a Client created
+// with the FaultyTransport option.
+var ErrFaultInjected = errors.New("fault injected")
+
+// FaultyTransport injects faults in requests to the Dispatch API.
+//
+// It's intended for resilience testing, to exercise the error handling
+// of code paths that dispatch function calls (e.g. retries) without
+// external tooling. Injected errors are connect errors with the
+// configured codes, like the errors returned by the Dispatch API, and
+// wrap ErrFaultInjected.
+func FaultyTransport(faults Faults) Option {
+	return func(c *Client) { c.faults = &faults }
+}
+
+// UniformLatency returns a latency distribution for Faults.Latency that
+// is uniform between min and max.
+func UniformLatency(min, max time.Duration) func() time.Duration {
+	return func() time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + rand.N(max-min)
+	}
+}
+
+func (f *Faults) interceptor() connect.UnaryInterceptorFunc {
+	seed := f.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	var mu sync.Mutex
+	prng := rand.New(rand.NewPCG(seed, seed))
+
+	codes := f.Codes
+	if len(codes) == 0 {
+		codes = []connect.Code{connect.CodeUnavailable}
+	}
+
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			mu.Lock()
+			fail := prng.Float64() < f.ErrorRate
+			code := codes[prng.IntN(len(codes))]
+			mu.Unlock()
+
+			if f.Latency != nil {
+				timer := time.NewTimer(f.Latency())
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				}
+			}
+			if fail {
+				return nil, connect.NewError(code, ErrFaultInjected)
+			}
+			return next(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package dispatchclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestClientFaultyTransport(t *testing.T) {
+	recorder := &countingRecorder{}
+	server := dispatchtest.NewServer(recorder)
+
+	client, err := dispatchclient.New(
+		dispatchclient.APIKey("foobar"),
+		dispatchclient.APIUrl(server.URL),
+		dispatchclient.FaultyTransport(dispatchclient.Faults{
+			ErrorRate: 0.5,
+			Codes:     []connect.Code{connect.CodeUnavailable, connect.CodeResourceExhausted},
+			Latency:   dispatchclient.UniformLatency(time.Millisecond, 2*time.Millisecond),
+			Seed:      1,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := dispatchproto.NewCall("http://example.com", "function1", dispatchproto.Int(11))
+
+	const attempts = 100
+	var failures int
+	for i := 0; i < attempts; i++ {
+		start := time.Now()
+		_, err := client.Dispatch(context.Background(), call)
+		if elapsed := time.Since(start); elapsed < time.Millisecond {
+			t.Errorf("expected latency to be injected, request took %s", elapsed)
+		}
+		if err == nil {
+			continue
+		}
+		failures++
+		if !errors.Is(err, dispatchclient.ErrFaultInjected) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		switch code := connect.CodeOf(err); code {
+		case connect.CodeUnavailable, connect.CodeResourceExhausted:
+		default:
+			t.Errorf("unexpected error code: %s", code)
+		}
+	}
+	if failures == 0 || failures == attempts {
+		t.Errorf("unexpected number of failures: %d/%d", failures, attempts)
+	}
+	if got := recorder.requests; got != attempts-failures {
+		t.Errorf("unexpected number of requests sent: got %d, want %d", got, attempts-failures)
+	}
+}
+
+type countingRecorder struct {
+	dispatchtest.CallRecorder
+	requests int
+}
+
+func (r *countingRecorder) Handle(ctx context.Context, header http.Header, calls []dispatchproto.Call) ([]dispatchproto.ID, error) {
+	r.requests++
+	return r.CallRecorder.Handle(ctx, header, calls)
+}
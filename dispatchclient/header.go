@@ -0,0 +1,55 @@
+//go:build !durable
+
+package dispatchclient
+
+import (
+	"context"
+	"net/http"
+)
+
+type headerKey struct{}
+
+// WithHeader returns a context that attaches HTTP headers to the
+// requests to the Dispatch API made with it, e.g. to dispatch calls
+// (including via dispatch.Function.Dispatch). Headers
+// can carry idempotency keys, tracing context or routing hints, without
+// wrapping the HTTP client of the Client.
+//
+// Headers are added to those already attached to the context. The
+// Authorization header is always set by the Client (see APIKey).
+func WithHeader(ctx context.Context, header http.Header) context.Context {
+	merged := HeaderFrom(ctx).Clone()
+	if merged == nil {
+		merged = make(http.Header, len(header))
+	}
+	for name, values := range header {
+		name = http.CanonicalHeaderKey(name)
+		merged[name] = append(merged[name], values...)
+	}
+	return context.WithValue(ctx, headerKey{}, merged)
+}
+
+// HeaderFrom returns the HTTP headers attached to a context with
+// WithHeader, or nil if there are none. The headers must not be
+// modified.
+func HeaderFrom(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerKey{}).(http.Header)
+	return header
+}
+
+// Header returns the HTTP headers attached to the request that
+// dispatches the batch, in addition to those attached to the context
+// passed to Dispatch (see WithHeader). The headers can be modified
+// until the batch is dispatched, and are cleared by Reset.
+func (b *Batch) Header() http.Header {
+	if b.header == nil {
+		b.header = http.Header{}
+	}
+	return b.header
+}
+
+func addHeader(ctx context.Context, header http.Header) {
+	for name, values := range HeaderFrom(ctx) {
+		header[name] = append(header[name], values...)
+	}
+}
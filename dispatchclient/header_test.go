@@ -0,0 +1,77 @@
+package dispatchclient_test
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestClientHeader(t *testing.T) {
+	recorder := &regionRecorder{CallRecorder: &dispatchtest.CallRecorder{}}
+	server := dispatchtest.NewServer(recorder)
+
+	client, err := dispatchclient.New(dispatchclient.APIKey("foobar"), dispatchclient.APIUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := dispatchproto.NewCall("http://example.com", "function1", dispatchproto.Int(11))
+
+	ctx := dispatchclient.WithHeader(context.Background(), http.Header{
+		"x-trace-id":    {"trace"},
+		"Authorization": {"Bearer other"},
+	})
+	if _, err := client.Dispatch(ctx, call); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := client.Batch()
+	batch.Add(call)
+	batch.Header().Set("X-Region", "eu")
+	batch.Header().Add("X-Trace-Id", "batch")
+	if _, err := batch.Dispatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reset clears the headers of the batch.
+	batch.Reset()
+	batch.Add(call)
+	if _, err := batch.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.Assert(t,
+		dispatchtest.DispatchRequest{
+			Header: http.Header{"Authorization": {"Bearer foobar"}, "X-Trace-Id": {"trace"}},
+			Calls:  []dispatchproto.Call{call},
+		},
+		dispatchtest.DispatchRequest{
+			Header: http.Header{"Authorization": {"Bearer foobar"}, "X-Trace-Id": {"trace", "batch"}, "X-Region": {"eu"}},
+			Calls:  []dispatchproto.Call{call},
+		},
+		dispatchtest.DispatchRequest{
+			Header: http.Header{"Authorization": {"Bearer foobar"}},
+			Calls:  []dispatchproto.Call{call},
+		},
+	)
+	if want := []string{"", "eu", ""}; !slices.Equal(recorder.regions, want) {
+		t.Errorf("unexpected X-Region headers: got %q, want %q", recorder.regions, want)
+	}
+}
+
+// regionRecorder records the X-Region header of each request, including
+// when it's absent.
+type regionRecorder struct {
+	*dispatchtest.CallRecorder
+	regions []string
+}
+
+func (r *regionRecorder) Handle(ctx context.Context, header http.Header, calls []dispatchproto.Call) ([]dispatchproto.ID, error) {
+	r.regions = append(r.regions, header.Get("X-Region"))
+	return r.CallRecorder.Handle(ctx, header, calls)
+}
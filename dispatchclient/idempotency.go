@@ -0,0 +1,150 @@
+//go:build !durable
+
+package dispatchclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+const defaultIdempotencyWindow = 10 * time.Minute
+
+// IdempotencyWindow sets how long the Client remembers the dispatch ID
+// of calls that carry an idempotency key (see
+// dispatchproto.IdempotencyKey).
+//
+// Dispatching a call with the same key as a call that was dispatched
+// within the window returns the ID of the prior call, rather than
+// dispatching it again. Keys are only remembered once a call has been
+// dispatched successfully, so concurrent dispatches with the same key
+// may both be sent.
+//
+// The keys are remembered in memory and are never sent to Dispatch, so
+// calls are only deduplicated by the same Client, within the same
+// process. Calls retried by another Client, or after the process
+// restarts, are dispatched again.
+//
+// It defaults to 10 minutes.
+func IdempotencyWindow(window time.Duration) Option {
+	return func(c *Client) { c.idempotencyWindow = window }
+}
+
+// Clock sets the function that the Client reads the current time from,
+// e.g. to expire the idempotency keys that are out of the window (see
+// IdempotencyWindow).
+//
+// It defaults to time.Now.
+func Clock(now func() time.Time) Option {
+	return func(c *Client) { c.now = now }
+}
+
+type idempotencyKeys struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	ids   map[string]idempotentDispatch
+	order []idempotentKey // in order of expiration
+}
+
+type idempotentDispatch struct {
+	id      dispatchproto.ID
+	expires time.Time
+}
+
+type idempotentKey struct {
+	key     string
+	expires time.Time
+}
+
+func newIdempotencyKeys(window time.Duration) *idempotencyKeys {
+	return &idempotencyKeys{window: window, ids: map[string]idempotentDispatch{}}
+}
+
+// lookup returns the ID of the call dispatched with the key, if it's
+// still in the window.
+func (k *idempotencyKeys) lookup(key string, now time.Time) (dispatchproto.ID, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.expire(now)
+	d, ok := k.ids[key]
+	return d.id, ok
+}
+
+func (k *idempotencyKeys) record(key string, id dispatchproto.ID, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	expires := now.Add(k.window)
+	k.ids[key] = idempotentDispatch{id: id, expires: expires}
+	k.order = append(k.order, idempotentKey{key: key, expires: expires})
+}
+
+func (k *idempotencyKeys) expire(now time.Time) {
+	n := 0
+	for _, e := range k.order {
+		if e.expires.After(now) {
+			break
+		}
+		// The key may have been recorded again since.
+		if d, ok := k.ids[e.key]; ok && !d.expires.After(now) {
+			delete(k.ids, e.key)
+		}
+		n++
+	}
+	k.order = k.order[n:]
+}
+
+// dispatchIdempotent dispatches the calls of the batch whose idempotency
+// key wasn't seen in the window, once per key, and returns the ID of
+// each call.
+func (b *Batch) dispatchIdempotent(ctx context.Context) ([]dispatchproto.ID, error) {
+	keys := b.client.idempotencyKeys
+	now := b.client.now()
+
+	ids := make([]dispatchproto.ID, len(b.calls))
+	first := map[string]int{}
+	var send []int
+	for i, key := range b.keys {
+		if key == "" {
+			send = append(send, i)
+		} else if id, ok := keys.lookup(key, now); ok {
+			b.client.log().DebugContext(ctx, "skipping function call with a known idempotency key", "idempotency_key", key, "dispatch_id", id)
+			ids[i] = id
+		} else if _, ok := first[key]; !ok {
+			first[key] = i
+			send = append(send, i)
+		}
+	}
+
+	if len(send) > 0 {
+		calls := make([]*sdkv1.Call, len(send))
+		for i, j := range send {
+			calls[i] = b.calls[j]
+		}
+		sent, err := b.client.dispatch(ctx, calls)
+		if err != nil {
+			return nil, err
+		}
+		now = b.client.now()
+		for i, j := range send {
+			ids[j] = sent[i]
+			if key := b.keys[j]; key != "" {
+				keys.record(key, sent[i], now)
+			}
+		}
+	}
+
+	// Calls with a key that's repeated in the batch share the ID of the
+	// first call.
+	for i, key := range b.keys {
+		if j, ok := first[key]; ok && ids[i] == "" {
+			ids[i] = ids[j]
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,83 @@
+package dispatchclient_test
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestClientIdempotencyKey(t *testing.T) {
+	recorder := &dispatchtest.CallRecorder{}
+	server := dispatchtest.NewServer(recorder)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client, err := dispatchclient.New(
+		dispatchclient.APIKey("foobar"),
+		dispatchclient.APIUrl(server.URL),
+		dispatchclient.IdempotencyWindow(time.Minute),
+		dispatchclient.Clock(func() time.Time { return now }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call1 := dispatchproto.NewCall("http://example.com", "function1", dispatchproto.Int(11), dispatchproto.IdempotencyKey("a"))
+	call2 := dispatchproto.NewCall("http://example.com", "function2", dispatchproto.Int(22), dispatchproto.IdempotencyKey("b"))
+	call3 := dispatchproto.NewCall("http://example.com", "function3", dispatchproto.Int(33))
+
+	id1, err := client.Dispatch(context.Background(), call1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A retry with the same key returns the same ID, without dispatching
+	// the call again.
+	id, err := client.Dispatch(context.Background(), call1)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != id1 {
+		t.Errorf("unexpected dispatch ID: got %q, want %q", id, id1)
+	}
+
+	// Calls with a key that's repeated in a batch are dispatched once.
+	batch := client.Batch()
+	batch.Add(call1, call2, call2, call3)
+	ids, err := batch.Dispatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []dispatchproto.ID{id1, "1", "1", "2"}; !slices.Equal(ids, want) {
+		t.Errorf("unexpected dispatch IDs: got %v, want %v", ids, want)
+	}
+
+	// Keys are remembered until the window has passed.
+	now = now.Add(59 * time.Second)
+	id, err = client.Dispatch(context.Background(), call1)
+	if err != nil {
+		t.Fatal(err)
+	} else if id != id1 {
+		t.Errorf("unexpected dispatch ID: got %q, want %q", id, id1)
+	}
+
+	// Keys are forgotten once the window has passed.
+	now = now.Add(time.Second)
+	id, err = client.Dispatch(context.Background(), call1)
+	if err != nil {
+		t.Fatal(err)
+	} else if id == id1 {
+		t.Errorf("expected a new dispatch ID, got %q", id)
+	}
+
+	header := http.Header{"Authorization": []string{"Bearer foobar"}}
+	recorder.Assert(t,
+		dispatchtest.DispatchRequest{Header: header, Calls: []dispatchproto.Call{call1}},
+		dispatchtest.DispatchRequest{Header: header, Calls: []dispatchproto.Call{call2, call3}},
+		dispatchtest.DispatchRequest{Header: header, Calls: []dispatchproto.Call{call1}},
+	)
+}
@@ -0,0 +1,62 @@
+//go:build !durable
+
+package dispatchclient
+
+import (
+	"crypto/ed25519"
+	"slices"
+
+	"connectrpc.com/connect"
+	"github.com/dispatchrun/dispatch-go/internal/auth"
+)
+
+// SigningKey sets an ed25519 key that the Client signs requests to the
+// Dispatch API with, in addition to authenticating them with the API
+// key. Requests are signed with the same HTTP message signature scheme
+// that Dispatch signs requests to endpoints with.
+//
+// The Client holds a copy of the key, which is zeroed when the Client
+// is closed (see Client.Close). The caller is responsible for zeroing
+// its own copy.
+//
+// By default the Client does not sign requests.
+func SigningKey(signingKey ed25519.PrivateKey) Option {
+	return func(c *Client) { c.signingKey = slices.Clone(signingKey) }
+}
+
+// SigningKeyID sets the key ID carried by the signatures of requests
+// signed with the SigningKey, so that the verifier can tell which key
+// to verify them with. Giving each key a distinct ID allows rotating
+// keys without downtime, as long as the verifier accepts both the old
+// and the new key while clients are updated.
+//
+// It defaults to "default", the key ID of the requests signed by
+// Dispatch.
+func SigningKeyID(keyID string) Option {
+	return func(c *Client) { c.signingKeyID = keyID }
+}
+
+func (c *Client) signingClient(client connect.HTTPClient) connect.HTTPClient {
+	if c.signingKey == nil {
+		return client
+	}
+	keyID := c.signingKeyID
+	if keyID == "" {
+		keyID = auth.DefaultKeyID
+	}
+	// The signer holds its own copy of the key, which it zeroes when
+	// it's closed, so the Client doesn't need to keep one.
+	c.signer = auth.NewSignerWithKeyID(keyID, c.signingKey)
+	clear(c.signingKey)
+	c.signingKey = nil
+	return c.signer.Client(client)
+}
+
+// Close zeroes the copy of the signing key held by the Client (see
+// SigningKey). Requests can't be signed once the Client is closed.
+func (c *Client) Close() error {
+	if c.signer != nil {
+		return c.signer.Close()
+	}
+	return nil
+}
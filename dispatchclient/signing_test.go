@@ -0,0 +1,120 @@
+package dispatchclient_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+	"github.com/dispatchrun/dispatch-go/internal/auth"
+)
+
+func TestClientSigningKey(t *testing.T) {
+	oldPublicKey, oldPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPublicKey, newPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &dispatchtest.CallRecorder{}
+	server, err := dispatchserver.New(recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := auth.NewKeysVerifier([]auth.VerificationKey{
+		{KeyID: "key-1", Key: oldPublicKey},
+		{KeyID: "key-2", Key: newPublicKey},
+	})
+	mux := http.NewServeMux()
+	path, handler := server.Handler()
+	mux.Handle(path, verifier.Middleware(handler))
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	call := dispatchproto.NewCall("http://example.com", "function", dispatchproto.Int(11))
+
+	for _, test := range []struct {
+		keyID  string
+		key    ed25519.PrivateKey
+		reject bool
+	}{
+		{keyID: "key-1", key: oldPrivateKey},
+		{keyID: "key-2", key: newPrivateKey},
+		{keyID: "key-1", key: newPrivateKey, reject: true},
+		{keyID: "", key: newPrivateKey, reject: true},
+	} {
+		opts := []dispatchclient.Option{
+			dispatchclient.APIKey("foobar"),
+			dispatchclient.APIUrl(httpServer.URL),
+			dispatchclient.SigningKey(test.key),
+		}
+		if test.keyID != "" {
+			opts = append(opts, dispatchclient.SigningKeyID(test.keyID))
+		}
+		client, err := dispatchclient.New(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Dispatch(context.Background(), call)
+		if test.reject && err == nil {
+			t.Errorf("expected request signed with key ID %q to be rejected", test.keyID)
+		} else if !test.reject && err != nil {
+			t.Errorf("unexpected error with key ID %q: %v", test.keyID, err)
+		}
+	}
+
+	if got := verifier.Rejections(); got[auth.ReasonInvalidSignature] != 1 || got[auth.ReasonUnknownKey] != 1 {
+		t.Errorf("unexpected rejections: %v", got)
+	}
+}
+
+func TestClientSigningKeyClose(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &dispatchtest.CallRecorder{}
+	server, err := dispatchserver.New(recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	path, handler := server.Handler()
+	mux.Handle(path, auth.NewVerifier(publicKey).Middleware(handler))
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	callerKey := bytes.Clone(privateKey)
+	client, err := dispatchclient.New(
+		dispatchclient.APIKey("foobar"),
+		dispatchclient.APIUrl(httpServer.URL),
+		dispatchclient.SigningKey(callerKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Client holds its own copy of the key.
+	clear(callerKey)
+	call := dispatchproto.NewCall("http://example.com", "function", dispatchproto.Int(11))
+	if _, err := client.Dispatch(context.Background(), call); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dispatch(context.Background(), call); err == nil {
+		t.Error("expected requests to fail once the client is closed")
+	}
+}
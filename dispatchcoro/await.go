@@ -6,7 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand/v2"
+	"slices"
 	"time"
 
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
@@ -14,65 +14,60 @@ import (
 
 // Await awaits the results of calls.
 func Await(strategy AwaitStrategy, calls ...dispatchproto.Call) ([]dispatchproto.CallResult, error) {
+	return AwaitN(strategy, 0, calls...)
+}
+
+// AwaitN is like Await, but keeps at most n calls in flight. There's
+// no limit if n is zero or negative.
+//
+// It's useful for large fan-outs, which would otherwise submit all the
+// calls in one poll, overwhelming downstream services. Calls are
+// submitted in order. The coroutine is resumed once half of the calls in
+// flight have completed, and the window of calls in flight is refilled.
+// When AwaitN returns early (see AwaitStrategy), calls that were not
+// submitted yet are never made, and their results are left unset.
+func AwaitN(strategy AwaitStrategy, n int, calls ...dispatchproto.Call) ([]dispatchproto.CallResult, error) {
 	if len(calls) == 0 {
 		return nil, nil
 	}
-
-	// Assign a correlation ID to each call, and map to the index
-	// in the provided set of []Call.
-	//
-	// The reason we use a random starting correlation ID, rather than
-	// the index of each Call, is that Dispatch has at-least once execution
-	// guarantees and may rarely deliver a call result from a previous Await
-	// operation. Using random correlation ID helps guard against this.
-	nextCorrelationID := rand.Uint64()
-	pending := map[uint64]int{}
-	for i, call := range calls {
-		correlationID := nextCorrelationID
-		nextCorrelationID++
-		pending[correlationID] = i
-		calls[i] = call.With(dispatchproto.CorrelationID(correlationID))
+	if n <= 0 || n > len(calls) {
+		n = len(calls)
 	}
 
-	// Set polling configuration. There's no value in waking up the
-	// coroutine sooner than when all results are available (by reducing
-	// minResults and/or maxWait), since there's no internal concurrency
-	// in the Go SDK.
-	minResults := len(calls)
-	maxResults := len(calls)
-	maxWait := 5 * time.Minute
-
+	pending := correlate(calls)
 	callResults := make([]dispatchproto.CallResult, len(calls))
 
-	// Poll until results available.
+	var submitted, inflight int
 	for len(pending) > 0 {
-		poll := dispatchproto.NewResponse(dispatchproto.NewPoll(minResults, maxResults, maxWait, dispatchproto.Calls(calls...)))
-		res := Yield(poll)
-
-		calls = nil // only submit calls once
-
-		// Unpack poll results.
-		pollResult, ok := res.PollResult()
-		if !ok {
-			return nil, fmt.Errorf("unexpected response when polling: %s", res)
-		} else if err, ok := pollResult.Error(); ok {
-			return nil, fmt.Errorf("poll error: %w", err)
+		// Refill the window of calls in flight.
+		submit := calls[submitted:min(submitted+n-inflight, len(calls))]
+		submitted += len(submit)
+		inflight += len(submit)
+
+		// Without a limit, there's no value in waking up the coroutine
+		// sooner than when all results are available (by reducing
+		// minResults), since there's no internal concurrency in the Go
+		// SDK.
+		minResults := min(inflight, (n+1)/2)
+		if n == len(calls) {
+			minResults = inflight
+		}
+		results, err := poll(minResults, inflight, submit, func(correlationID uint64) bool {
+			i, ok := pending[correlationID]
+			return ok && i < submitted
+		})
+		if err != nil {
+			return nil, err
 		}
 
 		// Map call results back to calls.
 		var hasSuccess bool
 		var hasFailure bool
-		for _, result := range pollResult.Results() {
+		for _, result := range results {
 			correlationID := result.CorrelationID()
-			i, ok := pending[correlationID]
-			if !ok {
-				// This can occur due to the at-least once execution
-				// guarantees of Dispatch.
-				slog.Debug("skipping call result with unknown correlation ID", "call_result", result, "correlation_id", correlationID)
-				continue
-			}
-			callResults[i] = result
+			callResults[pending[correlationID]] = result
 			delete(pending, correlationID)
+			inflight--
 
 			if _, failed := result.Error(); failed {
 				hasFailure = true
@@ -95,6 +90,55 @@ func Await(strategy AwaitStrategy, calls ...dispatchproto.Call) ([]dispatchproto
 	return callResults, nil
 }
 
+// pollMaxWait is the max wait of the polls of await operations. The
+// coroutine is resumed when the min results are available, so it only
+// bounds how long results are held back when fewer are available.
+const pollMaxWait = 5 * time.Minute
+
+// poll yields a Poll directive that submits the calls, and returns the
+// results it's resumed with. Results of calls that are not pending are
+// skipped: this can occur due to the at-least once execution guarantees
+// of Dispatch.
+func poll(minResults, maxResults int, calls []dispatchproto.Call, pending func(correlationID uint64) bool) ([]dispatchproto.CallResult, error) {
+	res := Yield(dispatchproto.NewResponse(dispatchproto.NewPoll(minResults, maxResults, pollMaxWait, dispatchproto.Calls(calls...))))
+
+	// Unpack poll results.
+	pollResult, ok := res.PollResult()
+	if !ok {
+		return nil, fmt.Errorf("unexpected response when polling: %s", res)
+	} else if err, ok := pollResult.Error(); ok {
+		return nil, fmt.Errorf("poll error: %w", err)
+	}
+
+	return slices.DeleteFunc(pollResult.Results(), func(result dispatchproto.CallResult) bool {
+		correlationID := result.CorrelationID()
+		if pending(correlationID) {
+			return false
+		}
+		slog.Debug("skipping call result with unknown correlation ID", "call_result", result, "correlation_id", correlationID)
+		return true
+	}), nil
+}
+
+// correlate assigns a correlation ID to each call, and returns a map
+// from correlation ID to the index in the provided set of []Call.
+//
+// The reason we use a random starting correlation ID, rather than
+// the index of each Call, is that Dispatch has at-least once execution
+// guarantees and may rarely deliver a call result from a previous Await
+// operation. Using random correlation ID helps guard against this.
+func correlate(calls []dispatchproto.Call) map[uint64]int {
+	nextCorrelationID := randomUint64()
+	pending := make(map[uint64]int, len(calls))
+	for i, call := range calls {
+		correlationID := nextCorrelationID
+		nextCorrelationID++
+		pending[correlationID] = i
+		calls[i] = call.With(dispatchproto.CorrelationID(correlationID))
+	}
+	return pending
+}
+
 func allFailed(results []dispatchproto.CallResult) bool {
 	for _, result := range results {
 		if _, ok := result.Error(); !ok {
@@ -138,11 +182,17 @@ const (
 // are available, or any call fails. It unpacks the output value
 // from the call result when all calls succeed.
 func Gather[O any](calls ...dispatchproto.Call) ([]O, error) {
+	return GatherN[O](0, calls...)
+}
+
+// GatherN is like Gather, but keeps at most n calls in flight (see
+// AwaitN). There's no limit if n is zero or negative.
+func GatherN[O any](n int, calls ...dispatchproto.Call) ([]O, error) {
 	if len(calls) == 0 {
 		return nil, nil
 	}
 
-	results, err := Await(AwaitAll, calls...)
+	results, err := AwaitN(AwaitAll, n, calls...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,85 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"fmt"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// CallDetail is the outcome of a call awaited by GatherDetailed.
+type CallDetail[O any] struct {
+	// Output is the output of the call. It's only set if the call
+	// succeeded.
+	Output O
+
+	// Err is the error of the call, if it failed.
+	Err error
+
+	// Status is the final status of the call. It's the status of the
+	// output if the call succeeded (see dispatchproto.StatusOf), and
+	// the status of the error if it failed.
+	//
+	// Dispatch doesn't report the status of failed calls in their
+	// results, so the status is derived from the error, which is
+	// reported as a PermanentErrorStatus unless the error carries a
+	// status of its own.
+	Status dispatchproto.Status
+
+	// DispatchID is the ID that Dispatch assigned to the call. It can
+	// be used to correlate the call with the logs of Dispatch, e.g. to
+	// find how many times it was attempted, since Dispatch doesn't
+	// report the number of attempts in call results.
+	DispatchID dispatchproto.ID
+}
+
+// GatherDetailed awaits the results of calls, and returns the outcome
+// of each call, in the order of the calls.
+//
+// Unlike Gather, GatherDetailed waits until all results are available,
+// even if calls fail, and doesn't return an error when they do: the
+// errors of the calls are reported in their CallDetail. An error is
+// only returned if polling fails, or if an output can't be unmarshaled.
+func GatherDetailed[O any](calls ...dispatchproto.Call) ([]CallDetail[O], error) {
+	if len(calls) == 0 {
+		return nil, nil
+	}
+
+	pending := correlate(calls)
+	details := make([]CallDetail[O], len(calls))
+
+	for len(pending) > 0 {
+		results, err := poll(len(pending), len(pending), calls, func(correlationID uint64) bool {
+			_, ok := pending[correlationID]
+			return ok
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		calls = nil // only submit calls once
+
+		// Map call results back to calls.
+		for _, result := range results {
+			correlationID := result.CorrelationID()
+			i := pending[correlationID]
+			delete(pending, correlationID)
+
+			detail := &details[i]
+			detail.DispatchID = result.DispatchID()
+			if err, failed := result.Error(); failed {
+				detail.Err = err
+				detail.Status = dispatchproto.StatusOf(err)
+				continue
+			}
+			if boxedOutput, ok := result.Output(); ok {
+				if err := boxedOutput.Unmarshal(&detail.Output); err != nil {
+					return nil, fmt.Errorf("failed to unmarshal call %d output: %w", i, err)
+				}
+			}
+			detail.Status = dispatchproto.StatusOf(detail.Output)
+		}
+	}
+	return details, nil
+}
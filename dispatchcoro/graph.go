@@ -0,0 +1,143 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Graph records the await operations of a coroutine, and the
+// dependencies between them, e.g. for visualization or to find the
+// critical path of a workflow.
+//
+// Each await operation made via Graph.Await is a step of the graph.
+// Steps are tagged with the steps whose results they depend on, i.e.
+// the steps whose outputs were used to build their calls.
+//
+// A Graph records a single execution. It's safe to use in both
+// volatile and durable mode, since it's part of the coroutine state.
+type Graph struct {
+	steps []StepInfo
+}
+
+// Step identifies a step of a Graph.
+type Step int
+
+// StepInfo is information about a step of a Graph.
+type StepInfo struct {
+	// Step is the step.
+	Step Step
+
+	// Functions are the names of the functions called by the step.
+	Functions []string
+
+	// DependsOn are the steps that the step depends on.
+	DependsOn []Step
+
+	// Start and End are the times the calls of the step were submitted,
+	// and the time the step completed.
+	Start, End time.Time
+
+	// Error is the error of the step, if any.
+	Error string
+}
+
+// Duration is the duration of the step.
+func (s StepInfo) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Await awaits the results of calls (see Await), and records the await
+// operation as a step of the graph that depends on the specified steps.
+func (g *Graph) Await(strategy AwaitStrategy, dependsOn []Step, calls ...dispatchproto.Call) (Step, []dispatchproto.CallResult, error) {
+	step := Step(len(g.steps))
+	for _, dep := range dependsOn {
+		if dep < 0 || dep >= step {
+			return step, nil, fmt.Errorf("invalid dependency on step %d", dep)
+		}
+	}
+	info := StepInfo{
+		Step:      step,
+		Functions: make([]string, len(calls)),
+		DependsOn: append([]Step(nil), dependsOn...),
+		Start:     now(),
+	}
+	for i, call := range calls {
+		info.Functions[i] = call.Function()
+	}
+
+	results, err := Await(strategy, calls...)
+
+	info.End = now()
+	if err != nil {
+		info.Error = err.Error()
+	}
+	g.steps = append(g.steps, info)
+	return step, results, err
+}
+
+// Steps returns the steps of the graph, in the order they were recorded.
+func (g *Graph) Steps() []StepInfo {
+	return g.steps
+}
+
+// CriticalPath returns the chain of dependent steps with the longest
+// total duration, and that duration.
+func (g *Graph) CriticalPath() ([]Step, time.Duration) {
+	if len(g.steps) == 0 {
+		return nil, 0
+	}
+	// Steps can only depend on earlier steps, so they're already
+	// sorted topologically.
+	total := make([]time.Duration, len(g.steps))
+	prev := make([]Step, len(g.steps))
+	last := Step(0)
+	for i, step := range g.steps {
+		prev[i] = -1
+		for _, dep := range step.DependsOn {
+			if prev[i] < 0 || total[dep] > total[prev[i]] {
+				prev[i] = dep
+			}
+		}
+		total[i] = step.Duration()
+		if prev[i] >= 0 {
+			total[i] += total[prev[i]]
+		}
+		if total[i] >= total[last] {
+			last = Step(i)
+		}
+	}
+
+	var path []Step
+	for step := last; step >= 0; step = prev[step] {
+		path = append(path, step)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, total[last]
+}
+
+// WriteDOT writes the graph in the DOT language of Graphviz.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph {\n")
+	for _, step := range g.steps {
+		label := fmt.Sprintf("%s\n%s", strings.Join(step.Functions, ", "), step.Duration())
+		if step.Error != "" {
+			label += "\nerror"
+		}
+		fmt.Fprintf(&b, "  step%d [label=%q];\n", step.Step, label)
+		for _, dep := range step.DependsOn {
+			fmt.Fprintf(&b, "  step%d -> step%d;\n", dep, step.Step)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
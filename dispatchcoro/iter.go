@@ -0,0 +1,90 @@
+//go:build !durable && go1.23
+
+package dispatchcoro
+
+import (
+	"fmt"
+	"iter"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// GatherSeq awaits the results of calls, and yields the output values
+// in the order the calls complete, e.g.
+//
+//	for output, err := range dispatchcoro.GatherSeq[string](calls...) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// If a call fails, its error is yielded and the iteration stops. Calls
+// that have not completed when the iteration stops (e.g. because the
+// loop exits early) are abandoned: their results are discarded if they
+// arrive later.
+func GatherSeq[O any](calls ...dispatchproto.Call) iter.Seq2[O, error] {
+	return GatherSeqN[O](0, calls...)
+}
+
+// GatherSeqN is like GatherSeq, but keeps at most n calls in flight
+// (see AwaitN). There's no limit if n is zero or negative.
+//
+// It's useful for very large fan-outs: since at most n results are
+// pending at any time, the size of each poll result is bounded, and the
+// outputs are never all held in memory at once. Calls that were not
+// submitted when the iteration stops are never made.
+func GatherSeqN[O any](n int, calls ...dispatchproto.Call) iter.Seq2[O, error] {
+	return func(yield func(O, error) bool) {
+		var zero O
+		if len(calls) == 0 {
+			return
+		}
+		calls := append([]dispatchproto.Call(nil), calls...)
+		pending := correlate(calls)
+		if n <= 0 {
+			n = len(calls)
+		}
+
+		var submitted, inflight int
+		for len(pending) > 0 {
+			// Refill the window of calls in flight.
+			submit := calls[submitted:min(submitted+n-inflight, len(calls))]
+			submitted += len(submit)
+			inflight += len(submit)
+
+			// Wake up the coroutine as soon as any result is available,
+			// so that outputs are yielded as they arrive.
+			results, err := poll(1, inflight, submit, func(correlationID uint64) bool {
+				i, ok := pending[correlationID]
+				return ok && i < submitted
+			})
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			for _, result := range results {
+				correlationID := result.CorrelationID()
+				i := pending[correlationID]
+				delete(pending, correlationID)
+				inflight--
+
+				if err, ok := result.Error(); ok {
+					yield(zero, err)
+					return
+				}
+				var output O
+				if boxedOutput, ok := result.Output(); ok {
+					if err := boxedOutput.Unmarshal(&output); err != nil {
+						yield(zero, fmt.Errorf("failed to unmarshal call %d output: %w", i, err))
+						return
+					}
+				}
+				if !yield(output, nil) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Parallel runs tasks concurrently, and waits for them to complete.
+//
+// At most limit tasks run at once; there's no limit if limit is zero or
+// negative. The context passed to tasks is cancelled as soon as a task
+// fails, and the first error is returned. If a task panics, the panic
+// is propagated to the caller of Parallel once all tasks are done.
+//
+// Parallel is the supported way to run local work (e.g. I/O) in
+// parallel within a Dispatch Function. Goroutines started by functions
+// must not outlive the current turn of the coroutine: they cannot be
+// suspended and resumed along with it, and their state cannot be
+// serialized in durable mode. Since Parallel only returns once all tasks
+// are done, it's safe to use in both volatile and durable mode.
+//
+// Tasks must not call Yield, Await or Gather (or functions that call
+// them, such as Function.Await); calls should be made from the function
+// itself, e.g. after Parallel returns.
+func Parallel(ctx context.Context, limit int, tasks ...func(context.Context) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		firstErr  error
+		panicOnce sync.Once
+		panicked  any
+		sem       chan struct{}
+	)
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel(err)
+		})
+	}
+
+	wg.Add(len(tasks))
+	for _, task := range tasks {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		go func() {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			defer func() {
+				if v := recover(); v != nil {
+					panicOnce.Do(func() { panicked = v })
+					fail(fmt.Errorf("task panicked: %v", v))
+				}
+			}()
+			if err := task(ctx); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if panicked != nil {
+		panic(panicked)
+	}
+	return firstErr
+}
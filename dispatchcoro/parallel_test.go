@@ -0,0 +1,66 @@
+package dispatchcoro_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+)
+
+func TestParallel(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	tasks := make([]func(context.Context) error, 10)
+	for i := range tasks {
+		tasks[i] = func(context.Context) error {
+			n := running.Add(1)
+			for {
+				max := maxRunning.Load()
+				if n <= max || maxRunning.CompareAndSwap(max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			return nil
+		}
+	}
+	if err := dispatchcoro.Parallel(context.Background(), 3, tasks...); err != nil {
+		t.Fatal(err)
+	}
+	if n := maxRunning.Load(); n > 3 {
+		t.Errorf("expected at most 3 concurrent tasks, got %d", n)
+	}
+}
+
+func TestParallelError(t *testing.T) {
+	failure := errors.New("failure")
+	err := dispatchcoro.Parallel(context.Background(), 0,
+		func(ctx context.Context) error {
+			return failure
+		},
+		func(ctx context.Context) error {
+			// The context is cancelled when another task fails.
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	)
+	if err != failure {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParallelPanic(t *testing.T) {
+	defer func() {
+		if v := recover(); v != "oops" {
+			t.Errorf("unexpected panic: %v", v)
+		}
+	}()
+	dispatchcoro.Parallel(context.Background(), 0,
+		func(ctx context.Context) error { panic("oops") },
+		func(ctx context.Context) error { <-ctx.Done(); return nil },
+	)
+	t.Error("expected a panic")
+}
@@ -0,0 +1,110 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Quorum is the outcome of GatherQuorum.
+type Quorum[O any] struct {
+	// Outputs holds the output of each call, in the order of the calls.
+	// Only the outputs of calls listed in Succeeded are set.
+	Outputs []O
+
+	// Succeeded holds the indexes of the calls that succeeded, in the
+	// order their results were received.
+	Succeeded []int
+
+	// Abandoned holds the indexes of the calls that had not completed
+	// when the quorum was reached, in the order of the calls.
+	Abandoned []int
+}
+
+// GatherQuorum awaits the results of calls until n of them succeed.
+//
+// It's useful for redundancy, e.g. to send the same query to three
+// replicas and use the first two answers. Calls that have not completed
+// when the quorum is reached are abandoned: GatherQuorum stops waiting
+// for them and their results are discarded if they arrive later. Their
+// indexes are reported in Quorum.Abandoned. The calls themselves are
+// not cancelled, since Dispatch has no way to cancel a call in flight.
+//
+// GatherQuorum returns an error if enough calls fail that the quorum
+// can no longer be reached. The error joins the errors of the calls
+// that failed.
+func GatherQuorum[O any](n int, calls ...dispatchproto.Call) (Quorum[O], error) {
+	var quorum Quorum[O]
+	if n <= 0 || n > len(calls) {
+		return quorum, fmt.Errorf("invalid quorum %d for %d calls", n, len(calls))
+	}
+
+	pending := correlate(calls)
+	callResults := make([]dispatchproto.CallResult, len(calls))
+	quorum.Outputs = make([]O, len(calls))
+
+	var failures int
+	for len(quorum.Succeeded) < n {
+		// Only wake up the coroutine once enough results may be
+		// available to reach the quorum.
+		minResults := n - len(quorum.Succeeded)
+		maxResults := len(pending)
+
+		results, err := poll(minResults, maxResults, calls, func(correlationID uint64) bool {
+			_, ok := pending[correlationID]
+			return ok
+		})
+		if err != nil {
+			return quorum, err
+		}
+
+		calls = nil // only submit calls once
+
+		// Map call results back to calls.
+		for _, result := range results {
+			correlationID := result.CorrelationID()
+			i := pending[correlationID]
+			callResults[i] = result
+			delete(pending, correlationID)
+
+			if _, failed := result.Error(); failed {
+				failures++
+				continue
+			}
+			if boxedOutput, ok := result.Output(); ok {
+				if err := boxedOutput.Unmarshal(&quorum.Outputs[i]); err != nil {
+					return quorum, fmt.Errorf("failed to unmarshal call %d output: %w", i, err)
+				}
+			}
+			quorum.Succeeded = append(quorum.Succeeded, i)
+		}
+
+		if len(quorum.Succeeded) < n && len(quorum.Succeeded)+len(pending) < n {
+			return quorum, fmt.Errorf("quorum of %d cannot be reached after %d failure(s): %w", n, failures, joinErrors(callResults))
+		}
+	}
+
+	for _, i := range pending {
+		quorum.Abandoned = append(quorum.Abandoned, i)
+	}
+	slices.Sort(quorum.Abandoned)
+	return quorum, nil
+}
+
+// Race awaits the results of calls until one of them succeeds, and
+// returns its output along with its index in the calls.
+//
+// It's GatherQuorum with a quorum of one: the other calls are abandoned
+// once a call succeeds, and an error is returned if all calls fail.
+func Race[O any](calls ...dispatchproto.Call) (O, int, error) {
+	var zero O
+	quorum, err := GatherQuorum[O](1, calls...)
+	if err != nil {
+		return zero, -1, err
+	}
+	i := quorum.Succeeded[0]
+	return quorum.Outputs[i], i, nil
+}
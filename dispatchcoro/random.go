@@ -0,0 +1,87 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// environment holds the source of randomness and the clock of
+// coroutines. They're held outside of coroutines, so that they're never
+// part of the coroutine state.
+var environment struct {
+	mu    sync.Mutex
+	rand  *rand.Rand       // nil for the default source
+	clock func() time.Time // nil for time.Now
+}
+
+// SetRandomSource sets the source of randomness of coroutines, e.g. of
+// the random starting correlation ID of the calls of each await
+// operation, and of the IDs of volatile coroutine instances. It returns
+// a function that restores the previous source.
+//
+// A seeded source makes the directives of coroutines reproducible, e.g.
+// for golden-file testing, as long as coroutines run in a deterministic
+// order. A nil source restores the default source.
+//
+// The source is global. It isn't meant to be replaced while functions
+// are running in production.
+func SetRandomSource(src rand.Source) (restore func()) {
+	environment.mu.Lock()
+	defer environment.mu.Unlock()
+
+	previous := environment.rand
+	environment.rand = nil
+	if src != nil {
+		environment.rand = rand.New(src)
+	}
+	return func() {
+		environment.mu.Lock()
+		defer environment.mu.Unlock()
+		environment.rand = previous
+	}
+}
+
+// SetClock sets the function that coroutines read the current time
+// from, e.g. for the times recorded by Graph. It returns a function that
+// restores the previous clock.
+//
+// A nil function restores the default clock, time.Now.
+//
+// The clock is global. It isn't meant to be replaced while functions
+// are running in production.
+func SetClock(now func() time.Time) (restore func()) {
+	environment.mu.Lock()
+	defer environment.mu.Unlock()
+
+	previous := environment.clock
+	environment.clock = now
+	return func() {
+		environment.mu.Lock()
+		defer environment.mu.Unlock()
+		environment.clock = previous
+	}
+}
+
+func randomUint64() uint64 {
+	environment.mu.Lock()
+	defer environment.mu.Unlock()
+
+	if environment.rand == nil {
+		return rand.Uint64()
+	}
+	return environment.rand.Uint64()
+}
+
+func now() time.Time {
+	environment.mu.Lock()
+	clock := environment.clock
+	environment.mu.Unlock()
+
+	if clock == nil {
+		return time.Now()
+	}
+	return clock()
+}
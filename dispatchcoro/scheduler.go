@@ -0,0 +1,181 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"fmt"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Scheduler multiplexes independent await operations within a
+// coroutine.
+//
+// Await serializes await operations: each one yields to Dispatch until
+// its results are available. With a Scheduler, the calls of many
+// logical await operations (e.g. started by different helper functions)
+// are merged into shared Poll directives, and call results are routed
+// back to the operation they belong to. Operations can be started at
+// any time, including after others complete, which allows a coroutine
+// to drive multiple chains of calls concurrently.
+//
+// The Scheduler must only be used by the coroutine that created it.
+// It's safe to use in both volatile and durable mode.
+type Scheduler struct {
+	pending map[uint64]scheduledCall
+	queued  []dispatchproto.Call
+	futures []*Future
+}
+
+type scheduledCall struct {
+	future *Future
+	index  int
+}
+
+// NewScheduler creates a Scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{pending: map[uint64]scheduledCall{}}
+}
+
+// Future is an await operation started with a Scheduler.
+type Future struct {
+	strategy AwaitStrategy
+	results  []dispatchproto.CallResult
+	received int
+	done     bool
+	returned bool
+	err      error
+}
+
+// Await starts an await operation for the calls. The calls are submitted
+// with the next Poll directive, when the Scheduler next waits for results.
+func (s *Scheduler) Await(strategy AwaitStrategy, calls ...dispatchproto.Call) *Future {
+	f := &Future{
+		strategy: strategy,
+		results:  make([]dispatchproto.CallResult, len(calls)),
+		done:     len(calls) == 0,
+	}
+	calls = append([]dispatchproto.Call(nil), calls...)
+	for correlationID, i := range correlate(calls) {
+		s.pending[correlationID] = scheduledCall{future: f, index: i}
+	}
+	s.queued = append(s.queued, calls...)
+	s.futures = append(s.futures, f)
+	return f
+}
+
+// Next waits until an await operation completes, and returns it.
+// Operations are returned once each; operations that completed while
+// waiting for others are returned first. Next returns nil once all
+// operations started with the Scheduler have been returned.
+func (s *Scheduler) Next() (*Future, error) {
+	for {
+		for _, f := range s.futures {
+			if f.done && !f.returned {
+				f.returned = true
+				return f, nil
+			}
+		}
+		if len(s.pending) == 0 {
+			return nil, nil
+		}
+		if err := s.poll(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Wait waits until all await operations started with the Scheduler
+// complete.
+func (s *Scheduler) Wait() error {
+	for len(s.pending) > 0 {
+		if err := s.poll(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) poll() error {
+	// Wake up the coroutine as soon as any result is available, so that
+	// completed operations can be handled while others are in flight.
+	results, err := poll(1, len(s.pending), s.queued, func(correlationID uint64) bool {
+		_, ok := s.pending[correlationID]
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+
+	s.queued = nil // only submit calls once
+
+	// Route call results to their await operation.
+	for _, result := range results {
+		correlationID := result.CorrelationID()
+		call := s.pending[correlationID]
+		delete(s.pending, correlationID)
+		call.future.receive(call.index, result)
+	}
+
+	// Stop tracking the calls of completed operations, e.g. the other
+	// calls of an AwaitAny operation once one succeeded.
+	for correlationID, call := range s.pending {
+		if call.future.done {
+			delete(s.pending, correlationID)
+		}
+	}
+	return nil
+}
+
+func (f *Future) receive(i int, result dispatchproto.CallResult) {
+	if f.done {
+		return
+	}
+	f.results[i] = result
+	f.received++
+
+	_, failed := result.Error()
+	switch {
+	case failed && f.strategy == AwaitAll:
+		f.done, f.err = true, joinErrors(f.results)
+	case !failed && f.strategy == AwaitAny:
+		f.done = true
+	case f.received == len(f.results):
+		f.done = true
+		if f.strategy == AwaitAny && allFailed(f.results) {
+			f.err = joinErrors(f.results)
+		}
+	}
+}
+
+// Done is true if the await operation completed.
+func (f *Future) Done() bool {
+	return f.done
+}
+
+// Results returns the results of the await operation, as returned by
+// Await. It returns an error if the operation has not completed.
+func (f *Future) Results() ([]dispatchproto.CallResult, error) {
+	if !f.done {
+		return nil, fmt.Errorf("await operation has not completed")
+	}
+	return f.results, f.err
+}
+
+// Outputs unpacks the output values from the results of an await
+// operation, like Gather.
+func Outputs[O any](f *Future) ([]O, error) {
+	results, err := f.Results()
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]O, len(results))
+	for i, result := range results {
+		if boxedOutput, ok := result.Output(); ok {
+			if err := boxedOutput.Unmarshal(&outputs[i]); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal call %d output: %w", i, err)
+			}
+		}
+	}
+	return outputs, nil
+}
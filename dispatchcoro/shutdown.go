@@ -0,0 +1,68 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dispatchrun/coroutine"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// shutdowns holds the scopes of the endpoints of this process that are
+// shutting down. Only the scope is stored in the context passed to
+// coroutines, so that it can be serialized with durable coroutine state.
+var shutdowns sync.Map // string => struct{}
+
+type shutdownScopeKey struct{}
+
+// WithShutdownScope returns a context for the coroutines of an
+// endpoint, identified by a scope, so that they can check whether the
+// endpoint is shutting down (see ShuttingDown).
+func WithShutdownScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, shutdownScopeKey{}, scope)
+}
+
+// BeginShutdown signals to the coroutines of the endpoint identified by
+// the scope that the endpoint is shutting down.
+func BeginShutdown(scope string) {
+	shutdowns.Store(scope, struct{}{})
+}
+
+// ShuttingDown is true if the endpoint running the coroutine is shutting
+// down.
+//
+// Long-running functions can check it to wrap up work early, so that
+// the endpoint drains quickly (see also Checkpoint).
+func ShuttingDown(ctx context.Context) bool {
+	scope, ok := ctx.Value(shutdownScopeKey{}).(string)
+	if !ok {
+		return false
+	}
+	_, ok = shutdowns.Load(scope)
+	return ok
+}
+
+// Checkpoint is a yield point for long-running local work.
+//
+// If the endpoint running the coroutine is shutting down, the coroutine
+// is suspended, and Dispatch resumes it right away, on an endpoint that
+// isn't shutting down. Otherwise, Checkpoint returns immediately.
+//
+// Coroutines can only be resumed elsewhere in durable mode, so
+// Checkpoint never suspends volatile coroutines, which run to
+// completion instead.
+func Checkpoint(ctx context.Context) error {
+	if !coroutine.Durable || !ShuttingDown(ctx) {
+		return nil
+	}
+	res := Yield(dispatchproto.NewResponse(dispatchproto.NewPoll(0, 0, 0)))
+	if pollResult, ok := res.PollResult(); !ok {
+		return fmt.Errorf("unexpected response when polling: %s", res)
+	} else if err, ok := pollResult.Error(); ok {
+		return fmt.Errorf("poll error: %w", err)
+	}
+	return nil
+}
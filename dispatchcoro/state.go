@@ -10,31 +10,100 @@ import (
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
-const durableCoroutineStateTypeUrl = "buf.build/stealthrocket/coroutine/coroutine.v1.State"
+// StateTypeURL is the type URL of the serialized state of durable
+// coroutines.
+const StateTypeURL = "buf.build/stealthrocket/coroutine/coroutine.v1.State"
+
+// CompressedStateTypeURL is the type URL of the serialized state of
+// durable coroutines, compressed with gzip (see Compress).
+const CompressedStateTypeURL = "buf.build/dispatchrun/dispatch-go/dispatchcoro.GzipState"
+
+// SerializeOption configures the serialization of coroutines.
+type SerializeOption func(*serializeOptions)
+
+type serializeOptions struct {
+	compress    bool
+	minSize     int
+	observeSize func(raw, size int, compressed bool)
+}
+
+// Compress compresses the state of coroutines with gzip when it's at
+// least minSize bytes long. Smaller states are left uncompressed, since
+// compression wouldn't save much, if anything.
+//
+// Deserialize accepts both compressed and uncompressed states, so that
+// compression can be enabled or disabled while coroutines are suspended.
+func Compress(minSize int) SerializeOption {
+	return func(o *serializeOptions) { o.compress, o.minSize = true, minSize }
+}
+
+// ObserveSize registers a function that's called with the size of the
+// state of each serialized coroutine, in bytes, before (raw) and after
+// (size) compression, e.g. to record metrics.
+func ObserveSize(fn func(raw, size int, compressed bool)) SerializeOption {
+	return func(o *serializeOptions) { o.observeSize = fn }
+}
 
 // Serialize serializes a coroutine.
-func Serialize(coro Coroutine) (dispatchproto.Any, error) {
+func Serialize(coro Coroutine, opts ...SerializeOption) (dispatchproto.Any, error) {
+	var options serializeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	rawState, err := coro.Context().Marshal()
 	if err != nil {
 		return dispatchproto.Any{}, fmt.Errorf("cannot serialize coroutine: %w", err)
 	}
-	return newProtoAny(&anypb.Any{
-		TypeUrl: durableCoroutineStateTypeUrl,
-		Value:   rawState,
-	}), nil
+	state, err := newState(rawState, options)
+	if err != nil {
+		return dispatchproto.Any{}, fmt.Errorf("cannot serialize coroutine: %w", err)
+	}
+	return state, nil
+}
+
+func newState(rawState []byte, options serializeOptions) (dispatchproto.Any, error) {
+	state := &anypb.Any{TypeUrl: StateTypeURL, Value: rawState}
+	if options.compress && len(rawState) >= options.minSize {
+		compressed, err := stateEncoding.Encode(rawState)
+		if err != nil {
+			return dispatchproto.Any{}, err
+		}
+		state = &anypb.Any{TypeUrl: CompressedStateTypeURL, Value: compressed}
+	}
+	if options.observeSize != nil {
+		options.observeSize(len(rawState), len(state.Value), state.TypeUrl == CompressedStateTypeURL)
+	}
+	return newProtoAny(state), nil
 }
 
 // Deserialize deserializes a coroutine.
 func Deserialize(coro Coroutine, state dispatchproto.Any) error {
-	if state.TypeURL() != durableCoroutineStateTypeUrl {
-		return fmt.Errorf("cannot deserialize coroutine state: unexpected type URL %q", state.TypeURL())
+	rawState, err := stateBytes(state)
+	if err != nil {
+		return fmt.Errorf("cannot deserialize coroutine state: %w", err)
 	}
-	if err := coro.Context().Unmarshal(anyProto(state).GetValue()); err != nil {
+	if err := coro.Context().Unmarshal(rawState); err != nil {
 		return fmt.Errorf("cannot deserialize coroutine state: %w", err)
 	}
 	return nil
 }
 
+func stateBytes(state dispatchproto.Any) ([]byte, error) {
+	switch state.TypeURL() {
+	case StateTypeURL:
+		return anyProto(state).GetValue(), nil
+	case CompressedStateTypeURL:
+		return stateEncoding.Decode(anyProto(state).GetValue())
+	default:
+		return nil, fmt.Errorf("unexpected type URL %q", state.TypeURL())
+	}
+}
+
+// stateEncoding compresses the state of coroutines. States that
+// decompress to more than dispatchproto.DefaultMaxDecodedSize bytes are
+// rejected, so that small states can't expand without bounds.
+var stateEncoding = dispatchproto.Gzip()
+
 //go:linkname newProtoAny github.com/dispatchrun/dispatch-go/dispatchproto.newProtoAny
 func newProtoAny(*anypb.Any) dispatchproto.Any
 
@@ -0,0 +1,73 @@
+package dispatchcoro
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestStateCompression(t *testing.T) {
+	raw := bytes.Repeat([]byte("coroutine state "), 1024)
+
+	var observed [][3]int
+	observe := ObserveSize(func(raw, size int, compressed bool) {
+		var c int
+		if compressed {
+			c = 1
+		}
+		observed = append(observed, [3]int{raw, size, c})
+	})
+
+	for _, test := range []struct {
+		name    string
+		opts    []SerializeOption
+		typeURL string
+	}{
+		{name: "uncompressed", opts: []SerializeOption{observe}, typeURL: StateTypeURL},
+		{name: "below minimum size", opts: []SerializeOption{Compress(len(raw) + 1), observe}, typeURL: StateTypeURL},
+		{name: "compressed", opts: []SerializeOption{Compress(len(raw)), observe}, typeURL: CompressedStateTypeURL},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			observed = nil
+
+			var options serializeOptions
+			for _, opt := range test.opts {
+				opt(&options)
+			}
+			state, err := newState(raw, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.TypeURL() != test.typeURL {
+				t.Errorf("unexpected type URL: %s", state.TypeURL())
+			}
+			if len(observed) != 1 || observed[0][0] != len(raw) || observed[0][1] != len(state.Value()) {
+				t.Errorf("unexpected observed sizes: %v", observed)
+			}
+			if test.typeURL == CompressedStateTypeURL && (observed[0][1] >= len(raw) || observed[0][2] != 1) {
+				t.Errorf("state wasn't compressed: %v", observed)
+			}
+
+			got, err := stateBytes(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, raw) {
+				t.Error("unexpected state after round trip")
+			}
+		})
+	}
+}
+
+func TestStateDecompressionLimit(t *testing.T) {
+	compressed, err := stateEncoding.Encode(make([]byte, dispatchproto.DefaultMaxDecodedSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := newProtoAny(&anypb.Any{TypeUrl: CompressedStateTypeURL, Value: compressed})
+	if _, err := stateBytes(state); err == nil {
+		t.Error("expected an error when the state decompresses beyond the limit")
+	}
+}
@@ -0,0 +1,148 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrStateNotFound is returned (wrapped) by a StateStore when there's no
+// state stored under a key.
+var ErrStateNotFound = errors.New("coroutine state not found")
+
+// StateStore stores the state of suspended coroutines out-of-band, so
+// that only a reference to the state is sent to Dispatch (see
+// dispatch.StateStorage).
+//
+// Stores must be safe for concurrent use, and be shared by all the
+// processes that serve an endpoint, since coroutines may resume on
+// another process than the one they suspended on.
+type StateStore interface {
+	// Put stores a state under a key. Keys are unique.
+	Put(ctx context.Context, key string, state []byte) error
+
+	// Get returns the state stored under a key, or an error wrapping
+	// ErrStateNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete deletes the state stored under a key. Deleting a key that
+	// doesn't exist isn't an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryStateStore returns a StateStore that holds states in memory.
+//
+// It's only suitable for tests, or for endpoints served by a single
+// process, since states are lost when the process exits.
+func MemoryStateStore() StateStore {
+	return &memoryStateStore{states: map[string][]byte{}}
+}
+
+type memoryStateStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func (m *memoryStateStore) Put(ctx context.Context, key string, state []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[key] = append([]byte(nil), state...)
+	return nil
+}
+
+func (m *memoryStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	}
+	return state, nil
+}
+
+func (m *memoryStateStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, key)
+	return nil
+}
+
+// DirStateStore returns a StateStore that stores states as files in a
+// local directory. It's useful when the processes that serve a Dispatch
+// endpoint share a disk.
+func DirStateStore(dir string) (StateStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return dirStateStore(dir), nil
+}
+
+type dirStateStore string
+
+func (d dirStateStore) path(key string) (string, error) {
+	// Only use files in the directory of the store.
+	if key == "" || key == "." || key == ".." || filepath.Base(key) != key {
+		return "", fmt.Errorf("invalid coroutine state key %q", key)
+	}
+	return filepath.Join(string(d), key), nil
+}
+
+func (d dirStateStore) Put(ctx context.Context, key string, state []byte) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that partially written states
+	// are never read.
+	f, err := os.CreateTemp(string(d), ".state-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(state); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (d dirStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	state, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrStateNotFound, key)
+	}
+	return state, err
+}
+
+func (d dirStateStore) Delete(ctx context.Context, key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
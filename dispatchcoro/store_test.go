@@ -0,0 +1,54 @@
+package dispatchcoro_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+)
+
+func TestStateStore(t *testing.T) {
+	dir, err := dispatchcoro.DirStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		store dispatchcoro.StateStore
+	}{
+		{name: "memory", store: dispatchcoro.MemoryStateStore()},
+		{name: "dir", store: dir},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := test.store
+
+			if _, err := store.Get(ctx, "key"); !errors.Is(err, dispatchcoro.ErrStateNotFound) {
+				t.Fatalf("expected ErrStateNotFound, got %v", err)
+			}
+			if err := store.Put(ctx, "key", []byte("state")); err != nil {
+				t.Fatal(err)
+			}
+			if state, err := store.Get(ctx, "key"); err != nil {
+				t.Fatal(err)
+			} else if string(state) != "state" {
+				t.Errorf("unexpected state: %q", state)
+			}
+			if err := store.Delete(ctx, "key"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get(ctx, "key"); !errors.Is(err, dispatchcoro.ErrStateNotFound) {
+				t.Errorf("expected ErrStateNotFound after Delete, got %v", err)
+			}
+			if err := store.Delete(ctx, "key"); err != nil {
+				t.Errorf("unexpected error deleting a missing key: %v", err)
+			}
+		})
+	}
+
+	if err := dir.Put(context.Background(), "../escape", []byte("state")); err == nil {
+		t.Error("expected an error for a key outside the directory")
+	}
+}
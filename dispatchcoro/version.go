@@ -0,0 +1,81 @@
+//go:build !durable
+
+package dispatchcoro
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/dispatchrun/coroutine"
+)
+
+const (
+	// CoroutineModule is the path of the module that implements
+	// coroutines.
+	CoroutineModule = "github.com/dispatchrun/coroutine"
+
+	// CoroutineVersion is the release line of CoroutineModule that the
+	// SDK supports. The serialization format of durable coroutines may
+	// change between minor versions, so other release lines may produce
+	// state that can't be resumed, or that's silently corrupt.
+	CoroutineVersion = "v0.9"
+)
+
+// ErrIncompatibleCoroutine is returned by CheckCoroutineVersion when the
+// linked version of CoroutineModule is not supported.
+var ErrIncompatibleCoroutine = fmt.Errorf("incompatible version of %s", CoroutineModule)
+
+func init() {
+	// Coroutine state is only serialized in durable mode. Fail fast,
+	// rather than producing state that can't be resumed.
+	if coroutine.Durable {
+		if err := CheckCoroutineVersion(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// LinkedCoroutineVersion returns the version of CoroutineModule linked
+// into the program. It returns an empty string if the version is not
+// known, e.g. if the module was replaced with a local directory, or if
+// the program was built without module support.
+func LinkedCoroutineVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != CoroutineModule {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return ""
+}
+
+// CheckCoroutineVersion checks that the version of CoroutineModule
+// linked into the program is supported (see CoroutineVersion).
+//
+// The check is skipped, and CheckCoroutineVersion returns nil, if the
+// linked version is not known (see LinkedCoroutineVersion).
+//
+// In durable mode, the check runs when the package is initialized, and
+// the program panics if the version is not supported.
+func CheckCoroutineVersion() error {
+	return checkCoroutineVersion(LinkedCoroutineVersion())
+}
+
+func checkCoroutineVersion(version string) error {
+	if version == "" || version == "(devel)" {
+		return nil
+	}
+	if rest, ok := strings.CutPrefix(version, CoroutineVersion); ok && (rest == "" || rest[0] == '.') {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %s is linked into the program, but the SDK supports %s.x (durable coroutine state may not be resumed); update go.mod to require a %s.x version",
+		ErrIncompatibleCoroutine, CoroutineModule, version, CoroutineVersion, CoroutineVersion)
+}
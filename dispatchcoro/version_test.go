@@ -0,0 +1,28 @@
+package dispatchcoro
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckCoroutineVersion(t *testing.T) {
+	if err := CheckCoroutineVersion(); err != nil {
+		t.Errorf("unexpected error for linked version %q: %v", LinkedCoroutineVersion(), err)
+	}
+
+	for _, version := range []string{"", "(devel)", "v0.9", "v0.9.0", "v0.9.1", "v0.9.2-0.20240701000000-abcdef123456"} {
+		if err := checkCoroutineVersion(version); err != nil {
+			t.Errorf("unexpected error for version %q: %v", version, err)
+		}
+	}
+
+	for _, version := range []string{"v0.8.3", "v0.10.0", "v0.90.0", "v1.9.0"} {
+		err := checkCoroutineVersion(version)
+		if !errors.Is(err, ErrIncompatibleCoroutine) {
+			t.Errorf("expected an error for version %q, got %v", version, err)
+		} else if !strings.Contains(err.Error(), version) {
+			t.Errorf("expected the error to report version %q: %v", version, err)
+		}
+	}
+}
@@ -4,7 +4,6 @@ package dispatchcoro
 
 import (
 	"fmt"
-	"math/rand/v2"
 	"sync"
 )
 
@@ -16,7 +15,6 @@ import (
 // around, since they can be serialized and later recreated.
 type VolatileCoroutines struct {
 	instances map[InstanceID]Coroutine
-	nextID    InstanceID
 	mu        sync.Mutex
 }
 
@@ -29,12 +27,13 @@ func (f *VolatileCoroutines) Register(coro Coroutine) InstanceID {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if f.nextID == 0 {
-		f.nextID = rand.Uint64()
+	// IDs are drawn from the source of randomness of coroutines, so
+	// that they're reproducible when the source is seeded (see
+	// SetRandomSource).
+	id := randomUint64()
+	for _, used := f.instances[id]; id == 0 || used; _, used = f.instances[id] {
+		id = randomUint64()
 	}
-	f.nextID++
-
-	id := f.nextID
 	if f.instances == nil {
 		f.instances = map[InstanceID]Coroutine{}
 	}
@@ -0,0 +1,291 @@
+//go:build !durable
+
+package dispatchcron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidExpression is returned (wrapped) when a cron expression
+// cannot be parsed.
+var ErrInvalidExpression = errors.New("invalid cron expression")
+
+// Schedule is a parsed cron expression.
+//
+// Occurrences are computed on the wall clock of the schedule's
+// location. When a daylight saving time transition skips a wall clock
+// time that the schedule matches, the occurrence happens at the instant
+// of the transition instead. When a transition repeats wall clock
+// times, occurrences matching those times only happen once, the first
+// time around.
+type Schedule struct {
+	expr string
+	loc  *time.Location
+
+	minute, hour, dom, month, dow uint64
+
+	// Cron semantics: if both the day of month and day of week fields
+	// are restricted, a day matches if either field matches.
+	domStar, dowStar bool
+}
+
+// maxSearch bounds the search for the next occurrence of a schedule,
+// so that schedules that never match (e.g. "0 0 30 2 *") terminate.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression.
+//
+// The expression has five space separated fields: minute, hour, day of
+// month, month and day of week. Fields accept values, names (JAN-DEC,
+// SUN-SAT), ranges (1-5), lists (1,15), steps (*/10, 0-30/5) and the
+// wildcard (*). Both 0 and 7 mean Sunday. The descriptors @yearly,
+// @annually, @monthly, @weekly, @daily, @midnight and @hourly are also
+// supported.
+//
+// The expression is evaluated in UTC, unless it is prefixed with
+// CRON_TZ=<zone> or TZ=<zone>, where zone is an IANA time zone name,
+// e.g. "CRON_TZ=Europe/Paris 30 9 * * MON-FRI".
+func Parse(expr string) (*Schedule, error) {
+	return ParseInLocation(expr, time.UTC)
+}
+
+// ParseInLocation is like Parse but evaluates the expression in the
+// specified location, unless the expression has a time zone prefix.
+func ParseInLocation(expr string, loc *time.Location) (*Schedule, error) {
+	s := &Schedule{expr: expr, loc: loc}
+
+	spec := strings.TrimSpace(expr)
+	if prefix, rest, ok := strings.Cut(spec, " "); ok {
+		for _, key := range []string{"CRON_TZ=", "TZ="} {
+			if zone, ok := strings.CutPrefix(prefix, key); ok {
+				l, err := time.LoadLocation(zone)
+				if err != nil {
+					return nil, fmt.Errorf("%w %q: %v", ErrInvalidExpression, expr, err)
+				}
+				s.loc = l
+				spec = strings.TrimSpace(rest)
+				break
+			}
+		}
+	}
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: expected 5 fields, got %d", ErrInvalidExpression, expr, len(fields))
+	}
+	var err error
+	for _, f := range []struct {
+		bits  *uint64
+		field field
+		spec  string
+	}{
+		{&s.minute, minuteField, fields[0]},
+		{&s.hour, hourField, fields[1]},
+		{&s.dom, domField, fields[2]},
+		{&s.month, monthField, fields[3]},
+		{&s.dow, dowField, fields[4]},
+	} {
+		if *f.bits, err = f.field.parse(f.spec); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidExpression, expr, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 << 0
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// MustParse is like Parse but panics if the expression is invalid.
+func MustParse(expr string) *Schedule {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (f field) parse(spec string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+
+		var lo, hi int
+		switch {
+		case rangeSpec == "*":
+			lo, hi = f.min, f.max
+		default:
+			loSpec, hiSpec, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if lo, err = f.value(loSpec); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiSpec); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rangeSpec)
+			}
+		}
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, stepSpec)
+			}
+			step = n
+		}
+		for i := lo; i <= hi; i += step {
+			set |= 1 << i
+		}
+	}
+	return set, nil
+}
+
+func (f field) value(spec string) (int, error) {
+	if n, ok := f.names[strings.ToLower(spec)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid %s %q", f.name, spec)
+	}
+	return n, nil
+}
+
+// Location returns the location the schedule is evaluated in.
+func (s *Schedule) Location() *time.Location {
+	return s.loc
+}
+
+// String returns the cron expression.
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first occurrence of the schedule strictly after t.
+//
+// It returns the zero time if the schedule has no occurrence within the
+// next five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+
+	// The search is performed on the wall clock, represented as a UTC
+	// time so that it isn't affected by time zone transitions.
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	limit := wall.Add(maxSearch)
+
+	for wall.Before(limit) {
+		switch {
+		case !has(s.month, int(wall.Month())):
+			wall = time.Date(wall.Year(), wall.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchDay(wall):
+			wall = time.Date(wall.Year(), wall.Month(), wall.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !has(s.hour, wall.Hour()):
+			wall = wall.Truncate(time.Hour).Add(time.Hour)
+		case !has(s.minute, wall.Minute()):
+			wall = wall.Add(time.Minute)
+		default:
+			if next, ok := s.instant(wall, t); ok {
+				return next
+			}
+			wall = wall.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// Occurrences returns the occurrences of the schedule strictly after
+// start and up to (and including) end.
+func (s *Schedule) Occurrences(start, end time.Time) []time.Time {
+	var times []time.Time
+	for t := s.Next(start); !t.IsZero() && !t.After(end); t = s.Next(t) {
+		times = append(times, t)
+	}
+	return times
+}
+
+func (s *Schedule) matchDay(wall time.Time) bool {
+	dom := has(s.dom, wall.Day())
+	dow := has(s.dow, int(wall.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// instant converts a matching wall clock time to an instant in the
+// schedule's location, returning false if the instant is not after t.
+func (s *Schedule) instant(wall, t time.Time) (time.Time, bool) {
+	next := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, s.loc)
+
+	if actual := time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute(), 0, 0, time.UTC); !actual.Equal(wall) {
+		// The wall clock time was skipped by a transition (e.g. when
+		// clocks move forward); use the instant of the transition.
+		start, end := next.ZoneBounds()
+		if actual.Before(wall) {
+			next = end
+		} else {
+			next = start
+		}
+	} else if start, _ := next.ZoneBounds(); !start.IsZero() {
+		// If the wall clock time is repeated by a transition (e.g. when
+		// clocks move back), use its first occurrence.
+		_, offset := next.Zone()
+		_, prevOffset := start.Add(-time.Second).Zone()
+		if prevOffset > offset {
+			earlier := next.Add(-time.Duration(prevOffset-offset) * time.Second)
+			if earlier.Hour() == wall.Hour() && earlier.Minute() == wall.Minute() {
+				next = earlier
+			}
+		}
+	}
+	return next, next.After(t)
+}
+
+func has(set uint64, i int) bool {
+	return set&(1<<i) != 0
+}
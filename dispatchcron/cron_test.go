@@ -0,0 +1,181 @@
+package dispatchcron_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchcron"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * FOO *",
+		"CRON_TZ=Not/AZone * * * * *",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := dispatchcron.Parse(expr); !errors.Is(err, dispatchcron.ErrInvalidExpression) {
+				t.Errorf("expected ErrInvalidExpression, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 10, 30, 0, 0, time.UTC) // a Monday
+
+	for _, test := range []struct {
+		expr string
+		want []string
+	}{
+		{
+			expr: "*/15 * * * *",
+			want: []string{"2024-01-01T10:45:00Z", "2024-01-01T11:00:00Z", "2024-01-01T11:15:00Z"},
+		},
+		{
+			expr: "@daily",
+			want: []string{"2024-01-02T00:00:00Z", "2024-01-03T00:00:00Z"},
+		},
+		{
+			expr: "0 9 * * MON-FRI",
+			want: []string{"2024-01-02T09:00:00Z", "2024-01-03T09:00:00Z", "2024-01-04T09:00:00Z", "2024-01-05T09:00:00Z", "2024-01-08T09:00:00Z"},
+		},
+		{
+			expr: "0 0 * * 7",
+			want: []string{"2024-01-07T00:00:00Z", "2024-01-14T00:00:00Z"},
+		},
+		{
+			// Day of month and day of week match either.
+			expr: "0 0 13 * FRI",
+			want: []string{"2024-01-05T00:00:00Z", "2024-01-12T00:00:00Z", "2024-01-13T00:00:00Z", "2024-01-19T00:00:00Z"},
+		},
+		{
+			expr: "0 0 29 FEB *",
+			want: []string{"2024-02-29T00:00:00Z", "2028-02-29T00:00:00Z"},
+		},
+		{
+			expr: "CRON_TZ=Asia/Tokyo 0 9 * * *",
+			want: []string{"2024-01-02T00:00:00Z", "2024-01-03T00:00:00Z"},
+		},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			s, err := dispatchcron.Parse(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := format(s.Occurrences(start, mustParseTime(test.want[len(test.want)-1])))
+			if !slices.Equal(got, test.want) {
+				t.Errorf("unexpected occurrences:\n got %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextNever(t *testing.T) {
+	s := dispatchcron.MustParse("0 0 30 FEB *")
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("unexpected occurrence: %v", next)
+	}
+}
+
+func TestScheduleDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	t.Run("spring forward", func(t *testing.T) {
+		// On 2024-03-10, clocks jump from 02:00 EST to 03:00 EDT.
+		s, err := dispatchcron.ParseInLocation("30 2 * * *", loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Date(2024, time.March, 9, 0, 0, 0, 0, loc)
+		got := format(s.Occurrences(start, start.Add(3*24*time.Hour)))
+		want := []string{
+			"2024-03-09T07:30:00Z", // 02:30 EST
+			"2024-03-10T07:00:00Z", // 03:00 EDT, the transition
+			"2024-03-11T06:30:00Z", // 02:30 EDT
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("unexpected occurrences:\n got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("fall back", func(t *testing.T) {
+		// On 2024-11-03, clocks go back from 02:00 EDT to 01:00 EST.
+		s, err := dispatchcron.ParseInLocation("30 * * * *", loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Date(2024, time.November, 3, 0, 0, 0, 0, loc)
+		got := format(s.Occurrences(start, start.Add(4*time.Hour)))
+		want := []string{
+			"2024-11-03T04:30:00Z", // 00:30 EDT
+			"2024-11-03T05:30:00Z", // 01:30 EDT, but not 01:30 EST
+			"2024-11-03T07:30:00Z", // 02:30 EST
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("unexpected occurrences:\n got %v\nwant %v", got, want)
+		}
+
+		// Starting from the repeated hour doesn't fire again.
+		repeated := time.Date(2024, time.November, 3, 6, 0, 0, 0, time.UTC) // 01:00 EST
+		if next := s.Next(repeated); next.UTC().Format(time.RFC3339) != "2024-11-03T07:30:00Z" {
+			t.Errorf("unexpected occurrence: %v", next.UTC())
+		}
+	})
+}
+
+func TestCallSchedule(t *testing.T) {
+	report := dispatch.Func("report", func(ctx context.Context, day string) (string, error) {
+		return "report for " + day, nil
+	})
+
+	runner := dispatchtest.NewRunner(report)
+
+	s := dispatchcron.MustParse("0 8 * * *")
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, time.January, 3, 23, 59, 0, 0, time.UTC)
+
+	outputs, err := dispatchtest.CallSchedule(runner, s, report, func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"report for 2024-01-01", "report for 2024-01-02", "report for 2024-01-03"}
+	if !slices.Equal(outputs, want) {
+		t.Errorf("unexpected outputs: got %v, want %v", outputs, want)
+	}
+}
+
+func format(times []time.Time) []string {
+	s := make([]string, len(times))
+	for i, t := range times {
+		s[i] = t.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+func mustParseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
@@ -0,0 +1,191 @@
+//go:build !durable
+
+package dispatchcron
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Holidays is a calendar of days on which business hours don't apply.
+type Holidays interface {
+	// IsHoliday returns true if the day of date, in the location of
+	// date, is a holiday.
+	IsHoliday(date time.Time) bool
+}
+
+// HolidayFunc is a Holidays calendar backed by a function.
+type HolidayFunc func(date time.Time) bool
+
+// IsHoliday calls f.
+func (f HolidayFunc) IsHoliday(date time.Time) bool { return f(date) }
+
+// Dates is a Holidays calendar of fixed dates. Only the year, month and
+// day of the dates are compared.
+func Dates(dates ...time.Time) Holidays {
+	days := make(map[civilDate]struct{}, len(dates))
+	for _, d := range dates {
+		days[dateOf(d)] = struct{}{}
+	}
+	return HolidayFunc(func(date time.Time) bool {
+		_, ok := days[dateOf(date)]
+		return ok
+	})
+}
+
+type civilDate struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+func dateOf(t time.Time) civilDate {
+	y, m, d := t.Date()
+	return civilDate{y, m, d}
+}
+
+// BusinessHours is a recurring window of time, e.g. 9am to 5pm on
+// weekdays, used to gate the actions of workflows (approvals,
+// notifications) to business hours.
+//
+// Windows are computed on the wall clock of the location, so they
+// follow daylight saving time transitions. The methods take the current
+// time as argument rather than reading the clock, so that workflows
+// using them can be tested with simulated time (see
+// dispatchtest.CallSchedule).
+type BusinessHours struct {
+	// Location is the location the window is evaluated in. It defaults
+	// to UTC.
+	Location *time.Location
+
+	// Start and End are the times of day at which the window opens and
+	// closes, as offsets from midnight on the wall clock, e.g. 9 *
+	// time.Hour and 17 * time.Hour. The window is empty if End is not
+	// after Start.
+	Start, End time.Duration
+
+	// Days are the days of the week on which the window opens. It
+	// defaults to Monday through Friday.
+	Days []time.Weekday
+
+	// Holidays is a calendar of days on which the window doesn't open.
+	// It's optional.
+	Holidays Holidays
+}
+
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// Open returns true if t is within the window.
+func (b *BusinessHours) Open(t time.Time) bool {
+	open, close, ok := b.window(t.In(b.location()))
+	return ok && !t.Before(open) && t.Before(close)
+}
+
+// NextOpen returns t if it's within the window, or the time at which
+// the window next opens otherwise.
+//
+// A workflow that must only act during business hours suspends for
+// NextOpen(now).Sub(now) before acting (see WaitOpen).
+//
+// It returns the zero time if the window doesn't open within the next
+// five years.
+func (b *BusinessHours) NextOpen(t time.Time) time.Time {
+	if b.Open(t) {
+		return t
+	}
+	return b.Next(t)
+}
+
+// WaitOpen suspends the calling function until the window is open on
+// the clock now (e.g. time.Now, or a simulated clock in tests). It
+// returns immediately if the window is open.
+//
+// The function is suspended with a poll that makes no calls, and waits
+// for NextOpen(now).Sub(now), so WaitOpen must be called from a
+// function registered on a Dispatch endpoint. Since the function may be
+// resumed before the window opens (e.g. if Dispatch caps the time that
+// functions can be suspended for), it suspends again until the window
+// is open.
+//
+// It returns an error if the window doesn't open within the next five
+// years.
+func (b *BusinessHours) WaitOpen(now func() time.Time) error {
+	for {
+		t := now()
+		next := b.NextOpen(t)
+		if next.IsZero() {
+			return errors.New("business hours never open")
+		}
+		if !next.After(t) {
+			return nil
+		}
+
+		poll := dispatchproto.NewPoll(1, 1, next.Sub(t))
+		res := dispatchcoro.Yield(dispatchproto.NewResponse(poll))
+
+		pollResult, ok := res.PollResult()
+		if !ok {
+			return fmt.Errorf("unexpected response when polling: %s", res)
+		} else if err, ok := pollResult.Error(); ok {
+			return fmt.Errorf("poll error: %w", err)
+		}
+	}
+}
+
+// Next returns the first time strictly after t at which the window
+// opens, which makes BusinessHours a dispatchtest.Schedule.
+//
+// It returns the zero time if the window doesn't open within the next
+// five years.
+func (b *BusinessHours) Next(t time.Time) time.Time {
+	if b.End <= b.Start {
+		return time.Time{}
+	}
+	t = t.In(b.location())
+	year, month, day := t.Date()
+	for i := 0; i < int(maxSearch/(24*time.Hour)); i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, t.Location())
+		if open, _, ok := b.window(date); ok && open.After(t) {
+			return open
+		}
+	}
+	return time.Time{}
+}
+
+// window returns the window on the day of t, which must be in the
+// location of b, and false if the window doesn't open on that day.
+func (b *BusinessHours) window(t time.Time) (open, close time.Time, ok bool) {
+	if b.End <= b.Start {
+		return
+	}
+	days := b.Days
+	if len(days) == 0 {
+		days = weekdays
+	}
+	if !slices.Contains(days, t.Weekday()) {
+		return
+	}
+	if b.Holidays != nil && b.Holidays.IsHoliday(t) {
+		return
+	}
+	year, month, day := t.Date()
+	open = wallClock(year, month, day, b.Start, t.Location())
+	close = wallClock(year, month, day, b.End, t.Location())
+	return open, close, true
+}
+
+func wallClock(year int, month time.Month, day int, offset time.Duration, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, int(offset), loc)
+}
+
+func (b *BusinessHours) location() *time.Location {
+	if b.Location == nil {
+		return time.UTC
+	}
+	return b.Location
+}
@@ -0,0 +1,131 @@
+package dispatchcron_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchcron"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestBusinessHours(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	hours := &dispatchcron.BusinessHours{
+		Location: loc,
+		Start:    9 * time.Hour,
+		End:      17*time.Hour + 30*time.Minute,
+		Holidays: dispatchcron.Dates(time.Date(2024, time.July, 4, 0, 0, 0, 0, time.UTC)),
+	}
+
+	for _, test := range []struct {
+		now  string
+		open bool
+		next string
+	}{
+		{now: "2024-07-01T14:00:00Z", open: true, next: "2024-07-01T14:00:00Z"},  // Monday 10:00 EDT
+		{now: "2024-07-01T12:00:00Z", open: false, next: "2024-07-01T13:00:00Z"}, // Monday 08:00 EDT
+		{now: "2024-07-01T21:30:00Z", open: false, next: "2024-07-02T13:00:00Z"}, // Monday 17:30 EDT
+		{now: "2024-07-03T22:00:00Z", open: false, next: "2024-07-05T13:00:00Z"}, // skips the holiday
+		{now: "2024-07-05T22:00:00Z", open: false, next: "2024-07-08T13:00:00Z"}, // skips the weekend
+		{now: "2024-11-01T22:00:00Z", open: false, next: "2024-11-04T14:00:00Z"}, // 09:00 EST after DST ends
+	} {
+		t.Run(test.now, func(t *testing.T) {
+			now := mustParseTime(test.now)
+			if open := hours.Open(now); open != test.open {
+				t.Errorf("unexpected Open: got %v, want %v", open, test.open)
+			}
+			if next := hours.NextOpen(now).UTC().Format(time.RFC3339); next != test.next {
+				t.Errorf("unexpected NextOpen: got %v, want %v", next, test.next)
+			}
+		})
+	}
+
+	empty := &dispatchcron.BusinessHours{Start: 9 * time.Hour, End: 9 * time.Hour}
+	if next := empty.NextOpen(mustParseTime("2024-07-01T00:00:00Z")); !next.IsZero() {
+		t.Errorf("unexpected NextOpen for an empty window: %v", next)
+	}
+}
+
+func TestBusinessHoursSchedule(t *testing.T) {
+	notify := dispatch.Func("notify", func(ctx context.Context, day string) (string, error) {
+		return "notified on " + day, nil
+	})
+
+	runner := dispatchtest.NewRunner(notify)
+
+	hours := &dispatchcron.BusinessHours{
+		Start:    9 * time.Hour,
+		End:      17 * time.Hour,
+		Days:     []time.Weekday{time.Saturday, time.Sunday},
+		Holidays: dispatchcron.HolidayFunc(func(date time.Time) bool { return date.Day() == 7 }),
+	}
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, time.January, 14, 23, 59, 0, 0, time.UTC)
+
+	outputs, err := dispatchtest.CallSchedule(runner, hours, notify, func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	}, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"notified on 2024-01-06", "notified on 2024-01-13", "notified on 2024-01-14"}
+	if !slices.Equal(outputs, want) {
+		t.Errorf("unexpected outputs: got %v, want %v", outputs, want)
+	}
+}
+
+func TestBusinessHoursWaitOpen(t *testing.T) {
+	hours := &dispatchcron.BusinessHours{Start: 9 * time.Hour, End: 17 * time.Hour}
+
+	// The clock reads 8:00 on a Monday, and 9:00 once the function
+	// resumes.
+	clock := []time.Time{
+		mustParseTime("2024-07-01T08:00:00Z"),
+		mustParseTime("2024-07-01T09:00:00Z"),
+	}
+	now := func() time.Time {
+		t := clock[0]
+		if len(clock) > 1 {
+			clock = clock[1:]
+		}
+		return t
+	}
+	notify := dispatch.Func("notify", func(ctx context.Context, msg string) (string, error) {
+		if err := hours.WaitOpen(now); err != nil {
+			return "", err
+		}
+		return msg + " at " + now().Format(time.Kitchen), nil
+	})
+	_, run := notify.Register(nil)
+
+	call, err := notify.BuildCall("approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := run(context.Background(), call.Request())
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	if calls := poll.Calls(); len(calls) != 0 {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+	if maxWait := poll.MaxWait(); maxWait != time.Hour {
+		t.Errorf("unexpected max wait: got %v, want %v", maxWait, time.Hour)
+	}
+
+	res = run(context.Background(), dispatchproto.NewRequest("notify", poll.Result()))
+	dispatchtest.AssertExit(t, res, "approved at 9:00AM")
+
+	empty := &dispatchcron.BusinessHours{Start: 9 * time.Hour, End: 9 * time.Hour}
+	if err := empty.WaitOpen(time.Now); err == nil {
+		t.Error("expected an error for an empty window")
+	}
+}
@@ -0,0 +1,346 @@
+//go:build !durable
+
+// Package dispatchgen generates manifests of the Dispatch functions of
+// a package.
+//
+// A manifest lists the functions that a package creates with
+// dispatch.Func (and its variants), along with their input and output
+// types and their position in the sources, without running the
+// program. It's generated from the sources of the package (see Scan),
+// typically by the dispatchgen command from a go:generate directive:
+//
+//	//go:generate go run github.com/dispatchrun/dispatch-go/cmd/dispatchgen
+//
+// Generating the manifest detects functions registered with the same
+// name at compile time, and lets tools export the functions of an
+// endpoint, or register them with Dispatch, without reflecting on the
+// running program.
+package dispatchgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DispatchPackage is the import path of the dispatch package, whose
+// function constructors are looked up by Scan.
+const DispatchPackage = "github.com/dispatchrun/dispatch-go"
+
+// DefaultOutput is the default name of the manifest file written by the
+// dispatchgen command.
+const DefaultOutput = "dispatch_manifest.json"
+
+// Manifest is the manifest of the Dispatch functions of a package.
+type Manifest struct {
+	// Package is the name of the package.
+	Package string `json:"package"`
+
+	// Functions are the functions created by the package, in the order
+	// that they appear in its sources.
+	Functions []Function `json:"functions"`
+}
+
+// Function is a Dispatch function of a Manifest.
+type Function struct {
+	// Name is the name of the function.
+	Name string `json:"name"`
+
+	// Constructor is the dispatch function that creates the function,
+	// e.g. "Func" or "PrimitiveFunc".
+	Constructor string `json:"constructor"`
+
+	// Input and Output are the input and output types of the function,
+	// as written in the sources of the package. They're empty if the
+	// function has no input or output (e.g. with dispatch.Func0), or if
+	// the type cannot be determined from the sources.
+	Input  string `json:"input,omitempty"`
+	Output string `json:"output,omitempty"`
+
+	// File and Line are the position of the call that creates the
+	// function. The file is relative to the directory of the package.
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Scan generates the manifest of the Dispatch functions created by the
+// package in dir, from its sources. Test files and files excluded by
+// build constraints are ignored.
+//
+// Functions are found by looking up calls to dispatch.Func,
+// dispatch.Func0, dispatch.FuncVoid and dispatch.PrimitiveFunc. Calls
+// that name the function with an expression other than a constant
+// string are skipped, since the name is only known when the program
+// runs.
+func Scan(dir string) (*Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if match, err := build.Default.MatchFile(dir, name); err != nil {
+			return nil, err
+		} else if !match {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	s := scanner{
+		fset:      fset,
+		funcs:     make(map[string]*ast.FuncType),
+		constants: make(map[string]string),
+	}
+	for _, file := range files {
+		s.declare(file)
+	}
+	m := &Manifest{Package: files[0].Name.Name}
+	for _, file := range files {
+		m.Functions = append(m.Functions, s.scan(file)...)
+	}
+	return m, nil
+}
+
+// Check returns an error if functions of the manifest have the same
+// name, which would make the registration of the functions panic.
+func (m *Manifest) Check() error {
+	var problems []string
+	seen := make(map[string]Function, len(m.Functions))
+	for _, fn := range m.Functions {
+		if prev, ok := seen[fn.Name]; ok {
+			problems = append(problems, fmt.Sprintf("%s:%d: function %q already created at %s:%d", fn.File, fn.Line, fn.Name, prev.File, prev.Line))
+			continue
+		}
+		seen[fn.Name] = fn
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("duplicate Dispatch functions:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// MarshalIndent encodes the manifest as indented JSON, as written by
+// the dispatchgen command.
+func (m *Manifest) MarshalIndent() ([]byte, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ReadFile reads a manifest written by the dispatchgen command.
+func ReadFile(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid Dispatch manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+type scanner struct {
+	fset *token.FileSet
+
+	// funcs and constants are the package-level functions and string
+	// constants, which can be passed to the function constructors.
+	funcs     map[string]*ast.FuncType
+	constants map[string]string
+}
+
+func (s *scanner) declare(file *ast.File) {
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				s.funcs[decl.Name.Name] = decl.Type
+			}
+		case *ast.GenDecl:
+			if decl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.ValueSpec)
+				for i, name := range spec.Names {
+					if i < len(spec.Values) {
+						if value, ok := stringLiteral(spec.Values[i]); ok {
+							s.constants[name.Name] = value
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *scanner) scan(file *ast.File) []Function {
+	dispatch := importName(file)
+	if dispatch == "" {
+		return nil
+	}
+	var functions []Function
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		constructor, typeArgs := constructorOf(call.Fun, dispatch)
+		if constructor == "" {
+			return true
+		}
+		name, ok := s.name(call.Args[0])
+		if !ok {
+			return true
+		}
+		pos := s.fset.Position(call.Pos())
+		fn := Function{
+			Name:        name,
+			Constructor: constructor,
+			File:        filepath.Base(pos.Filename),
+			Line:        pos.Line,
+		}
+		fn.Input, fn.Output = s.types(constructor, typeArgs, call.Args[1])
+		functions = append(functions, fn)
+		return true
+	})
+	return functions
+}
+
+func (s *scanner) name(expr ast.Expr) (string, bool) {
+	if value, ok := stringLiteral(expr); ok {
+		return value, true
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		value, ok := s.constants[ident.Name]
+		return value, ok
+	}
+	return "", false
+}
+
+// types returns the input and output types of a function, from the
+// explicit type arguments of the constructor if any, or else from the
+// signature of the Go function.
+func (s *scanner) types(constructor string, typeArgs []ast.Expr, fn ast.Expr) (input, output string) {
+	switch constructor {
+	case "PrimitiveFunc":
+		return "dispatchproto.Any", "dispatchproto.Any"
+	case "Func":
+		if len(typeArgs) == 2 {
+			return types.ExprString(typeArgs[0]), types.ExprString(typeArgs[1])
+		}
+	case "Func0", "FuncVoid":
+		if len(typeArgs) == 1 {
+			if constructor == "Func0" {
+				return "", types.ExprString(typeArgs[0])
+			}
+			return types.ExprString(typeArgs[0]), ""
+		}
+	}
+
+	var signature *ast.FuncType
+	switch fn := fn.(type) {
+	case *ast.FuncLit:
+		signature = fn.Type
+	case *ast.Ident:
+		signature = s.funcs[fn.Name]
+	}
+	if signature == nil {
+		return "", ""
+	}
+	params, results := fieldTypes(signature.Params), fieldTypes(signature.Results)
+	if constructor != "Func0" && len(params) == 2 {
+		input = types.ExprString(params[1])
+	}
+	if constructor != "FuncVoid" && len(results) == 2 {
+		output = types.ExprString(results[0])
+	}
+	return input, output
+}
+
+// importName returns the name that the file imports the dispatch
+// package with, or an empty string if it doesn't import it.
+func importName(file *ast.File) string {
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || path != DispatchPackage {
+			continue
+		}
+		if spec.Name == nil {
+			return "dispatch"
+		}
+		if name := spec.Name.Name; name != "_" && name != "." {
+			return name
+		}
+	}
+	return ""
+}
+
+var constructors = []string{"Func", "Func0", "FuncVoid", "PrimitiveFunc"}
+
+func constructorOf(fun ast.Expr, dispatch string) (string, []ast.Expr) {
+	var typeArgs []ast.Expr
+	switch expr := fun.(type) {
+	case *ast.IndexExpr:
+		fun, typeArgs = expr.X, []ast.Expr{expr.Index}
+	case *ast.IndexListExpr:
+		fun, typeArgs = expr.X, expr.Indices
+	}
+	sel, ok := fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", nil
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != dispatch || !slices.Contains(constructors, sel.Sel.Name) {
+		return "", nil
+	}
+	return sel.Sel.Name, typeArgs
+}
+
+// fieldTypes returns the type of each parameter or result of a list of
+// fields, e.g. (ctx context.Context, a, b int) has three.
+func fieldTypes(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var exprs []ast.Expr
+	for _, field := range fields.List {
+		n := max(len(field.Names), 1)
+		for range n {
+			exprs = append(exprs, field.Type)
+		}
+	}
+	return exprs
+}
+
+func stringLiteral(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	return value, err == nil
+}
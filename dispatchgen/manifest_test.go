@@ -0,0 +1,105 @@
+package dispatchgen_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchgen"
+)
+
+const source = `package app
+
+import (
+	"context"
+
+	d "github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+const greetName = "greet"
+
+var (
+	greet = d.Func(greetName, func(ctx context.Context, name string) (string, error) {
+		return "hello " + name, nil
+	})
+	count   = d.Func("count", countWords)
+	now     = d.Func0("now", func(context.Context) (int64, error) { return 0, nil })
+	log     = d.FuncVoid[[]string]("log", nil)
+	raw     = d.PrimitiveFunc("raw", func(ctx context.Context, input dispatchproto.Any) (dispatchproto.Any, error) { return input, nil })
+	dynamic = d.Func(dynamicName(), countWords)
+)
+
+func countWords(ctx context.Context, text string) (n int, err error) { return 0, nil }
+
+func dynamicName() string { return "dynamic" }
+`
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "app.go", source)
+	writeFile(t, dir, "app_test.go", "package app\n\nvar _ = d.Func(\"test\", countWords)\n")
+	writeFile(t, dir, "app_durable.go", "//go:build durable\n\npackage app\n")
+
+	manifest, err := dispatchgen.Scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &dispatchgen.Manifest{
+		Package: "app",
+		Functions: []dispatchgen.Function{
+			{Name: "greet", Constructor: "Func", Input: "string", Output: "string", File: "app.go", Line: 13},
+			{Name: "count", Constructor: "Func", Input: "string", Output: "int", File: "app.go", Line: 16},
+			{Name: "now", Constructor: "Func0", Output: "int64", File: "app.go", Line: 17},
+			{Name: "log", Constructor: "FuncVoid", Input: "[]string", File: "app.go", Line: 18},
+			{Name: "raw", Constructor: "PrimitiveFunc", Input: "dispatchproto.Any", Output: "dispatchproto.Any", File: "app.go", Line: 19},
+		},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("unexpected manifest:\n got %+v\nwant %+v", manifest, want)
+	}
+	if err := manifest.Check(); err != nil {
+		t.Error(err)
+	}
+
+	// The manifest round-trips through its file.
+	b, err := manifest.MarshalIndent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, dispatchgen.DefaultOutput, string(b))
+	read, err := dispatchgen.ReadFile(filepath.Join(dir, dispatchgen.DefaultOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, manifest) {
+		t.Errorf("unexpected manifest read: %+v", read)
+	}
+}
+
+func TestCheckDuplicates(t *testing.T) {
+	manifest := &dispatchgen.Manifest{
+		Package: "app",
+		Functions: []dispatchgen.Function{
+			{Name: "greet", Constructor: "Func", File: "a.go", Line: 3},
+			{Name: "other", Constructor: "Func", File: "a.go", Line: 4},
+			{Name: "greet", Constructor: "Func0", File: "b.go", Line: 7},
+		},
+	}
+	err := manifest.Check()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if want := `b.go:7: function "greet" already created at a.go:3`; !strings.Contains(err.Error(), want) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
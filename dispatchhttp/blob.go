@@ -0,0 +1,125 @@
+//go:build !durable
+
+package dispatchhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BlobStore stores response bodies that are too large to be serialized
+// with the Response (see Client.SpillThreshold).
+//
+// Bodies are referenced by URLs, whose scheme identifies the store that
+// holds them (e.g. "file:///var/lib/bodies/body-123"). Stores must be
+// registered with RegisterBlobStore by all the processes that unmarshal
+// responses, so that bodies can be read back.
+type BlobStore interface {
+	// Put stores a blob, and returns a URL that references it.
+	Put(ctx context.Context, r io.Reader) (string, error)
+
+	// Get opens a blob referenced by a URL returned by Put.
+	Get(ctx context.Context, ref string) (io.ReadCloser, error)
+}
+
+var (
+	blobStoresMu sync.RWMutex
+	blobStores   = map[string]BlobStore{}
+)
+
+// RegisterBlobStore registers the BlobStore that holds blobs referenced
+// by URLs with the specified scheme. Registering a store for a scheme
+// that already has one replaces it.
+func RegisterBlobStore(scheme string, store BlobStore) {
+	blobStoresMu.Lock()
+	defer blobStoresMu.Unlock()
+	blobStores[scheme] = store
+}
+
+// LookupBlobStore returns the BlobStore registered for the specified
+// scheme.
+func LookupBlobStore(scheme string) (BlobStore, bool) {
+	blobStoresMu.RLock()
+	defer blobStoresMu.RUnlock()
+	store, ok := blobStores[scheme]
+	return store, ok
+}
+
+func lookupBlobStore(ref string) (BlobStore, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob reference %q: %w", ref, err)
+	}
+	store, ok := LookupBlobStore(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("cannot read blob %q: no blob store registered for scheme %q", ref, u.Scheme)
+	}
+	return store, nil
+}
+
+func readBlob(ctx context.Context, ref string) ([]byte, error) {
+	store, err := lookupBlobStore(ref)
+	if err != nil {
+		return nil, err
+	}
+	r, err := store.Get(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blob %q: %w", ref, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// DirBlobStore returns a BlobStore that stores blobs as files in a local
+// directory, referenced by "file" URLs. It's useful when the processes
+// that serve a Dispatch endpoint share a disk.
+//
+// Blobs are never deleted by the store.
+func DirBlobStore(dir string) (BlobStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return dirBlobStore(dir), nil
+}
+
+type dirBlobStore string
+
+func (d dirBlobStore) Put(ctx context.Context, r io.Reader) (string, error) {
+	f, err := os.CreateTemp(string(d), "body-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(f.Name())}).String(), nil
+}
+
+func (d dirBlobStore) Get(ctx context.Context, ref string) (io.ReadCloser, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.FromSlash(u.Path)
+	// Only serve files from the directory of the store.
+	if rel, err := filepath.Rel(string(d), path); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("blob %q is not in directory %s", ref, string(d))
+	}
+	return os.Open(path)
+}
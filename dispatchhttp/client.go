@@ -5,12 +5,39 @@ package dispatchhttp
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
 )
 
 // Client wraps an http.Client to accept Request instances
 // and return Response instances.
-type Client struct{ Client *http.Client }
+type Client struct {
+	// Client is the underlying HTTP client. It defaults to
+	// http.DefaultClient.
+	Client *http.Client
+
+	// Header is a set of headers that are added to each request,
+	// unless the request sets them.
+	Header http.Header
+
+	// Timeout is the maximum duration of each request, including
+	// reading the response body. There's no timeout if it's zero.
+	Timeout time.Duration
+
+	// SpillThreshold is the size in bytes above which response bodies
+	// are spilled to the BlobStore rather than buffered in memory and
+	// serialized with the Response (see Response.BodyRef). Bodies are
+	// not spilled if it's zero, or if BlobStore is nil.
+	SpillThreshold int64
+
+	// BlobStore is the store that large response bodies are spilled to.
+	// It must also be registered with RegisterBlobStore, so that bodies
+	// can be read back when responses are unmarshaled.
+	BlobStore BlobStore
+}
 
 // DefaultClient is the default client.
 var DefaultClient = &Client{Client: http.DefaultClient}
@@ -29,16 +56,55 @@ func Get(ctx context.Context, url string) (*Response, error) {
 }
 
 // Do makes a HTTP Request and returns its Response.
+//
+// Requests can use any HTTP method. The Response is returned regardless
+// of its status code; its Status categorizes it for Dispatch (see
+// dispatchproto.StatusOf).
 func (c *Client) Do(ctx context.Context, r *Request) (*Response, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
 	httpReq, err := http.NewRequestWithContext(ctx, r.Method, r.URL, bytes.NewReader(r.Body))
 	if err != nil {
 		return nil, err
 	}
+	copyHeader(httpReq.Header, c.Header)
 	copyHeader(httpReq.Header, r.Header)
 
-	httpRes, err := c.Client.Do(httpReq)
+	httpClient := c.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpRes, err := httpClient.Do(httpReq)
 	if err != nil {
 		return nil, err
 	}
+	if c.SpillThreshold > 0 && c.BlobStore != nil {
+		return spill(ctx, httpRes, c.SpillThreshold, c.BlobStore)
+	}
 	return FromResponse(httpRes)
 }
+
+// Func creates a Dispatch function that makes HTTP requests with the
+// client, so that other functions can Await HTTP requests durably.
+//
+// The status of each response is mapped to a Dispatch status (see
+// Response.Status), e.g. responses with a 5xx status code are
+// categorized as temporary errors and retried by Dispatch.
+func (c *Client) Func(name string) *dispatch.Function[*Request, *Response] {
+	return dispatch.Func(name, func(ctx context.Context, req *Request) (*Response, error) {
+		if req == nil {
+			return nil, fmt.Errorf("%w: missing HTTP request", dispatch.ErrInvalidArgument)
+		}
+		return c.Do(ctx, req)
+	})
+}
+
+// Func creates a Dispatch function that makes HTTP requests with the
+// DefaultClient (see Client.Func).
+func Func(name string) *dispatch.Function[*Request, *Response] {
+	return DefaultClient.Func(name)
+}
@@ -1,12 +1,20 @@
 package dispatchhttp_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dispatchrun/dispatch-go/dispatchhttp"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -157,3 +165,157 @@ func TestStatusCodeStatus(t *testing.T) {
 		})
 	}
 }
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Agent", r.Header.Get("User-Agent"))
+		w.Header().Set("X-Foo", r.Header.Get("X-Foo"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client := &dispatchhttp.Client{
+		Header: http.Header{
+			"User-Agent": []string{"test"},
+			"X-Foo":      []string{"default"},
+		},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	res, err := client.Do(context.Background(), &dispatchhttp.Request{
+		Method: "PUT",
+		URL:    server.URL,
+		Header: http.Header{"X-Foo": []string{"bar"}},
+		Body:   []byte("abc"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &dispatchhttp.Response{
+		StatusCode: http.StatusCreated,
+		Header: http.Header{
+			"X-Method": []string{"PUT"},
+			"X-Agent":  []string{"test"},
+			"X-Foo":    []string{"bar"},
+		},
+		Body: []byte("abc"),
+	}
+	res.Header.Del("Date")
+	res.Header.Del("Content-Length")
+	res.Header.Del("Content-Type")
+	if diff := cmp.Diff(want, res); diff != "" {
+		t.Errorf("unexpected response: %v", diff)
+	}
+
+	_, err = client.Get(context.Background(), server.URL+"/slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("ok"))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	fetch := dispatchhttp.Func("fetch")
+	runner := dispatchtest.NewRunner(fetch)
+
+	res, err := dispatchtest.Call(runner, fetch, &dispatchhttp.Request{Method: "GET", URL: server.URL + "/ok"})
+	if err != nil {
+		t.Fatal(err)
+	} else if string(res.Body) != "ok" {
+		t.Errorf("unexpected response body: %q", res.Body)
+	}
+
+	// Responses are categorized by their status code, so that Dispatch
+	// retries the call.
+	call, err := fetch.BuildCall(&dispatchhttp.Request{Method: "GET", URL: server.URL + "/unavailable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := runner.Run(call.Request()).Status(); status != dispatchproto.TemporaryErrorStatus {
+		t.Errorf("unexpected status: got %v, want %v", status, dispatchproto.TemporaryErrorStatus)
+	}
+}
+
+func TestClientSpill(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/small" {
+			w.Write([]byte("small"))
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	store, err := dispatchhttp.DirBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchhttp.RegisterBlobStore("file", store)
+
+	client := &dispatchhttp.Client{SpillThreshold: 100, BlobStore: store}
+
+	// Small bodies are not spilled.
+	res, err := client.Get(context.Background(), server.URL+"/small")
+	if err != nil {
+		t.Fatal(err)
+	} else if string(res.Body) != "small" || res.BodyRef != "" {
+		t.Errorf("unexpected response: %+v", res)
+	}
+
+	res, err = client.Get(context.Background(), server.URL+"/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Body != nil || !strings.HasPrefix(res.BodyRef, "file://") {
+		t.Fatalf("expected body to be spilled: %+v", res)
+	}
+	r, err := res.OpenBody(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, body) {
+		t.Errorf("unexpected body: got %d bytes, want %d", len(b), len(body))
+	}
+
+	// The body isn't serialized with the response, and is read back
+	// from the store on unmarshal.
+	boxed, err := dispatchproto.Marshal(res, dispatchproto.WithCodec(dispatchproto.JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	} else if n := len(boxed.Value()); n >= len(body) {
+		t.Errorf("serialized response is too large: %d bytes", n)
+	}
+	var res2 *dispatchhttp.Response
+	if err := boxed.Unmarshal(&res2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res2.Body, body) || res2.BodyRef != res.BodyRef {
+		t.Errorf("unexpected unmarshaled response: %d bytes, ref %q", len(res2.Body), res2.BodyRef)
+	}
+
+	// The store only serves files from its directory.
+	if _, err := store.Get(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("expected an error reading a file outside of the store")
+	}
+}
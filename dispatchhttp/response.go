@@ -3,7 +3,10 @@
 package dispatchhttp
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 
@@ -15,6 +18,26 @@ type Response struct {
 	StatusCode int
 	Header     http.Header
 	Body       []byte
+
+	// BodyRef references the body in a BlobStore, if it was spilled
+	// because it was too large (see Client.SpillThreshold). When set,
+	// the body isn't serialized with the response, and is read back
+	// from the store into Body when the response is unmarshaled. Until
+	// then, Body is empty; OpenBody streams the body from the store.
+	BodyRef string
+}
+
+// OpenBody opens the body of the response, reading it from the
+// BlobStore it was spilled to if Body is empty (see BodyRef).
+func (r *Response) OpenBody(ctx context.Context) (io.ReadCloser, error) {
+	if r.BodyRef == "" || r.Body != nil {
+		return io.NopCloser(bytes.NewReader(r.Body)), nil
+	}
+	store, err := lookupBlobStore(r.BodyRef)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(ctx, r.BodyRef)
 }
 
 // FromResponse creates a Response from an http.Response.
@@ -39,13 +62,43 @@ func FromResponse(r *http.Response) (*Response, error) {
 	}, nil
 }
 
+// spill reads a response body, and stores it in a BlobStore if it's
+// larger than the threshold.
+func spill(ctx context.Context, r *http.Response, threshold int64, store BlobStore) (*Response, error) {
+	defer r.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(r.Body, threshold+1))
+	if err != nil {
+		return nil, err
+	}
+	res := &Response{
+		StatusCode: r.StatusCode,
+		Header:     cloneHeader(r.Header),
+	}
+	if int64(len(b)) <= threshold {
+		res.Body = b
+		return res, nil
+	}
+	// Stream the rest of the body to the store, rather than buffering it.
+	ref, err := store.Put(ctx, io.MultiReader(bytes.NewReader(b), r.Body))
+	if err != nil {
+		return nil, fmt.Errorf("cannot spill response body: %w", err)
+	}
+	res.BodyRef = ref
+	return res, nil
+}
+
 func (r *Response) MarshalJSON() ([]byte, error) {
 	// Indirection is required to avoid an infinite loop.
-	return json.Marshal(jsonResponse{
+	jr := jsonResponse{
 		StatusCode: r.StatusCode,
 		Header:     r.Header,
 		Body:       r.Body,
-	})
+		BodyRef:    r.BodyRef,
+	}
+	if r.BodyRef != "" {
+		jr.Body = nil
+	}
+	return json.Marshal(jr)
 }
 
 func (r *Response) UnmarshalJSON(b []byte) error {
@@ -56,6 +109,14 @@ func (r *Response) UnmarshalJSON(b []byte) error {
 	r.StatusCode = jr.StatusCode
 	r.Header = jr.Header
 	r.Body = jr.Body
+	r.BodyRef = jr.BodyRef
+	if jr.BodyRef != "" {
+		body, err := readBlob(context.Background(), jr.BodyRef)
+		if err != nil {
+			return err
+		}
+		r.Body = body
+	}
 	return nil
 }
 
@@ -63,6 +124,7 @@ type jsonResponse struct {
 	StatusCode int         `json:"status_code,omitempty"`
 	Header     http.Header `json:"header,omitempty"`
 	Body       []byte      `json:"body,omitempty"`
+	BodyRef    string      `json:"body_ref,omitempty"`
 }
 
 // Status is the status for the response.
@@ -3,6 +3,7 @@ package dispatchlambda
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	_ "unsafe"
 
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
@@ -22,16 +23,44 @@ func Start(functions ...dispatch.AnyFunction) {
 // Handler creates a lambda function handler executing the given
 // Dispatch functions when invoked.
 func Handler(functions ...dispatch.AnyFunction) lambda.Handler {
-	handler := &handler{functions: dispatchproto.FunctionMap{}}
+	h, _ := NewHandler(functions)
+	return h
+}
+
+// NewHandler is like Handler, but accepts options to configure the
+// handler.
+func NewHandler(functions []dispatch.AnyFunction, opts ...Option) (lambda.Handler, error) {
+	h := &handler{
+		functions:      dispatchproto.FunctionMap{},
+		errorSizeLimit: dispatchproto.DefaultErrorSizeLimit,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.errorSizeLimit <= 0 {
+		return nil, fmt.Errorf("invalid limit provided via ErrorSizeLimit(..): %d", h.errorSizeLimit)
+	}
 	for _, fn := range functions {
 		name, primitive := fn.Register(nil)
-		handler.functions[name] = primitive
+		h.functions[name] = primitive
 	}
-	return handler
+	return h, nil
+}
+
+// Option configures a lambda function handler.
+type Option func(*handler)
+
+// ErrorSizeLimit sets the maximum size, in bytes, of the message,
+// traceback and value of errors returned by functions (see
+// dispatch.ErrorSizeLimit). The limit must be positive, and defaults
+// to dispatchproto.DefaultErrorSizeLimit.
+func ErrorSizeLimit(limit int) Option {
+	return func(h *handler) { h.errorSizeLimit = limit }
 }
 
 type handler struct {
-	functions dispatchproto.FunctionMap
+	functions      dispatchproto.FunctionMap
+	errorSizeLimit int
 }
 
 func (h *handler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
@@ -58,6 +87,11 @@ func (h *handler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
 	}
 
 	res := h.functions.Run(ctx, newProtoRequest(req))
+	if err, ok := res.Error(); ok {
+		if truncated := err.Truncate(h.errorSizeLimit); !truncated.Equal(err) {
+			res = res.With(truncated)
+		}
+	}
 
 	rawResponse, err := proto.Marshal(responseProto(res))
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"strings"
 	"testing"
 
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
@@ -189,6 +190,47 @@ func TestHandlerInvokeFunction(t *testing.T) {
 	}
 }
 
+func TestHandlerErrorSizeLimit(t *testing.T) {
+	if _, err := dispatchlambda.NewHandler(nil, dispatchlambda.ErrorSizeLimit(0)); err == nil || err.Error() != "invalid limit provided via ErrorSizeLimit(..): 0" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	fn := dispatch.Func("handler", func(ctx context.Context, input string) (string, error) {
+		return "", errors.New(strings.Repeat("x", 100))
+	})
+	h, err := dispatchlambda.NewHandler([]dispatch.AnyFunction{fn}, dispatchlambda.ErrorSizeLimit(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input, err := anypb.New(&wrapperspb.StringValue{Value: "input"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(&sdkv1.RunRequest{
+		Function:  "handler",
+		Directive: &sdkv1.RunRequest_Input{Input: input},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = h.Invoke(context.Background(), []byte(`"`+base64.StdEncoding.EncodeToString(b)+`"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = base64.StdEncoding.DecodeString(string(b[1 : len(b)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := new(sdkv1.RunResponse)
+	if err := proto.Unmarshal(b, res); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("x", 8) + "... [truncated 92 bytes]"; res.GetExit().GetResult().GetError().GetMessage() != want {
+		t.Errorf("unexpected error message: got %q, want %q", res.GetExit().GetResult().GetError().GetMessage(), want)
+	}
+}
+
 func assertInvokeError(t *testing.T, err error, typ, msg string) {
 	t.Helper()
 
@@ -3,11 +3,18 @@
 package dispatchproto
 
 import (
+	"bytes"
+	"context"
 	"encoding"
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
+	"unsafe"
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
@@ -85,13 +92,38 @@ func Duration(v time.Duration) Any {
 // Primitive values (booleans, integers, floats, strings, bytes, timestamps,
 // durations) are supported, along with values that implement either
 // proto.Message, json.Marshaler, encoding.TextMarshaler or
-// encoding.BinaryMarshaler. Slices and maps are also supported, as long
-// as they are JSON-like in shape.
-func Marshal(v any) (Any, error) {
+// encoding.BinaryMarshaler. Slices, maps, structs and pointers are also
+// supported, as long as they are JSON-like in shape. Struct fields are
+// serialized like encoding/json does, honoring json struct tags (see
+// WithFieldNaming to name untagged fields otherwise). An Any
+// is returned as is.
+//
+// Types with a string codec (see RegisterStringCodec), such as *big.Int
+// and *big.Rat, are serialized as strings.
+//
+// Values are encoded with ProtoCodec by default (see WithCodec). The
+// output isn't deterministic unless the Deterministic option is set.
+func Marshal(v any, opts ...MarshalOption) (Any, error) {
+	if a, ok := v.(Any); ok {
+		return a, nil
+	}
+	var o marshalOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.codec == JSONCodec {
+		return marshalJSON(v, o.deterministic)
+	}
 	rv := reflect.ValueOf(v)
 	if rv.Kind() == reflect.Pointer && rv.IsNil() {
 		return Nil(), nil
 	}
+	if s, ok, err := marshalString(rv); ok {
+		if err != nil {
+			return Any{}, fmt.Errorf("cannot serialize %T: %w", v, err)
+		}
+		return newAny(wrapperspb.String(s), o.deterministic)
+	}
 	var m proto.Message
 	switch vv := v.(type) {
 	case nil:
@@ -103,19 +135,11 @@ func Marshal(v any) (Any, error) {
 	case time.Duration:
 		m = durationpb.New(vv)
 	case json.Marshaler:
-		// Obviously not ideal going to bytes, then to any, then
-		// to structpb.Value! It would be more efficient to use
-		// a json.Decoder, and/or to use a third-party JSON library.
 		b, err := vv.MarshalJSON()
 		if err != nil {
 			return Any{}, err
 		}
-		var v any
-		if err := json.Unmarshal(b, &v); err != nil {
-			return Any{}, err
-		}
-		m, err = structpb.NewValue(v)
-		if err != nil {
+		if m, err = newStructpbValueFromJSON(b); err != nil {
 			return Any{}, err
 		}
 
@@ -162,17 +186,41 @@ func Marshal(v any) (Any, error) {
 	case []byte:
 		m = wrapperspb.Bytes(vv)
 	default:
-		var err error
-		if m, err = newStructpbValue(rv); err != nil {
-			return Any{}, fmt.Errorf("cannot serialize %v: %w", v, err)
+		// Named types of primitive kinds (e.g. enum-like types, see
+		// Enum) are serialized like their underlying type. json.Number
+		// is validated and serialized as a number.
+		kind := rv.Kind()
+		if rv.Type() == jsonNumberType {
+			kind = reflect.Invalid
+		}
+		switch kind {
+		case reflect.Bool:
+			m = wrapperspb.Bool(rv.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			m = wrapperspb.Int64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			m = wrapperspb.UInt64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			m = wrapperspb.Double(rv.Float())
+		case reflect.String:
+			m = wrapperspb.String(rv.String())
+		default:
+			var err error
+			if m, err = (&structpbEncoder{naming: o.naming}).encode(rv); err != nil {
+				return Any{}, fmt.Errorf("cannot serialize %T: %w", v, err)
+			}
 		}
 	}
 
-	proto, err := anypb.New(m)
-	if err != nil {
+	return newAny(m, o.deterministic)
+}
+
+func newAny(m proto.Message, deterministic bool) (Any, error) {
+	a := new(anypb.Any)
+	if err := anypb.MarshalFrom(a, m, proto.MarshalOptions{Deterministic: deterministic}); err != nil {
 		return Any{}, err
 	}
-	return Any{proto}, nil
+	return Any{a}, nil
 }
 
 func knownAny(v any) Any {
@@ -184,8 +232,12 @@ func knownAny(v any) Any {
 }
 
 var (
-	timeType     = reflect.TypeFor[time.Time]()
-	durationType = reflect.TypeFor[time.Duration]()
+	timeType       = reflect.TypeFor[time.Time]()
+	durationType   = reflect.TypeFor[time.Duration]()
+	jsonNumberType = reflect.TypeFor[json.Number]()
+
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
 
 	jsonUnmarshalerType   = reflect.TypeFor[json.Unmarshaler]()
 	textUnmarshalerType   = reflect.TypeFor[encoding.TextUnmarshaler]()
@@ -193,7 +245,65 @@ var (
 )
 
 // Unmarshal unmarshals the value.
+//
+// Numbers nested in slices and maps are stored as float64 values, as in
+// JSON. Unmarshaling such a number into an interface value yields a
+// float64 (see UnmarshalOptions to change this). Integers that cannot be
+// represented exactly as a float64 (e.g. IDs above 2^53) are stored as
+// strings instead, as in the JSON mapping of 64-bit integers in Protocol
+// Buffers, and can be unmarshaled into integer types, json.Number or
+// strings. Other strings, e.g. "42", are not unmarshaled into integer
+// types or json.Number. Unmarshaling a number into an integer type fails
+// rather than losing information.
 func (a Any) Unmarshal(v any) error {
+	return UnmarshalOptions{}.Unmarshal(a, v)
+}
+
+// UnmarshalOptions configures the unmarshaling of Any values.
+type UnmarshalOptions struct {
+	// UseNumber causes numbers nested in slices and maps to be
+	// unmarshaled into interface values as a json.Number instead of
+	// as a float64, like json.Decoder.UseNumber.
+	UseNumber bool
+
+	// MaxDepth is the maximum depth of the lists and objects nested in
+	// structpb and JSON values (DefaultMaxDepth if zero), and
+	// MaxElements the maximum number of scalars, lists and objects
+	// that they contain (DefaultMaxElements if zero). Unmarshaling
+	// values that exceed the limits fails with InvalidArgumentStatus,
+	// rather than consuming unbounded memory and CPU. Negative values
+	// disable the limits.
+	MaxDepth    int
+	MaxElements int
+
+	// Encodings are the names of the encodings that values can be
+	// decoded with (see Encoding). Values encoded with other encodings
+	// fail to unmarshal with InvalidArgumentStatus. If nil, values can
+	// be decoded with any registered encoding.
+	Encodings []string
+
+	// FieldNaming is the naming of struct fields that don't have a name
+	// in their json tag (see WithFieldNaming). It doesn't apply to values
+	// encoded with JSONCodec, which are named by encoding/json.
+	FieldNaming FieldNaming
+
+	// DisallowUnknownFields causes keys that don't match a field of the
+	// destination struct to fail unmarshaling, rather than being ignored
+	// as in encoding/json. It applies to both codecs.
+	DisallowUnknownFields bool
+}
+
+// Unmarshal unmarshals an Any value using the options.
+//
+// Unmarshaling a value that isn't allowed into an enum type fails (see
+// Enum).
+func (o UnmarshalOptions) Unmarshal(a Any, v any) error {
+	return o.UnmarshalContext(context.Background(), a, v)
+}
+
+// UnmarshalContext is like Unmarshal, but passes the context to the
+// encodings that the value is decoded with (see ContextEncoding).
+func (o UnmarshalOptions) UnmarshalContext(ctx context.Context, a Any, v any) error {
 	if a.proto == nil {
 		return fmt.Errorf("empty Any")
 	}
@@ -202,9 +312,36 @@ func (a Any) Unmarshal(v any) error {
 	if rv.Kind() != reflect.Pointer || rv.IsNil() {
 		panic("Any.Unmarshal expects a pointer to a non-nil object")
 	}
+	if err := o.unmarshal(ctx, a, v, rv); err != nil {
+		return err
+	}
+	return checkEnum(rv.Elem())
+}
+
+func (o UnmarshalOptions) unmarshal(ctx context.Context, a Any, v any, rv reflect.Value) error {
 	elem := rv.Elem()
 
-	m, err := a.proto.UnmarshalNew()
+	if target, ok := v.(*Any); ok {
+		*target = a
+		return nil
+	}
+
+	a, err := o.decode(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	if a.proto.TypeUrl == JSONTypeURL {
+		if err := o.checkJSON(a.proto.Value); err != nil {
+			return err
+		}
+		if err := o.unmarshalJSON(a.proto.Value, v); err != nil {
+			return fmt.Errorf("cannot deserialize JSON into %v: %w", elem.Type(), err)
+		}
+		return nil
+	}
+
+	m, err := o.unmarshalNew(a.proto)
 	if err != nil {
 		return err
 	}
@@ -216,6 +353,13 @@ func (a Any) Unmarshal(v any) error {
 		return nil
 	}
 
+	// Check for types with a registered string codec.
+	if sv, ok := m.(*wrapperspb.StringValue); ok {
+		if ok, err := unmarshalString(sv.Value, elem); ok {
+			return err
+		}
+	}
+
 	// Check for:
 	// - structpb.Value => json.Unmarshaler
 	// - wrapperspb.StringValue => encoding.TextUnmarshaler
@@ -285,6 +429,28 @@ func (a Any) Unmarshal(v any) error {
 	}
 
 	switch elem.Type() {
+	case jsonNumberType:
+		var n string
+		switch v := m.(type) {
+		case *structpb.Value:
+			return o.fromStructpbValue(elem, v)
+		case *wrapperspb.StringValue:
+			if !isNumber(v.Value) {
+				return fmt.Errorf("cannot unmarshal %q into json.Number", v.Value)
+			}
+			n = v.Value
+		case *wrapperspb.Int64Value:
+			n = strconv.FormatInt(v.Value, 10)
+		case *wrapperspb.UInt64Value:
+			n = strconv.FormatUint(v.Value, 10)
+		case *wrapperspb.DoubleValue:
+			n = formatNumber(v.Value)
+		default:
+			return fmt.Errorf("cannot unmarshal %T into json.Number", m)
+		}
+		elem.SetString(n)
+		return nil
+
 	case timeType:
 		v, ok := m.(*timestamppb.Timestamp)
 		if !ok {
@@ -392,7 +558,7 @@ func (a Any) Unmarshal(v any) error {
 	}
 
 	if s, ok := m.(*structpb.Value); ok {
-		return fromStructpbValue(elem, s)
+		return o.fromStructpbValue(elem, s)
 	}
 
 	return fmt.Errorf("cannot deserialize %T into %v (%v kind)", m, elem.Type(), elem.Kind())
@@ -404,6 +570,13 @@ func (a Any) TypeURL() string {
 	return a.proto.GetTypeUrl()
 }
 
+// Value is the serialized value. It can be compared byte for byte, e.g.
+// to use values as cache keys, if it was marshaled deterministically
+// (see Deterministic). The returned slice must not be modified.
+func (a Any) Value() []byte {
+	return a.proto.GetValue()
+}
+
 // String is the string representation of the any value.
 func (a Any) String() string {
 	return fmt.Sprintf("Any(%s)", a.proto)
@@ -415,23 +588,79 @@ func (a Any) Equal(other Any) bool {
 }
 
 func newStructpbValue(rv reflect.Value) (*structpb.Value, error) {
+	return new(structpbEncoder).encode(rv)
+}
+
+// structpbEncoder converts Go values to structpb values. It tracks the
+// pointers, maps and slices that are being converted, so that cyclic
+// values fail rather than recursing until the stack overflows.
+type structpbEncoder struct {
+	visiting map[structpbVisit]struct{}
+	naming   FieldNaming
+}
+
+type structpbVisit struct {
+	ptr unsafe.Pointer
+	len int
+	typ reflect.Type
+}
+
+// enter records that a pointer, map or slice is being converted, and
+// returns a function that must be called once it's converted. It fails
+// if the value is already being converted, i.e. if it's cyclic.
+func (e *structpbEncoder) enter(rv reflect.Value) (func(), error) {
+	v := structpbVisit{ptr: rv.UnsafePointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		v.len = rv.Len()
+	}
+	if _, ok := e.visiting[v]; ok {
+		return nil, fmt.Errorf("cannot serialize cyclic value of type %s", rv.Type())
+	}
+	if e.visiting == nil {
+		e.visiting = map[structpbVisit]struct{}{}
+	}
+	e.visiting[v] = struct{}{}
+	return func() { delete(e.visiting, v) }, nil
+}
+
+func (e *structpbEncoder) encode(rv reflect.Value) (*structpb.Value, error) {
+	if rv.Type() == jsonNumberType {
+		return newStructpbNumber(json.Number(rv.String()))
+	}
+	if rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return structpb.NewNullValue(), nil
+	}
+	if s, ok, err := marshalString(rv); ok {
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewStringValue(s), nil
+	}
+	// Values nested in slices, maps and structs may implement
+	// json.Marshaler or encoding.TextMarshaler (e.g. time.Time).
+	if rv.Kind() != reflect.Interface {
+		switch v := rv.Interface().(type) {
+		case json.Marshaler:
+			b, err := v.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			return newStructpbValueFromJSON(b)
+		case encoding.TextMarshaler:
+			b, err := v.MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			return structpb.NewStringValue(string(b)), nil
+		}
+	}
 	switch rv.Kind() {
 	case reflect.Bool:
 		return structpb.NewBoolValue(rv.Bool()), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		n := rv.Int()
-		f := float64(n)
-		if int64(f) != n {
-			return nil, fmt.Errorf("cannot serialize %d as number structpb.Value (%v) without losing information", n, f)
-		}
-		return structpb.NewNumberValue(f), nil
+		return newStructpbNumber(json.Number(strconv.FormatInt(rv.Int(), 10)))
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		n := rv.Uint()
-		f := float64(n)
-		if uint64(f) != n {
-			return nil, fmt.Errorf("cannot serialize %d as number structpb.Value (%v) without losing information", n, f)
-		}
-		return structpb.NewNumberValue(f), nil
+		return newStructpbNumber(json.Number(strconv.FormatUint(rv.Uint(), 10)))
 	case reflect.Float32, reflect.Float64:
 		return structpb.NewNumberValue(rv.Float()), nil
 	case reflect.String:
@@ -442,20 +671,34 @@ func newStructpbValue(rv reflect.Value) (*structpb.Value, error) {
 			if v == nil {
 				return structpb.NewNullValue(), nil
 			}
-			return newStructpbValue(reflect.ValueOf(v))
+			return e.encode(reflect.ValueOf(v))
 		}
 	case reflect.Slice:
+		if rv.Len() > 0 {
+			exit, err := e.enter(rv)
+			if err != nil {
+				return nil, err
+			}
+			defer exit()
+		}
 		list := &structpb.ListValue{Values: make([]*structpb.Value, rv.Len())}
 		for i := range list.Values {
 			elem := rv.Index(i)
 			var err error
-			list.Values[i], err = newStructpbValue(elem)
+			list.Values[i], err = e.encode(elem)
 			if err != nil {
 				return nil, err
 			}
 		}
 		return structpb.NewListValue(list), nil
 	case reflect.Map:
+		if rv.Len() > 0 {
+			exit, err := e.enter(rv)
+			if err != nil {
+				return nil, err
+			}
+			defer exit()
+		}
 		strct := &structpb.Struct{Fields: make(map[string]*structpb.Value, rv.Len())}
 		iter := rv.MapRange()
 		for iter.Next() {
@@ -477,18 +720,97 @@ func newStructpbValue(rv reflect.Value) (*structpb.Value, error) {
 				return nil, fmt.Errorf("cannot serialize map with %s (%s) key", k.Type(), k.Kind())
 			}
 
-			v, err := newStructpbValue(iter.Value())
+			v, err := e.encode(iter.Value())
 			if err != nil {
 				return nil, err
 			}
 			strct.Fields[strKey] = v
 		}
 		return structpb.NewStructValue(strct), nil
+	case reflect.Pointer:
+		exit, err := e.enter(rv)
+		if err != nil {
+			return nil, err
+		}
+		defer exit()
+		return e.encode(rv.Elem())
+	case reflect.Struct:
+		fields := structFields(rv.Type(), e.naming)
+		strct := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(fields))}
+		for _, f := range fields {
+			fv, ok := fieldByIndex(rv, f.index, false)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			v, err := e.encode(fv)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			strct.Fields[f.name] = v
+		}
+		return structpb.NewStructValue(strct), nil
 	}
 	return nil, fmt.Errorf("not implemented: %s", rv.Type())
 }
 
-func fromStructpbValue(rv reflect.Value, s *structpb.Value) error {
+func newStructpbValueFromJSON(b []byte) (*structpb.Value, error) {
+	// Obviously not ideal going to bytes, then to any, then
+	// to structpb.Value! It would be more efficient to use
+	// a json.Decoder, and/or to use a third-party JSON library.
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return newStructpbValue(reflect.ValueOf(&v).Elem())
+}
+
+func (o UnmarshalOptions) fromStructpbValue(rv reflect.Value, s *structpb.Value) error {
+	if err := o.fromStructpb(rv, s); err != nil {
+		return err
+	}
+	return checkEnum(rv)
+}
+
+func (o UnmarshalOptions) fromStructpb(rv reflect.Value, s *structpb.Value) error {
+	if rv.Type() == jsonNumberType {
+		switch v := s.Kind.(type) {
+		case *structpb.Value_NumberValue:
+			rv.SetString(formatNumber(v.NumberValue))
+			return nil
+		case *structpb.Value_StringValue:
+			if isLargeNumber(v.StringValue) {
+				rv.SetString(v.StringValue)
+				return nil
+			}
+		}
+		return fmt.Errorf("cannot deserialize %v into json.Number", s)
+	}
+
+	if str, ok := s.Kind.(*structpb.Value_StringValue); ok {
+		if ok, err := unmarshalString(str.StringValue, rv); ok {
+			return err
+		}
+	}
+
+	// Values nested in slices, maps and structs may implement
+	// json.Unmarshaler or encoding.TextUnmarshaler (e.g. time.Time).
+	if rv.Kind() != reflect.Pointer && rv.CanAddr() {
+		switch v := rv.Addr().Interface().(type) {
+		case json.Unmarshaler:
+			b, err := s.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			return v.UnmarshalJSON(b)
+		case encoding.TextUnmarshaler:
+			if str, ok := s.Kind.(*structpb.Value_StringValue); ok {
+				return v.UnmarshalText([]byte(str.StringValue))
+			}
+		}
+	}
+
 	switch rv.Kind() {
 	case reflect.Bool:
 		if b, ok := s.Kind.(*structpb.Value_BoolValue); ok {
@@ -496,14 +818,40 @@ func fromStructpbValue(rv reflect.Value, s *structpb.Value) error {
 			return nil
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if n, ok := s.Kind.(*structpb.Value_NumberValue); ok {
-			rv.SetInt(int64(n.NumberValue))
+		switch v := s.Kind.(type) {
+		case *structpb.Value_NumberValue:
+			f := v.NumberValue
+			if f != math.Trunc(f) || f < -(1<<63) || f >= 1<<63 || rv.OverflowInt(int64(f)) {
+				return fmt.Errorf("cannot deserialize %v into %v without losing information", f, rv.Type())
+			}
+			rv.SetInt(int64(f))
 			return nil
+		case *structpb.Value_StringValue:
+			if !isLargeNumber(v.StringValue) {
+				break
+			}
+			if i, err := strconv.ParseInt(v.StringValue, 10, 64); err == nil && !rv.OverflowInt(i) {
+				rv.SetInt(i)
+				return nil
+			}
 		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		if n, ok := s.Kind.(*structpb.Value_NumberValue); ok {
-			rv.SetUint(uint64(n.NumberValue))
+		switch v := s.Kind.(type) {
+		case *structpb.Value_NumberValue:
+			f := v.NumberValue
+			if f != math.Trunc(f) || f < 0 || f >= 1<<64 || rv.OverflowUint(uint64(f)) {
+				return fmt.Errorf("cannot deserialize %v into %v without losing information", f, rv.Type())
+			}
+			rv.SetUint(uint64(f))
 			return nil
+		case *structpb.Value_StringValue:
+			if !isLargeNumber(v.StringValue) {
+				break
+			}
+			if u, err := strconv.ParseUint(v.StringValue, 10, 64); err == nil && !rv.OverflowUint(u) {
+				rv.SetUint(u)
+				return nil
+			}
 		}
 	case reflect.Float32, reflect.Float64:
 		if n, ok := s.Kind.(*structpb.Value_NumberValue); ok {
@@ -521,7 +869,7 @@ func fromStructpbValue(rv reflect.Value, s *structpb.Value) error {
 			rv.Grow(len(values))
 			rv.SetLen(len(values))
 			for i, value := range values {
-				if err := fromStructpbValue(rv.Index(i), value); err != nil {
+				if err := o.fromStructpbValue(rv.Index(i), value); err != nil {
 					return err
 				}
 			}
@@ -534,23 +882,126 @@ func fromStructpbValue(rv reflect.Value, s *structpb.Value) error {
 			valueType := rv.Type().Elem()
 			for key, value := range fields {
 				mv := reflect.New(valueType).Elem()
-				if err := fromStructpbValue(mv, value); err != nil {
+				if err := o.fromStructpbValue(mv, value); err != nil {
 					return err
 				}
 				rv.SetMapIndex(reflect.ValueOf(key), mv)
 			}
 			return nil
 		}
+	case reflect.Pointer:
+		if _, ok := s.Kind.(*structpb.Value_NullValue); ok {
+			rv.SetZero()
+			return nil
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return o.fromStructpbValue(rv.Elem(), s)
+	case reflect.Struct:
+		if strct, ok := s.Kind.(*structpb.Value_StructValue); ok {
+			fields := structFields(rv.Type(), o.FieldNaming)
+			for key, value := range strct.StructValue.Fields {
+				f, ok := lookupField(fields, key)
+				if !ok {
+					if o.DisallowUnknownFields {
+						return fmt.Errorf("unknown field %q in %v", key, rv.Type())
+					}
+					continue // unknown fields are ignored, as in encoding/json
+				}
+				fv, _ := fieldByIndex(rv, f.index, true)
+				if err := o.fromStructpbValue(fv, value); err != nil {
+					return fmt.Errorf("field %s: %w", f.name, err)
+				}
+			}
+			return nil
+		}
 	case reflect.Interface:
 		if rv.NumMethod() == 0 { // interface{} aka. any
-			v := s.AsInterface()
+			v := o.asInterface(s)
 			if v == nil {
 				rv.SetZero()
 			} else {
-				rv.Set(reflect.ValueOf(s.AsInterface()))
+				rv.Set(reflect.ValueOf(v))
 			}
 			return nil
 		}
 	}
 	return fmt.Errorf("cannot deserialize %T into %v (%v kind)", s, rv.Type(), rv.Kind())
 }
+
+// asInterface is like structpb.Value.AsInterface, but optionally
+// converts numbers to json.Number.
+func (o UnmarshalOptions) asInterface(s *structpb.Value) any {
+	if !o.UseNumber {
+		return s.AsInterface()
+	}
+	switch v := s.Kind.(type) {
+	case *structpb.Value_NumberValue:
+		return json.Number(formatNumber(v.NumberValue))
+	case *structpb.Value_ListValue:
+		values := v.ListValue.GetValues()
+		list := make([]any, len(values))
+		for i, value := range values {
+			list[i] = o.asInterface(value)
+		}
+		return list
+	case *structpb.Value_StructValue:
+		fields := v.StructValue.GetFields()
+		m := make(map[string]any, len(fields))
+		for key, value := range fields {
+			m[key] = o.asInterface(value)
+		}
+		return m
+	default:
+		return s.AsInterface()
+	}
+}
+
+// newStructpbNumber converts a json.Number to a structpb.Value. Integers
+// that cannot be represented exactly as a float64 are converted to a
+// string, to avoid losing information.
+func newStructpbNumber(n json.Number) (*structpb.Value, error) {
+	s := string(n)
+	if !isNumber(s) {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	if isLargeNumber(s) {
+		return structpb.NewStringValue(s), nil
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return structpb.NewNumberValue(f), nil
+}
+
+// isLargeNumber is true if s is a number that can't be represented
+// exactly as a float64, i.e. an integer with more than 53 bits of
+// precision or a number out of range for a float64. Such numbers are
+// stored as strings in structpb values (see newStructpbNumber).
+func isLargeNumber(s string) bool {
+	if !isNumber(s) {
+		return false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return true // out of range
+	}
+	if strings.ContainsAny(s, ".eE") {
+		return false
+	}
+	i, _ := new(big.Int).SetString(s, 10)
+	exact, _ := big.NewFloat(f).Int(nil)
+	return exact.Cmp(i) != 0
+}
+
+// isNumber is true if s is a valid JSON number.
+func isNumber(s string) bool {
+	return s != "" && (s[0] == '-' || ('0' <= s[0] && s[0] <= '9')) && json.Valid([]byte(s))
+}
+
+// formatNumber formats a float64 like encoding/json does.
+func formatNumber(f float64) string {
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
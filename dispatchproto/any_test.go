@@ -245,6 +245,360 @@ func TestAnyJsonMarshaler(t *testing.T) {
 	}
 }
 
+func TestAnyStruct(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	v := structValue{
+		Name:    "foo",
+		Created: now,
+		Child:   &structValue{Name: "bar"},
+		Embedded: Embedded{
+			ID:   "xyz",
+			Name: "hidden", // hidden by structValue.Name
+		},
+		internal: "ignored",
+	}
+	boxed, err := dispatchproto.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check a structpb.Value is sent on the wire, with the
+	// fields named and omitted according to json tags.
+	var s *structpb.Value
+	if err := boxed.Unmarshal(&s); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"name":    "foo",
+		"created": "2024-06-01T12:00:00Z",
+		"child": map[string]any{
+			"name":    "bar",
+			"created": "0001-01-01T00:00:00Z",
+			"ID":      "",
+		},
+		"ID": "xyz",
+	}
+	if diff := cmp.Diff(want, s.AsInterface()); diff != "" {
+		t.Errorf("unexpected serialized value: %v", diff)
+	}
+
+	var got structValue
+	if err := boxed.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	}
+	v.Embedded.Name = ""
+	v.internal = ""
+	if diff := cmp.Diff(v, got, cmp.AllowUnexported(structValue{})); diff != "" {
+		t.Errorf("unexpected struct: %v", diff)
+	}
+
+	// Check keys are matched case-insensitively, and that
+	// unknown keys are ignored.
+	boxed, err = dispatchproto.Marshal(map[string]any{"NAME": "foo", "count": 2, "unknown": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = structValue{}
+	if err := boxed.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if got.Name != "foo" || got.Count != 2 {
+		t.Errorf("unexpected struct: %+v", got)
+	}
+
+	// Check type mismatches are reported.
+	boxed, err = dispatchproto.Marshal(map[string]any{"count": "two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := boxed.Unmarshal(&got); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestAnyStructFieldNaming(t *testing.T) {
+	type naming struct {
+		UserID     string
+		HTTPServer string
+		Tagged     string `json:"TAGGED"`
+	}
+	v := naming{UserID: "u", HTTPServer: "h", Tagged: "t"}
+
+	for _, test := range []struct {
+		naming dispatchproto.FieldNaming
+		want   map[string]any
+	}{
+		{
+			naming: dispatchproto.GoFieldNames,
+			want:   map[string]any{"UserID": "u", "HTTPServer": "h", "TAGGED": "t"},
+		},
+		{
+			naming: dispatchproto.LowerCamelCaseFieldNames,
+			want:   map[string]any{"userID": "u", "httpServer": "h", "TAGGED": "t"},
+		},
+		{
+			naming: dispatchproto.SnakeCaseFieldNames,
+			want:   map[string]any{"user_id": "u", "http_server": "h", "TAGGED": "t"},
+		},
+	} {
+		t.Run(test.naming.String(), func(t *testing.T) {
+			boxed, err := dispatchproto.Marshal(v, dispatchproto.WithFieldNaming(test.naming))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var s *structpb.Value
+			if err := boxed.Unmarshal(&s); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, s.AsInterface()); diff != "" {
+				t.Errorf("unexpected serialized value: %v", diff)
+			}
+
+			var got naming
+			opts := dispatchproto.UnmarshalOptions{FieldNaming: test.naming, DisallowUnknownFields: true}
+			if err := opts.Unmarshal(boxed, &got); err != nil {
+				t.Fatal(err)
+			} else if got != v {
+				t.Errorf("unexpected struct: %+v", got)
+			}
+		})
+	}
+}
+
+func TestAnyStructUnknownFields(t *testing.T) {
+	opts := dispatchproto.UnmarshalOptions{DisallowUnknownFields: true}
+
+	for _, codec := range []dispatchproto.Codec{dispatchproto.ProtoCodec, dispatchproto.JSONCodec} {
+		t.Run(codec.String(), func(t *testing.T) {
+			boxed, err := dispatchproto.Marshal(map[string]any{"name": "foo", "unknown": true}, dispatchproto.WithCodec(codec))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got structValue
+			if err := boxed.Unmarshal(&got); err != nil {
+				t.Fatal(err)
+			} else if got.Name != "foo" {
+				t.Errorf("unexpected struct: %+v", got)
+			}
+
+			if err := opts.Unmarshal(boxed, &got); err == nil || !strings.Contains(err.Error(), `unknown field "unknown"`) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAnyCyclic(t *testing.T) {
+	v := &structValue{Name: "foo"}
+	v.Child = v
+	if _, err := dispatchproto.Marshal(v); err == nil || !strings.Contains(err.Error(), "cyclic value") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := dispatchproto.Marshal(v, dispatchproto.WithCodec(dispatchproto.JSONCodec)); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	m := map[string]any{}
+	m["self"] = m
+	if _, err := dispatchproto.Marshal(m); err == nil || !strings.Contains(err.Error(), "cyclic value") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	s := []any{nil}
+	s[0] = s
+	if _, err := dispatchproto.Marshal(s); err == nil || !strings.Contains(err.Error(), "cyclic value") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Values referenced more than once, without cycles, are serialized.
+	shared := &structValue{Name: "bar"}
+	boxed, err := dispatchproto.Marshal([]*structValue{shared, shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []structValue
+	if err := boxed.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 2 || got[0].Name != "bar" || got[1].Name != "bar" {
+		t.Errorf("unexpected value: %+v", got)
+	}
+}
+
+type structValue struct {
+	Name    string         `json:"name"`
+	Count   int            `json:"count,omitempty"`
+	Tags    []string       `json:"tags,omitempty"`
+	Created time.Time      `json:"created"`
+	Child   *structValue   `json:"child,omitempty"`
+	Skipped map[string]int `json:"-"`
+
+	Embedded
+
+	internal string
+}
+
+type point struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Label string `json:"label,omitempty"`
+}
+
+type Embedded struct {
+	ID   string
+	Name string `json:"name"`
+}
+
+func TestAnyAny(t *testing.T) {
+	boxed := dispatchproto.String("foo")
+
+	// Check an Any is passed through as is in both directions.
+	rewrapped, err := dispatchproto.Marshal(boxed)
+	if err != nil {
+		t.Fatal(err)
+	} else if !rewrapped.Equal(boxed) {
+		t.Errorf("unexpected Any: got %v, want %v", rewrapped, boxed)
+	}
+
+	var got dispatchproto.Any
+	if err := boxed.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if !got.Equal(boxed) {
+		t.Errorf("unexpected Any: got %v, want %v", got, boxed)
+	}
+}
+
+func TestAnyNestedNumbers(t *testing.T) {
+	v := map[string]any{
+		"int":   11,
+		"float": 2.5,
+		"list":  []any{1, 2.5, []any{3}},
+		"map":   map[string]any{"n": 4},
+	}
+	boxed, err := dispatchproto.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default, numbers become float64, as in JSON.
+	var got any
+	if err := boxed.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"int":   11.0,
+		"float": 2.5,
+		"list":  []any{1.0, 2.5, []any{3.0}},
+		"map":   map[string]any{"n": 4.0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected result: %v", diff)
+	}
+
+	// With UseNumber, numbers become json.Number.
+	if err := (dispatchproto.UnmarshalOptions{UseNumber: true}).Unmarshal(boxed, &got); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]any{
+		"int":   json.Number("11"),
+		"float": json.Number("2.5"),
+		"list":  []any{json.Number("1"), json.Number("2.5"), []any{json.Number("3")}},
+		"map":   map[string]any{"n": json.Number("4")},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected result: %v", diff)
+	}
+}
+
+func TestAnyLargeIntegers(t *testing.T) {
+	const id = "12345678901234567891" // > 2^63, not representable as a float64
+
+	// json.Number values are preserved exactly.
+	boxed, err := dispatchproto.Marshal(map[string]any{"id": json.Number(id), "n": json.Number("42")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers map[string]json.Number
+	if err := boxed.Unmarshal(&numbers); err != nil {
+		t.Fatal(err)
+	} else if numbers["id"] != id || numbers["n"] != "42" {
+		t.Errorf("unexpected result: %v", numbers)
+	}
+	var integers map[string]uint64
+	if err := boxed.Unmarshal(&integers); err != nil {
+		t.Fatal(err)
+	} else if integers["id"] != 12345678901234567891 || integers["n"] != 42 {
+		t.Errorf("unexpected result: %v", integers)
+	}
+
+	// Numbers produced by json.Marshaler are preserved exactly.
+	boxed, err = dispatchproto.Marshal(json.RawMessage(`{"id":` + id + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := boxed.Unmarshal(&integers); err != nil {
+		t.Fatal(err)
+	} else if integers["id"] != 12345678901234567891 {
+		t.Errorf("unexpected result: %v", integers)
+	}
+
+	// Numbers are never silently truncated.
+	boxed, err = dispatchproto.Marshal([]any{2.5, 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ints []int
+	if err := boxed.Unmarshal(&ints); err == nil {
+		t.Errorf("expected an error, got %v", ints)
+	}
+	var int8s []int8
+	boxed, err = dispatchproto.Marshal([]any{300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := boxed.Unmarshal(&int8s); err == nil {
+		t.Errorf("expected an error, got %v", int8s)
+	}
+
+	// Invalid numbers are rejected.
+	if _, err := dispatchproto.Marshal(json.Number("x")); err == nil {
+		t.Error("expected an error")
+	}
+
+	// Native integers nested in maps and slices are preserved exactly.
+	boxed, err = dispatchproto.Marshal(map[string]any{
+		"int":  int64(1<<60 + 1),
+		"uint": uint64(math.MaxUint64),
+		"list": []int64{-(1<<60 + 1), 42},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var native struct {
+		Int  int64   `json:"int"`
+		Uint uint64  `json:"uint"`
+		List []int64 `json:"list"`
+	}
+	if err := boxed.Unmarshal(&native); err != nil {
+		t.Fatal(err)
+	} else if native.Int != 1<<60+1 || native.Uint != math.MaxUint64 || len(native.List) != 2 || native.List[0] != -(1<<60+1) || native.List[1] != 42 {
+		t.Errorf("unexpected result: %+v", native)
+	}
+
+	// Strings that are not large integers are not unmarshaled into
+	// integers.
+	boxed, err = dispatchproto.Marshal(map[string]any{"n": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var strs map[string]int
+	if err := boxed.Unmarshal(&strs); err == nil {
+		t.Errorf("expected an error, got %v", strs)
+	}
+	if err := boxed.Unmarshal(&numbers); err == nil {
+		t.Errorf("expected an error, got %v", numbers)
+	}
+}
+
 func TestOverflow(t *testing.T) {
 	var i8 int8
 	if err := dispatchproto.Int(math.MinInt8 - 1).Unmarshal(&i8); err == nil || err.Error() != "cannot unmarshal *wrapperspb.Int64Value of -129 into int8" {
@@ -370,6 +724,12 @@ func TestAny(t *testing.T) {
 		map[string]int{"n": 3},
 		map[string]http.Header{"original": {"X-Foo": []string{"bar"}}},
 		map[any]any{"foo": "bar", "pi": 3.14},
+
+		// structs
+		point{X: 1, Y: -1},
+		&point{X: 1, Y: 2, Label: "foo"},
+		[]point{{X: 1}, {Y: 2}},
+		map[string]*point{"origin": {}, "none": nil},
 	} {
 		t.Run(fmt.Sprintf("%v", v), func(t *testing.T) {
 			boxed, err := dispatchproto.Marshal(v)
@@ -0,0 +1,65 @@
+//go:build !durable
+
+package dispatchproto
+
+import "fmt"
+
+// NewCallResultFor creates a CallResult with an output, serialized with
+// Marshal.
+func NewCallResultFor[T any](output T, opts ...CallResultOption) (CallResult, error) {
+	boxedOutput, err := Marshal(output)
+	if err != nil {
+		return CallResult{}, err
+	}
+	return NewCallResult(append([]CallResultOption{boxedOutput}, opts...)...), nil
+}
+
+// PollResultBuilder builds the PollResult that resumes a function after
+// a Poll directive, with the results of the calls of the directive.
+//
+// The results of calls carry the correlation ID of the call they're
+// for, and the PollResult carries the coroutine state of the directive.
+type PollResultBuilder struct {
+	poll    Poll
+	results []CallResult
+	err     error
+}
+
+// ResultBuilder creates a PollResultBuilder for the Poll directive.
+func (p Poll) ResultBuilder() *PollResultBuilder {
+	return &PollResultBuilder{poll: p}
+}
+
+// Output adds the result of a call that returned an output, which is
+// serialized with Marshal.
+func (b *PollResultBuilder) Output(call Call, output any) *PollResultBuilder {
+	boxedOutput, err := Marshal(output)
+	if err != nil {
+		if b.err == nil {
+			b.err = fmt.Errorf("cannot serialize output of call %d: %w", call.CorrelationID(), err)
+		}
+		return b
+	}
+	return b.Result(call, NewCallResult(boxedOutput))
+}
+
+// Error adds the result of a call that failed.
+func (b *PollResultBuilder) Error(call Call, err error) *PollResultBuilder {
+	return b.Result(call, NewCallResult(NewError(err)))
+}
+
+// Result adds the result of a call. The correlation ID of the call is
+// set on the result.
+func (b *PollResultBuilder) Result(call Call, result CallResult) *PollResultBuilder {
+	b.results = append(b.results, result.With(CorrelationID(call.CorrelationID())))
+	return b
+}
+
+// Build creates the PollResult. It returns an error if an output could
+// not be serialized.
+func (b *PollResultBuilder) Build() (PollResult, error) {
+	if b.err != nil {
+		return PollResult{}, b.err
+	}
+	return b.poll.Result().With(CallResults(b.results...)), nil
+}
@@ -0,0 +1,77 @@
+package dispatchproto_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+func TestNewCallResultFor(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+		Y int `json:"y"`
+	}
+	result, err := dispatchproto.NewCallResultFor(point{1, 2}, dispatchproto.CorrelationID(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := result.CorrelationID(); id != 7 {
+		t.Errorf("unexpected correlation ID: %d", id)
+	}
+	output, ok := result.Output()
+	if !ok {
+		t.Fatalf("expected output, got %s", result)
+	}
+	var got point
+	if err := output.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if got != (point{1, 2}) {
+		t.Errorf("unexpected output: %+v", got)
+	}
+
+	if _, err := dispatchproto.NewCallResultFor(make(chan int)); err == nil {
+		t.Error("expected an error for an output that can't be serialized")
+	}
+}
+
+func TestPollResultBuilder(t *testing.T) {
+	state := dispatchproto.String("state")
+	calls := []dispatchproto.Call{
+		dispatchproto.NewCall("", "a", dispatchproto.CorrelationID(1)),
+		dispatchproto.NewCall("", "b", dispatchproto.CorrelationID(2)),
+	}
+	poll := dispatchproto.NewPoll(2, 2, time.Minute, dispatchproto.Calls(calls...), dispatchproto.CoroutineState(state))
+
+	pollResult, err := poll.ResultBuilder().
+		Output(calls[0], 42).
+		Error(calls[1], errors.New("oops")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pollResult.CoroutineState().Equal(state) {
+		t.Errorf("unexpected coroutine state: %s", pollResult.CoroutineState())
+	}
+
+	want := []dispatchproto.CallResult{
+		dispatchproto.NewCallResult(dispatchproto.Int(42), dispatchproto.CorrelationID(1)),
+		dispatchproto.NewCallResult(dispatchproto.NewError(errors.New("oops")), dispatchproto.CorrelationID(2)),
+	}
+	results := pollResult.Results()
+	if len(results) != len(want) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	for i := range want {
+		if !results[i].Equal(want[i]) {
+			t.Errorf("unexpected result %d: got %s, want %s", i, results[i], want[i])
+		}
+	}
+
+	_, err = poll.ResultBuilder().Output(calls[0], math.NaN).Build()
+	if err == nil {
+		t.Error("expected an error for an output that can't be serialized")
+	}
+}
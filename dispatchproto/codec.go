@@ -0,0 +1,142 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Codec is the encoding of values in an Any.
+//
+// The codec is recorded in the type URL of an Any, so that values can
+// be unmarshaled regardless of the codec that was used to marshal them,
+// including by SDKs for other languages.
+type Codec int
+
+const (
+	// ProtoCodec encodes values as Protocol Buffers messages. Primitive
+	// values are encoded with wrapper types (e.g. google.protobuf.Int64Value)
+	// and JSON-like values are encoded as a google.protobuf.Value. The type
+	// URL is that of the message. It's the default codec.
+	ProtoCodec Codec = iota
+
+	// JSONCodec encodes values as raw JSON bytes, using encoding/json
+	// (or protojson for proto.Message values). The type URL is JSONTypeURL.
+	JSONCodec
+)
+
+// JSONTypeURL is the type URL of values encoded with JSONCodec.
+const JSONTypeURL = "dispatch.run/encoding/json"
+
+func (c Codec) String() string {
+	switch c {
+	case ProtoCodec:
+		return "ProtoCodec"
+	case JSONCodec:
+		return "JSONCodec"
+	default:
+		return "Codec(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+// MarshalOption configures the marshaling of Go values into an Any.
+type MarshalOption func(*marshalOptions)
+
+type marshalOptions struct {
+	codec         Codec
+	deterministic bool
+	naming        FieldNaming
+}
+
+// WithCodec sets the codec used to marshal values. It defaults to
+// ProtoCodec.
+func WithCodec(codec Codec) MarshalOption {
+	return func(o *marshalOptions) { o.codec = codec }
+}
+
+// Deterministic makes marshaling deterministic: the same value is
+// always marshaled to the same bytes by a given binary. Map entries are
+// sorted by key, including the fields of JSON-like values encoded as a
+// google.protobuf.Struct, and the whitespace that protojson randomizes is
+// removed.
+//
+// It's useful to compare values byte for byte, or to use them as cache
+// keys. Deterministic output isn't canonical, though: it may change
+// across versions of the Protocol Buffers library, and should not be
+// persisted as a key.
+func Deterministic() MarshalOption {
+	return func(o *marshalOptions) { o.deterministic = true }
+}
+
+// WithFieldNaming sets the naming of struct fields that don't have a
+// name in their json tag. It defaults to GoFieldNames. Values encoded
+// with JSONCodec are named by encoding/json, and aren't affected.
+//
+// Keys are matched case-insensitively when unmarshaling, so values
+// marshaled with LowerCamelCaseFieldNames can be unmarshaled with the
+// default naming, but values marshaled with SnakeCaseFieldNames must be
+// unmarshaled with the same naming (see UnmarshalOptions.FieldNaming).
+func WithFieldNaming(naming FieldNaming) MarshalOption {
+	return func(o *marshalOptions) { o.naming = naming }
+}
+
+// Codec is the codec that was used to marshal the value.
+func (a Any) Codec() Codec {
+	if typeURL, _, _ := strings.Cut(a.TypeURL(), "+"); typeURL == JSONTypeURL {
+		return JSONCodec
+	}
+	return ProtoCodec
+}
+
+var protoMessageType = reflect.TypeFor[proto.Message]()
+
+func marshalJSON(v any, deterministic bool) (Any, error) {
+	var b []byte
+	var err error
+	if m, ok := v.(proto.Message); ok {
+		if b, err = protojson.Marshal(m); err == nil && deterministic {
+			var buf bytes.Buffer
+			err = json.Compact(&buf, b)
+			b = buf.Bytes()
+		}
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		return Any{}, fmt.Errorf("cannot serialize %T: %w", v, err)
+	}
+	return Any{&anypb.Any{TypeUrl: JSONTypeURL, Value: b}}, nil
+}
+
+func (o UnmarshalOptions) unmarshalJSON(b []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return protojson.Unmarshal(b, m)
+	}
+	if elem := reflect.ValueOf(v).Elem(); elem.Kind() == reflect.Pointer && elem.Type().Implements(protoMessageType) {
+		if bytes.Equal(bytes.TrimSpace(b), []byte("null")) {
+			elem.SetZero()
+			return nil
+		}
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		return protojson.Unmarshal(b, elem.Interface().(proto.Message))
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	if o.UseNumber {
+		d.UseNumber()
+	}
+	if o.DisallowUnknownFields {
+		d.DisallowUnknownFields()
+	}
+	return d.Decode(v)
+}
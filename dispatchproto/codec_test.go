@@ -0,0 +1,141 @@
+package dispatchproto_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestJSONCodec(t *testing.T) {
+	for _, v := range []any{
+		true,
+		11,
+		int64(-1),
+		uint64(1 << 63), // not representable exactly as a float64
+		3.14,
+		"foo",
+		[]byte("bar"),
+		time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		[]string{"foo", "bar"},
+		map[string]int{"n": 3},
+		point{X: 1, Y: 2, Label: "foo"},
+		&point{X: 1},
+		(*point)(nil),
+		&wrapperspb.Int32Value{Value: 11},
+	} {
+		t.Run(fmt.Sprintf("%v", v), func(t *testing.T) {
+			boxed, err := dispatchproto.Marshal(v, dispatchproto.WithCodec(dispatchproto.JSONCodec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := boxed.TypeURL(); got != dispatchproto.JSONTypeURL {
+				t.Errorf("unexpected type URL: %q", got)
+			}
+			if got := boxed.Codec(); got != dispatchproto.JSONCodec {
+				t.Errorf("unexpected codec: %v", got)
+			}
+
+			rv := reflect.New(reflect.TypeOf(v))
+			if err := boxed.Unmarshal(rv.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			got := rv.Elem().Interface()
+			if want, ok := v.(proto.Message); ok {
+				if !proto.Equal(got.(proto.Message), want) {
+					t.Errorf("unexpected result: %v", got)
+				}
+			} else if diff := cmp.Diff(v, got); diff != "" {
+				t.Errorf("unexpected result: %v", diff)
+			}
+		})
+	}
+}
+
+func TestJSONCodecUseNumber(t *testing.T) {
+	boxed, err := dispatchproto.Marshal(map[string]any{"n": 11}, dispatchproto.WithCodec(dispatchproto.JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got any
+	if err := boxed.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if diff := cmp.Diff(map[string]any{"n": 11.0}, got); diff != "" {
+		t.Errorf("unexpected result: %v", diff)
+	}
+
+	if err := (dispatchproto.UnmarshalOptions{UseNumber: true}).Unmarshal(boxed, &got); err != nil {
+		t.Fatal(err)
+	} else if diff := cmp.Diff(map[string]any{"n": json.Number("11")}, got); diff != "" {
+		t.Errorf("unexpected result: %v", diff)
+	}
+}
+
+func TestProtoCodec(t *testing.T) {
+	boxed, err := dispatchproto.Marshal(11, dispatchproto.WithCodec(dispatchproto.ProtoCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := boxed.Codec(); got != dispatchproto.ProtoCodec {
+		t.Errorf("unexpected codec: %v", got)
+	}
+	if !boxed.Equal(dispatchproto.Int(11)) {
+		t.Errorf("unexpected value: %v", boxed)
+	}
+
+	// Values encoded with JSONCodec can't be unmarshaled into
+	// incompatible types.
+	boxed, err = dispatchproto.Marshal("foo", dispatchproto.WithCodec(dispatchproto.JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := boxed.Unmarshal(&n); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	m := map[string]any{}
+	for i := 0; i < 32; i++ {
+		m[fmt.Sprintf("key%d", i)] = map[string]int{"a": i, "b": -i, "c": i * 2}
+	}
+	for _, test := range []struct {
+		name  string
+		value any
+		codec dispatchproto.Codec
+	}{
+		{"map", m, dispatchproto.ProtoCodec},
+		{"map json", m, dispatchproto.JSONCodec},
+		{"proto json", &wrapperspb.StringValue{Value: "foo"}, dispatchproto.JSONCodec},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			want, err := dispatchproto.Marshal(test.value, dispatchproto.WithCodec(test.codec), dispatchproto.Deterministic())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				got, err := dispatchproto.Marshal(test.value, dispatchproto.WithCodec(test.codec), dispatchproto.Deterministic())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Value(), want.Value()) {
+					t.Fatalf("marshaling is not deterministic:\n%x\n%x", got.Value(), want.Value())
+				}
+			}
+
+			var v any
+			if err := want.Unmarshal(&v); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+)
+
+// Details of errors are serialized into the value of the Error (see
+// Error.Value) as a JSON object with a "details" field that maps keys
+// to string values, e.g.
+//
+//	{"details":{"field":"email","reason":"already_registered"}}
+//
+// so that they can be read by the callers of functions written in any
+// language.
+type errorValue struct {
+	Details map[string]string `json:"details"`
+}
+
+// ErrorDetails sets structured details of the error: machine-readable
+// key/value fields that callers can branch on, rather than parsing the
+// message of the error. The details are serialized into the value of
+// the error (see ErrorValue), replacing it.
+func ErrorDetails(details map[string]string) ErrorOption {
+	return func(e *Error) {
+		if len(details) == 0 {
+			return
+		}
+		value, err := json.Marshal(errorValue{Details: details})
+		if err != nil {
+			return // unreachable: maps of strings always serialize
+		}
+		e.proto.Value = value
+	}
+}
+
+// Details returns the structured details of the error (see
+// ErrorDetails), or nil if the error has none.
+func (e Error) Details() map[string]string {
+	value := e.Value()
+	if len(value) == 0 {
+		return nil
+	}
+	var v errorValue
+	if err := json.Unmarshal(value, &v); err != nil {
+		return nil
+	}
+	return v.Details
+}
+
+// WithDetails wraps an error to attach structured details to it. When
+// a function returns the error, the details are carried by the Error
+// that its caller receives (see ErrorDetails), e.g.
+//
+//	return dispatchproto.WithDetails(err, map[string]string{"field": "email"})
+//
+// The wrapped error keeps the message and status of err.
+func WithDetails(err error, details map[string]string) error {
+	if err == nil {
+		return nil
+	}
+	return &detailedError{err: err, details: maps.Clone(details)}
+}
+
+// DetailsOf returns the structured details of an error, attached with
+// WithDetails or carried by an Error that the error wraps (e.g. the
+// error returned when awaiting a call that failed), or nil if the
+// error has none.
+func DetailsOf(err error) map[string]string {
+	var detailed interface{ Details() map[string]string }
+	if errors.As(err, &detailed) {
+		return detailed.Details()
+	}
+	return nil
+}
+
+type detailedError struct {
+	err     error
+	details map[string]string
+}
+
+func (e *detailedError) Error() string              { return e.err.Error() }
+func (e *detailedError) Unwrap() error              { return e.err }
+func (e *detailedError) Details() map[string]string { return e.details }
@@ -0,0 +1,63 @@
+package dispatchproto_test
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+func TestErrorDetails(t *testing.T) {
+	details := map[string]string{"field": "email", "reason": "already_registered"}
+
+	e := dispatchproto.NewErrorMessage("ValidationError", "invalid email", dispatchproto.ErrorDetails(details))
+	if got := e.Details(); !maps.Equal(got, details) {
+		t.Errorf("unexpected details: got %v, want %v", got, details)
+	}
+	if got, want := string(e.Value()), `{"details":{"field":"email","reason":"already_registered"}}`; got != want {
+		t.Errorf("unexpected value: got %s, want %s", got, want)
+	}
+
+	// Errors with no details, or with a value in another format.
+	if got := dispatchproto.NewErrorMessage("Error", "oops").Details(); got != nil {
+		t.Errorf("unexpected details: %v", got)
+	}
+	if got := dispatchproto.NewErrorMessage("Error", "oops", dispatchproto.ErrorValue([]byte("pickle"))).Details(); got != nil {
+		t.Errorf("unexpected details: %v", got)
+	}
+}
+
+func TestWithDetails(t *testing.T) {
+	details := map[string]string{"field": "email"}
+
+	err := dispatchproto.WithDetails(connect.NewError(connect.CodeInvalidArgument, errors.New("invalid email")), details)
+	if got := err.Error(); got != "invalid_argument: invalid email" {
+		t.Errorf("unexpected message: %q", got)
+	}
+	if got := dispatchproto.ErrorStatus(err); got != dispatchproto.InvalidArgumentStatus {
+		t.Errorf("unexpected status: %v", got)
+	}
+
+	e := dispatchproto.NewError(err)
+	if got := e.Type(); got != "Error" {
+		t.Errorf("unexpected type: %q", got)
+	}
+	if got := e.Details(); !maps.Equal(got, details) {
+		t.Errorf("unexpected details: got %v, want %v", got, details)
+	}
+
+	// Callers find the details of the Error in the chain of errors.
+	wrapped := fmt.Errorf("call failed: %w", e)
+	if got := dispatchproto.DetailsOf(wrapped); !maps.Equal(got, details) {
+		t.Errorf("unexpected details: got %v, want %v", got, details)
+	}
+	if got := dispatchproto.DetailsOf(errors.New("oops")); got != nil {
+		t.Errorf("unexpected details: %v", got)
+	}
+	if dispatchproto.WithDetails(nil, details) != nil {
+		t.Error("expected nil error")
+	}
+}
@@ -0,0 +1,277 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Encoding transforms the serialized value of an Any, e.g. to compress
+// or encrypt it.
+//
+// Encodings are recorded as suffixes of the type URL of an Any (e.g.
+// "type.googleapis.com/google.protobuf.StringValue+gzip"), and are
+// decoded transparently by Any.Unmarshal. Encodings can be stacked,
+// e.g. to compress and then encrypt values; they're decoded in reverse
+// order.
+type Encoding interface {
+	// Name is the name of the encoding, which is used as the suffix of
+	// type URLs. It must be made of lowercase letters, digits and dashes.
+	Name() string
+
+	// Encode encodes a serialized value.
+	Encode(b []byte) ([]byte, error)
+
+	// Decode decodes a value encoded with Encode.
+	Decode(b []byte) ([]byte, error)
+}
+
+// ContextEncoding is an Encoding that performs I/O, e.g. to store values
+// remotely (see Offload). Values are encoded and decoded with the
+// context methods when a context is available (see Any.EncodeContext
+// and Any.DecodeContext), so that the I/O can be canceled.
+type ContextEncoding interface {
+	Encoding
+
+	// EncodeContext is like Encode, but takes a context.
+	EncodeContext(ctx context.Context, b []byte) ([]byte, error)
+
+	// DecodeContext is like Decode, but takes a context.
+	DecodeContext(ctx context.Context, b []byte) ([]byte, error)
+}
+
+// Identity is the name of the encoding that leaves values unchanged.
+// Values encoded with it have no type URL suffix.
+const Identity = "identity"
+
+const (
+	// MaxEncodings is the maximum number of encodings that can be
+	// stacked on a value. Values with more encodings fail to decode.
+	MaxEncodings = 8
+
+	// DefaultMaxDecodedSize is the maximum size of the values
+	// decompressed by the Gzip encoding, or reassembled by the Offload
+	// encoding.
+	DefaultMaxDecodedSize = 64 << 20
+)
+
+var (
+	encodingsMu sync.RWMutex
+	encodings   = map[string]Encoding{}
+
+	encodingName = regexp.MustCompile(`^[a-z0-9-]+$`)
+)
+
+func init() {
+	RegisterEncoding(Gzip())
+}
+
+// RegisterEncoding registers an encoding, so that values can be encoded
+// with it (see Any.Encode) and decoded.
+//
+// Encodings must be registered by all the processes that decode values,
+// typically in an init function. Registering an encoding with the name
+// of a registered encoding replaces it. It panics if the name of the
+// encoding is invalid.
+func RegisterEncoding(encoding Encoding) {
+	name := encoding.Name()
+	if !encodingName.MatchString(name) || name == Identity {
+		panic(fmt.Sprintf("invalid encoding name %q", name))
+	}
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	encodings[name] = encoding
+}
+
+// LookupEncoding returns the registered encoding with the specified
+// name.
+func LookupEncoding(name string) (Encoding, bool) {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+	encoding, ok := encodings[name]
+	return encoding, ok
+}
+
+// Encode encodes the value with the registered encodings, in order.
+func (a Any) Encode(names ...string) (Any, error) {
+	return a.EncodeContext(context.Background(), names...)
+}
+
+// EncodeContext is like Encode, but passes the context to the encodings
+// that take one (see ContextEncoding).
+func (a Any) EncodeContext(ctx context.Context, names ...string) (Any, error) {
+	typeURL, value := a.proto.GetTypeUrl(), a.proto.GetValue()
+	for _, name := range names {
+		if name == Identity {
+			continue
+		}
+		encoding, ok := LookupEncoding(name)
+		if !ok {
+			return Any{}, fmt.Errorf("encoding %q is not registered", name)
+		}
+		var err error
+		if value, err = encodeContext(ctx, encoding, value); err != nil {
+			return Any{}, fmt.Errorf("cannot encode value with %s: %w", name, err)
+		}
+		typeURL += "+" + name
+	}
+	return Any{&anypb.Any{TypeUrl: typeURL, Value: value}}, nil
+}
+
+// Decode decodes the value, if it was encoded (see Encode). Values that
+// were not encoded are returned as is.
+func (a Any) Decode() (Any, error) {
+	return a.DecodeContext(context.Background())
+}
+
+// DecodeContext is like Decode, but passes the context to the encodings
+// that take one (see ContextEncoding).
+func (a Any) DecodeContext(ctx context.Context) (Any, error) {
+	return UnmarshalOptions{}.decode(ctx, a)
+}
+
+// decode decodes the value with the encodings allowed by the options
+// (see UnmarshalOptions.Encodings).
+func (o UnmarshalOptions) decode(ctx context.Context, a Any) (Any, error) {
+	typeURL, value := a.proto.GetTypeUrl(), a.proto.GetValue()
+	decoded := 0
+	for {
+		i := strings.LastIndexByte(typeURL, '+')
+		if i < 0 || strings.IndexByte(typeURL[i:], '/') >= 0 {
+			break
+		}
+		if decoded == MaxEncodings {
+			return Any{}, limitError("cannot decode value: more than %d encodings", MaxEncodings)
+		}
+		name := typeURL[i+1:]
+		if o.Encodings != nil && !slices.Contains(o.Encodings, name) {
+			return Any{}, limitError("cannot decode value: encoding %q is not allowed", name)
+		}
+		encoding, ok := LookupEncoding(name)
+		if !ok {
+			return Any{}, fmt.Errorf("cannot decode value: encoding %q is not registered", name)
+		}
+		var err error
+		if value, err = decodeContext(ctx, encoding, value); err != nil {
+			return Any{}, fmt.Errorf("cannot decode value with %s: %w", name, err)
+		}
+		typeURL = typeURL[:i]
+		decoded++
+	}
+	if decoded == 0 {
+		return a, nil
+	}
+	return Any{&anypb.Any{TypeUrl: typeURL, Value: value}}, nil
+}
+
+func encodeContext(ctx context.Context, encoding Encoding, b []byte) ([]byte, error) {
+	if e, ok := encoding.(ContextEncoding); ok {
+		return e.EncodeContext(ctx, b)
+	}
+	return encoding.Encode(b)
+}
+
+func decodeContext(ctx context.Context, encoding Encoding, b []byte) ([]byte, error) {
+	if e, ok := encoding.(ContextEncoding); ok {
+		return e.DecodeContext(ctx, b)
+	}
+	return encoding.Decode(b)
+}
+
+// Gzip returns an Encoding that compresses values with gzip. It's
+// registered by default, with the name "gzip". Values that decompress
+// to more than DefaultMaxDecodedSize bytes fail to decode.
+func Gzip() Encoding { return GzipMaxSize(DefaultMaxDecodedSize) }
+
+// GzipMaxSize is like Gzip, but values that decompress to more than
+// maxSize bytes fail to decode. Registering it replaces the default
+// gzip encoding, e.g. to accept larger values:
+//
+//	dispatchproto.RegisterEncoding(dispatchproto.GzipMaxSize(256 << 20))
+func GzipMaxSize(maxSize int) Encoding { return gzipEncoding{maxSize: maxSize} }
+
+type gzipEncoding struct{ maxSize int }
+
+func (gzipEncoding) Name() string { return "gzip" }
+
+func (gzipEncoding) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (e gzipEncoding) Decode(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	value, err := io.ReadAll(io.LimitReader(r, int64(e.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > e.maxSize {
+		return nil, limitError("decompressed value exceeds %d bytes", e.maxSize)
+	}
+	return value, nil
+}
+
+// AESGCM returns an Encoding that encrypts values with AES-GCM, using
+// a 16, 24 or 32 byte key. A random nonce is generated for each value.
+//
+// The name of the encoding identifies the key, e.g. "aes-2024", so that
+// keys can be rotated: values encrypted with a previous key can still be
+// decrypted as long as its encoding is registered.
+func AESGCM(name string, key []byte) (Encoding, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesgcmEncoding{name: name, aead: aead}, nil
+}
+
+type aesgcmEncoding struct {
+	name string
+	aead cipher.AEAD
+}
+
+func (e *aesgcmEncoding) Name() string { return e.name }
+
+func (e *aesgcmEncoding) Encode(b []byte) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(b)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, b, nil), nil
+}
+
+func (e *aesgcmEncoding) Decode(b []byte) ([]byte, error) {
+	n := e.aead.NonceSize()
+	if len(b) < n {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	return e.aead.Open(nil, b[:n], b[n:], nil)
+}
@@ -0,0 +1,176 @@
+package dispatchproto_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+func TestEncoding(t *testing.T) {
+	aes, err := dispatchproto.AESGCM("aes-test", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchproto.RegisterEncoding(aes)
+
+	value := strings.Repeat("foo", 100)
+	for _, encodings := range [][]string{
+		nil,
+		{dispatchproto.Identity},
+		{"gzip"},
+		{"aes-test"},
+		{"gzip", "aes-test"},
+	} {
+		t.Run(strings.Join(encodings, "+"), func(t *testing.T) {
+			boxed, err := dispatchproto.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := boxed.Encode(encodings...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantTypeURL := boxed.TypeURL()
+			for _, name := range encodings {
+				if name != dispatchproto.Identity {
+					wantTypeURL += "+" + name
+				}
+			}
+			if got := encoded.TypeURL(); got != wantTypeURL {
+				t.Errorf("unexpected type URL: got %q, want %q", got, wantTypeURL)
+			}
+
+			// Values are decoded transparently.
+			var got string
+			if err := encoded.Unmarshal(&got); err != nil {
+				t.Fatal(err)
+			} else if got != value {
+				t.Errorf("unexpected value: got %q, want %q", got, value)
+			}
+
+			decoded, err := encoded.Decode()
+			if err != nil {
+				t.Fatal(err)
+			} else if !decoded.Equal(boxed) {
+				t.Errorf("unexpected decoded value: got %v, want %v", decoded, boxed)
+			}
+		})
+	}
+}
+
+func TestEncodingCodec(t *testing.T) {
+	boxed, err := dispatchproto.Marshal([]int{1, 2, 3}, dispatchproto.WithCodec(dispatchproto.JSONCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := boxed.Encode("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec := encoded.Codec(); codec != dispatchproto.JSONCodec {
+		t.Errorf("unexpected codec: %v", codec)
+	}
+	var got []int
+	if err := encoded.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 3 || got[2] != 3 {
+		t.Errorf("unexpected value: %v", got)
+	}
+}
+
+func TestEncodingErrors(t *testing.T) {
+	boxed := dispatchproto.String("foo")
+	if _, err := boxed.Encode("unknown"); err == nil {
+		t.Error("expected an error when encoding with an unknown encoding")
+	}
+
+	other, err := dispatchproto.AESGCM("aes-other", bytes.Repeat([]byte{2}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchproto.RegisterEncoding(other)
+	encoded, err := boxed.Encode("aes-other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values can't be decoded if the encoding registered with their
+	// name uses another key.
+	rotated, err := dispatchproto.AESGCM("aes-other", bytes.Repeat([]byte{3}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchproto.RegisterEncoding(rotated)
+	var got string
+	if err := encoded.Unmarshal(&got); err == nil {
+		t.Error("expected an error when decrypting with the wrong key")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected RegisterEncoding to panic")
+		}
+	}()
+	dispatchproto.RegisterEncoding(badEncoding{})
+}
+
+func TestEncodingLimits(t *testing.T) {
+	boxed := dispatchproto.String(strings.Repeat("a", 1<<20))
+
+	// Values that decompress to more than the limit are rejected.
+	small := dispatchproto.GzipMaxSize(1 << 10)
+	compressed, err := small.Encode(boxed.Value())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := small.Decode(compressed); err == nil || !strings.Contains(err.Error(), "decompressed value exceeds 1024 bytes") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Values with too many stacked encodings are rejected.
+	names := make([]string, dispatchproto.MaxEncodings+1)
+	for i := range names {
+		names[i] = "gzip"
+	}
+	encoded, err := dispatchproto.String("foo").Encode(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoded.Decode(); err == nil || !strings.Contains(err.Error(), "more than 8 encodings") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	encoded, err = dispatchproto.String("foo").Encode(names[1:]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoded.Decode(); err != nil {
+		t.Error(err)
+	}
+
+	// Only the encodings allowed by the options are decoded.
+	encoded, err = dispatchproto.String("foo").Encode("gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := (dispatchproto.UnmarshalOptions{Encodings: []string{}}).Unmarshal(encoded, &got); err == nil {
+		t.Error("expected an error when decoding with an encoding that isn't allowed")
+	} else if !errors.Is(err, dispatchproto.StatusError(dispatchproto.InvalidArgumentStatus)) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (dispatchproto.UnmarshalOptions{Encodings: []string{"gzip"}}).Unmarshal(encoded, &got); err != nil {
+		t.Error(err)
+	} else if got != "foo" {
+		t.Errorf("unexpected value: %q", got)
+	}
+}
+
+type badEncoding struct{}
+
+func (badEncoding) Name() string                    { return "Not/Valid" }
+func (badEncoding) Encode(b []byte) ([]byte, error) { return b, nil }
+func (badEncoding) Decode(b []byte) ([]byte, error) { return b, nil }
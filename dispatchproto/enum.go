@@ -0,0 +1,55 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Enum is implemented by enum-like types, i.e. integer or string types
+// with a fixed set of constants, to declare the values they allow.
+//
+// Values of enum types are serialized like values of their underlying
+// type, or with their MarshalText method if they implement
+// encoding.TextMarshaler. Unmarshaling a value that isn't allowed into
+// an enum type fails, whether it's the unmarshaled value or a value
+// nested in a slice, map or struct, so that functions reject inputs with
+// unknown values (with InvalidArgumentStatus) instead of silently
+// accepting them. Values encoded with JSONCodec are only checked when
+// they're the unmarshaled value. For example:
+//
+//	type Color string
+//
+//	const (
+//		Red   Color = "red"
+//		Green Color = "green"
+//	)
+//
+//	func (Color) EnumValues() []any { return []any{Red, Green} }
+type Enum interface {
+	// EnumValues returns the values that are allowed, which must be of
+	// the type that implements Enum.
+	EnumValues() []any
+}
+
+var enumType = reflect.TypeFor[Enum]()
+
+// checkEnum checks that an unmarshaled value is allowed if its type is
+// an enum type.
+func checkEnum(rv reflect.Value) error {
+	if !rv.IsValid() || rv.Kind() == reflect.Interface || rv.Kind() == reflect.Pointer || !rv.CanInterface() {
+		return nil
+	}
+	if !rv.Type().Implements(enumType) {
+		return nil
+	}
+	v := rv.Interface()
+	values := v.(Enum).EnumValues()
+	for _, allowed := range values {
+		if v == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %v value %#v: allowed values are %v", rv.Type(), v, values)
+}
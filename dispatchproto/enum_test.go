@@ -0,0 +1,93 @@
+package dispatchproto_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+type color string
+
+const (
+	red   color = "red"
+	green color = "green"
+)
+
+func (color) EnumValues() []any { return []any{red, green} }
+
+type priority int
+
+const (
+	low priority = iota
+	high
+)
+
+func (priority) EnumValues() []any { return []any{low, high} }
+
+type task struct {
+	Priority priority `json:"priority"`
+	Colors   []color  `json:"colors"`
+}
+
+func TestEnum(t *testing.T) {
+	boxed, err := dispatchproto.Marshal(green)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c color
+	if err := boxed.Unmarshal(&c); err != nil {
+		t.Fatal(err)
+	} else if c != green {
+		t.Errorf("unexpected value: %v", c)
+	}
+	// Enums are serialized like their underlying type.
+	if !boxed.Equal(dispatchproto.String("green")) {
+		t.Errorf("unexpected serialization: %v", boxed)
+	}
+
+	boxed, err = dispatchproto.Marshal(high)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p priority
+	if err := boxed.Unmarshal(&p); err != nil {
+		t.Fatal(err)
+	} else if p != high {
+		t.Errorf("unexpected value: %v", p)
+	}
+
+	boxed, err = dispatchproto.Marshal(task{Priority: high, Colors: []color{red, green}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tk task
+	if err := boxed.Unmarshal(&tk); err != nil {
+		t.Fatal(err)
+	} else if tk.Priority != high || len(tk.Colors) != 2 || tk.Colors[1] != green {
+		t.Errorf("unexpected value: %+v", tk)
+	}
+}
+
+func TestEnumInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		value any
+		into  any
+		want  string
+	}{
+		{name: "string", value: "blue", into: new(color), want: `invalid dispatchproto_test.color value "blue"`},
+		{name: "integer", value: 7, into: new(priority), want: "invalid dispatchproto_test.priority value 7"},
+		{name: "nested", value: map[string]any{"priority": 1, "colors": []any{"red", "blue"}}, into: new(task), want: `field colors: invalid dispatchproto_test.color value "blue"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			boxed, err := dispatchproto.Marshal(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := boxed.Unmarshal(test.into); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -254,6 +254,9 @@ func errorTypeOf(err error) string {
 	if err == nil {
 		return ""
 	}
+	if e, ok := err.(*detailedError); ok {
+		return errorTypeOf(e.err)
+	}
 	typ := reflect.TypeOf(err)
 	if name := typ.Name(); name != "" {
 		return name
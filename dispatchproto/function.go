@@ -2,7 +2,11 @@
 
 package dispatchproto
 
-import "context"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
 
 // Function is a Dispatch function.
 type Function func(context.Context, Request) Response
@@ -18,3 +22,56 @@ func (m FunctionMap) Run(ctx context.Context, req Request) Response {
 	}
 	return fn(ctx, req)
 }
+
+// AtomicFunctionMap is a FunctionMap that is safe for concurrent use.
+//
+// Reads are lock-free. The underlying map is never mutated; updates
+// copy it and atomically replace it, so readers always see a consistent
+// snapshot. This makes the type suitable for read-heavy workloads, such
+// as looking up functions for each request served by an endpoint, where
+// functions are rarely added.
+//
+// The zero value is an empty map. An AtomicFunctionMap must not be
+// copied after first use.
+type AtomicFunctionMap struct {
+	m  atomic.Pointer[FunctionMap]
+	mu sync.Mutex // serializes updates
+}
+
+// Load returns a snapshot of the map. The snapshot must not be
+// modified.
+func (a *AtomicFunctionMap) Load() FunctionMap {
+	if m := a.m.Load(); m != nil {
+		return *m
+	}
+	return nil
+}
+
+// Store replaces the map. The map must not be modified after it has
+// been stored.
+func (a *AtomicFunctionMap) Store(m FunctionMap) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.m.Store(&m)
+}
+
+// Add adds a function to the map, replacing any function with the
+// same name.
+func (a *AtomicFunctionMap) Add(name string, fn Function) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	prev := a.Load()
+	m := make(FunctionMap, len(prev)+1)
+	for k, v := range prev {
+		m[k] = v
+	}
+	m[name] = fn
+	a.m.Store(&m)
+}
+
+// Run runs a function.
+func (a *AtomicFunctionMap) Run(ctx context.Context, req Request) Response {
+	return a.Load().Run(ctx, req)
+}
@@ -0,0 +1,58 @@
+package dispatchproto_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+func TestAtomicFunctionMap(t *testing.T) {
+	var m dispatchproto.AtomicFunctionMap
+
+	req := dispatchproto.NewRequest("identity", dispatchproto.Int(1))
+	if res := m.Run(context.Background(), req); res.Status() != dispatchproto.NotFoundStatus {
+		t.Errorf("unexpected status: %v", res.Status())
+	}
+
+	identity := func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		input, _ := req.Input()
+		return dispatchproto.NewResponse(input)
+	}
+	m.Add("identity", identity)
+
+	snapshot := m.Load()
+
+	// Concurrent reads and updates.
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			m.Add("identity"+strconv.Itoa(i), identity)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if res := m.Run(context.Background(), req); !res.OK() {
+				t.Errorf("unexpected status: %v", res.Status())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(m.Load()); got != n+1 {
+		t.Errorf("unexpected number of functions: got %d, want %d", got, n+1)
+	}
+	// Snapshots are not affected by updates.
+	if got := len(snapshot); got != 1 {
+		t.Errorf("unexpected number of functions in snapshot: got %d, want 1", got)
+	}
+
+	m.Store(dispatchproto.FunctionMap{})
+	if res := m.Run(context.Background(), req); res.Status() != dispatchproto.NotFoundStatus {
+		t.Errorf("unexpected status: %v", res.Status())
+	}
+}
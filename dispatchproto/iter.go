@@ -0,0 +1,29 @@
+//go:build !durable && go1.23
+
+package dispatchproto
+
+import "iter"
+
+// CallsSeq is an iterator over the function calls attached to the poll
+// directive. Unlike Calls, it doesn't allocate a slice.
+func (p Poll) CallsSeq() iter.Seq[Call] {
+	return func(yield func(Call) bool) {
+		for _, proto := range p.proto.GetCalls() {
+			if !yield(Call{proto: proto}) {
+				return
+			}
+		}
+	}
+}
+
+// ResultsSeq is an iterator over the function call results attached to
+// the poll result. Unlike Results, it doesn't allocate a slice.
+func (r PollResult) ResultsSeq() iter.Seq[CallResult] {
+	return func(yield func(CallResult) bool) {
+		for _, proto := range r.proto.GetResults() {
+			if !yield(CallResult{proto}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build go1.23
+
+package dispatchproto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollCallsSeq(t *testing.T) {
+	poll := NewPoll(1, 3, time.Minute, Calls(
+		NewCall("", "a", Int(1)),
+		NewCall("", "b", Int(2)),
+		NewCall("", "c", Int(3)),
+	))
+
+	var names []string
+	for call := range poll.CallsSeq() {
+		names = append(names, call.Function())
+		if call.Function() == "b" {
+			break
+		}
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("unexpected calls: %v", names)
+	}
+}
+
+func TestPollResultResultsSeq(t *testing.T) {
+	result := NewPollResult(CallResults(
+		NewCallResult(CorrelationID(1)),
+		NewCallResult(CorrelationID(2)),
+	))
+
+	var correlationIDs []uint64
+	for r := range result.ResultsSeq() {
+		correlationIDs = append(correlationIDs, r.CorrelationID())
+	}
+	if len(correlationIDs) != 2 || correlationIDs[0] != 1 || correlationIDs[1] != 2 {
+		t.Errorf("unexpected results: %v", correlationIDs)
+	}
+}
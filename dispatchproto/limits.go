@@ -0,0 +1,227 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// DefaultMaxDepth is the default maximum depth of the lists and
+	// objects nested in the values unmarshaled by UnmarshalOptions.
+	DefaultMaxDepth = 512
+
+	// DefaultMaxElements is the default maximum number of elements
+	// (scalars, lists and objects) of the values unmarshaled by
+	// UnmarshalOptions.
+	DefaultMaxElements = 1_000_000
+)
+
+func (o UnmarshalOptions) maxDepth() int {
+	if o.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+	return o.MaxDepth
+}
+
+func (o UnmarshalOptions) maxElements() int {
+	if o.MaxElements == 0 {
+		return DefaultMaxElements
+	}
+	return o.MaxElements
+}
+
+func limitError(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{StatusError(InvalidArgumentStatus)}, args...)...)
+}
+
+var (
+	structpbValueTypeURL     = "type.googleapis.com/" + string((&structpb.Value{}).ProtoReflect().Descriptor().FullName())
+	structpbStructTypeURL    = "type.googleapis.com/" + string((&structpb.Struct{}).ProtoReflect().Descriptor().FullName())
+	structpbListValueTypeURL = "type.googleapis.com/" + string((&structpb.ListValue{}).ProtoReflect().Descriptor().FullName())
+)
+
+// unmarshalNew unmarshals the message of an Any value. The depth and
+// number of elements of structpb values are checked on their wire
+// representation, so that deeply nested or large payloads fail before
+// they're decoded. The nesting of protobuf messages is also limited
+// while they're decoded, as a safeguard: each list or object nested in
+// a structpb value is two messages deeper.
+func (o UnmarshalOptions) unmarshalNew(a *anypb.Any) (proto.Message, error) {
+	var root structpbKind
+	switch a.TypeUrl {
+	case structpbValueTypeURL:
+		root = structpbValue
+	case structpbStructTypeURL:
+		root = structpbStruct
+	case structpbListValueTypeURL:
+		root = structpbList
+	default:
+		return a.UnmarshalNew()
+	}
+	if err := o.checkStructpb(root, a.Value); err != nil {
+		return nil, err
+	}
+	var opts proto.UnmarshalOptions
+	if maxDepth := o.maxDepth(); maxDepth > 0 {
+		opts.RecursionLimit = 2*maxDepth + 8
+	}
+	m, err := anypb.UnmarshalNew(a, opts)
+	if err != nil {
+		return nil, limitError("cannot unmarshal %s: %v", a.TypeUrl, err)
+	}
+	return m, nil
+}
+
+// structpbKind is the kind of a message of a structpb value.
+type structpbKind int
+
+const (
+	structpbValue structpbKind = iota
+	structpbStruct
+	structpbList
+	structpbEntry // entry of the fields map of a Struct
+)
+
+// structpbFrame is a message being scanned by checkStructpb, with the
+// offset of its end and the number of lists and objects that enclose
+// its fields.
+type structpbFrame struct {
+	kind  structpbKind
+	end   int
+	depth int
+}
+
+// checkStructpb checks the depth and number of elements of the wire
+// representation of a structpb message, without recursion. Malformed
+// messages are left for proto.Unmarshal to report.
+func (o UnmarshalOptions) checkStructpb(root structpbKind, b []byte) error {
+	maxDepth, maxElements := o.maxDepth(), o.maxElements()
+	if maxDepth <= 0 && maxElements <= 0 {
+		return nil
+	}
+
+	var stack []structpbFrame
+	var elements int
+	push := func(kind structpbKind, end, depth int) error {
+		if kind == structpbValue {
+			if elements++; maxElements > 0 && elements > maxElements {
+				return limitError("value exceeds the maximum number of elements (%d)", maxElements)
+			}
+		}
+		if kind == structpbStruct || kind == structpbList {
+			if depth++; maxDepth > 0 && depth > maxDepth {
+				return limitError("value exceeds the maximum depth (%d)", maxDepth)
+			}
+		}
+		stack = append(stack, structpbFrame{kind, end, depth})
+		return nil
+	}
+	if root != structpbValue {
+		elements++ // the list or object itself
+	}
+	if err := push(root, len(b), 0); err != nil {
+		return err
+	}
+
+	for pos := 0; len(stack) > 0; {
+		f := stack[len(stack)-1]
+		if pos >= f.end {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		num, typ, n := protowire.ConsumeTag(b[pos:f.end])
+		if n < 0 {
+			return nil
+		}
+		pos += n
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b[pos:f.end]); n < 0 {
+				return nil
+			}
+			pos += n
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b[pos:f.end])
+		if n < 0 {
+			return nil
+		}
+		start := pos + n - len(v)
+		pos += n
+
+		// Field numbers of the messages of google/protobuf/struct.proto.
+		var child structpbKind
+		switch {
+		case f.kind == structpbValue && num == 5:
+			child = structpbStruct
+		case f.kind == structpbValue && num == 6:
+			child = structpbList
+		case f.kind == structpbStruct && num == 1:
+			child = structpbEntry
+		case f.kind == structpbEntry && num == 2, f.kind == structpbList && num == 1:
+			child = structpbValue
+		default:
+			continue
+		}
+		if err := push(child, start+len(v), f.depth); err != nil {
+			return err
+		}
+		pos = start
+	}
+	return nil
+}
+
+// checkJSON checks the depth and number of elements of a JSON value,
+// before it's unmarshaled.
+func (o UnmarshalOptions) checkJSON(b []byte) error {
+	maxDepth, maxElements := o.maxDepth(), o.maxElements()
+	if maxDepth <= 0 && maxElements <= 0 {
+		return nil
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	// objects holds whether each nested list or object is an object, and
+	// key whether the next token of the innermost object is a key, since
+	// keys are not elements.
+	var objects []bool
+	var key bool
+	var elements int
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return nil // reported when the value is unmarshaled
+		}
+		switch token {
+		case json.Delim('}'), json.Delim(']'):
+			objects = objects[:len(objects)-1]
+			key = len(objects) > 0 && objects[len(objects)-1]
+			continue
+		}
+		if key {
+			key = false
+			continue
+		}
+		if elements++; maxElements > 0 && elements > maxElements {
+			return limitError("value exceeds the maximum number of elements (%d)", maxElements)
+		}
+		key = len(objects) > 0 && objects[len(objects)-1]
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			if maxDepth > 0 && len(objects)+1 > maxDepth {
+				return limitError("value exceeds the maximum depth (%d)", maxDepth)
+			}
+			objects = append(objects, token == json.Delim('{'))
+			key = token == json.Delim('{')
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package dispatchproto_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+func TestUnmarshalLimits(t *testing.T) {
+	// [[[...]]] nested 10 times, and an object with 100 elements.
+	var nested any = []any{}
+	for range 9 {
+		nested = []any{nested}
+	}
+	large := map[string]any{}
+	for i := range 99 {
+		large[strings.Repeat("k", i+1)] = i
+	}
+
+	for _, codec := range []dispatchproto.Codec{dispatchproto.ProtoCodec, dispatchproto.JSONCodec} {
+		boxedNested, err := dispatchproto.Marshal(nested, dispatchproto.WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		boxedLarge, err := dispatchproto.Marshal(large, dispatchproto.WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range []struct {
+			value dispatchproto.Any
+			opts  dispatchproto.UnmarshalOptions
+			err   string
+		}{
+			{value: boxedNested},
+			{value: boxedNested, opts: dispatchproto.UnmarshalOptions{MaxDepth: 10}},
+			{value: boxedNested, opts: dispatchproto.UnmarshalOptions{MaxDepth: 9}, err: "maximum depth (9)"},
+			{value: boxedNested, opts: dispatchproto.UnmarshalOptions{MaxDepth: 2}, err: "maximum depth (2)"},
+			{value: boxedNested, opts: dispatchproto.UnmarshalOptions{MaxDepth: -1}},
+			{value: boxedLarge},
+			{value: boxedLarge, opts: dispatchproto.UnmarshalOptions{MaxElements: 100}},
+			{value: boxedLarge, opts: dispatchproto.UnmarshalOptions{MaxElements: 99}, err: "maximum number of elements (99)"},
+			{value: boxedLarge, opts: dispatchproto.UnmarshalOptions{MaxElements: -1}},
+		} {
+			var v any
+			err := test.opts.Unmarshal(test.value, &v)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("%s: unexpected error: %v", test.value.TypeURL(), err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: unexpected error: got %v, want %q", test.value.TypeURL(), err, test.err)
+			}
+			if !errors.Is(err, dispatchproto.StatusError(dispatchproto.InvalidArgumentStatus)) {
+				t.Errorf("%s: unexpected error status: %v", test.value.TypeURL(), dispatchproto.ErrorStatus(err))
+			}
+		}
+	}
+}
+
+func TestUnmarshalDefaultMaxDepth(t *testing.T) {
+	var nested any = []any{}
+	for range dispatchproto.DefaultMaxDepth * 2 {
+		nested = []any{nested}
+	}
+	boxed, err := dispatchproto.Marshal(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	err = boxed.Unmarshal(&v)
+	if !errors.Is(err, dispatchproto.StatusError(dispatchproto.InvalidArgumentStatus)) {
+		t.Errorf("unexpected error: %v", err)
+	} else if !strings.Contains(err.Error(), "maximum depth (512)") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
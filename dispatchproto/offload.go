@@ -0,0 +1,111 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// BlobStore stores values offloaded by an Offload encoding. It has the
+// method set of dispatchhttp.BlobStore, so the stores of dispatchhttp
+// (e.g. dispatchhttp.DirBlobStore) can be used.
+type BlobStore interface {
+	// Put stores a blob, and returns a reference to it.
+	Put(ctx context.Context, r io.Reader) (string, error)
+
+	// Get opens a blob referenced by a reference returned by Put.
+	Get(ctx context.Context, ref string) (io.ReadCloser, error)
+}
+
+// Offload returns an Encoding that stores values in a BlobStore, in
+// chunks of at most chunkSize bytes, and replaces them with references
+// to the chunks. Decoding the value reads the chunks back and
+// reassembles them.
+//
+// It's useful for values that exceed the payload size limit of
+// Dispatch, e.g. the outputs of data-heavy functions (see
+// dispatch.OutputOffload). Like other encodings, it must be registered
+// by all the processes that decode values (see RegisterEncoding), with
+// a store that can read the chunks back.
+//
+// The encoding is a ContextEncoding, so the chunks are stored and read
+// with the context of the function call when one is available. Values
+// that are reassembled to more than DefaultMaxDecodedSize bytes fail to
+// decode. Chunks are never deleted by the encoding.
+func Offload(name string, store BlobStore, chunkSize int) ContextEncoding {
+	return OffloadMaxSize(name, store, chunkSize, DefaultMaxDecodedSize)
+}
+
+// OffloadMaxSize is like Offload, but values that are reassembled to
+// more than maxSize bytes fail to decode.
+func OffloadMaxSize(name string, store BlobStore, chunkSize, maxSize int) ContextEncoding {
+	if chunkSize <= 0 {
+		panic("chunk size must be positive")
+	}
+	return &offloadEncoding{name: name, store: store, chunkSize: chunkSize, maxSize: maxSize}
+}
+
+type offloadEncoding struct {
+	name      string
+	store     BlobStore
+	chunkSize int
+	maxSize   int
+}
+
+func (e *offloadEncoding) Name() string { return e.name }
+
+func (e *offloadEncoding) Encode(b []byte) ([]byte, error) {
+	return e.EncodeContext(context.Background(), b)
+}
+
+func (e *offloadEncoding) Decode(b []byte) ([]byte, error) {
+	return e.DecodeContext(context.Background(), b)
+}
+
+func (e *offloadEncoding) EncodeContext(ctx context.Context, b []byte) ([]byte, error) {
+	refs := make([]string, 0, (len(b)+e.chunkSize-1)/e.chunkSize)
+	for len(b) > 0 {
+		chunk := b[:min(e.chunkSize, len(b))]
+		ref, err := e.store.Put(ctx, bytes.NewReader(chunk))
+		if err != nil {
+			return nil, fmt.Errorf("cannot store chunk %d: %w", len(refs), err)
+		}
+		refs = append(refs, ref)
+		b = b[len(chunk):]
+	}
+	return json.Marshal(refs)
+}
+
+func (e *offloadEncoding) DecodeContext(ctx context.Context, b []byte) ([]byte, error) {
+	var refs []string
+	if err := json.Unmarshal(b, &refs); err != nil {
+		return nil, fmt.Errorf("invalid chunk references: %w", err)
+	}
+	var buf bytes.Buffer
+	for i, ref := range refs {
+		if err := e.read(ctx, &buf, ref); err != nil {
+			return nil, fmt.Errorf("cannot read chunk %d (%s): %w", i, ref, err)
+		}
+		if buf.Len() > e.maxSize {
+			return nil, limitError("reassembled value exceeds %d bytes", e.maxSize)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// read appends a chunk to the buffer. It reads at most one byte past
+// the maximum size of values, so that oversized chunks are detected
+// without reading them entirely.
+func (e *offloadEncoding) read(ctx context.Context, buf *bytes.Buffer, ref string) error {
+	r, err := e.store.Get(ctx, ref)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(buf, io.LimitReader(r, int64(e.maxSize-buf.Len())+1))
+	return err
+}
@@ -0,0 +1,148 @@
+package dispatchproto_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+type memoryBlobStore struct {
+	mu    sync.Mutex
+	blobs [][]byte
+}
+
+func (m *memoryBlobStore) Put(ctx context.Context, r io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs = append(m.blobs, b)
+	return fmt.Sprintf("mem://%d", len(m.blobs)-1), nil
+}
+
+func (m *memoryBlobStore) Get(ctx context.Context, ref string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var i int
+	if _, err := fmt.Sscanf(ref, "mem://%d", &i); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i < 0 || i >= len(m.blobs) {
+		return nil, fmt.Errorf("blob %s not found", ref)
+	}
+	return io.NopCloser(bytes.NewReader(m.blobs[i])), nil
+}
+
+func TestOffload(t *testing.T) {
+	store := &memoryBlobStore{}
+	dispatchproto.RegisterEncoding(dispatchproto.Offload("test-offload", store, 100))
+
+	value := strings.Repeat("x", 1000)
+	boxed, err := dispatchproto.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := boxed.Encode("test-offload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.blobs) != 11 { // 1000 bytes plus the serialization overhead
+		t.Errorf("unexpected number of chunks: %d", len(store.blobs))
+	}
+	if len(encoded.Value()) >= len(boxed.Value()) {
+		t.Errorf("value wasn't offloaded: %d bytes", len(encoded.Value()))
+	}
+
+	var got string
+	if err := encoded.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if got != value {
+		t.Errorf("unexpected value after reassembly: %d bytes", len(got))
+	}
+
+	// Missing chunks are reported.
+	store.blobs = store.blobs[:5]
+	if err := encoded.Unmarshal(&got); err == nil || !strings.Contains(err.Error(), "cannot read chunk 5") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOffloadContext(t *testing.T) {
+	store := &memoryBlobStore{}
+	dispatchproto.RegisterEncoding(dispatchproto.Offload("test-offload-context", store, 100))
+
+	boxed, err := dispatchproto.Marshal(strings.Repeat("x", 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := boxed.EncodeContext(canceled, "test-offload-context"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	encoded, err := boxed.EncodeContext(context.Background(), "test-offload-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoded.DecodeContext(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	var got string
+	if err := (dispatchproto.UnmarshalOptions{}).UnmarshalContext(canceled, encoded, &got); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err := (dispatchproto.UnmarshalOptions{}).UnmarshalContext(context.Background(), encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOffloadMaxSize(t *testing.T) {
+	store := &memoryBlobStore{}
+	dispatchproto.RegisterEncoding(dispatchproto.OffloadMaxSize("test-offload-max-size", store, 100, 500))
+
+	small, err := dispatchproto.Marshal(strings.Repeat("x", 400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := small.Encode("test-offload-max-size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := encoded.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values are reassembled up to the maximum size, regardless of the
+	// number of chunks they're split into.
+	large, err := dispatchproto.Marshal(strings.Repeat("x", 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err = large.Encode("test-offload-max-size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encoded.Unmarshal(&got)
+	if err == nil || !strings.Contains(err.Error(), "reassembled value exceeds 500 bytes") {
+		t.Errorf("unexpected error: %v", err)
+	} else if dispatchproto.StatusOf(err) != dispatchproto.InvalidArgumentStatus {
+		t.Errorf("unexpected status: %v", dispatchproto.StatusOf(err))
+	}
+}
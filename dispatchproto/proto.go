@@ -5,6 +5,7 @@ package dispatchproto
 import (
 	"fmt"
 	"time"
+	"unicode/utf8"
 
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	"google.golang.org/protobuf/proto"
@@ -15,11 +16,15 @@ import (
 // Call is a function call.
 type Call struct {
 	proto *sdkv1.Call
+
+	// The idempotency key isn't part of the protocol. It's used by
+	// clients to deduplicate calls (see IdempotencyKey).
+	idempotencyKey string
 }
 
 // NewCall creates a Call.
 func NewCall(endpoint, function string, opts ...CallOption) Call {
-	call := Call{&sdkv1.Call{
+	call := Call{proto: &sdkv1.Call{
 		Endpoint: endpoint,
 		Function: function,
 	}}
@@ -70,6 +75,26 @@ type correlationIDOption uint64
 func (id correlationIDOption) configureCall(c *Call)             { c.proto.CorrelationId = uint64(id) }
 func (id correlationIDOption) configureCallResult(r *CallResult) { r.proto.CorrelationId = uint64(id) }
 
+// IdempotencyKey sets a key that identifies a function call, so that
+// it's dispatched at most once even if the caller retries.
+//
+// The key is never sent to Dispatch. It's used by dispatchclient.Client
+// to deduplicate calls: dispatching a call with the same key as a
+// prior call, within a window of time, returns the ID of the prior call
+// rather than dispatching it again (see dispatchclient.IdempotencyWindow).
+// Since the keys are only remembered in memory by each Client, calls
+// are only deduplicated within one Client process; retries from another
+// process, or after a restart, are dispatched again. The key is ignored
+// for calls that are made by a coroutine.
+func IdempotencyKey(key string) CallOption {
+	return callOptionFunc(func(c *Call) { c.idempotencyKey = key })
+}
+
+// Endpoint sets the URL of the service where the function resides.
+func Endpoint(endpoint string) CallOption {
+	return callOptionFunc(func(c *Call) { c.proto.Endpoint = endpoint })
+}
+
 // Version sets a function call version.
 func Version(version string) CallOption {
 	return callOptionFunc(func(c *Call) { c.proto.Version = version })
@@ -102,6 +127,12 @@ func (c Call) Version() string {
 	return c.proto.GetVersion()
 }
 
+// IdempotencyKey is the key used to deduplicate the call, or an empty
+// string if the call has none (see IdempotencyKey).
+func (c Call) IdempotencyKey() string {
+	return c.idempotencyKey
+}
+
 // Request converts the call to a request.
 func (c Call) Request() Request {
 	return NewRequest(c.Function(), c.Input())
@@ -128,7 +159,7 @@ func (c Call) Clone() Call {
 	if c.proto == nil {
 		return Call{}
 	}
-	return Call{proto.Clone(c.proto).(*sdkv1.Call)}
+	return Call{proto: proto.Clone(c.proto).(*sdkv1.Call), idempotencyKey: c.idempotencyKey}
 }
 
 // With creates a copy of the Call with additional options applied.
@@ -257,9 +288,12 @@ type Error struct {
 }
 
 // NewError creates an Error from a Go error.
+//
+// The structured details of the error, if any, are carried by the Error
+// (see WithDetails).
 func NewError(err error) Error {
-	// TODO: use ErrorValue / Traceback
-	return NewErrorMessage(errorTypeOf(err), err.Error())
+	// TODO: use Traceback
+	return NewErrorMessage(errorTypeOf(err), err.Error(), ErrorDetails(DetailsOf(err)))
 }
 
 // Errorf creates an Error from the specified message and args.
@@ -346,6 +380,112 @@ func (e Error) Equal(other Error) bool {
 	return proto.Equal(e.proto, other.proto)
 }
 
+// DefaultErrorSizeLimit is the default maximum size, in bytes, of the
+// message, traceback and value of an Error carried by a Response.
+const DefaultErrorSizeLimit = 64 * 1024
+
+// Truncate creates a copy of the Error where the message and traceback
+// are truncated to at most limit bytes.
+//
+// A marker is appended to truncated fields to indicate how many bytes
+// were removed; it counts towards the limit. The language-specific
+// error value is dropped, rather than truncated, if it exceeds the
+// limit, since it could not be decoded once truncated.
+func (e Error) Truncate(limit int) Error {
+	return e.TruncateFunc(limit, nil)
+}
+
+// TruncateFunc is like Truncate, but calls the offload function with the
+// full contents of each field ("message", "traceback" or "value") that
+// exceeds the limit before it's truncated.
+//
+// The offload function can store the contents elsewhere (e.g. in a blob
+// store), and return a reference to the contents that is included in the
+// truncation marker. The reference to a dropped value is included in the
+// message. If the offload function returns an error, the field is
+// truncated without a reference.
+func (e Error) TruncateFunc(limit int, offload func(field string, data []byte) (string, error)) Error {
+	if e.proto == nil {
+		return e
+	}
+	message := e.proto.GetMessage()
+	traceback := e.proto.GetTraceback()
+	value := e.proto.GetValue()
+	if len(message) <= limit && len(traceback) <= limit && len(value) <= limit {
+		return e
+	}
+
+	var opts []ErrorOption
+	var note string
+	if len(value) > limit {
+		if ref := offloadField("value", value, offload); ref != "" {
+			if note = fmt.Sprintf(" [dropped value of %d bytes, see %s]", len(value), ref); len(note) > limit {
+				note = ""
+			}
+		}
+	} else if len(value) > 0 {
+		opts = append(opts, ErrorValue(value))
+	}
+	if len(message)+len(note) > limit {
+		ref := offloadField("message", []byte(message), offload)
+		message = truncateField([]byte(message), limit-len(note), ref)
+	}
+	message += note
+	if len(traceback) > limit {
+		ref := offloadField("traceback", traceback, offload)
+		traceback = []byte(truncateField(traceback, limit, ref))
+	}
+	if len(traceback) > 0 {
+		opts = append(opts, Traceback(traceback))
+	}
+	return NewErrorMessage(e.proto.GetType(), message, opts...)
+}
+
+func offloadField(field string, data []byte, offload func(string, []byte) (string, error)) string {
+	if offload == nil {
+		return ""
+	}
+	ref, err := offload(field, data)
+	if err != nil {
+		return ""
+	}
+	return ref
+}
+
+// truncateField truncates data to at most limit bytes, including the
+// marker. If the marker doesn't fit, data is cut without a marker.
+func truncateField(data []byte, limit int, ref string) string {
+	if len(data) <= limit {
+		return string(data)
+	}
+	marker := func(truncated int) string {
+		if ref != "" {
+			return fmt.Sprintf("... [truncated %d bytes, see %s]", truncated, ref)
+		}
+		return fmt.Sprintf("... [truncated %d bytes]", truncated)
+	}
+	// The length of the marker depends on the number of bytes removed,
+	// which depends on the length of the marker.
+	for n := limit; n >= 0; {
+		n = runeStart(data, n)
+		m := marker(len(data) - n)
+		if n+len(m) <= limit {
+			return string(data[:n]) + m
+		}
+		n = limit - len(m)
+	}
+	return string(data[:runeStart(data, limit)])
+}
+
+// runeStart returns the largest offset not greater than n that doesn't
+// split a multi-byte UTF-8 sequence of data.
+func runeStart(data []byte, n int) int {
+	for n > 0 && n < len(data) && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	return n
+}
+
 func (e Error) configureCallResult(r *CallResult) {
 	r.proto.Error = e.proto
 }
@@ -422,7 +562,7 @@ func (e Exit) Output() (Any, bool) {
 // TailCall is the tail call the exit directive carries.
 func (e Exit) TailCall() (Call, bool) {
 	proto := e.proto.GetTailCall()
-	return Call{proto}, proto != nil
+	return Call{proto: proto}, proto != nil
 }
 
 // String is the string representation of the Exit directive.
@@ -537,7 +677,7 @@ func (p Poll) Calls() []Call {
 	}
 	calls := make([]Call, len(raw))
 	for i, proto := range raw {
-		calls[i] = Call{proto}
+		calls[i] = Call{proto: proto}
 	}
 	return calls
 }
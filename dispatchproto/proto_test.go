@@ -1,6 +1,7 @@
 package dispatchproto
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -108,3 +109,72 @@ func TestCall(t *testing.T) {
 		}
 	})
 }
+
+func TestErrorTruncate(t *testing.T) {
+	t.Run("small", func(t *testing.T) {
+		err := NewErrorMessage("T", "message", Traceback([]byte("traceback")), ErrorValue([]byte("value")))
+		if got := err.Truncate(100); !got.Equal(err) {
+			t.Errorf("unexpected truncation: %v", got)
+		}
+	})
+
+	t.Run("oversized", func(t *testing.T) {
+		err := NewErrorMessage("T", strings.Repeat("0123456789", 5), Traceback([]byte(strings.Repeat("abcdefghij", 4))), ErrorValue([]byte(strings.Repeat("v", 50))))
+		got := err.Truncate(30)
+		if got.Type() != "T" {
+			t.Errorf("unexpected type: %v", got.Type())
+		}
+		if want := "012345... [truncated 44 bytes]"; got.Message() != want {
+			t.Errorf("unexpected message: got %q, want %q", got.Message(), want)
+		}
+		if want := "abcdef... [truncated 34 bytes]"; string(got.Traceback()) != want {
+			t.Errorf("unexpected traceback: got %q, want %q", got.Traceback(), want)
+		}
+		if got.Value() != nil {
+			t.Errorf("unexpected value: %q", got.Value())
+		}
+	})
+
+	t.Run("utf8", func(t *testing.T) {
+		err := NewErrorMessage("T", strings.Repeat("é", 20))
+		got := err.Truncate(31)
+		if want := "ééé... [truncated 34 bytes]"; got.Message() != want {
+			t.Errorf("unexpected message: got %q, want %q", got.Message(), want)
+		}
+	})
+
+	t.Run("tiny", func(t *testing.T) {
+		// Fields are cut without a marker if it doesn't fit.
+		err := NewErrorMessage("T", "0123456789")
+		got := err.Truncate(4)
+		if want := "0123"; got.Message() != want {
+			t.Errorf("unexpected message: got %q, want %q", got.Message(), want)
+		}
+	})
+
+	t.Run("offload", func(t *testing.T) {
+		offloaded := map[string]string{}
+		message := strings.Repeat("0123456789", 10)
+		value := strings.Repeat("v", 120)
+		err := NewErrorMessage("T", message, Traceback([]byte("abc")), ErrorValue([]byte(value)))
+		got := err.TruncateFunc(100, func(field string, data []byte) (string, error) {
+			offloaded[field] = string(data)
+			return "blob://" + field, nil
+		})
+		if want := "012345678... [truncated 91 bytes, see blob://message] [dropped value of 120 bytes, see blob://value]"; got.Message() != want {
+			t.Errorf("unexpected message: got %q, want %q", got.Message(), want)
+		}
+		if n := len(got.Message()); n > 100 {
+			t.Errorf("message exceeds the limit: %d bytes", n)
+		}
+		if want := "abc"; string(got.Traceback()) != want {
+			t.Errorf("unexpected traceback: got %q, want %q", got.Traceback(), want)
+		}
+		if got.Value() != nil {
+			t.Errorf("unexpected value: %q", got.Value())
+		}
+		if len(offloaded) != 2 || offloaded["message"] != message || offloaded["value"] != value {
+			t.Errorf("unexpected offloaded fields: %v", offloaded)
+		}
+	})
+}
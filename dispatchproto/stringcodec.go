@@ -0,0 +1,136 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+)
+
+// RegisterStringCodec registers functions that marshal values of type T
+// to strings, and unmarshal them back. Values of type T are serialized
+// as strings, rather than according to their shape or the interfaces
+// they implement (e.g. json.Marshaler), including when they're nested in
+// slices, maps and structs.
+//
+// It's useful for types that would otherwise lose information, such as
+// arbitrary precision numbers that would be serialized as float64
+// values. *big.Int and *big.Rat are registered by default. Decimal types
+// can be registered the same way, e.g.
+//
+//	dispatchproto.RegisterStringCodec(
+//		func(d decimal.Decimal) (string, error) { return d.String(), nil },
+//		decimal.NewFromString,
+//	)
+//
+// When T is a pointer type, values of its element type are serialized
+// with the codec too. Registering a codec for a type that already has
+// one replaces it. Codecs should be registered in an init function.
+func RegisterStringCodec[T any](marshal func(T) (string, error), unmarshal func(string) (T, error)) {
+	stringCodecsMu.Lock()
+	defer stringCodecsMu.Unlock()
+	stringCodecs[reflect.TypeFor[T]()] = stringCodec{
+		marshal: func(v reflect.Value) (string, error) {
+			return marshal(v.Interface().(T))
+		},
+		unmarshal: func(s string) (reflect.Value, error) {
+			v, err := unmarshal(s)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(&v).Elem(), nil
+		},
+	}
+}
+
+type stringCodec struct {
+	marshal   func(reflect.Value) (string, error)
+	unmarshal func(string) (reflect.Value, error)
+}
+
+var (
+	stringCodecsMu sync.RWMutex
+	stringCodecs   = map[reflect.Type]stringCodec{}
+)
+
+func init() {
+	RegisterStringCodec(func(x *big.Int) (string, error) {
+		return x.String(), nil
+	}, func(s string) (*big.Int, error) {
+		x, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid big.Int: %q", s)
+		}
+		return x, nil
+	})
+
+	RegisterStringCodec(func(x *big.Rat) (string, error) {
+		return x.RatString(), nil
+	}, func(s string) (*big.Rat, error) {
+		x, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid big.Rat: %q", s)
+		}
+		return x, nil
+	})
+}
+
+// lookupStringCodec returns the codec registered for a type. If the
+// type is not a pointer, it looks up the codec of the pointer type, and
+// reports that values must be indirected.
+func lookupStringCodec(t reflect.Type) (codec stringCodec, indirect, ok bool) {
+	stringCodecsMu.RLock()
+	defer stringCodecsMu.RUnlock()
+
+	if codec, ok = stringCodecs[t]; ok {
+		return codec, false, true
+	}
+	if t.Kind() != reflect.Pointer {
+		if codec, ok = stringCodecs[reflect.PointerTo(t)]; ok {
+			return codec, true, true
+		}
+	}
+	return stringCodec{}, false, false
+}
+
+// marshalString marshals a value with the codec registered for its
+// type, if any. Nil pointers must be handled by the caller.
+func marshalString(rv reflect.Value) (string, bool, error) {
+	if !rv.IsValid() || rv.Kind() == reflect.Interface {
+		return "", false, nil
+	}
+	codec, indirect, ok := lookupStringCodec(rv.Type())
+	if !ok {
+		return "", false, nil
+	}
+	if indirect {
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		rv = p
+	}
+	s, err := codec.marshal(rv)
+	return s, true, err
+}
+
+// unmarshalString unmarshals a string into a settable value, with the
+// codec registered for its type, if any.
+func unmarshalString(s string, rv reflect.Value) (bool, error) {
+	codec, indirect, ok := lookupStringCodec(rv.Type())
+	if !ok {
+		return false, nil
+	}
+	v, err := codec.unmarshal(s)
+	if err != nil {
+		return true, err
+	}
+	if indirect {
+		if v.IsNil() {
+			return true, fmt.Errorf("cannot unmarshal %q into %v: codec returned nil", s, rv.Type())
+		}
+		v = v.Elem()
+	}
+	rv.Set(v)
+	return true, nil
+}
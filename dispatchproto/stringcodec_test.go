@@ -0,0 +1,117 @@
+package dispatchproto_test
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/google/go-cmp/cmp"
+)
+
+// cents is a fixed-point decimal, serialized with a string codec.
+type cents int64
+
+func (c cents) MarshalJSON() ([]byte, error) {
+	panic("not called: the string codec takes precedence")
+}
+
+func init() {
+	dispatchproto.RegisterStringCodec(func(c cents) (string, error) {
+		return fmt.Sprintf("%d.%02d", c/100, c%100), nil
+	}, func(s string) (cents, error) {
+		units, frac, ok := strings.Cut(s, ".")
+		if !ok || len(frac) != 2 {
+			return 0, fmt.Errorf("invalid amount: %q", s)
+		}
+		n, err := strconv.ParseInt(units+frac, 10, 64)
+		return cents(n), err
+	})
+}
+
+func TestAnyStringCodec(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	bigRat := big.NewRat(-1, 3)
+
+	type account struct {
+		Balance  *big.Int            `json:"balance"`
+		Rate     big.Rat             `json:"rate"`
+		Amounts  []cents             `json:"amounts"`
+		Holdings map[string]*big.Int `json:"holdings"`
+		Missing  *big.Int            `json:"missing"`
+	}
+
+	for _, test := range []struct {
+		value any
+		wire  string
+	}{
+		{bigInt, "-123456789012345678901234567890"},
+		{*bigInt, "-123456789012345678901234567890"},
+		{bigRat, "-1/3"},
+		{big.NewRat(4, 2), "2"},
+		{cents(12345), "123.45"},
+	} {
+		t.Run(fmt.Sprintf("%T", test.value), func(t *testing.T) {
+			boxed, err := dispatchproto.Marshal(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wire string
+			if err := boxed.Unmarshal(&wire); err != nil {
+				t.Fatal(err)
+			} else if wire != test.wire {
+				t.Errorf("unexpected wire format: got %q, want %q", wire, test.wire)
+			}
+
+			got := reflect.New(reflect.TypeOf(test.value))
+			if err := boxed.Unmarshal(got.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.value, got.Elem().Interface(), bigComparer); diff != "" {
+				t.Errorf("unexpected value: %v", diff)
+			}
+		})
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		want := account{
+			Balance:  bigInt,
+			Rate:     *bigRat,
+			Amounts:  []cents{100, 2550},
+			Holdings: map[string]*big.Int{"a": big.NewInt(1), "b": bigInt},
+		}
+		boxed, err := dispatchproto.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got account
+		if err := boxed.Unmarshal(&got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got, bigComparer); diff != "" {
+			t.Errorf("unexpected value: %v", diff)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		boxed := dispatchproto.String("1.5")
+		var n *big.Int
+		if err := boxed.Unmarshal(&n); err == nil {
+			t.Errorf("expected an error, got %v", n)
+		}
+		var c cents
+		if err := boxed.Unmarshal(&c); err == nil {
+			t.Errorf("expected an error, got %v", c)
+		}
+	})
+}
+
+var bigComparer = cmp.Options{
+	cmp.Comparer(func(a, b *big.Int) bool { return (a == nil) == (b == nil) && (a == nil || a.Cmp(b) == 0) }),
+	cmp.Comparer(func(a, b big.Int) bool { return a.Cmp(&b) == 0 }),
+	cmp.Comparer(func(a, b *big.Rat) bool { return (a == nil) == (b == nil) && (a == nil || a.Cmp(b) == 0) }),
+	cmp.Comparer(func(a, b big.Rat) bool { return a.Cmp(&b) == 0 }),
+}
@@ -0,0 +1,273 @@
+//go:build !durable
+
+package dispatchproto
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// FieldNaming is the naming of struct fields that don't have a name in
+// their json tag, when structs are serialized as a google.protobuf.Struct
+// (see WithFieldNaming and UnmarshalOptions.FieldNaming). Names from json
+// tags always take precedence, so that contracts with other languages can
+// be matched field by field where the default doesn't fit.
+type FieldNaming int
+
+const (
+	// GoFieldNames names fields after the Go field, as in
+	// encoding/json (e.g. "UserID"). It's the default.
+	GoFieldNames FieldNaming = iota
+
+	// LowerCamelCaseFieldNames names fields in lowerCamelCase
+	// (e.g. "userID"), as in the JSON mapping of Protocol Buffers.
+	LowerCamelCaseFieldNames
+
+	// SnakeCaseFieldNames names fields in snake_case (e.g. "user_id").
+	SnakeCaseFieldNames
+)
+
+func (n FieldNaming) String() string {
+	switch n {
+	case GoFieldNames:
+		return "GoFieldNames"
+	case LowerCamelCaseFieldNames:
+		return "LowerCamelCaseFieldNames"
+	case SnakeCaseFieldNames:
+		return "SnakeCaseFieldNames"
+	default:
+		return "FieldNaming(" + strconv.Itoa(int(n)) + ")"
+	}
+}
+
+func (n FieldNaming) name(field string) string {
+	switch n {
+	case LowerCamelCaseFieldNames:
+		return lowerCamelCase(field)
+	case SnakeCaseFieldNames:
+		return snakeCase(field)
+	default:
+		return field
+	}
+}
+
+// lowerCamelCase lowercases the leading capitals of a name, keeping the
+// last one of an initialism that starts a new word (e.g. "HTTPServer"
+// becomes "httpServer").
+func lowerCamelCase(s string) string {
+	r := []rune(s)
+	n := 0
+	for n < len(r) && unicode.IsUpper(r[n]) {
+		n++
+	}
+	if n > 1 && n < len(r) && unicode.IsLower(r[n]) {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// snakeCase lowercases a name and separates its words with underscores
+// (e.g. "HTTPServerID" becomes "http_server_id").
+func snakeCase(s string) string {
+	r := []rune(s)
+	var b strings.Builder
+	for i, c := range r {
+		if unicode.IsUpper(c) {
+			if i > 0 && (unicode.IsLower(r[i-1]) || unicode.IsDigit(r[i-1]) ||
+				(unicode.IsUpper(r[i-1]) && i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// structField is a field of a struct that is serialized as a field of
+// a structpb.Struct. Fields are named and promoted from embedded
+// structs following the rules of encoding/json.
+type structField struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+}
+
+type structFieldsKey struct {
+	typ    reflect.Type
+	naming FieldNaming
+}
+
+var structFieldsCache sync.Map // map[structFieldsKey][]structField
+
+// structFields returns the serialized fields of a struct type.
+func structFields(t reflect.Type, naming FieldNaming) []structField {
+	key := structFieldsKey{t, naming}
+	if fields, ok := structFieldsCache.Load(key); ok {
+		return fields.([]structField)
+	}
+	fields, _ := structFieldsCache.LoadOrStore(key, typeFields(t, naming))
+	return fields.([]structField)
+}
+
+func typeFields(t reflect.Type, naming FieldNaming) []structField {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields []structField
+	visited := map[reflect.Type]bool{}
+
+	// Walk the struct and its embedded structs breadth-first, so that
+	// fields at shallower depths take precedence.
+	next := []embedded{{typ: t}}
+	for len(next) > 0 {
+		current := next
+		next = nil
+
+		var depth []structField
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i := 0; i < e.typ.NumField(); i++ {
+				f := e.typ.Field(i)
+				tag := f.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(slices.Clip(e.index), i)
+
+				if f.Anonymous {
+					ft := f.Type
+					if ft.Kind() == reflect.Pointer {
+						ft = ft.Elem()
+					}
+					if name == "" && ft.Kind() == reflect.Struct {
+						if !f.IsExported() && f.Type.Kind() == reflect.Pointer {
+							continue // cannot allocate through an unexported pointer
+						}
+						next = append(next, embedded{typ: ft, index: index})
+						continue
+					}
+				}
+				if !f.IsExported() {
+					continue
+				}
+
+				field := structField{name: name, index: index, tagged: name != ""}
+				if name == "" {
+					field.name = naming.name(f.Name)
+				}
+				for opts != "" {
+					var opt string
+					opt, opts, _ = strings.Cut(opts, ",")
+					if opt == "omitempty" {
+						field.omitEmpty = true
+					}
+				}
+				depth = append(depth, field)
+			}
+		}
+
+		// Fields at this depth are hidden by fields at shallower depths.
+		// Among fields with the same name at the same depth, a tagged field
+		// wins; otherwise, they're all dropped.
+		for _, name := range fieldNames(depth) {
+			if slices.ContainsFunc(fields, func(f structField) bool { return f.name == name }) {
+				continue
+			}
+			var candidates []structField
+			for _, f := range depth {
+				if f.name == name {
+					candidates = append(candidates, f)
+				}
+			}
+			if len(candidates) > 1 {
+				candidates = slices.DeleteFunc(candidates, func(f structField) bool { return !f.tagged })
+			}
+			if len(candidates) == 1 {
+				fields = append(fields, candidates[0])
+			}
+		}
+	}
+
+	slices.SortFunc(fields, func(a, b structField) int {
+		return slices.Compare(a.index, b.index)
+	})
+	return fields
+}
+
+func fieldNames(fields []structField) []string {
+	var names []string
+	for _, f := range fields {
+		if !slices.Contains(names, f.name) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// fieldByIndex returns the struct field at the index path, skipping
+// nil embedded pointers (if alloc is false) or allocating them (if
+// alloc is true).
+func fieldByIndex(rv reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// lookupField finds the field for a key of a structpb.Struct. Exact
+// matches are preferred, but keys are matched case-insensitively as
+// in encoding/json.
+func lookupField(fields []structField, key string) (structField, bool) {
+	for _, f := range fields {
+		if f.name == key {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, key) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+// isEmptyValue reports whether a field tagged with omitempty is omitted,
+// as in encoding/json.
+func isEmptyValue(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return rv.IsZero()
+	}
+	return false
+}
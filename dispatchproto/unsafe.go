@@ -15,7 +15,7 @@ import (
 
 //go:linkname newProtoCall
 func newProtoCall(proto *sdkv1.Call) Call { //nolint
-	return Call{proto}
+	return Call{proto: proto}
 }
 
 //go:linkname newProtoAny
@@ -0,0 +1,67 @@
+//go:build !durable
+
+package dispatchserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+)
+
+// ErrInvalidAPIKey is returned by the functions that authenticate API
+// keys (see Authenticate) to reject a request.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// Authenticate creates an option for New that authenticates requests
+// to the Dispatch API server with the API key of their bearer token,
+// like the Dispatch API does. The dispatchclient.Client sends its API
+// key this way (see dispatchclient.APIKey).
+//
+// The function is called with the API key of each request, and returns
+// an error to reject the request. Requests without an API key are
+// rejected before the function is called. Requests that are rejected
+// fail with connect.CodeUnauthenticated, unless the error of the
+// function is a *connect.Error.
+func Authenticate(authenticate func(ctx context.Context, apiKey string) error) connect.HandlerOption {
+	return connect.WithInterceptors(connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			apiKey, ok := bearerToken(req.Header())
+			if !ok {
+				return nil, connect.NewError(connect.CodeUnauthenticated, errors.New("missing API key"))
+			}
+			if err := authenticate(ctx, apiKey); err != nil {
+				var connectErr *connect.Error
+				if errors.As(err, &connectErr) {
+					return nil, err
+				}
+				return nil, connect.NewError(connect.CodeUnauthenticated, err)
+			}
+			return next(ctx, req)
+		}
+	}))
+}
+
+// APIKeys creates an option for New that only accepts requests to the
+// Dispatch API server carrying one of the API keys (see Authenticate).
+func APIKeys(apiKeys ...string) connect.HandlerOption {
+	return Authenticate(func(ctx context.Context, apiKey string) error {
+		for _, key := range apiKeys {
+			if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+				return nil
+			}
+		}
+		return ErrInvalidAPIKey
+	})
+}
+
+func bearerToken(header http.Header) (string, bool) {
+	scheme, token, ok := strings.Cut(header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
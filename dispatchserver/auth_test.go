@@ -0,0 +1,51 @@
+package dispatchserver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestAPIKeys(t *testing.T) {
+	recorder := &dispatchtest.CallRecorder{}
+	server, err := dispatchserver.New(recorder, dispatchserver.APIKeys("old-key", "new-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(server.Handler())
+	httpServer := httptest.NewServer(mux)
+	defer httpServer.Close()
+
+	call := dispatchproto.NewCall("http://example.com", "function", dispatchproto.Int(11))
+
+	for _, key := range []string{"old-key", "new-key"} {
+		client, err := dispatchclient.New(dispatchclient.APIKey(key), dispatchclient.APIUrl(httpServer.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Dispatch(context.Background(), call); err != nil {
+			t.Errorf("unexpected error with key %q: %v", key, err)
+		}
+	}
+
+	client, err := dispatchclient.New(dispatchclient.APIKey("other-key"), dispatchclient.APIUrl(httpServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dispatch(context.Background(), call); err == nil || !strings.Contains(err.Error(), "invalid Dispatch API key") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	recorder.Assert(t,
+		dispatchtest.DispatchRequest{Header: http.Header{"Authorization": {"Bearer old-key"}}, Calls: []dispatchproto.Call{call}},
+		dispatchtest.DispatchRequest{Header: http.Header{"Authorization": {"Bearer new-key"}}, Calls: []dispatchproto.Call{call}},
+	)
+}
@@ -4,16 +4,19 @@ package dispatchserver
 
 import (
 	"context"
+	"crypto"
 	"crypto/ed25519"
+	"log/slog"
 	"net/http"
+	"time"
 	_ "unsafe"
 
 	"buf.build/gen/go/stealthrocket/dispatch-proto/connectrpc/go/dispatch/sdk/v1/sdkv1connect"
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	"connectrpc.com/connect"
-	"connectrpc.com/validate"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
 	"github.com/dispatchrun/dispatch-go/internal/auth"
+	"github.com/dispatchrun/dispatch-go/internal/validator"
 )
 
 // EndpointClient is a client for a Dispatch endpoint.
@@ -25,8 +28,14 @@ import (
 type EndpointClient struct {
 	httpClient connect.HTTPClient
 	signingKey ed25519.PrivateKey
+	keyID      string
+	external   crypto.Signer
+	signer     *auth.Signer
 	header     http.Header
 	opts       []connect.ClientOption
+	hedgeDelay time.Duration
+	maxHedges  int
+	logger     *slog.Logger
 
 	client sdkv1connect.FunctionServiceClient
 }
@@ -43,17 +52,29 @@ func NewEndpointClient(endpointUrl string, opts ...EndpointClientOption) (*Endpo
 	}
 
 	// Setup request signing.
-	if c.signingKey != nil {
-		signer := auth.NewSigner(c.signingKey)
-		c.httpClient = signer.Client(c.httpClient)
+	keyID := c.keyID
+	if keyID == "" {
+		keyID = auth.DefaultKeyID
+	}
+	if c.external != nil {
+		signer, err := auth.NewExternalSignerWithKeyID(keyID, c.external)
+		if err != nil {
+			return nil, err
+		}
+		c.signer = signer
+	} else if c.signingKey != nil {
+		c.signer = auth.NewSignerWithKeyID(keyID, c.signingKey)
+	}
+	if c.signer != nil {
+		c.httpClient = c.signer.Client(c.httpClient)
 	}
 
 	// Setup the gRPC client.
-	validator, err := validate.NewInterceptor()
+	validators, err := validator.Interceptors()
 	if err != nil {
 		return nil, err
 	}
-	c.opts = append(c.opts, connect.WithInterceptors(validator))
+	c.opts = append(c.opts, connect.WithInterceptors(validators...))
 	c.client = sdkv1connect.NewFunctionServiceClient(c.httpClient, endpointUrl, c.opts...)
 
 	return c, nil
@@ -65,11 +86,34 @@ type EndpointClientOption func(*EndpointClient)
 // SigningKey sets the signing key to use when signing requests bound
 // for the endpoint.
 //
+// The EndpointClient holds a copy of the key, which is zeroed when the
+// client is closed (see EndpointClient.Close).
+//
 // By default the EndpointClient does not sign requests to the endpoint.
 func SigningKey(signingKey ed25519.PrivateKey) EndpointClientOption {
 	return func(c *EndpointClient) { c.signingKey = signingKey }
 }
 
+// SigningKeyID sets the key ID carried by the signatures of requests
+// signed with the SigningKey or the ExternalSigner (see
+// dispatch.VerificationKeyID).
+//
+// It defaults to "default", the key ID of the requests signed by
+// Dispatch.
+func SigningKeyID(keyID string) EndpointClientOption {
+	return func(c *EndpointClient) { c.keyID = keyID }
+}
+
+// ExternalSigner sets a signer that holds the ed25519 key to use when
+// signing requests bound for the endpoint, e.g. a hardware security
+// module or a key management service, so that the signing key never
+// lives in process memory. It takes precedence over SigningKey.
+//
+// By default the EndpointClient does not sign requests to the endpoint.
+func ExternalSigner(signer crypto.Signer) EndpointClientOption {
+	return func(c *EndpointClient) { c.external = signer }
+}
+
 // HTTPClient sets the HTTP client to use when making requests to the endpoint.
 //
 // By default http.DefaultClient is used.
@@ -87,8 +131,96 @@ func ClientOptions(opts ...connect.ClientOption) EndpointClientOption {
 	return func(c *EndpointClient) { c.opts = append(c.opts, opts...) }
 }
 
+// HedgeRequests enables hedging of Run requests, to reduce the tail
+// latency of slow endpoints. If the endpoint hasn't responded to a Run
+// request after the delay, the request is sent again, up to maxHedges
+// more times. The first successful response is returned, and the
+// other requests are cancelled.
+//
+// Only requests that start a call (with an input) and have a dispatch
+// ID are hedged: the endpoint receives the same request, with the same
+// dispatch ID, more than once, which the at-least-once execution
+// guarantees of Dispatch allow for. Requests that resume a call (with
+// a poll result) are never hedged, since the suspended state of a call
+// can only be resumed once.
+//
+// By default Run requests are not hedged.
+func HedgeRequests(delay time.Duration, maxHedges int) EndpointClientOption {
+	return func(c *EndpointClient) { c.hedgeDelay, c.maxHedges = delay, maxHedges }
+}
+
+// Logger sets the logger of the EndpointClient. Requests, responses and
+// hedged requests are logged at the Debug level, and failures at the
+// Warn level.
+//
+// It defaults to slog.Default().
+func Logger(logger *slog.Logger) EndpointClientOption {
+	return func(c *EndpointClient) { c.logger = logger }
+}
+
+func (c *EndpointClient) log() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
+	}
+	return c.logger
+}
+
 // Run sends a RunRequest and returns a RunResponse.
 func (c *EndpointClient) Run(ctx context.Context, req dispatchproto.Request) (dispatchproto.Response, error) {
+	_, hasInput := req.Input()
+	if c.hedgeDelay <= 0 || c.maxHedges <= 0 || req.DispatchID() == "" || !hasInput {
+		return c.run(ctx, req)
+	}
+	return c.runHedged(ctx, req)
+}
+
+func (c *EndpointClient) runHedged(ctx context.Context, req dispatchproto.Request) (dispatchproto.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		res dispatchproto.Response
+		err error
+	}
+	results := make(chan result, c.maxHedges+1)
+
+	var sent, inflight int
+	send := func() {
+		sent++
+		inflight++
+		go func() {
+			res, err := c.run(ctx, req)
+			results <- result{res, err}
+		}()
+	}
+
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	send()
+	for {
+		select {
+		case <-timer.C:
+			if sent <= c.maxHedges {
+				c.log().DebugContext(ctx, "hedging Dispatch request", "function", req.Function(), "dispatch_id", req.DispatchID(), "attempt", sent+1)
+				send()
+				timer.Reset(c.hedgeDelay)
+			}
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				return r.res, nil
+			}
+			// Hedging isn't retrying: fail once no request that may
+			// succeed is in flight.
+			if inflight == 0 {
+				return dispatchproto.Response{}, r.err
+			}
+		}
+	}
+}
+
+func (c *EndpointClient) run(ctx context.Context, req dispatchproto.Request) (dispatchproto.Response, error) {
 	connectReq := connect.NewRequest(requestProto(req))
 
 	header := connectReq.Header()
@@ -96,11 +228,33 @@ func (c *EndpointClient) Run(ctx context.Context, req dispatchproto.Request) (di
 		header[name] = values
 	}
 
+	logger := c.log()
+	attrs := []any{"function", req.Function()}
+	if id := req.DispatchID(); id != "" {
+		attrs = append(attrs, "dispatch_id", id)
+	}
+	logger.DebugContext(ctx, "sending Dispatch request", attrs...)
+
 	res, err := c.client.Run(ctx, connectReq)
 	if err != nil {
+		// Cancelled hedged requests aren't failures.
+		if ctx.Err() == nil {
+			logger.WarnContext(ctx, "Dispatch request failed", append(attrs, "error", err)...)
+		}
 		return dispatchproto.Response{}, err
 	}
-	return newProtoResponse(res.Msg), nil
+	response := newProtoResponse(res.Msg)
+	logger.DebugContext(ctx, "received Dispatch response", append(attrs, "status", response.Status().String())...)
+	return response, nil
+}
+
+// Close zeroes the copy of the signing key held by the client (see
+// SigningKey). Requests can't be sent once the client is closed.
+func (c *EndpointClient) Close() error {
+	if c.signer != nil {
+		return c.signer.Close()
+	}
+	return nil
 }
 
 //go:linkname newProtoResponse github.com/dispatchrun/dispatch-go/dispatchproto.newProtoResponse
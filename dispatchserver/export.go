@@ -0,0 +1,164 @@
+//go:build !durable
+
+package dispatchserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	_ "unsafe"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// redacted replaces the values redacted from exported executions.
+const redacted = "[REDACTED]"
+
+// ExportOption configures the export of executions (see Export).
+type ExportOption func(*exporter)
+
+type exporter struct {
+	redactions map[string][][]string // function => paths
+}
+
+// Redact redacts values from the inputs and outputs of the executions
+// of a function when they're exported, e.g. to keep personal data out
+// of audit storage. An empty function name redacts the values from the
+// executions of all functions.
+//
+// Paths are dot-separated keys of JSON-like values (e.g. "user.email"),
+// and apply to each element of the lists on the way. The values at the
+// paths are replaced with "[REDACTED]", and an empty path redacts the
+// whole value. Values that aren't JSON-like, such as protobuf messages,
+// are redacted whole if any path is set.
+func Redact(function string, paths ...string) ExportOption {
+	return func(e *exporter) {
+		if e.redactions == nil {
+			e.redactions = map[string][][]string{}
+		}
+		for _, path := range paths {
+			var keys []string
+			if path != "" {
+				keys = strings.Split(path, ".")
+			}
+			e.redactions[function] = append(e.redactions[function], keys)
+		}
+	}
+}
+
+// exportedExecution is the representation of an execution in exports.
+type exportedExecution struct {
+	ID              dispatchproto.ID `json:"id"`
+	Function        string           `json:"function"`
+	Created         time.Time        `json:"created"`
+	Completed       time.Time        `json:"completed"`
+	ResubmittedFrom dispatchproto.ID `json:"resubmitted_from,omitempty"`
+	Status          string           `json:"status"`
+	Input           json.RawMessage  `json:"input"`
+	Output          json.RawMessage  `json:"output,omitempty"`
+	Error           *exportedError   `json:"error,omitempty"`
+}
+
+type exportedError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Export writes the executions that completed to w in the JSON Lines
+// format, one execution per line, for long-term audit storage. The
+// executions still running are skipped. For example, the history of a
+// scheduler can be archived with:
+//
+//	err := dispatchserver.Export(w, scheduler.History())
+//
+// Inputs and outputs that are JSON-like are written as JSON values.
+// Others are written in the JSON mapping of google.protobuf.Any, and
+// fail to export if their type isn't known (e.g. if they're encrypted),
+// unless they're redacted (see Redact).
+func Export(w io.Writer, executions []Execution, opts ...ExportOption) error {
+	var e exporter
+	for _, opt := range opts {
+		opt(&e)
+	}
+	enc := json.NewEncoder(w)
+	for _, execution := range executions {
+		if !execution.Done {
+			continue
+		}
+		exported, err := e.export(execution)
+		if err != nil {
+			return fmt.Errorf("cannot export execution %s: %w", execution.ID, err)
+		}
+		if err := enc.Encode(exported); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exporter) export(execution Execution) (*exportedExecution, error) {
+	function := execution.Call.Function()
+	redactions := slices.Concat(e.redactions[""], e.redactions[function])
+
+	exported := &exportedExecution{
+		ID:              execution.ID,
+		Function:        function,
+		Created:         execution.Created,
+		Completed:       execution.Completed,
+		ResubmittedFrom: execution.ResubmittedFrom,
+		Status:          execution.Response.Status().String(),
+	}
+	var err error
+	if exported.Input, err = exportValue(execution.Call.Input(), redactions); err != nil {
+		return nil, fmt.Errorf("input: %w", err)
+	}
+	if output, ok := execution.Response.Output(); ok {
+		if exported.Output, err = exportValue(output, redactions); err != nil {
+			return nil, fmt.Errorf("output: %w", err)
+		}
+	}
+	if err, ok := execution.Response.Error(); ok {
+		exported.Error = &exportedError{Type: err.Type(), Message: err.Message()}
+	}
+	return exported, nil
+}
+
+func exportValue(a dispatchproto.Any, redactions [][]string) (json.RawMessage, error) {
+	var v any
+	if err := a.Unmarshal(&v); err != nil {
+		if len(redactions) > 0 {
+			return json.Marshal(redacted)
+		}
+		return protojson.Marshal(anyProto(a))
+	}
+	for _, path := range redactions {
+		v = redact(v, path)
+	}
+	return json.Marshal(v)
+}
+
+// redact replaces the values at a path in a JSON-like value.
+func redact(v any, path []string) any {
+	if len(path) == 0 {
+		return redacted
+	}
+	switch vv := v.(type) {
+	case map[string]any:
+		if field, ok := vv[path[0]]; ok {
+			vv[path[0]] = redact(field, path[1:])
+		}
+	case []any:
+		for i, elem := range vv {
+			vv[i] = redact(elem, path)
+		}
+	}
+	return v
+}
+
+//go:linkname anyProto github.com/dispatchrun/dispatch-go/dispatchproto.anyProto
+func anyProto(dispatchproto.Any) *anypb.Any
@@ -0,0 +1,119 @@
+package dispatchserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExport(t *testing.T) {
+	type user struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	type signup struct {
+		Users []user `json:"users"`
+		Token string `json:"token"`
+	}
+	register := dispatch.Func("register", func(ctx context.Context, s signup) (int, error) {
+		if s.Token == "" {
+			return 0, fmt.Errorf("%w: missing token", dispatch.ErrPermanent)
+		}
+		return len(s.Users), nil
+	})
+
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{register})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	users := []user{{Name: "alice", Email: "alice@example.com"}, {Name: "bob", Email: "bob@example.com"}}
+	var calls []dispatchproto.Call
+	for _, input := range []signup{{Users: users, Token: "secret"}, {Users: users}} {
+		call, err := register.BuildCall(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, call)
+	}
+	ids, err := scheduler.Handle(ctx, nil, calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := scheduler.Wait(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	err = dispatchserver.Export(&b, scheduler.History(),
+		dispatchserver.Redact("register", "users.email", "token"),
+		dispatchserver.Redact("other", "users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected export: %s", b.String())
+	}
+	redactedInput := map[string]any{
+		"users": []any{
+			map[string]any{"name": "alice", "email": "[REDACTED]"},
+			map[string]any{"name": "bob", "email": "[REDACTED]"},
+		},
+		"token": "[REDACTED]",
+	}
+	for i, want := range []map[string]any{
+		{
+			"id":       string(ids[0]),
+			"function": "register",
+			"status":   "OK",
+			"input":    redactedInput,
+			"output":   "[REDACTED]", // not JSON-like
+		},
+		{
+			"id":       string(ids[1]),
+			"function": "register",
+			"status":   "PermanentError",
+			"input":    redactedInput,
+			"error":    map[string]any{"type": "wrapError", "message": "PermanentError: missing token"},
+		},
+	} {
+		var got map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatal(err)
+		}
+		delete(got, "created")
+		delete(got, "completed")
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected execution %d: %v", i, diff)
+		}
+	}
+
+	// Values that aren't JSON-like are exported in the JSON mapping of
+	// google.protobuf.Any.
+	b.Reset()
+	if err := dispatchserver.Export(&b, scheduler.History()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Output map[string]any }
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"@type": "type.googleapis.com/google.protobuf.Int64Value", "value": "2"}
+	if diff := cmp.Diff(want, got.Output); diff != "" {
+		t.Errorf("unexpected output: %v", diff)
+	}
+}
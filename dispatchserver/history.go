@@ -0,0 +1,242 @@
+//go:build !durable
+
+package dispatchserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+var (
+	// ErrExecutionNotFound is returned when an execution is not in the
+	// history of a LocalScheduler.
+	ErrExecutionNotFound = errors.New("execution not found")
+
+	// ErrExecutionNotFailed is returned when resubmitting an execution
+	// that is still running, or that succeeded.
+	ErrExecutionNotFailed = errors.New("execution has not failed")
+)
+
+// Execution is an execution of a call dispatched to a LocalScheduler,
+// as recorded in its history (see LocalScheduler.History).
+type Execution struct {
+	// ID is the dispatch ID of the execution.
+	ID dispatchproto.ID
+
+	// Call is the call that was dispatched, with its original input.
+	Call dispatchproto.Call
+
+	// Created is the time the call was dispatched.
+	Created time.Time
+
+	// Done is true once the execution completed, and Response is its
+	// final response.
+	Done     bool
+	Response dispatchproto.Response
+
+	// Completed is the time the execution completed.
+	Completed time.Time
+
+	// ResubmittedFrom is the ID of the execution that this execution
+	// resubmits, if any (see LocalScheduler.Resubmit).
+	ResubmittedFrom dispatchproto.ID
+
+	// Deleted is true if the execution was deleted from the history
+	// (see LocalScheduler.Delete).
+	Deleted bool
+}
+
+// Failed is true if the execution completed with a status other than
+// OKStatus.
+func (e Execution) Failed() bool {
+	return e.Done && e.Response.Status() != dispatchproto.OKStatus
+}
+
+// History returns the executions of the calls dispatched to the
+// scheduler, in the order they were dispatched. Executions deleted with
+// Delete are omitted. The calls made by functions are not included.
+//
+// The history is held in memory, even when the queue is persisted (see
+// PersistQueue).
+func (s *LocalScheduler) History() []Execution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	executions := make([]Execution, 0, len(s.dispatched))
+	for _, id := range s.dispatched {
+		if e := s.history[id]; !e.Deleted {
+			executions = append(executions, s.execution(e))
+		}
+	}
+	return executions
+}
+
+// Execution returns the execution of a call dispatched to the
+// scheduler, including if it was deleted from the history.
+func (s *LocalScheduler) Execution(id dispatchproto.ID) (Execution, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.history[id]
+	if !ok {
+		return Execution{}, false
+	}
+	return s.execution(e), true
+}
+
+// Delete soft-deletes an execution from the history: it's omitted by
+// History, but can still be looked up by ID and resubmitted. Deleting
+// an execution doesn't cancel it (see LocalScheduler.Cancel).
+func (s *LocalScheduler) Delete(id dispatchproto.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.history[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	e.Deleted = true
+	s.history[id] = e
+	return nil
+}
+
+// Resubmit dispatches the call of an execution that failed again, with
+// its original input, e.g. to re-drive failures after fixing a bug. It
+// returns the dispatch ID of the new execution, which links to the
+// original execution (see Execution.ResubmittedFrom).
+//
+// It returns ErrExecutionNotFound if the execution is not in the
+// history, and ErrExecutionNotFailed if it's still running or if it
+// succeeded.
+func (s *LocalScheduler) Resubmit(ctx context.Context, id dispatchproto.ID) (dispatchproto.ID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.history[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	if !s.execution(e).Failed() {
+		return "", fmt.Errorf("%w: %s", ErrExecutionNotFailed, id)
+	}
+	t := s.dispatch(e.Call, time.Now())
+	resubmitted := s.history[t.id]
+	resubmitted.ResubmittedFrom = id
+	s.history[t.id] = resubmitted
+	s.save()
+	s.notify()
+	return t.id, nil
+}
+
+// RetainHistory sets the retention policy of the history of the
+// scheduler, so that it doesn't grow unbounded in long-running
+// schedulers. Executions are collected from the history once they've
+// been completed for longer than maxAge, and the executions that
+// completed first are collected when more than maxSize completed
+// executions are retained. Zero values disable the limits. Executions
+// still running are never collected.
+//
+// Executions are collected by Run, along with their final response,
+// after which Execution and Wait no longer find them (see OnCollect to
+// archive them first).
+func RetainHistory(maxAge time.Duration, maxSize int) LocalSchedulerOption {
+	return func(s *LocalScheduler) { s.maxHistoryAge, s.maxHistorySize = maxAge, maxSize }
+}
+
+// OnCollect sets a function that is called with each execution
+// collected from the history (see RetainHistory), e.g. to archive it
+// with Export before it's discarded. Run calls the function, and waits
+// for it to return before running more calls.
+func OnCollect(fn func(Execution)) LocalSchedulerOption {
+	return func(s *LocalScheduler) { s.onCollect = fn }
+}
+
+// GCStats are statistics of the collection of executions from the
+// history of a LocalScheduler (see RetainHistory), e.g. to export as
+// metrics.
+type GCStats struct {
+	// Retained is the number of executions in the history, including
+	// the executions that are still running and deleted executions.
+	Retained int
+
+	// Collections is the number of times executions were collected,
+	// and Collected the total number of executions collected.
+	Collections int
+	Collected   int
+
+	// LastCollection is the last time executions were collected.
+	LastCollection time.Time
+}
+
+// GCStats returns statistics of the collection of executions from the
+// history.
+func (s *LocalScheduler) GCStats() GCStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.gcStats
+	stats.Retained = len(s.history)
+	return stats
+}
+
+type completion struct {
+	id   dispatchproto.ID
+	time time.Time
+}
+
+// collect removes the executions that completed from the history
+// according to the retention policy, and returns them along with the
+// time until more executions expire.
+func (s *LocalScheduler) collect(now time.Time) ([]Execution, time.Duration) {
+	var collected []Execution
+	wait := time.Hour
+	for len(s.completed) > 0 {
+		c := s.completed[0]
+		excess := s.maxHistorySize > 0 && len(s.completed) > s.maxHistorySize
+		expired := s.maxHistoryAge > 0 && now.Sub(c.time) >= s.maxHistoryAge
+		if !excess && !expired {
+			if s.maxHistoryAge > 0 {
+				wait = c.time.Add(s.maxHistoryAge).Sub(now)
+			}
+			break
+		}
+		s.completed = s.completed[1:]
+
+		e, ok := s.history[c.id]
+		if !ok {
+			e = Execution{ID: c.id} // restored from the persisted queue
+		}
+		collected = append(collected, s.execution(e))
+		delete(s.history, c.id)
+		delete(s.results, c.id)
+	}
+	if len(collected) > 0 {
+		s.dispatched = slices.DeleteFunc(s.dispatched, func(id dispatchproto.ID) bool {
+			_, ok := s.history[id]
+			return !ok
+		})
+		s.gcStats.Collections++
+		s.gcStats.Collected += len(collected)
+		s.gcStats.LastCollection = now
+	}
+	return collected, wait
+}
+
+// dispatch queues a call dispatched to the scheduler, and records it in
+// the history.
+func (s *LocalScheduler) dispatch(call dispatchproto.Call, now time.Time) *task {
+	t := s.spawn(call, nil, now)
+	s.history[t.id] = Execution{ID: t.id, Call: call, Created: now}
+	s.dispatched = append(s.dispatched, t.id)
+	return t
+}
+
+func (s *LocalScheduler) execution(e Execution) Execution {
+	e.Response, e.Done = s.results[e.ID]
+	return e
+}
@@ -0,0 +1,172 @@
+package dispatchserver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestLocalSchedulerResubmit(t *testing.T) {
+	var fixed atomic.Bool
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		if !fixed.Load() {
+			return 0, fmt.Errorf("%w: bug", dispatch.ErrPermanent)
+		}
+		return n * 2, nil
+	})
+
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{double})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	failing, err := double.BuildCall(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	succeeding, err := double.BuildCall(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := scheduler.Handle(ctx, nil, []dispatchproto.Call{failing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := scheduler.Wait(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.PermanentErrorStatus, "bug")
+
+	// Executions that didn't fail cannot be resubmitted.
+	fixed.Store(true)
+	other, err := scheduler.Handle(ctx, nil, []dispatchproto.Call{succeeding})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scheduler.Wait(ctx, other[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scheduler.Resubmit(ctx, other[0]); !errors.Is(err, dispatchserver.ErrExecutionNotFailed) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := scheduler.Resubmit(ctx, "unknown"); !errors.Is(err, dispatchserver.ErrExecutionNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The failed execution is resubmitted with its original input, once
+	// the bug is fixed.
+	id, err := scheduler.Resubmit(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == ids[0] {
+		t.Fatal("resubmitted execution has the same ID")
+	}
+	res, err = scheduler.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 42)
+
+	history := scheduler.History()
+	if len(history) != 3 {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	for i, want := range []dispatchproto.ID{ids[0], other[0], id} {
+		if got := history[i].ID; got != want {
+			t.Errorf("unexpected execution %d: got %s, want %s", i, got, want)
+		}
+	}
+	if !history[0].Failed() || history[1].Failed() || history[2].Failed() {
+		t.Errorf("unexpected failures: %v %v %v", history[0].Failed(), history[1].Failed(), history[2].Failed())
+	}
+	if got := history[2].ResubmittedFrom; got != ids[0] {
+		t.Errorf("unexpected link to the original execution: %q", got)
+	}
+	if !history[2].Call.Equal(failing) {
+		t.Errorf("unexpected call: %v", history[2].Call)
+	}
+
+	// Deleted executions are omitted from the history, but can still be
+	// resubmitted.
+	if err := scheduler.Delete(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if history := scheduler.History(); len(history) != 2 || history[0].ID != other[0] {
+		t.Errorf("unexpected history: %+v", history)
+	}
+	if e, ok := scheduler.Execution(ids[0]); !ok || !e.Deleted {
+		t.Errorf("unexpected execution: %+v", e)
+	}
+	if _, err := scheduler.Resubmit(ctx, ids[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalSchedulerRetainHistory(t *testing.T) {
+	identity := dispatch.Func("identity", func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+
+	collected := make(chan dispatchserver.Execution, 10)
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{identity},
+		dispatchserver.RetainHistory(200*time.Millisecond, 2),
+		dispatchserver.OnCollect(func(e dispatchserver.Execution) { collected <- e }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	var ids []dispatchproto.ID
+	for i := range 3 {
+		call, err := identity.BuildCall(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := scheduler.Handle(ctx, nil, []dispatchproto.Call{call})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := scheduler.Wait(ctx, id[0]); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id[0])
+	}
+
+	// The oldest execution is collected once more than two executions
+	// completed, and the others once they expire.
+	for _, want := range ids {
+		select {
+		case e := <-collected:
+			if e.ID != want || !e.Done || e.Completed.IsZero() {
+				t.Errorf("unexpected collected execution: %+v", e)
+			}
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+	if history := scheduler.History(); len(history) != 0 {
+		t.Errorf("unexpected history: %+v", history)
+	}
+	if _, ok := scheduler.Execution(ids[0]); ok {
+		t.Error("collected execution found")
+	}
+	if _, err := scheduler.Wait(ctx, ids[0]); !errors.Is(err, dispatchserver.ErrExecutionNotFound) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	stats := scheduler.GCStats()
+	if stats.Retained != 0 || stats.Collected != 3 || stats.Collections < 2 || stats.LastCollection.IsZero() {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,668 @@
+//go:build !durable
+
+package dispatchserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	_ "unsafe"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// LocalScheduler is a Handler that schedules function calls in
+// process, for local development without the Dispatch service.
+//
+// Calls dispatched to the scheduler (e.g. by serving it with New, and
+// pointing a dispatchclient.Client at the server) are queued, and run
+// on an endpoint by Run. Like Dispatch, the scheduler retries calls
+// that fail with a temporary status, fails calls that expire, runs the
+// calls made by functions that poll, and resumes the functions with
+// their results according to the min results, max results and max wait
+// of the poll.
+//
+// All calls run on the endpoint of the EndpointClient of the scheduler,
+// regardless of the endpoint they're addressed to.
+//
+// The scheduler records the calls dispatched to it in a history, from
+// which calls that failed can be resubmitted (see History and
+// Resubmit), and which can be archived (see Export). The history is
+// kept until the scheduler is discarded, unless a retention policy is
+// set (see RetainHistory).
+type LocalScheduler struct {
+	client      *EndpointClient
+	path        string
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	concurrency int
+
+	mu      sync.Mutex
+	tasks   map[dispatchproto.ID]*task
+	running int
+	results map[dispatchproto.ID]dispatchproto.Response
+	done    chan struct{} // closed and replaced when a call completes
+	wake    chan struct{}
+
+	history    map[dispatchproto.ID]Execution
+	dispatched []dispatchproto.ID // in the order they were dispatched
+
+	maxHistoryAge  time.Duration
+	maxHistorySize int
+	onCollect      func(Execution)
+	completed      []completion // in the order they completed
+	gcStats        GCStats
+}
+
+// LocalSchedulerOption configures a LocalScheduler.
+type LocalSchedulerOption func(*LocalScheduler)
+
+// PersistQueue sets the path of a file the queue of the scheduler is
+// saved to each time it changes, and restored from when the scheduler
+// is created, so that calls survive restarts. Calls that were running
+// when the scheduler stopped are run again.
+//
+// By default the queue is only held in memory.
+func PersistQueue(path string) LocalSchedulerOption {
+	return func(s *LocalScheduler) { s.path = path }
+}
+
+// MaxAttempts sets the maximum number of attempts of each request to
+// the endpoint, after which the call fails with the status of the last
+// attempt. It defaults to 10.
+func MaxAttempts(n int) LocalSchedulerOption {
+	return func(s *LocalScheduler) { s.maxAttempts = n }
+}
+
+// RetryBackoff sets the delay before the first retry of a request,
+// which doubles with each attempt up to max. It defaults to 100ms,
+// up to 10s.
+func RetryBackoff(min, max time.Duration) LocalSchedulerOption {
+	return func(s *LocalScheduler) { s.minBackoff, s.maxBackoff = min, max }
+}
+
+// MaxConcurrency sets the maximum number of requests in flight to the
+// endpoint. It defaults to 16.
+func MaxConcurrency(n int) LocalSchedulerOption {
+	return func(s *LocalScheduler) { s.concurrency = n }
+}
+
+// NewLocalScheduler creates a LocalScheduler that runs calls on the
+// endpoint of the client.
+func NewLocalScheduler(client *EndpointClient, opts ...LocalSchedulerOption) (*LocalScheduler, error) {
+	s := &LocalScheduler{
+		client:      client,
+		maxAttempts: 10,
+		minBackoff:  100 * time.Millisecond,
+		maxBackoff:  10 * time.Second,
+		concurrency: 16,
+		tasks:       map[dispatchproto.ID]*task{},
+		results:     map[dispatchproto.ID]dispatchproto.Response{},
+		history:     map[dispatchproto.ID]Execution{},
+		done:        make(chan struct{}),
+		wake:        make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxAttempts <= 0 {
+		return nil, fmt.Errorf("invalid max attempts: %d", s.maxAttempts)
+	}
+	if s.concurrency <= 0 {
+		return nil, fmt.Errorf("invalid max concurrency: %d", s.concurrency)
+	}
+	if s.maxHistoryAge < 0 {
+		return nil, fmt.Errorf("invalid max history age: %v", s.maxHistoryAge)
+	}
+	if s.maxHistorySize < 0 {
+		return nil, fmt.Errorf("invalid max history size: %d", s.maxHistorySize)
+	}
+	if s.path != "" {
+		if err := s.load(); err != nil {
+			return nil, fmt.Errorf("cannot load queue %s: %w", s.path, err)
+		}
+	}
+	return s, nil
+}
+
+// Handle queues calls, and returns their dispatch IDs.
+func (s *LocalScheduler) Handle(ctx context.Context, header http.Header, calls []dispatchproto.Call) ([]dispatchproto.ID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ids := make([]dispatchproto.ID, len(calls))
+	for i, call := range calls {
+		ids[i] = s.dispatch(call, now).id
+	}
+	s.save()
+	s.notify()
+	return ids, nil
+}
+
+// Cancel cancels calls queued in the scheduler, and the calls they
+// made. The calls fail with PermanentErrorStatus. Requests to the
+// endpoint that are in flight aren't interrupted, but their responses
+// are discarded.
+//
+// Cancellation is local to the scheduler: the Dispatch API has no
+// equivalent, so it can't be requested by a dispatchclient.Client.
+func (s *LocalScheduler) Cancel(ctx context.Context, ids, rootIDs []dispatchproto.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancelled := map[dispatchproto.ID]bool{}
+	for _, id := range ids {
+		cancelled[id] = true
+	}
+	for _, id := range rootIDs {
+		cancelled[id] = true
+	}
+	for _, t := range s.tasks {
+		if cancelled[t.root] {
+			cancelled[t.id] = true
+		}
+	}
+	// Cancel the calls made by cancelled calls, until there are no more.
+	for changed := true; changed; {
+		changed = false
+		for _, t := range s.tasks {
+			if !cancelled[t.id] && cancelled[t.parent] {
+				cancelled[t.id] = true
+				changed = true
+			}
+		}
+	}
+
+	res := dispatchproto.NewResponse(dispatchproto.PermanentErrorStatus, dispatchproto.NewErrorMessage("Cancelled", "call cancelled"))
+	for id := range cancelled {
+		if t, ok := s.tasks[id]; ok && !cancelled[t.parent] {
+			s.complete(t, res)
+		}
+	}
+	for id := range cancelled {
+		delete(s.tasks, id)
+	}
+	s.save()
+	s.notify()
+	return nil
+}
+
+// Wait waits for a call dispatched to the scheduler to complete, and
+// returns its final response. The responses of calls are kept in
+// memory until they're collected from the history (see RetainHistory).
+// Waiting for a call that the scheduler doesn't know about, e.g.
+// because it was collected, fails with ErrExecutionNotFound.
+func (s *LocalScheduler) Wait(ctx context.Context, id dispatchproto.ID) (dispatchproto.Response, error) {
+	for {
+		s.mu.Lock()
+		res, ok := s.results[id]
+		_, running := s.tasks[id]
+		_, dispatched := s.history[id]
+		done := s.done
+		s.mu.Unlock()
+		if ok {
+			return res, nil
+		}
+		if !running && !dispatched {
+			return dispatchproto.Response{}, fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return dispatchproto.Response{}, ctx.Err()
+		}
+	}
+}
+
+// Run runs the queued calls until the context is canceled, and returns
+// the error of the context. Requests that are in flight when the
+// context is canceled are run again the next time Run is called.
+func (s *LocalScheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		collected, collectWait := s.collect(now)
+		tasks, wait := s.ready(now)
+		for _, t := range tasks {
+			wg.Add(1)
+			go func(t *task, req dispatchproto.Request) {
+				defer wg.Done()
+				s.execute(ctx, t, req)
+			}(t, t.req)
+		}
+		s.mu.Unlock()
+
+		if s.onCollect != nil {
+			for _, e := range collected {
+				s.onCollect(e)
+			}
+		}
+
+		timer := time.NewTimer(min(wait, collectWait))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-s.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+type taskStatus int
+
+const (
+	queued taskStatus = iota
+	running
+	waiting
+)
+
+type task struct {
+	id            dispatchproto.ID
+	parent        dispatchproto.ID
+	root          dispatchproto.ID
+	correlationID uint64
+	function      string
+	created       time.Time
+	expires       time.Time
+
+	status   taskStatus
+	req      dispatchproto.Request // the next request to send
+	due      time.Time
+	attempts int
+
+	// The state of the function while it waits for the results of
+	// calls, and the results received so far.
+	state      dispatchproto.Any
+	results    []dispatchproto.CallResult
+	minResults int
+	maxResults int
+	deadline   time.Time
+	children   int
+}
+
+// request creates a request to run the call with a directive.
+func (t *task) request(directive dispatchproto.RequestOption) dispatchproto.Request {
+	opts := []dispatchproto.RequestOption{
+		directive,
+		dispatchproto.DispatchID(t.id),
+		dispatchproto.RootDispatchID(t.root),
+		dispatchproto.CreationTime(t.created),
+	}
+	if t.parent != "" {
+		opts = append(opts, dispatchproto.ParentDispatchID(t.parent))
+	}
+	if !t.expires.IsZero() {
+		opts = append(opts, dispatchproto.ExpirationTime(t.expires))
+	}
+	return dispatchproto.NewRequest(t.function, opts...)
+}
+
+func (t *task) start(call dispatchproto.Call, now time.Time) {
+	t.function = call.Function()
+	t.expires = time.Time{}
+	if expiration := call.Expiration(); expiration > 0 {
+		t.expires = now.Add(expiration)
+	}
+	t.req = t.request(call.Input())
+	t.status, t.due, t.attempts = queued, now, 0
+}
+
+func (s *LocalScheduler) spawn(call dispatchproto.Call, parent *task, now time.Time) *task {
+	t := &task{
+		id:            newID(),
+		correlationID: call.CorrelationID(),
+		created:       now,
+	}
+	if parent != nil {
+		t.parent, t.root = parent.id, parent.root
+		parent.children++
+	} else {
+		t.root = t.id
+	}
+	t.start(call, now)
+	s.tasks[t.id] = t
+	return t
+}
+
+// ready returns the calls that are ready to run, marking them as
+// running, and the time until other calls may be ready.
+func (s *LocalScheduler) ready(now time.Time) ([]*task, time.Duration) {
+	var tasks []*task
+	wait := time.Hour
+	for _, t := range s.tasks {
+		if t.status == waiting {
+			if !t.resumable() && now.Before(t.deadline) {
+				wait = min(wait, t.deadline.Sub(now))
+				continue
+			}
+			s.resume(t, now)
+		}
+		if t.status != queued {
+			continue
+		}
+		if t.due.After(now) {
+			wait = min(wait, t.due.Sub(now))
+			continue
+		}
+		if s.running < s.concurrency {
+			t.status = running
+			s.running++
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks, wait
+}
+
+// resumable is true if a function that polls can be resumed before the
+// max wait of the poll: it has min results, or all the results of its
+// calls if there are fewer.
+func (t *task) resumable() bool {
+	available := len(t.results)
+	return available >= t.minResults || (available > 0 && t.children == 0)
+}
+
+// resume queues a request that resumes a function with the results of
+// its calls.
+func (s *LocalScheduler) resume(t *task, now time.Time) {
+	results := t.results
+	if t.maxResults > 0 && len(results) > t.maxResults {
+		results = results[:t.maxResults]
+	}
+	t.results = t.results[len(results):]
+	t.req = t.request(dispatchproto.NewPollResult(
+		dispatchproto.CoroutineState(t.state),
+		dispatchproto.CallResults(results...)))
+	t.state = dispatchproto.Any{}
+	t.status, t.due, t.attempts = queued, now, 0
+}
+
+func (s *LocalScheduler) execute(ctx context.Context, t *task, req dispatchproto.Request) {
+	var res dispatchproto.Response
+	var err error
+	if !t.expires.IsZero() && !time.Now().Before(t.expires) {
+		res = dispatchproto.NewResponse(dispatchproto.TimeoutStatus, dispatchproto.NewErrorMessage("Timeout", "call expired"))
+	} else {
+		res, err = s.client.Run(ctx, req)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.notify()
+
+	s.running--
+	if s.tasks[t.id] != t {
+		return // cancelled
+	}
+	now := time.Now()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			t.status = queued // interrupted by Run returning
+			return
+		}
+		res = dispatchproto.NewResponse(dispatchproto.ErrorStatus(err), dispatchproto.NewError(err))
+	}
+
+	switch poll, ok := res.Poll(); {
+	case ok:
+		t.state = poll.CoroutineState()
+		t.minResults = int(poll.MinResults())
+		t.maxResults = int(poll.MaxResults())
+		t.deadline = now.Add(poll.MaxWait())
+		t.status = waiting
+		for _, call := range poll.Calls() {
+			s.spawn(call, t, now)
+		}
+	case hasTailCall(res):
+		// The tail call replaces the call.
+		exit, _ := res.Exit()
+		tailCall, _ := exit.TailCall()
+		t.results = nil
+		t.start(tailCall, now)
+	default:
+		t.attempts++
+		if retryable(res.Status()) && t.attempts < s.maxAttempts {
+			backoff := s.minBackoff << (t.attempts - 1)
+			if backoff > s.maxBackoff || backoff <= 0 {
+				backoff = s.maxBackoff
+			}
+			t.status, t.due = queued, now.Add(backoff)
+		} else {
+			s.complete(t, res)
+			delete(s.tasks, t.id)
+		}
+	}
+	s.save()
+}
+
+// complete records the final response of a call, and delivers its
+// result to the function that made the call, if any.
+func (s *LocalScheduler) complete(t *task, res dispatchproto.Response) {
+	result, _ := res.Result()
+	result = result.With(dispatchproto.DispatchID(t.id))
+
+	if t.parent == "" {
+		now := time.Now()
+		s.results[t.id] = res
+		if e, ok := s.history[t.id]; ok {
+			e.Completed = now
+			s.history[t.id] = e
+		}
+		if s.maxHistoryAge > 0 || s.maxHistorySize > 0 {
+			s.completed = append(s.completed, completion{id: t.id, time: now})
+		}
+		close(s.done)
+		s.done = make(chan struct{})
+		return
+	}
+	if parent, ok := s.tasks[t.parent]; ok {
+		parent.children--
+		parent.results = append(parent.results, result.With(dispatchproto.CorrelationID(t.correlationID)))
+	}
+}
+
+func (s *LocalScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func hasTailCall(res dispatchproto.Response) bool {
+	exit, ok := res.Exit()
+	if !ok {
+		return false
+	}
+	_, ok = exit.TailCall()
+	return ok
+}
+
+func retryable(status dispatchproto.Status) bool {
+	switch status {
+	case dispatchproto.TimeoutStatus,
+		dispatchproto.ThrottledStatus,
+		dispatchproto.TemporaryErrorStatus,
+		dispatchproto.DNSErrorStatus,
+		dispatchproto.TCPErrorStatus,
+		dispatchproto.TLSErrorStatus,
+		dispatchproto.HTTPErrorStatus:
+		return true
+	default:
+		return false
+	}
+}
+
+func newID() dispatchproto.ID {
+	var b [16]byte
+	rand.Read(b[:])
+	return dispatchproto.ID(hex.EncodeToString(b[:]))
+}
+
+// taskSnapshot is the representation of a task in the file the queue is
+// persisted to. Requests are serialized as protobuf messages. The state
+// and results of functions that poll are serialized as the request that
+// resumes them, since they have no serialization of their own.
+type taskSnapshot struct {
+	ID            dispatchproto.ID `json:"id"`
+	ParentID      dispatchproto.ID `json:"parent_id,omitempty"`
+	RootID        dispatchproto.ID `json:"root_id"`
+	CorrelationID uint64           `json:"correlation_id,omitempty"`
+	Function      string           `json:"function"`
+	Created       time.Time        `json:"created"`
+	Expires       time.Time        `json:"expires,omitempty"`
+	Waiting       bool             `json:"waiting,omitempty"`
+	Request       []byte           `json:"request,omitempty"`
+	Due           time.Time        `json:"due,omitempty"`
+	Attempts      int              `json:"attempts,omitempty"`
+	Poll          []byte           `json:"poll,omitempty"`
+	MinResults    int              `json:"min_results,omitempty"`
+	MaxResults    int              `json:"max_results,omitempty"`
+	Deadline      time.Time        `json:"deadline,omitempty"`
+}
+
+// save writes the queue to the file it's persisted to, if any.
+func (s *LocalScheduler) save() {
+	if s.path == "" {
+		return
+	}
+	if err := s.write(); err != nil {
+		s.client.log().Warn("cannot persist Dispatch queue", "path", s.path, "error", err)
+	}
+}
+
+func (s *LocalScheduler) write() error {
+	snapshots := make([]taskSnapshot, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		snapshot := taskSnapshot{
+			ID:            t.id,
+			ParentID:      t.parent,
+			RootID:        t.root,
+			CorrelationID: t.correlationID,
+			Function:      t.function,
+			Created:       t.created,
+			Expires:       t.expires,
+			Waiting:       t.status == waiting,
+			Due:           t.due,
+			Attempts:      t.attempts,
+			MinResults:    t.minResults,
+			MaxResults:    t.maxResults,
+			Deadline:      t.deadline,
+		}
+		var err error
+		if t.status != waiting {
+			if snapshot.Request, err = proto.Marshal(requestProto(t.req)); err != nil {
+				return err
+			}
+		}
+		if t.status == waiting || len(t.results) > 0 {
+			poll := t.request(dispatchproto.NewPollResult(
+				dispatchproto.CoroutineState(t.state),
+				dispatchproto.CallResults(t.results...)))
+			if snapshot.Poll, err = proto.Marshal(requestProto(poll)); err != nil {
+				return err
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	b, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that the queue is never left
+	// truncated.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *LocalScheduler) load() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var snapshots []taskSnapshot
+	if err := json.Unmarshal(b, &snapshots); err != nil {
+		return err
+	}
+	for _, snapshot := range snapshots {
+		t := &task{
+			id:            snapshot.ID,
+			parent:        snapshot.ParentID,
+			root:          snapshot.RootID,
+			correlationID: snapshot.CorrelationID,
+			function:      snapshot.Function,
+			created:       snapshot.Created,
+			expires:       snapshot.Expires,
+			due:           snapshot.Due,
+			attempts:      snapshot.Attempts,
+			minResults:    snapshot.MinResults,
+			maxResults:    snapshot.MaxResults,
+			deadline:      snapshot.Deadline,
+		}
+		if snapshot.Waiting {
+			t.status = waiting
+		}
+		if snapshot.Request != nil {
+			req, err := unmarshalRequest(snapshot.Request)
+			if err != nil {
+				return fmt.Errorf("invalid request of call %s: %w", t.id, err)
+			}
+			t.req = req
+		}
+		if snapshot.Poll != nil {
+			req, err := unmarshalRequest(snapshot.Poll)
+			if err != nil {
+				return fmt.Errorf("invalid poll state of call %s: %w", t.id, err)
+			}
+			pollResult, _ := req.PollResult()
+			t.state, t.results = pollResult.CoroutineState(), pollResult.Results()
+		}
+		s.tasks[t.id] = t
+	}
+	for _, t := range s.tasks {
+		if parent, ok := s.tasks[t.parent]; ok {
+			parent.children++
+		}
+	}
+	return nil
+}
+
+func unmarshalRequest(b []byte) (dispatchproto.Request, error) {
+	var req sdkv1.RunRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return dispatchproto.Request{}, err
+	}
+	return newProtoRequest(&req), nil
+}
+
+//go:linkname newProtoRequest github.com/dispatchrun/dispatch-go/dispatchproto.newProtoRequest
+func newProtoRequest(r *sdkv1.RunRequest) dispatchproto.Request
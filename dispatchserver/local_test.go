@@ -0,0 +1,199 @@
+package dispatchserver_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestLocalScheduler(t *testing.T) {
+	var attempts atomic.Int64
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		if attempts.Add(1) == 1 {
+			return 0, fmt.Errorf("%w: try again", dispatch.ErrTemporary)
+		}
+		return n * 2, nil
+	})
+	sum := dispatch.Func("sum", func(ctx context.Context, inputs []int) (int, error) {
+		outputs, err := double.Gather(inputs)
+		if err != nil {
+			return 0, err
+		}
+		var total int
+		for _, n := range outputs {
+			total += n
+		}
+		return total, nil
+	})
+
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{double, sum},
+		dispatchserver.RetryBackoff(time.Millisecond, time.Millisecond))
+
+	server := dispatchtest.NewServer(scheduler)
+	defer server.Close()
+	client, err := dispatchclient.New(dispatchclient.APIKey("foobar"), dispatchclient.APIUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	call, err := sum.BuildCall([]int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := client.Dispatch(ctx, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := scheduler.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 12)
+
+	// The call that failed with a temporary error was retried.
+	if n := attempts.Load(); n != 4 {
+		t.Errorf("unexpected number of attempts: got %d, want 4", n)
+	}
+}
+
+func TestLocalSchedulerFailures(t *testing.T) {
+	fail := dispatch.Func("fail", func(ctx context.Context, n int) (int, error) {
+		return 0, fmt.Errorf("%w: unavailable", dispatch.ErrTemporary)
+	})
+	block := dispatch.Func("block", func(ctx context.Context, n int) (int, error) {
+		return fail.Await(n)
+	})
+
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{fail, block},
+		dispatchserver.MaxAttempts(3),
+		dispatchserver.RetryBackoff(time.Millisecond, time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Calls that expire before they run time out.
+	expired, err := fail.BuildCall(1, dispatchproto.Expiration(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing, err := block.BuildCall(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := scheduler.Handle(ctx, nil, []dispatchproto.Call{expired, failing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	go scheduler.Run(ctx)
+
+	res, err := scheduler.Wait(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.TimeoutStatus, "call expired")
+
+	// Calls fail after the maximum number of attempts, and the error is
+	// delivered to the caller, which fails permanently.
+	res, err = scheduler.Wait(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.PermanentErrorStatus, "unavailable")
+}
+
+func TestLocalSchedulerPersistQueue(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{double}, dispatchserver.PersistQueue(path))
+	call, err := double.BuildCall(21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := scheduler.Handle(context.Background(), nil, []dispatchproto.Call{call})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The call is restored by a scheduler using the same queue.
+	scheduler = newLocalScheduler(t, []dispatch.AnyFunction{double}, dispatchserver.PersistQueue(path))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	res, err := scheduler.Wait(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertExit(t, res, 42)
+}
+
+func TestLocalSchedulerCancel(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	wait := dispatch.Func("wait", func(ctx context.Context, n int) (int, error) {
+		return double.Await(n)
+	})
+
+	scheduler := newLocalScheduler(t, []dispatch.AnyFunction{double, wait})
+	call, err := wait.BuildCall(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := scheduler.Handle(ctx, nil, []dispatchproto.Call{call})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Cancel(ctx, nil, ids); err != nil {
+		t.Fatal(err)
+	}
+	res, err := scheduler.Wait(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchtest.AssertError(t, res, dispatchproto.PermanentErrorStatus, "call cancelled")
+}
+
+func newLocalScheduler(t *testing.T, functions []dispatch.AnyFunction, opts ...dispatchserver.LocalSchedulerOption) *dispatchserver.LocalScheduler {
+	t.Helper()
+
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	for _, fn := range functions {
+		endpoint.Register(fn)
+	}
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err := dispatchserver.NewLocalScheduler(client, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scheduler
+}
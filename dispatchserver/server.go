@@ -10,8 +10,8 @@ import (
 	"buf.build/gen/go/stealthrocket/dispatch-proto/connectrpc/go/dispatch/sdk/v1/sdkv1connect"
 	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
 	"connectrpc.com/connect"
-	"connectrpc.com/validate"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/internal/validator"
 )
 
 // Handler handles requests to a Dispatch API server.
@@ -31,12 +31,16 @@ func (h handlerFunc) Handle(ctx context.Context, header http.Header, calls []dis
 }
 
 // New creates a Server.
+//
+// Requests are validated against the schema of the Dispatch API before
+// they reach the handler. Options can authenticate the requests (see
+// Authenticate and APIKeys).
 func New(handler Handler, opts ...connect.HandlerOption) (*Server, error) {
-	validator, err := validate.NewInterceptor()
+	validators, err := validator.Interceptors()
 	if err != nil {
 		return nil, err
 	}
-	opts = append(opts, connect.WithInterceptors(validator))
+	opts = append(opts, connect.WithInterceptors(validators...))
 	grpcHandler := &dispatchServiceHandler{handler}
 	path, httpHandler := sdkv1connect.NewDispatchServiceHandler(grpcHandler, opts...)
 	return &Server{
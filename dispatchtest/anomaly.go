@@ -0,0 +1,104 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/google/go-cmp/cmp"
+)
+
+// Anomaly is an anomaly that can occur when running functions, due to
+// the at-least-once execution guarantees of Dispatch.
+type Anomaly int
+
+const (
+	// DuplicateResults delivers each call result twice.
+	DuplicateResults Anomaly = iota
+
+	// StaleResults delivers the call results of the previous poll
+	// (e.g. from a previous Await) again along with the results of the
+	// current poll, as well as a result with a correlation ID that
+	// doesn't match any call.
+	StaleResults
+
+	// ReplayedRequests runs each call twice, as if the response to the
+	// first request had been lost. The first response is discarded.
+	// Functions with side effects must make them idempotent.
+	ReplayedRequests
+)
+
+// Anomalies is the list of anomalies that can be injected by a Runner.
+var Anomalies = []Anomaly{DuplicateResults, StaleResults, ReplayedRequests}
+
+func (a Anomaly) String() string {
+	switch a {
+	case DuplicateResults:
+		return "DuplicateResults"
+	case StaleResults:
+		return "StaleResults"
+	case ReplayedRequests:
+		return "ReplayedRequests"
+	default:
+		return "Anomaly(" + strconv.Itoa(int(a)) + ")"
+	}
+}
+
+// WithAnomalies returns a Runner that runs the same functions, and
+// injects the specified anomalies when running them (and the calls
+// they make).
+//
+// Requests that carry poll results are not replayed, since suspended
+// coroutines cannot be resumed twice in volatile mode.
+func (r *Runner) WithAnomalies(anomalies ...Anomaly) *Runner {
+	runner := *r
+	runner.anomalies = anomalies
+	return &runner
+}
+
+func (r *Runner) injects(anomaly Anomaly) bool {
+	return slices.Contains(r.anomalies, anomaly)
+}
+
+func (r *Runner) deliver(results, previous []dispatchproto.CallResult) []dispatchproto.CallResult {
+	delivered := results
+	if r.injects(DuplicateResults) {
+		delivered = append(slices.Clip(delivered), results...)
+	}
+	if r.injects(StaleResults) {
+		delivered = append(slices.Clip(delivered), previous...)
+		delivered = append(delivered, dispatchproto.NewCallResult(
+			dispatchproto.String("stale"),
+			dispatchproto.CorrelationID(r.randomUint64())))
+	}
+	return delivered
+}
+
+// AssertIdempotent calls a function using the specified Runner, first
+// normally and then injecting each of the Anomalies, and fails the
+// test if the output, status or error of the function differs.
+//
+// Side effects that aren't reflected in the output of the function
+// (e.g. writes to a database) should be checked separately, e.g. by
+// running the function with a Runner returned by WithAnomalies.
+func AssertIdempotent[I, O any](t testing.TB, runner *Runner, fn *dispatch.Function[I, O], input I) {
+	t.Helper()
+
+	want, err := run(runner, fn, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, anomaly := range Anomalies {
+		got, err := run(runner.WithAnomalies(anomaly), fn, input)
+		if err != nil {
+			t.Fatalf("%s: %v", anomaly, err)
+		}
+		if diff := cmp.Diff(want, got, compareOptions...); diff != "" {
+			t.Errorf("%s: function %s is not idempotent (-want +got):\n%s", anomaly, fn.Name(), diff)
+		}
+	}
+}
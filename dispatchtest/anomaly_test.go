@@ -0,0 +1,81 @@
+package dispatchtest_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestAssertIdempotent(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	quadruple := dispatch.Func("quadruple", func(ctx context.Context, n int) (int, error) {
+		n, err := double.Await(n)
+		if err != nil {
+			return 0, err
+		}
+		return double.Await(n)
+	})
+
+	// A naive implementation of await, which assumes that the poll
+	// result only carries the result of the call.
+	naive := dispatch.Func("naive", func(ctx context.Context, n int) (int, error) {
+		call, err := double.BuildCall(n)
+		if err != nil {
+			return 0, err
+		}
+		call = call.With(dispatchproto.CorrelationID(1))
+		poll := dispatchproto.NewPoll(1, 1, time.Minute, dispatchproto.Calls(call))
+		req := dispatchcoro.Yield(dispatchproto.NewResponse(poll))
+		result, _ := req.PollResult()
+		if results := result.Results(); len(results) != 1 {
+			return 0, fmt.Errorf("expected 1 result, got %d", len(results))
+		}
+		var output int
+		out, _ := result.Results()[0].Output()
+		err = out.Unmarshal(&output)
+		return output, err
+	})
+
+	runner := dispatchtest.NewRunner(double, quadruple, naive)
+
+	dispatchtest.AssertIdempotent(t, runner, quadruple, 3)
+
+	rt := &recordingT{TB: t}
+	dispatchtest.AssertIdempotent(rt, runner, naive, 3)
+	if len(rt.errors) != 2 {
+		t.Errorf("expected 2 errors, got %q", rt.errors)
+	}
+}
+
+func TestReplayedRequests(t *testing.T) {
+	var count int
+	counter := dispatch.Func("counter", func(ctx context.Context, n int) (int, error) {
+		count++
+		return n, nil
+	})
+
+	runner := dispatchtest.NewRunner(counter).WithAnomalies(dispatchtest.ReplayedRequests)
+	if _, err := dispatchtest.Call(runner, counter, 1); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected the function to run twice, got %d", count)
+	}
+}
+
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
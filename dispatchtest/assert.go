@@ -0,0 +1,114 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/google/go-cmp/cmp"
+)
+
+// AssertExit asserts that a response is an exit with an output that
+// equals want. The output is unmarshaled into a value of type O before
+// being compared.
+func AssertExit[O any](t testing.TB, res dispatchproto.Response, want O) {
+	t.Helper()
+
+	exit, ok := res.Exit()
+	if !ok {
+		t.Fatalf("expected exit response, got %s", res)
+	}
+	if err, ok := exit.Error(); ok {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got O
+	if boxedOutput, ok := exit.Output(); !ok {
+		t.Fatalf("expected exit output, got %s", exit)
+	} else if err := boxedOutput.Unmarshal(&got); err != nil {
+		t.Fatalf("failed to unmarshal output: %v", err)
+	}
+	if diff := cmp.Diff(want, got, compareOptions...); diff != "" {
+		t.Errorf("unexpected output (-want +got):\n%s", diff)
+	}
+}
+
+// AssertError asserts that a response is an exit with an error, that
+// the response has the specified status, and that the error message
+// contains the specified string.
+func AssertError(t testing.TB, res dispatchproto.Response, wantStatus dispatchproto.Status, msgContains string) {
+	t.Helper()
+
+	exit, ok := res.Exit()
+	if !ok {
+		t.Fatalf("expected exit response, got %s", res)
+	}
+	err, ok := exit.Error()
+	if !ok {
+		t.Fatalf("expected exit error, got %s", exit)
+	}
+	if status := res.Status(); status != wantStatus {
+		t.Errorf("unexpected status: got %s, want %s", status, wantStatus)
+	}
+	if !strings.Contains(err.Message(), msgContains) {
+		t.Errorf("unexpected error message: got %q, want it to contain %q", err.Message(), msgContains)
+	}
+}
+
+// CallMatcher matches a call (see AssertPollCalls). It returns an error
+// describing the mismatch if the call doesn't match.
+type CallMatcher func(dispatchproto.Call) error
+
+// MatchFunction matches calls to the specified function.
+func MatchFunction(function string) CallMatcher {
+	return func(call dispatchproto.Call) error {
+		if call.Function() != function {
+			return fmt.Errorf("got call to function %q, want %q", call.Function(), function)
+		}
+		return nil
+	}
+}
+
+// MatchCall matches calls to the specified function with the specified
+// input. The input of calls is unmarshaled into a value of type I before
+// being compared.
+func MatchCall[I any](function string, input I) CallMatcher {
+	matchFunction := MatchFunction(function)
+	return func(call dispatchproto.Call) error {
+		if err := matchFunction(call); err != nil {
+			return err
+		}
+		var got I
+		if err := call.Input().Unmarshal(&got); err != nil {
+			return fmt.Errorf("failed to unmarshal input: %w", err)
+		}
+		if diff := cmp.Diff(input, got, compareOptions...); diff != "" {
+			return fmt.Errorf("unexpected input (-want +got):\n%s", diff)
+		}
+		return nil
+	}
+}
+
+// AssertPollCalls asserts that a response is a poll, and that its calls
+// match the matchers, in order. It returns the poll, so that tests can
+// deliver results of the calls (see dispatchproto.Poll.Result).
+func AssertPollCalls(t testing.TB, res dispatchproto.Response, matchers ...CallMatcher) dispatchproto.Poll {
+	t.Helper()
+
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != len(matchers) {
+		t.Fatalf("unexpected number of poll calls: got %d, want %d", len(calls), len(matchers))
+	}
+	for i, call := range calls {
+		if err := matchers[i](call); err != nil {
+			t.Errorf("call %d: %v", i, err)
+		}
+	}
+	return poll
+}
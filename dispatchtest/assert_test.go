@@ -0,0 +1,98 @@
+package dispatchtest_test
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func (t *recordingT) Fatalf(format string, args ...any) {
+	t.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// failures runs an assertion, and returns the failures it reported.
+func failures(parent *testing.T, assert func(testing.TB)) []string {
+	t := &recordingT{TB: parent}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert(t)
+	}()
+	<-done
+	return t.errors
+}
+
+func TestAssertExit(t *testing.T) {
+	res := dispatchproto.NewResponse(dispatchproto.String("ok"))
+
+	if f := failures(t, func(t testing.TB) { dispatchtest.AssertExit(t, res, "ok") }); len(f) != 0 {
+		t.Errorf("unexpected failures: %v", f)
+	}
+	if f := failures(t, func(t testing.TB) { dispatchtest.AssertExit(t, res, "ko") }); len(f) != 1 {
+		t.Errorf("expected a failure, got %v", f)
+	}
+
+	errRes := dispatchproto.NewResponseError(errors.New("oops"))
+	if f := failures(t, func(t testing.TB) { dispatchtest.AssertExit(t, errRes, "ok") }); len(f) != 1 {
+		t.Errorf("expected a failure, got %v", f)
+	}
+}
+
+func TestAssertError(t *testing.T) {
+	res := dispatchproto.NewResponseErrorf("%w: invalid input", dispatchproto.StatusError(dispatchproto.InvalidArgumentStatus))
+
+	if f := failures(t, func(t testing.TB) {
+		dispatchtest.AssertError(t, res, dispatchproto.InvalidArgumentStatus, "invalid input")
+	}); len(f) != 0 {
+		t.Errorf("unexpected failures: %v", f)
+	}
+	if f := failures(t, func(t testing.TB) {
+		dispatchtest.AssertError(t, res, dispatchproto.TemporaryErrorStatus, "unavailable")
+	}); len(f) != 2 {
+		t.Errorf("expected two failures, got %v", f)
+	}
+
+	okRes := dispatchproto.NewResponse(dispatchproto.String("ok"))
+	if f := failures(t, func(t testing.TB) {
+		dispatchtest.AssertError(t, okRes, dispatchproto.InvalidArgumentStatus, "")
+	}); len(f) != 1 {
+		t.Errorf("expected a failure, got %v", f)
+	}
+}
+
+func TestAssertPollCalls(t *testing.T) {
+	res := dispatchproto.NewResponse(dispatchproto.NewPoll(1, 2, time.Minute, dispatchproto.Calls(
+		dispatchproto.NewCall("http://example.com", "a", dispatchproto.Int(1)),
+		dispatchproto.NewCall("http://example.com", "b", dispatchproto.Int(2)),
+	)))
+
+	if f := failures(t, func(t testing.TB) {
+		poll := dispatchtest.AssertPollCalls(t, res,
+			dispatchtest.MatchCall("a", 1),
+			dispatchtest.MatchFunction("b"),
+		)
+		if poll.MaxResults() != 2 {
+			t.Errorf("unexpected poll: %s", poll)
+		}
+	}); len(f) != 0 {
+		t.Errorf("unexpected failures: %v", f)
+	}
+	if f := failures(t, func(t testing.TB) {
+		dispatchtest.AssertPollCalls(t, res,
+			dispatchtest.MatchCall("a", 2),
+			dispatchtest.MatchFunction("c"),
+		)
+	}); len(f) != 2 {
+		t.Errorf("expected two failures, got %v", f)
+	}
+	if f := failures(t, func(t testing.TB) {
+		dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchFunction("a"))
+	}); len(f) != 1 {
+		t.Errorf("expected a failure, got %v", f)
+	}
+}
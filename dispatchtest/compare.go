@@ -0,0 +1,119 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// Outcome is the outcome of a function call.
+type Outcome[O any] struct {
+	// Output is the output of the function, if any.
+	Output O
+
+	// Status is the status of the response.
+	Status dispatchproto.Status
+
+	// Error is the error returned by the function, if any.
+	Error string
+}
+
+// Divergence is a difference between the outcomes of two functions
+// called with the same input (see CompareFunctions).
+type Divergence[I, O any] struct {
+	// Index is the index of the input.
+	Index int
+
+	// Input is the input the functions were called with.
+	Input I
+
+	// A and B are the outcomes of the two functions.
+	A, B Outcome[O]
+
+	// Diff is a human-readable report of the differences between the
+	// outcomes, in the format of github.com/google/go-cmp/cmp.Diff
+	// (i.e. -A +B).
+	Diff string
+}
+
+func (d Divergence[I, O]) String() string {
+	return fmt.Sprintf("input %d (%v):\n%s", d.Index, d.Input, d.Diff)
+}
+
+// CompareFunctions calls two functions using the specified Runner with
+// each of the inputs, and returns the divergences between the outcomes
+// of the two functions. Outputs, statuses and error messages are
+// compared.
+//
+// It's useful to check that a new implementation of a function behaves
+// like the one it replaces. Both functions must be registered on the
+// runner, along with the functions they call.
+func CompareFunctions[I, O any](runner *Runner, a, b *dispatch.Function[I, O], inputs []I) ([]Divergence[I, O], error) {
+	var divergences []Divergence[I, O]
+	for i, input := range inputs {
+		outcomeA, err := run(runner, a, input)
+		if err != nil {
+			return divergences, fmt.Errorf("input %d: %w", i, err)
+		}
+		outcomeB, err := run(runner, b, input)
+		if err != nil {
+			return divergences, fmt.Errorf("input %d: %w", i, err)
+		}
+		if diff := cmp.Diff(outcomeA, outcomeB, compareOptions...); diff != "" {
+			divergences = append(divergences, Divergence[I, O]{
+				Index: i,
+				Input: input,
+				A:     outcomeA,
+				B:     outcomeB,
+				Diff:  diff,
+			})
+		}
+	}
+	return divergences, nil
+}
+
+// FormatDivergences formats divergences returned by CompareFunctions
+// as a report, e.g. for use with testing.T.Error.
+func FormatDivergences[I, O any](divergences []Divergence[I, O]) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d divergence(s):", len(divergences))
+	for _, d := range divergences {
+		b.WriteString("\n")
+		b.WriteString(d.String())
+	}
+	return b.String()
+}
+
+var compareOptions = []cmp.Option{
+	protocmp.Transform(),
+	cmp.Exporter(func(reflect.Type) bool { return true }),
+}
+
+func run[I, O any](runner *Runner, fn *dispatch.Function[I, O], input I) (Outcome[O], error) {
+	var outcome Outcome[O]
+	call, err := fn.BuildCall(input)
+	if err != nil {
+		return outcome, err
+	}
+
+	res := runner.Run(call.Request())
+	outcome.Status = res.Status()
+	if result, ok := res.Result(); ok {
+		if resultErr, ok := result.Error(); ok {
+			outcome.Error = resultErr.Error()
+		}
+	}
+	if boxedOutput, ok := res.Output(); ok {
+		if err := boxedOutput.Unmarshal(&outcome.Output); err != nil {
+			return outcome, fmt.Errorf("failed to unmarshal output of %s: %w", fn.Name(), err)
+		}
+	}
+	return outcome, nil
+}
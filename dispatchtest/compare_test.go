@@ -0,0 +1,51 @@
+package dispatchtest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestCompareFunctions(t *testing.T) {
+	a := dispatch.Func("a", func(ctx context.Context, n int) (int, error) {
+		if n < 0 {
+			return 0, errors.New("negative input")
+		}
+		return n * 2, nil
+	})
+	b := dispatch.Func("b", func(ctx context.Context, n int) (int, error) {
+		if n == 3 {
+			return 7, nil
+		}
+		return n + n, nil
+	})
+
+	runner := dispatchtest.NewRunner(a, b)
+
+	divergences, err := dispatchtest.CompareFunctions(runner, a, b, []int{1, 2, 3, -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(divergences) != 2 {
+		t.Fatalf("expected 2 divergences, got %s", dispatchtest.FormatDivergences(divergences))
+	}
+
+	output := divergences[0]
+	if output.Index != 2 || output.A.Output != 6 || output.B.Output != 7 {
+		t.Errorf("unexpected divergence: %+v", output)
+	}
+
+	status := divergences[1]
+	if status.Index != 3 || status.A.Status == dispatchproto.OKStatus || status.B.Status != dispatchproto.OKStatus {
+		t.Errorf("unexpected divergence: %+v", status)
+	} else if !strings.Contains(status.A.Error, "negative input") {
+		t.Errorf("unexpected error: %q", status.A.Error)
+	} else if !strings.Contains(status.Diff, "Status") {
+		t.Errorf("unexpected diff: %s", status.Diff)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/internal/calltree"
+)
+
+// WithMaxDepth returns a Runner that runs the same functions, and
+// limits the depth of the trees of calls they make, like an endpoint
+// configured with dispatch.MaxCallDepth.
+//
+// The Runner assigns dispatch IDs to the calls it runs, and sets the
+// parent and root dispatch IDs of nested calls.
+func (r *Runner) WithMaxDepth(depth int) *Runner {
+	runner := *r
+	runner.maxDepth = depth
+	runner.calls = new(calltree.Tracker)
+	return &runner
+}
+
+func newDispatchID() dispatchproto.ID {
+	var b [16]byte
+	rand.Read(b[:])
+	return dispatchproto.ID(hex.EncodeToString(b[:]))
+}
@@ -0,0 +1,36 @@
+package dispatchtest_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestRunnerWithMaxDepth(t *testing.T) {
+	var countdown *dispatch.Function[int, int]
+	countdown = dispatch.Func("countdown", func(ctx context.Context, n int) (int, error) {
+		if n == 0 {
+			return 0, nil
+		}
+		return countdown.Await(n - 1)
+	})
+
+	runner := dispatchtest.NewRunner(countdown).WithMaxDepth(3)
+
+	// countdown(2) -> countdown(1) -> countdown(0)
+	if _, err := dispatchtest.Call(runner, countdown, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := dispatchtest.Call(runner, countdown, 5)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := "maximum call depth of 3 exceeded by recursive calls to countdown: countdown -> countdown -> countdown -> countdown"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// CallEnqueued is emitted when a call is submitted to the Runner,
+	// either directly or by a function that awaits its result.
+	CallEnqueued EventType = iota
+
+	// CallStarted is emitted when the Runner starts running a call.
+	CallStarted
+
+	// CallRetried is emitted when the Runner runs a call again, which
+	// happens when the ReplayedRequests anomaly is injected.
+	CallRetried
+
+	// CallCompleted is emitted when a call returns, exits or fails.
+	CallCompleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case CallEnqueued:
+		return "CallEnqueued"
+	case CallStarted:
+		return "CallStarted"
+	case CallRetried:
+		return "CallRetried"
+	case CallCompleted:
+		return "CallCompleted"
+	default:
+		return "EventType(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// Event is an event emitted by a Runner when it schedules a call (see
+// WithEvents).
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Function is the name of the function that was called.
+	Function string
+
+	// DispatchID identifies the call. ParentID and RootID identify the
+	// call that made it, and the root of its tree of calls, if any.
+	DispatchID dispatchproto.ID
+	ParentID   dispatchproto.ID
+	RootID     dispatchproto.ID
+
+	// Status is the status of the response of CallCompleted events.
+	Status dispatchproto.Status
+}
+
+// WithEvents returns a Runner that runs the same functions, and sends
+// an Event to the channel each time it schedules a call, so that tests
+// and load testing tools can observe calls as they progress.
+//
+// Events of nested calls are sent concurrently, since nested calls run
+// concurrently. The Runner blocks until events are received, so the
+// channel must be drained (or buffered) while functions run.
+//
+// The Runner assigns dispatch IDs to the calls it runs, and sets the
+// parent and root dispatch IDs of nested calls.
+func (r *Runner) WithEvents(events chan<- Event) *Runner {
+	runner := *r
+	runner.events = &eventStream{events}
+	return &runner
+}
+
+// eventStream holds the channel events are sent to. Runners may be
+// captured in the state of durable coroutines, which can't serialize
+// channels, so the channel is held behind a pointer that is nil unless
+// events are enabled.
+type eventStream struct {
+	ch chan<- Event
+}
+
+func (r *Runner) emit(typ EventType, req dispatchproto.Request, res *dispatchproto.Response) {
+	if r.events == nil {
+		return
+	}
+	event := Event{
+		Type:       typ,
+		Time:       r.now(),
+		Function:   req.Function(),
+		DispatchID: req.DispatchID(),
+		ParentID:   req.ParentID(),
+		RootID:     req.RootID(),
+	}
+	if res != nil {
+		event.Status = res.Status()
+	}
+	r.events.ch <- event
+}
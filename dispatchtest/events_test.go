@@ -0,0 +1,84 @@
+package dispatchtest_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestRunnerWithEvents(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		return double.Await(n)
+	})
+
+	events := make(chan dispatchtest.Event, 100)
+	runner := dispatchtest.NewRunner(double, workflow).WithEvents(events)
+
+	if _, err := dispatchtest.Call(runner, workflow, 2); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+
+	type event struct {
+		typ      dispatchtest.EventType
+		function string
+	}
+	var got []event
+	ids := map[string]dispatchproto.ID{}
+	for e := range events {
+		got = append(got, event{e.Type, e.Function})
+		if e.DispatchID == "" {
+			t.Errorf("event without a dispatch ID: %+v", e)
+		}
+		ids[e.Function] = e.DispatchID
+		if e.Function == "double" && (e.ParentID != ids["workflow"] || e.RootID != ids["workflow"]) {
+			t.Errorf("unexpected parent of nested call: %+v", e)
+		}
+		if e.Type == dispatchtest.CallCompleted && e.Status != dispatchproto.OKStatus {
+			t.Errorf("unexpected status: %+v", e)
+		}
+	}
+	want := []event{
+		{dispatchtest.CallEnqueued, "workflow"},
+		{dispatchtest.CallStarted, "workflow"},
+		{dispatchtest.CallEnqueued, "double"},
+		{dispatchtest.CallStarted, "double"},
+		{dispatchtest.CallCompleted, "double"},
+		{dispatchtest.CallCompleted, "workflow"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected events:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestRunnerWithEventsRetried(t *testing.T) {
+	events := make(chan dispatchtest.Event, 100)
+	runner := dispatchtest.NewRunner(dispatch.Identity("identity")).
+		WithAnomalies(dispatchtest.ReplayedRequests).
+		WithEvents(events)
+
+	runner.Run(dispatchproto.NewRequest("identity", dispatchproto.Int(1)))
+	close(events)
+
+	var got []dispatchtest.EventType
+	for e := range events {
+		got = append(got, e.Type)
+	}
+	want := []dispatchtest.EventType{
+		dispatchtest.CallEnqueued,
+		dispatchtest.CallStarted,
+		dispatchtest.CallRetried,
+		dispatchtest.CallStarted,
+		dispatchtest.CallCompleted,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected events: got %v, want %v", got, want)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"strconv"
 	"strings"
 
 	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
 	"github.com/dispatchrun/dispatch-go/dispatchtest"
 )
 
@@ -29,6 +31,17 @@ func run() error {
 	})
 
 	doubleAndRepeat := dispatch.Func("double-repeat", func(ctx context.Context, n int) (string, error) {
+		// Local work can run in parallel between yields.
+		var squares [2]int
+		if err := dispatchcoro.Parallel(ctx, 2,
+			func(context.Context) error { squares[0] = n * n; return nil },
+			func(context.Context) error { squares[1] = n * n; return nil },
+		); err != nil {
+			return "", err
+		} else if squares[0] != squares[1] {
+			return "", fmt.Errorf("unexpected squares: %v", squares)
+		}
+
 		doubled, err := double.Await(n)
 		if err != nil {
 			return "", err
@@ -37,10 +50,33 @@ func run() error {
 		if err != nil {
 			return "", err
 		}
+		// Secrets are resolved on the host the coroutine resumes on.
+		if secret, ok := dispatch.Secret(ctx, "INTEGRATION_SECRET"); !ok || secret != "xyzzy" {
+			return "", fmt.Errorf("unexpected secret: %q", secret)
+		}
 		return strings.Repeat(stringified, doubled), nil
+	}).WithSecrets("INTEGRATION_SECRET")
+
+	// Tail calls continue with fresh state after the coroutine yields.
+	var countdown *dispatch.Function[int, string]
+	countdown = dispatch.Func("countdown", func(ctx context.Context, n int) (string, error) {
+		if n == 0 {
+			return "liftoff", nil
+		}
+		doubled, err := double.Await(n)
+		if err != nil {
+			return "", err
+		}
+		return "", countdown.TailCall(doubled/2 - 1)
 	})
 
-	runner := dispatchtest.NewRunner(stringify, double, doubleAndRepeat)
+	os.Setenv("INTEGRATION_SECRET", "xyzzy")
+
+	functions := []dispatch.AnyFunction{stringify, double, doubleAndRepeat, countdown}
+	if isChild() {
+		return serveRequest(functions...)
+	}
+	runner := newRunner(functions...)
 
 	output, err := dispatchtest.Call(runner, doubleAndRepeat, 4)
 	if err != nil {
@@ -49,6 +85,17 @@ func run() error {
 	if output != "88888888" {
 		return fmt.Errorf("unexpected output: %q", output)
 	}
+
+	output, err = dispatchtest.Call(runner, countdown, 3)
+	if err != nil {
+		return err
+	}
+	if output != "liftoff" {
+		return fmt.Errorf("unexpected output: %q", output)
+	}
 	fmt.Println("OK")
+	if n := processes.Load(); n > 0 {
+		fmt.Printf("%d requests ran in separate processes\n", n)
+	}
 	return nil
 }
@@ -0,0 +1,119 @@
+//go:build !durable
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	_ "unsafe"
+
+	sdkv1 "buf.build/gen/go/stealthrocket/dispatch-proto/protocolbuffers/go/dispatch/sdk/v1"
+	"github.com/dispatchrun/coroutine"
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+	"google.golang.org/protobuf/proto"
+)
+
+// childEnv is set in the environment of the processes that run requests.
+const childEnv = "DISPATCH_INTEGRATION_CHILD"
+
+// processes is the number of processes that ran requests.
+var processes atomic.Int64
+
+// newRunner creates the runner of the integration test.
+//
+// In durable mode, each request runs in a new process, which exits once
+// the function returns or yields. Coroutines are thus resumed from their
+// serialized state in another process than the one they were suspended
+// in, as in durable deployments. When the binary is built with -cover,
+// every process writes its coverage counters to GOCOVERDIR, so that they
+// can be merged into a single profile (see integration-coverage in the
+// Makefile).
+func newRunner(functions ...dispatch.AnyFunction) *dispatchtest.Runner {
+	if !coroutine.Durable {
+		return dispatchtest.NewRunner(functions...)
+	}
+	runner := dispatchtest.NewRunner()
+	for _, fn := range functions {
+		name, _ := fn.Register(nil)
+		runner.RegisterPrimitive(name, runProcess)
+	}
+	return runner
+}
+
+// isChild is true in the processes that run requests.
+func isChild() bool {
+	return os.Getenv(childEnv) != ""
+}
+
+// runProcess runs a request in a new process.
+func runProcess(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+	processes.Add(1)
+
+	b, err := proto.Marshal(requestProto(req))
+	if err != nil {
+		return dispatchproto.NewResponse(dispatchproto.ErrorStatus(err), dispatchproto.NewError(err))
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return dispatchproto.NewResponse(dispatchproto.ErrorStatus(err), dispatchproto.NewError(err))
+	}
+	cmd := exec.CommandContext(ctx, executable)
+	cmd.Env = append(os.Environ(), childEnv+"=1")
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("cannot run %s in a new process: %w", req.Function(), err)
+		return dispatchproto.NewResponse(dispatchproto.ErrorStatus(err), dispatchproto.NewError(err))
+	}
+
+	var res sdkv1.RunResponse
+	if err := proto.Unmarshal(out, &res); err != nil {
+		return dispatchproto.NewResponse(dispatchproto.ErrorStatus(err), dispatchproto.NewError(err))
+	}
+	return newProtoResponse(&res)
+}
+
+// serveRequest runs the request read from stdin, and writes the
+// response to stdout.
+func serveRequest(functions ...dispatch.AnyFunction) error {
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	var r sdkv1.RunRequest
+	if err := proto.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	req := newProtoRequest(&r)
+
+	primitives := dispatchproto.FunctionMap{}
+	for _, fn := range functions {
+		name, primitive := fn.Register(nil)
+		primitives[name] = primitive
+	}
+	res := primitives.Run(context.Background(), req)
+
+	b, err = res.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(b)
+	return err
+}
+
+//go:linkname newProtoRequest github.com/dispatchrun/dispatch-go/dispatchproto.newProtoRequest
+func newProtoRequest(r *sdkv1.RunRequest) dispatchproto.Request
+
+//go:linkname requestProto github.com/dispatchrun/dispatch-go/dispatchproto.requestProto
+func requestProto(r dispatchproto.Request) *sdkv1.RunRequest
+
+//go:linkname newProtoResponse github.com/dispatchrun/dispatch-go/dispatchproto.newProtoResponse
+func newProtoResponse(r *sdkv1.RunResponse) dispatchproto.Response
@@ -0,0 +1,120 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// ReproducibleEpoch is the time that the clock of a Runner in
+// reproducible mode starts at (see Runner.WithReproducibleMode).
+var ReproducibleEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// WithReproducibleMode returns a Runner that runs the same functions,
+// reproducibly: each call to Run with the same seed produces the same
+// sequence of directives, byte for byte, e.g. for golden-file testing.
+//
+// While the Runner runs a call:
+//   - coroutines draw correlation IDs (and the IDs of volatile coroutine
+//     instances) from a source seeded with the seed (see
+//     dispatchcoro.SetRandomSource)
+//   - coroutines and events read the time from a fake clock, which
+//     starts at ReproducibleEpoch and advances by a millisecond each
+//     time it's read (see dispatchcoro.SetClock)
+//   - dispatch IDs are drawn from a source seeded with the seed
+//   - nested calls run one after the other, rather than concurrently
+//
+// The source of randomness and the clock of coroutines are global, so
+// calls run by Runners in reproducible mode are serialized. Functions
+// must themselves be deterministic for their directives to be
+// reproducible.
+func (r *Runner) WithReproducibleMode(seed uint64) *Runner {
+	runner := *r
+	runner.reproducible = &reproducibility{seed: seed}
+	return &runner
+}
+
+// reproducibility is the state of a Runner in reproducible mode. It only
+// holds plain values, since Runners may be captured in the state of
+// durable coroutines.
+type reproducibility struct {
+	seed uint64
+
+	mu  sync.Mutex
+	ids rand.PCG
+	now time.Time
+}
+
+// reproducibleRuns serializes the calls run in reproducible mode, which
+// replace the global source of randomness and clock of coroutines.
+var reproducibleRuns sync.Mutex
+
+// begin resets the state of the Runner to the seed, and installs its
+// source of randomness and clock. It returns a function that restores
+// them.
+func (p *reproducibility) begin() (end func()) {
+	reproducibleRuns.Lock()
+
+	p.mu.Lock()
+	p.ids = *rand.NewPCG(p.seed, ^p.seed)
+	p.now = ReproducibleEpoch
+	p.mu.Unlock()
+
+	restoreRandom := dispatchcoro.SetRandomSource(rand.NewPCG(p.seed, p.seed))
+	restoreClock := dispatchcoro.SetClock(p.clock)
+	return func() {
+		restoreClock()
+		restoreRandom()
+		reproducibleRuns.Unlock()
+	}
+}
+
+func (p *reproducibility) clock() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now
+	p.now = p.now.Add(time.Millisecond)
+	return now
+}
+
+func (p *reproducibility) newDispatchID() dispatchproto.ID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return dispatchproto.ID(fmt.Sprintf("%016x%016x", p.ids.Uint64(), p.ids.Uint64()))
+}
+
+func (p *reproducibility) uint64() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.ids.Uint64()
+}
+
+func (r *Runner) randomUint64() uint64 {
+	if r.reproducible != nil {
+		return r.reproducible.uint64()
+	}
+	return rand.Uint64()
+}
+
+func (r *Runner) newDispatchID() dispatchproto.ID {
+	if r.reproducible != nil {
+		return r.reproducible.newDispatchID()
+	}
+	return newDispatchID()
+}
+
+func (r *Runner) now() time.Time {
+	if r.reproducible != nil {
+		return r.reproducible.clock()
+	}
+	return time.Now()
+}
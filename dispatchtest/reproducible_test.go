@@ -0,0 +1,88 @@
+package dispatchtest_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestRunnerWithReproducibleMode(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (time.Time, error) {
+		var graph dispatchcoro.Graph
+		if _, err := double.Gather([]int{n, n + 1}); err != nil {
+			return time.Time{}, err
+		}
+		call, err := double.BuildCall(n)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if _, _, err := graph.Await(dispatchcoro.AwaitAll, nil, call); err != nil {
+			return time.Time{}, err
+		}
+		return graph.Steps()[0].Start, nil
+	})
+
+	// Record the directives of the workflow.
+	run := func(seed uint64) (directives [][]byte, events []dispatchtest.Event, output time.Time) {
+		name, primitive := workflow.Register(nil)
+		recorder := dispatchproto.FunctionMap{name: func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+			res := primitive(ctx, req)
+			b, err := res.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			directives = append(directives, b)
+			return res
+		}}
+		ch := make(chan dispatchtest.Event, 100)
+		runner := dispatchtest.NewRunner(double).WithEvents(ch).WithReproducibleMode(seed)
+		runner.RegisterPrimitive(name, recorder[name])
+
+		var err error
+		output, err = dispatchtest.Call(runner, workflow, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		close(ch)
+		for e := range ch {
+			events = append(events, e)
+		}
+		return
+	}
+
+	directives1, events1, output1 := run(1)
+	directives2, events2, output2 := run(1)
+	if len(directives1) != 3 {
+		t.Fatalf("unexpected number of directives: %d", len(directives1))
+	}
+	if !slices.EqualFunc(directives1, directives2, bytes.Equal) {
+		t.Error("directives differ between runs with the same seed")
+	}
+	if !slices.Equal(events1, events2) {
+		t.Errorf("events differ between runs with the same seed:\n%v\n%v", events1, events2)
+	}
+	if !output1.Equal(output2) || output1.Before(dispatchtest.ReproducibleEpoch) || output1.After(dispatchtest.ReproducibleEpoch.Add(time.Second)) {
+		t.Errorf("unexpected graph start times: %v, %v", output1, output2)
+	}
+	if !events1[0].Time.Equal(dispatchtest.ReproducibleEpoch) {
+		t.Errorf("unexpected time of the first event: %v", events1[0].Time)
+	}
+
+	directives3, events3, _ := run(2)
+	if slices.EqualFunc(directives1, directives3, bytes.Equal) {
+		t.Error("directives are the same with different seeds")
+	}
+	if events1[0].DispatchID == events3[0].DispatchID {
+		t.Error("dispatch IDs are the same with different seeds")
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/dispatchrun/dispatch-go"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/internal/calltree"
 )
 
 // Call calls a dispatch.Function using the specified Runner.
@@ -50,6 +52,14 @@ func Call[I, O any](runner *Runner, fn *dispatch.Function[I, O], input I) (O, er
 // Runner runs functions.
 type Runner struct {
 	functions dispatchproto.FunctionMap
+	anomalies []Anomaly
+
+	maxDepth int
+	calls    *calltree.Tracker
+
+	events *eventStream
+
+	reproducible *reproducibility
 }
 
 // NewRunner creates a Runner.
@@ -74,21 +84,74 @@ func (r *Runner) RegisterPrimitive(name string, fn dispatchproto.Function) {
 
 // Run runs a function to completion and returns its response.
 func (r *Runner) Run(req dispatchproto.Request) dispatchproto.Response {
+	if r.reproducible != nil {
+		defer r.reproducible.begin()()
+	}
+	return r.call(req)
+}
+
+func (r *Runner) call(req dispatchproto.Request) dispatchproto.Response {
+	if (r.maxDepth > 0 || r.events != nil) && req.DispatchID() == "" {
+		req = req.With(dispatchproto.DispatchID(r.newDispatchID()))
+	}
+	r.emit(CallEnqueued, req, nil)
+
+	if _, ok := req.Input(); ok && r.injects(ReplayedRequests) {
+		r.run(req) // the response is lost
+		r.emit(CallRetried, req, nil)
+	}
+	res := r.run(req)
+	r.emit(CallCompleted, req, &res)
+	return res
+}
+
+func (r *Runner) run(req dispatchproto.Request) dispatchproto.Response {
+	r.emit(CallStarted, req, nil)
+
+	if r.maxDepth > 0 {
+		chain := r.calls.Enter(req.DispatchID(), req.ParentID(), req.Function(), time.Now())
+		defer r.calls.Exit(req.DispatchID())
+		if err := calltree.Check(chain, r.maxDepth); err != nil {
+			return dispatchproto.NewResponseErrorf("%w: %v", dispatch.ErrPermanent, err)
+		}
+	}
+
+	var previous []dispatchproto.CallResult
 	for {
 		res := r.RoundTrip(req)
-		if _, ok := res.Exit(); ok {
-			return res
+		if exit, ok := res.Exit(); ok {
+			tailCall, ok := exit.TailCall()
+			if !ok {
+				return res
+			}
+			// The tail call replaces the current call.
+			req, previous = r.tailCall(req, tailCall), nil
+			continue
 		}
-		req = r.poll(req, res)
+		req, previous = r.poll(req, res, previous)
 	}
 }
 
+func (r *Runner) tailCall(req dispatchproto.Request, call dispatchproto.Call) dispatchproto.Request {
+	var opts []dispatchproto.RequestOption
+	if id := req.DispatchID(); id != "" {
+		opts = append(opts, dispatchproto.DispatchID(id))
+	}
+	if id := req.ParentID(); id != "" {
+		opts = append(opts, dispatchproto.ParentDispatchID(id))
+	}
+	if id := req.RootID(); id != "" {
+		opts = append(opts, dispatchproto.RootDispatchID(id))
+	}
+	return call.Request().With(opts...)
+}
+
 // RoundTrip sends a request to a function and returns its response.
 func (r Runner) RoundTrip(req dispatchproto.Request) dispatchproto.Response {
 	return r.functions.Run(context.Background(), req)
 }
 
-func (r *Runner) poll(req dispatchproto.Request, res dispatchproto.Response) dispatchproto.Request {
+func (r *Runner) poll(req dispatchproto.Request, res dispatchproto.Response, previous []dispatchproto.CallResult) (dispatchproto.Request, []dispatchproto.CallResult) {
 	poll, ok := res.Poll()
 	if !ok {
 		panic(fmt.Errorf("not implemented: %s", res))
@@ -98,15 +161,35 @@ func (r *Runner) poll(req dispatchproto.Request, res dispatchproto.Response) dis
 
 	// Make nested calls.
 	if calls := poll.Calls(); len(calls) > 0 {
-		callResults := gomap(calls, func(call dispatchproto.Call) dispatchproto.CallResult {
-			res := r.Run(call.Request())
+		var opts []dispatchproto.RequestOption
+		if id := req.DispatchID(); id != "" {
+			root := req.RootID()
+			if root == "" {
+				root = id
+			}
+			opts = append(opts, dispatchproto.ParentDispatchID(id), dispatchproto.RootDispatchID(root))
+		}
+		run := func(call dispatchproto.Call) dispatchproto.CallResult {
+			res := r.call(call.Request().With(opts...))
 			callResult, _ := res.Result()
 			return callResult.With(dispatchproto.CorrelationID(call.CorrelationID()))
-		})
-		result = result.With(dispatchproto.CallResults(callResults...))
+		}
+		var callResults []dispatchproto.CallResult
+		if r.reproducible != nil {
+			// Nested calls run in order, so that they draw from the
+			// source of randomness in the same order on each run.
+			callResults = make([]dispatchproto.CallResult, len(calls))
+			for i, call := range calls {
+				callResults[i] = run(call)
+			}
+		} else {
+			callResults = gomap(calls, run)
+		}
+		result = result.With(dispatchproto.CallResults(r.deliver(callResults, previous)...))
+		previous = callResults
 	}
 
-	return req.With(result)
+	return req.With(result), previous
 }
 
 // Concurrently convert []I to []O using the func(I) O mapper.
@@ -0,0 +1,37 @@
+//go:build !durable
+
+package dispatchtest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+)
+
+// Schedule is a recurring schedule, such as a *dispatchcron.Schedule.
+type Schedule interface {
+	// Next returns the first occurrence of the schedule strictly after
+	// t, or the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// CallSchedule calls a dispatch.Function using the specified Runner at
+// each occurrence of a schedule strictly after start and up to (and
+// including) end.
+//
+// Time is simulated: calls are made immediately and in order. The input
+// function is called with each occurrence to build the corresponding
+// input. The outputs of the calls are returned in order. CallSchedule
+// stops at the first call that fails.
+func CallSchedule[I, O any](runner *Runner, schedule Schedule, fn *dispatch.Function[I, O], input func(time.Time) I, start, end time.Time) ([]O, error) {
+	var outputs []O
+	for t := schedule.Next(start); !t.IsZero() && !t.After(end); t = schedule.Next(t) {
+		output, err := Call(runner, fn, input(t))
+		if err != nil {
+			return outputs, fmt.Errorf("scheduled call at %s: %w", t.Format(time.RFC3339), err)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
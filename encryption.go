@@ -0,0 +1,152 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// EncryptedStateTypeURL is the type URL of coroutine state encrypted
+// with StateEncryption.
+const EncryptedStateTypeURL = "buf.build/dispatchrun/dispatch-go/dispatch.EncryptedState"
+
+// StateEncryption encrypts the state of suspended functions with
+// AES-GCM before it's sent to Dispatch, and decrypts it when they
+// resume, so that values captured by coroutines (e.g. secrets in local
+// variables) aren't stored in cleartext. It applies to the state of
+// coroutines and to the snapshots of state machines.
+//
+// The key must be 16, 24 or 32 bytes long, to select AES-128, AES-192
+// or AES-256. State is encrypted with key, and is tagged with an ID
+// derived from the key. The previous keys are only used to decrypt
+// state, so that keys can be rotated without failing the calls that
+// are in flight: add the current key to the previous keys, set a new
+// key, and drop the previous key once calls that suspended before the
+// rotation have completed.
+//
+// State is bound to the function it belongs to, and can't be resumed
+// by another function. State that isn't encrypted, e.g. of calls that
+// suspended before encryption was enabled, is passed through as is.
+func StateEncryption(key []byte, previousKeys ...[]byte) Option {
+	return optionFunc(func(d *Dispatch) {
+		d.stateKeys = append([][]byte{key}, previousKeys...)
+	})
+}
+
+type stateKeyID [8]byte
+
+func newStateKeyID(key []byte) (id stateKeyID) {
+	sum := sha256.Sum256(key)
+	copy(id[:], sum[:])
+	return id
+}
+
+type stateCipher struct {
+	current stateKeyID
+	keys    map[stateKeyID]dispatchproto.Encoding
+}
+
+func newStateCipher(keys [][]byte) (*stateCipher, error) {
+	c := &stateCipher{keys: map[stateKeyID]dispatchproto.Encoding{}}
+	for i, key := range keys {
+		id := newStateKeyID(key)
+		encoding, err := dispatchproto.AESGCM(hex.EncodeToString(id[:]), key)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			c.current = id
+		}
+		c.keys[id] = encoding
+	}
+	return c, nil
+}
+
+// encrypt encrypts the state of a function. The encrypted state is the
+// ID of the key, followed by the state sealed with the key. The sealed
+// state is prefixed with the name of the function, which binds it to
+// the function.
+func (c *stateCipher) encrypt(function string, state dispatchproto.Any) (dispatchproto.Any, error) {
+	b, err := proto.Marshal(anyProto(state))
+	if err != nil {
+		return dispatchproto.Any{}, err
+	}
+	plaintext := binary.AppendUvarint(nil, uint64(len(function)))
+	plaintext = append(plaintext, function...)
+	plaintext = append(plaintext, b...)
+
+	sealed, err := c.keys[c.current].Encode(plaintext)
+	if err != nil {
+		return dispatchproto.Any{}, err
+	}
+	value := make([]byte, 0, len(c.current)+len(sealed))
+	value = append(value, c.current[:]...)
+	value = append(value, sealed...)
+	return newProtoAny(&anypb.Any{TypeUrl: EncryptedStateTypeURL, Value: value}), nil
+}
+
+func (c *stateCipher) decrypt(function string, state dispatchproto.Any) (dispatchproto.Any, error) {
+	if state.TypeURL() != EncryptedStateTypeURL {
+		return state, nil
+	}
+	value := anyProto(state).GetValue()
+
+	var id stateKeyID
+	if len(value) < len(id) {
+		return dispatchproto.Any{}, errors.New("state is truncated")
+	}
+	copy(id[:], value)
+	encoding, ok := c.keys[id]
+	if !ok {
+		return dispatchproto.Any{}, fmt.Errorf("state was encrypted with unknown key %x", id)
+	}
+	plaintext, err := encoding.Decode(value[len(id):])
+	if err != nil {
+		return dispatchproto.Any{}, err
+	}
+
+	n, size := binary.Uvarint(plaintext)
+	if size <= 0 || uint64(len(plaintext)-size) < n {
+		return dispatchproto.Any{}, errors.New("state is truncated")
+	}
+	if owner := string(plaintext[size : size+int(n)]); owner != function {
+		return dispatchproto.Any{}, fmt.Errorf("state belongs to function %q", owner)
+	}
+	decrypted := new(anypb.Any)
+	if err := proto.Unmarshal(plaintext[size+int(n):], decrypted); err != nil {
+		return dispatchproto.Any{}, err
+	}
+	return newProtoAny(decrypted), nil
+}
+
+func (c *stateCipher) interceptor(next dispatchproto.Function) dispatchproto.Function {
+	return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		if pollResult, ok := req.PollResult(); ok && anyProto(pollResult.CoroutineState()) != nil {
+			state, err := c.decrypt(req.Function(), pollResult.CoroutineState())
+			if err != nil {
+				return dispatchproto.NewResponseErrorf("%w: cannot decrypt state: %v", ErrIncompatibleState, err)
+			}
+			req = req.With(pollResult.With(dispatchproto.CoroutineState(state)))
+		}
+
+		res := next(ctx, req)
+
+		if poll, ok := res.Poll(); ok && anyProto(poll.CoroutineState()) != nil {
+			state, err := c.encrypt(req.Function(), poll.CoroutineState())
+			if err != nil {
+				return dispatchproto.NewResponseErrorf("%w: cannot encrypt state: %v", ErrPermanent, err)
+			}
+			res = res.With(dispatchproto.CoroutineState(state))
+		}
+		return res
+	}
+}
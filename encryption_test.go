@@ -0,0 +1,70 @@
+package dispatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestStateCipher(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 16)
+
+	oldCipher, err := newStateCipher([][]byte{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := dispatchproto.String("secret")
+	encrypted, err := oldCipher.encrypt("f", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted.TypeURL() != EncryptedStateTypeURL || bytes.Contains(encrypted.Value(), []byte("secret")) {
+		t.Fatalf("state was not encrypted: %v", encrypted)
+	}
+
+	// State encrypted with a previous key can be decrypted after the
+	// key has been rotated.
+	newCipher, err := newStateCipher([][]byte{newKey, oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := newCipher.decrypt("f", encrypted); err != nil {
+		t.Fatal(err)
+	} else if !decrypted.Equal(state) {
+		t.Errorf("unexpected state: got %v, want %v", decrypted, state)
+	}
+
+	// State is bound to its function.
+	if _, err := newCipher.decrypt("g", encrypted); err == nil {
+		t.Error("expected an error when decrypting the state of another function")
+	}
+
+	// Tampered state is rejected.
+	tampered := proto.Clone(anyProto(encrypted)).(*anypb.Any)
+	tampered.Value[len(tampered.Value)-1] ^= 1
+	if _, err := newCipher.decrypt("f", newProtoAny(tampered)); err == nil {
+		t.Error("expected an error when decrypting tampered state")
+	}
+
+	// State encrypted with a key that was dropped is rejected.
+	rotatedCipher, err := newStateCipher([][]byte{newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotatedCipher.decrypt("f", encrypted); err == nil {
+		t.Error("expected an error when decrypting state encrypted with an unknown key")
+	}
+
+	// State that isn't encrypted is passed through.
+	if decrypted, err := rotatedCipher.decrypt("f", state); err != nil || !decrypted.Equal(state) {
+		t.Errorf("unexpected state: %v (%v)", decrypted, err)
+	}
+
+	if _, err := newStateCipher([][]byte{[]byte("short")}); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
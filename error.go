@@ -2,7 +2,14 @@
 
 package dispatch
 
-import "github.com/dispatchrun/dispatch-go/dispatchproto"
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
 
 var (
 	// ErrTimeout indicates an operation failed due to a timeout.
@@ -49,3 +56,88 @@ var (
 	// ErrNotFound indicates an operation failed because a resource could not be found.
 	ErrNotFound error = dispatchproto.StatusError(dispatchproto.NotFoundStatus)
 )
+
+// WithStatus wraps an error to associate it with a Status.
+//
+// The Status takes precedence over the categorization that would
+// otherwise be derived from the error, and is preserved when the
+// returned error is wrapped again (e.g. with fmt.Errorf and the %w verb,
+// or with errors.Join), so that errors can be classified at the point
+// they occur and then annotated further as they propagate.
+//
+// The error message is unchanged, and errors.Is and errors.As see
+// through the wrapper.
+//
+// WithStatus returns nil if err is nil.
+func WithStatus(err error, status dispatchproto.Status) error {
+	if err == nil {
+		return nil
+	}
+	return &statusError{err: err, status: status}
+}
+
+// StatusOfError returns the Status associated with an error.
+//
+// If the error, or any error it wraps, was associated with a Status
+// via WithStatus (or is one of the Err* errors in this package), the
+// outermost such Status is returned. Otherwise, the Status is derived
+// from the error (see dispatchproto.ErrorStatus).
+func StatusOfError(err error) dispatchproto.Status {
+	return dispatchproto.StatusOf(err)
+}
+
+type statusError struct {
+	err    error
+	status dispatchproto.Status
+}
+
+func (e *statusError) Error() string                { return e.err.Error() }
+func (e *statusError) Unwrap() error                { return e.err }
+func (e *statusError) Status() dispatchproto.Status { return e.status }
+
+func newResponseError(err error) dispatchproto.Response {
+	var tailCall *tailCallError
+	if errors.As(err, &tailCall) {
+		return dispatchproto.NewResponse(dispatchproto.OKStatus, dispatchproto.NewExit(dispatchproto.TailCall(tailCall.call)))
+	}
+
+	status := StatusOfError(err)
+
+	// Report the type of the underlying error, rather than
+	// the type of the wrapper.
+	for {
+		se, ok := err.(*statusError)
+		if !ok {
+			break
+		}
+		err = se.err
+	}
+	return dispatchproto.NewResponse(status, dispatchproto.NewError(err))
+}
+
+// tailCallError is returned by a function to exit, and continue with
+// a tail call (see Function.TailCall).
+type tailCallError struct {
+	call dispatchproto.Call
+}
+
+func (e *tailCallError) Error() string {
+	return fmt.Sprintf("tail call to function %s", e.call.Function())
+}
+
+// recoverPanic recovers a panic in a function, and sets the response
+// to a permanent error that carries the stack trace. It must be called
+// with defer.
+func recoverPanic(logger *slog.Logger, name string, res *dispatchproto.Response) {
+	if v := recover(); v != nil {
+		*res = panicResponse(logger, name, v)
+	}
+}
+
+func panicResponse(logger *slog.Logger, name string, v any) dispatchproto.Response {
+	stack := debug.Stack()
+	message := fmt.Sprintf("function %s panicked: %v", name, v)
+	logger.Error(message, "function", name, "stack", string(stack))
+	err := dispatchproto.NewErrorMessage("panic", message, dispatchproto.Traceback(stack))
+	return dispatchproto.NewResponse(dispatchproto.PermanentErrorStatus, err)
+}
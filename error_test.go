@@ -20,6 +20,7 @@ import (
 	"connectrpc.com/connect"
 	"github.com/dispatchrun/dispatch-go"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
 )
 
 func TestErrorStatus(t *testing.T) {
@@ -917,3 +918,118 @@ MHcCAQEEIIrYSSNQFaA2Hwf1duRSxKtLYX5CB04fSeQ6tF1aY/PuoAoGCCqGSM49
 AwEHoUQDQgAEPR3tU2Fta9ktY+6P9G0cWO+0kETA6SFs38GecTyudlHz6xvCdz8q
 EKTcWGekdmdDPsHloRNtsiCa697B2O9IFA==
 -----END EC PRIVATE KEY-----`)
+
+func TestWithStatus(t *testing.T) {
+	errBase := errors.New("base")
+
+	tests := []struct {
+		scenario string
+		error    error
+		status   dispatchproto.Status
+	}{
+		{
+			scenario: "unwrapped",
+			error:    dispatch.WithStatus(errBase, dispatchproto.ThrottledStatus),
+			status:   dispatchproto.ThrottledStatus,
+		},
+
+		// Errors are typically wrapped many times as they propagate
+		// through layers of an application. The status must be
+		// preserved.
+
+		{
+			scenario: "wrapped several times with %w",
+			error: fmt.Errorf("handler: %w",
+				fmt.Errorf("service: %w",
+					fmt.Errorf("repository: %w",
+						dispatch.WithStatus(errBase, dispatchproto.ThrottledStatus)))),
+			status: dispatchproto.ThrottledStatus,
+		},
+
+		{
+			scenario: "joined with an uncategorized error",
+			error: fmt.Errorf("handler: %w", errors.Join(
+				errors.New("cleanup failed"),
+				fmt.Errorf("service: %w", dispatch.WithStatus(errBase, dispatchproto.TimeoutStatus)),
+			)),
+			status: dispatchproto.TimeoutStatus,
+		},
+
+		{
+			scenario: "wrapped with multiple %w verbs",
+			error:    fmt.Errorf("%w: %w", dispatch.WithStatus(errBase, dispatchproto.NotFoundStatus), io.EOF),
+			status:   dispatchproto.NotFoundStatus,
+		},
+
+		// The explicit status takes precedence over the categorization
+		// that would be derived from the wrapped error.
+
+		{
+			scenario: "overrides the derived status",
+			error:    fmt.Errorf("request: %w", dispatch.WithStatus(context.Canceled, dispatchproto.PermanentErrorStatus)),
+			status:   dispatchproto.PermanentErrorStatus,
+		},
+
+		{
+			scenario: "outermost status wins",
+			error: dispatch.WithStatus(
+				fmt.Errorf("retry: %w", dispatch.WithStatus(errBase, dispatchproto.TemporaryErrorStatus)),
+				dispatchproto.PermanentErrorStatus),
+			status: dispatchproto.PermanentErrorStatus,
+		},
+
+		// Errors formatted with %v (rather than %w) are not wrapped, so
+		// the status cannot be preserved. Use %w when wrapping errors.
+
+		{
+			scenario: "formatted with %v",
+			error:    fmt.Errorf("handler: %v", dispatch.WithStatus(errBase, dispatchproto.ThrottledStatus)),
+			status:   dispatchproto.PermanentErrorStatus,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			if status := dispatch.StatusOfError(test.error); status != test.status {
+				t.Errorf("unexpected status: got %s, want %s", status, test.status)
+			}
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		if err := dispatch.WithStatus(nil, dispatchproto.TimeoutStatus); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("transparent", func(t *testing.T) {
+		err := dispatch.WithStatus(errBase, dispatchproto.TimeoutStatus)
+		if err.Error() != "base" {
+			t.Errorf("unexpected error message: %q", err.Error())
+		}
+		if !errors.Is(err, errBase) {
+			t.Error("expected errors.Is to see through the wrapper")
+		}
+	})
+
+	t.Run("function", func(t *testing.T) {
+		fn := dispatch.Func("fn", func(ctx context.Context, input string) (string, error) {
+			err := dispatch.WithStatus(errors.New("rate limited"), dispatchproto.ThrottledStatus)
+			return "", fmt.Errorf("handler: %w", errors.Join(errors.New("cleanup failed"), err))
+		})
+
+		call, err := fn.BuildCall("x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := dispatchtest.NewRunner(fn).Run(call.Request())
+		if res.Status() != dispatchproto.ThrottledStatus {
+			t.Errorf("unexpected response status: %s", res.Status())
+		}
+		if resErr, ok := res.Error(); !ok {
+			t.Errorf("expected an error: %s", res)
+		} else if resErr.Type() != "wrapError" {
+			t.Errorf("unexpected error type: %s", resErr.Type())
+		}
+	})
+}
@@ -0,0 +1,97 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// FuncOf creates a Function named after the Go function it runs, e.g.
+// "github.com/example/app/billing.ChargeCard" for the ChargeCard
+// function of the billing package. Deriving the name from the Go
+// symbol avoids typos and mismatches between the name that functions
+// are registered with and the name they're called with.
+//
+// The name of anonymous functions (closures) depends on their position
+// in the sources, so it isn't derived: they must be named with
+// WithName, which also overrides the derived name of other functions,
+// e.g. to keep the name stable when the Go function is renamed.
+//
+// Registering the function panics if another function with a different
+// Go symbol is registered with the same name.
+func FuncOf[I, O any](fn func(context.Context, I) (O, error)) *Function[I, O] {
+	symbol := symbolOf(fn)
+	f := Func(derivedName(symbol), fn)
+	f.symbol = symbol
+	return f
+}
+
+// WithName sets the name of the function, and returns the function.
+func (f *Function[I, O]) WithName(name string) *Function[I, O] {
+	f.name = name
+	return f
+}
+
+func symbolOf(fn any) string {
+	pc := reflect.ValueOf(fn).Pointer()
+	if f := runtime.FuncForPC(pc); f != nil {
+		// Method values are wrapped by functions with a -fm suffix.
+		return strings.TrimSuffix(f.Name(), "-fm")
+	}
+	return ""
+}
+
+// anonymous matches the symbols of closures, e.g. main.main.func1 or
+// main.main.func1.2.
+var anonymous = regexp.MustCompile(`\.func\d+(\.\d+)*$`)
+
+func derivedName(symbol string) string {
+	if symbol == "" || anonymous.MatchString(symbol) {
+		return ""
+	}
+	return symbol
+}
+
+// checkName panics if a function cannot be registered with a name,
+// because the name couldn't be derived from its Go symbol, or because
+// another function with a different Go symbol is registered with the
+// same name (see FuncOf).
+func (d *Dispatch) checkName(name string, fn AnyFunction) {
+	symbol := functionSymbol(fn)
+	if symbol != "" && name == "" {
+		panic(fmt.Sprintf("dispatch: cannot derive the name of anonymous function %s (use WithName to name it)", symbol))
+	}
+	prev, ok := d.infos.Load(name)
+	if !ok {
+		return
+	}
+	// Functions created with Func can replace each other (e.g. with
+	// HotRegister), but not functions created with FuncOf.
+	prevSymbol := prev.(FunctionInfo).Symbol
+	if (symbol != "" || prevSymbol != "") && symbol != prevSymbol {
+		panic(fmt.Sprintf("dispatch: function %q of %s collides with function %q of %s", name, describeSymbol(symbol), name, describeSymbol(prevSymbol)))
+	}
+}
+
+func functionSymbol(fn AnyFunction) string {
+	if f, ok := fn.(interface{ funcSymbol() string }); ok {
+		return f.funcSymbol()
+	}
+	return ""
+}
+
+func (f *Function[I, O]) funcSymbol() string {
+	return f.symbol
+}
+
+func describeSymbol(symbol string) string {
+	if symbol == "" {
+		return "another Go function"
+	}
+	return symbol
+}
@@ -0,0 +1,79 @@
+package dispatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+)
+
+func chargeCard(ctx context.Context, amount int) (int, error) { return amount, nil }
+
+func refundCard(ctx context.Context, amount int) (int, error) { return -amount, nil }
+
+type billing struct{}
+
+func (b *billing) Invoice(ctx context.Context, amount int) (int, error) { return amount, nil }
+
+func TestFuncOf(t *testing.T) {
+	for _, test := range []struct {
+		fn   *dispatch.Function[int, int]
+		name string
+	}{
+		{dispatch.FuncOf(chargeCard), "github.com/dispatchrun/dispatch-go_test.chargeCard"},
+		{dispatch.FuncOf((&billing{}).Invoice), "github.com/dispatchrun/dispatch-go_test.(*billing).Invoice"},
+		{dispatch.FuncOf(chargeCard).WithName("charge"), "charge"},
+		{dispatch.FuncOf(func(ctx context.Context, amount int) (int, error) { return amount, nil }), ""},
+	} {
+		if got := test.fn.Name(); got != test.name {
+			t.Errorf("unexpected name: got %q, want %q", got, test.name)
+		}
+	}
+
+	endpoint, err := dispatch.New(dispatch.EndpointUrl("http://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	charge := dispatch.FuncOf(chargeCard)
+	endpoint.Register(charge)
+	call, err := charge.BuildCall(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := call.Function(); got != charge.Name() {
+		t.Errorf("unexpected function of call: %q", got)
+	}
+	if functions := endpoint.Functions(); len(functions) != 1 || functions[0].Symbol != "github.com/dispatchrun/dispatch-go_test.chargeCard" {
+		t.Errorf("unexpected functions: %+v", functions)
+	}
+
+	// Registering the same Go function again is allowed.
+	endpoint.Register(dispatch.FuncOf(chargeCard))
+	endpoint.Register(dispatch.FuncOf(refundCard).WithName("refund"))
+
+	// Functions with names that collide, or that cannot be named, are
+	// rejected.
+	assertPanics(t, "collides with function", func() {
+		endpoint.Register(dispatch.FuncOf(refundCard).WithName(charge.Name()))
+	})
+	assertPanics(t, "collides with function", func() {
+		endpoint.Register(dispatch.Func("refund", refundCard))
+	})
+	assertPanics(t, "cannot derive the name of anonymous function", func() {
+		endpoint.Register(dispatch.FuncOf(func(ctx context.Context, amount int) (int, error) { return amount, nil }))
+	})
+}
+
+func assertPanics(t *testing.T, want string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		if r := recover(); r == nil {
+			t.Errorf("expected a panic containing %q", want)
+		} else if msg, _ := r.(string); !strings.Contains(msg, want) {
+			t.Errorf("unexpected panic: %v", r)
+		}
+	}()
+	fn()
+}
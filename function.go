@@ -5,9 +5,12 @@ package dispatch
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"slices"
+	"time"
 
 	"github.com/dispatchrun/coroutine"
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
 	"github.com/dispatchrun/dispatch-go/dispatchcoro"
 	"github.com/dispatchrun/dispatch-go/dispatchproto"
 )
@@ -17,14 +20,44 @@ func Func[I, O any](name string, fn func(context.Context, I) (O, error)) *Functi
 	return &Function[I, O]{name: name, fn: fn}
 }
 
+// PrimitiveFunction is a Function whose input and output are untyped.
+//
+// Its Await and Gather methods take and return dispatchproto.Any values,
+// so that low-level orchestrators can compose calls without knowing
+// the types of inputs and outputs.
+type PrimitiveFunction = Function[dispatchproto.Any, dispatchproto.Any]
+
+// PrimitiveFunc creates a PrimitiveFunction.
+func PrimitiveFunc(name string, fn func(context.Context, dispatchproto.Any) (dispatchproto.Any, error)) *PrimitiveFunction {
+	return Func(name, fn)
+}
+
 // Function is a Dispatch Function.
 type Function[I, O any] struct {
 	name string
 
+	// symbol is the Go symbol of fn, if the function was created with
+	// FuncOf.
+	symbol string
+
 	fn func(ctx context.Context, input I) (O, error)
 
+	machine func() Machine[I, O]
+
 	endpoint *Dispatch
 
+	secrets []string
+
+	shadow *functionShadow[I, O]
+
+	interceptors []Interceptor
+
+	codec         dispatchproto.Codec
+	deterministic bool
+
+	stateVersion string
+	migrateState func(from, to string, state []byte) ([]byte, error)
+
 	instances dispatchcoro.VolatileCoroutines
 }
 
@@ -33,9 +66,67 @@ func (f *Function[I, O]) Name() string {
 	return f.name
 }
 
+// WithCodec sets the codec used to marshal the inputs of calls to the
+// function, and its outputs, and returns the function. It defaults to
+// dispatchproto.ProtoCodec.
+//
+// Inputs and outputs are unmarshaled regardless of their codec, so the
+// codec can be changed while calls are in flight.
+func (f *Function[I, O]) WithCodec(codec dispatchproto.Codec) *Function[I, O] {
+	f.codec = codec
+	return f
+}
+
+// WithDeterministicMarshaling makes the marshaling of the inputs of calls
+// to the function, and of its outputs, deterministic (see
+// dispatchproto.Deterministic), and returns the function.
+//
+// It's useful when inputs are compared byte for byte, e.g. to cache
+// results keyed on serialized inputs. Note that payload encodings that
+// are randomized, such as encryption with dispatchproto.AESGCM, are not
+// deterministic.
+func (f *Function[I, O]) WithDeterministicMarshaling() *Function[I, O] {
+	f.deterministic = true
+	return f
+}
+
+// marshal serializes an input or output of the function, using the
+// codec of the function and the payload encodings of its endpoint.
+func (f *Function[I, O]) marshal(v any) (dispatchproto.Any, error) {
+	opts := []dispatchproto.MarshalOption{dispatchproto.WithCodec(f.codec)}
+	if f.deterministic {
+		opts = append(opts, dispatchproto.Deterministic())
+	}
+	boxed, err := dispatchproto.Marshal(v, opts...)
+	if err != nil || f.endpoint == nil || len(f.endpoint.payloadEncodings) == 0 {
+		return boxed, err
+	}
+	return boxed.Encode(f.endpoint.payloadEncodings...)
+}
+
+// unmarshalInput deserializes an input of the function, within the
+// limits of its endpoint (see InputLimits).
+func (f *Function[I, O]) unmarshalInput(ctx context.Context, boxed dispatchproto.Any, input *I) error {
+	var opts dispatchproto.UnmarshalOptions
+	if f.endpoint != nil {
+		opts = f.endpoint.inputLimits
+	}
+	return opts.UnmarshalContext(ctx, boxed, input)
+}
+
+// marshalOutput serializes an output of the function, and offloads it
+// if it exceeds the limit of its endpoint (see OutputOffload).
+func (f *Function[I, O]) marshalOutput(ctx context.Context, v any) (dispatchproto.Any, error) {
+	boxed, err := f.marshal(v)
+	if err != nil || f.endpoint == nil || f.endpoint.outputOffloadEncoding == "" || len(boxed.Value()) <= f.endpoint.outputOffloadLimit {
+		return boxed, err
+	}
+	return boxed.EncodeContext(ctx, f.endpoint.outputOffloadEncoding)
+}
+
 // BuildCall creates (but does not dispatch) a Call for the function.
 func (f *Function[I, O]) BuildCall(input I, opts ...dispatchproto.CallOption) (dispatchproto.Call, error) {
-	boxedInput, err := dispatchproto.Marshal(input)
+	boxedInput, err := f.marshal(input)
 	if err != nil {
 		return dispatchproto.Call{}, fmt.Errorf("cannot serialize input: %v", err)
 	}
@@ -44,7 +135,11 @@ func (f *Function[I, O]) BuildCall(input I, opts ...dispatchproto.CallOption) (d
 		url = f.endpoint.URL()
 	}
 	opts = append(slices.Clip(opts), boxedInput)
-	return dispatchproto.NewCall(url, f.name, opts...), nil
+	call := dispatchproto.NewCall(url, f.name, opts...)
+	if f.endpoint != nil && f.endpoint.endpointResolver != nil {
+		return dispatchclient.ResolveEndpoint(f.endpoint.endpointResolver, call)
+	}
+	return call, nil
 }
 
 // Dispatch dispatches a Call to the function.
@@ -63,22 +158,81 @@ func (f *Function[I, O]) Dispatch(ctx context.Context, input I, opts ...dispatch
 	return client.Dispatch(ctx, call)
 }
 
-func (f *Function[I, O]) run(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+// DispatchBatch dispatches many calls to the function, one per input,
+// in a single batch request. It returns the dispatch IDs of the calls,
+// in the order of the inputs.
+func (f *Function[I, O]) DispatchBatch(ctx context.Context, inputs []I, opts ...dispatchproto.CallOption) ([]dispatchproto.ID, error) {
+	if f.endpoint == nil {
+		return nil, fmt.Errorf("cannot dispatch function calls: function has not been registered with a Dispatch endpoint")
+	}
+	client, err := f.endpoint.Client()
+	if err != nil {
+		return nil, fmt.Errorf("cannot dispatch function calls: %w", err)
+	}
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	batch := client.Batch()
+	for _, call := range calls {
+		batch.Add(call)
+	}
+	return batch.Dispatch(ctx)
+}
+
+// buildCalls creates a Call for each input.
+func (f *Function[I, O]) buildCalls(inputs []I, opts []dispatchproto.CallOption) ([]dispatchproto.Call, error) {
+	calls := make([]dispatchproto.Call, len(inputs))
+	for i, input := range inputs {
+		call, err := f.BuildCall(input, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		calls[i] = call
+	}
+	return calls, nil
+}
+
+func (f *Function[I, O]) run(ctx context.Context, req dispatchproto.Request) (res dispatchproto.Response) {
+	// Panics in durable coroutines and state machines unwind to here
+	// (see setUp for volatile coroutines).
+	defer recoverPanic(f.endpoint.log(), f.name, &res)
+
 	if name := req.Function(); name != f.name {
 		return dispatchproto.NewResponseErrorf("%w: function %q received call for function %q", ErrInvalidArgument, f.name, name)
 	}
+	if err := f.secretsError(); err != nil {
+		return dispatchproto.NewResponseError(err)
+	}
+	if f.machine != nil {
+		return f.runMachine(ctx, req)
+	}
 
-	id, coro, err := f.setUp(req)
+	profile := profileOf(ctx)
+	start := time.Now()
+	id, coro, err := f.setUp(ctx, req)
 	if err != nil {
 		return dispatchproto.NewResponseError(err)
 	}
 	defer f.tearDown(id, coro)
+	if profile != nil {
+		profile.Deserialize = time.Since(start)
+		start = time.Now()
+	}
+	if _, ok := req.PollResult(); ok {
+		debugRequest(ctx, f.endpoint.log(), "coroutine resumed", req)
+	}
 
 	// Send results from Dispatch to the coroutine (if applicable).
 	coro.Send(req)
 
 	// Run the coroutine until it yields or returns.
-	if returned := !coro.Next(); returned {
+	returned := !coro.Next()
+	if profile != nil {
+		profile.Run = time.Since(start)
+		start = time.Now()
+	}
+	if returned {
 		return coro.Result()
 	}
 	yield := coro.Recv()
@@ -98,10 +252,14 @@ func (f *Function[I, O]) run(ctx context.Context, req dispatchproto.Request) dis
 	if err != nil {
 		return dispatchproto.NewResponseError(err)
 	}
+	if profile != nil {
+		profile.Serialize = time.Since(start)
+	}
+	debugRequest(ctx, f.endpoint.log(), "coroutine suspended", req, "state_size", len(state.Value()))
 	return yield.With(dispatchproto.CoroutineState(state))
 }
 
-func (f *Function[I, O]) setUp(req dispatchproto.Request) (dispatchcoro.InstanceID, dispatchcoro.Coroutine, error) {
+func (f *Function[I, O]) setUp(ctx context.Context, req dispatchproto.Request) (dispatchcoro.InstanceID, dispatchcoro.Coroutine, error) {
 	// If the request carries a poll result, find/deserialize the
 	// suspended coroutine.
 	if pollResult, ok := req.PollResult(); ok {
@@ -114,10 +272,19 @@ func (f *Function[I, O]) setUp(req dispatchproto.Request) (dispatchcoro.Instance
 	if !ok {
 		return 0, dispatchcoro.Coroutine{}, fmt.Errorf("%w: unsupported request: %v", ErrInvalidArgument, req)
 	}
-	if err := boxedInput.Unmarshal(&input); err != nil {
-		return 0, dispatchcoro.Coroutine{}, fmt.Errorf("%w: invalid input %v: %v", ErrInvalidArgument, boxedInput, err)
+	if err := f.unmarshalInput(ctx, boxedInput, &input); err != nil {
+		return 0, dispatchcoro.Coroutine{}, fmt.Errorf("%w: invalid input of type %s (%d bytes): %v", ErrInvalidArgument, boxedInput.TypeURL(), len(boxedInput.Value()), err)
+	}
+	principal, _ := Principal(ctx)
+	entrypoint := f.entrypoint(input, principal)
+
+	// Volatile coroutines run on their own goroutine, so panics must be
+	// recovered there. Durable coroutines run on the caller's goroutine,
+	// and panics unwind to run.
+	if !coroutine.Durable {
+		entrypoint = recoverable(f.endpoint.log(), f.name, entrypoint)
 	}
-	coro := dispatchcoro.New(f.entrypoint(input))
+	coro := dispatchcoro.New(entrypoint)
 
 	// In volatile mode, register the coroutine instance and assign a unique ID.
 	var id dispatchcoro.InstanceID
@@ -127,6 +294,17 @@ func (f *Function[I, O]) setUp(req dispatchproto.Request) (dispatchcoro.Instance
 	return id, coro, nil
 }
 
+func recoverable(logger *slog.Logger, name string, fn func() dispatchproto.Response) func() dispatchproto.Response {
+	return func() (res dispatchproto.Response) {
+		defer func() {
+			if v := recover(); v != nil {
+				res = panicResponse(logger, name, v)
+			}
+		}()
+		return fn()
+	}
+}
+
 func (f *Function[I, O]) tearDown(id dispatchcoro.InstanceID, coro dispatchcoro.Coroutine) {
 	// Remove volatile coroutine instances only once they're done.
 	if !coroutine.Durable && coro.Done() {
@@ -149,7 +327,7 @@ func (f *Function[I, O]) serialize(id dispatchcoro.InstanceID, coro dispatchcoro
 	}
 
 	// In durable mode, serialize the state of the coroutine.
-	state, err := dispatchcoro.Serialize(coro)
+	state, err := dispatchcoro.Serialize(coro, f.endpoint.serializeOptions()...)
 	if err != nil {
 		return dispatchproto.Any{}, fmt.Errorf("%w: %v", ErrPermanent, err)
 	}
@@ -160,7 +338,7 @@ func (f *Function[I, O]) deserialize(state dispatchproto.Any) (dispatchcoro.Inst
 	// In durable mode, create the coroutine and then deserialize its prior state.
 	if coroutine.Durable {
 		var zero I
-		coro := dispatchcoro.New(f.entrypoint(zero))
+		coro := dispatchcoro.New(f.entrypoint(zero, ""))
 		if err := dispatchcoro.Deserialize(coro, state); err != nil {
 			return 0, dispatchcoro.Coroutine{}, fmt.Errorf("%w: %v", ErrIncompatibleState, err)
 		}
@@ -181,31 +359,45 @@ func (f *Function[I, O]) deserialize(state dispatchproto.Any) (dispatchcoro.Inst
 func (f *Function[I, O]) Register(endpoint *Dispatch) (string, dispatchproto.Function) {
 	f.endpoint = endpoint
 
-	return f.name, func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+	return f.name, intercept(func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		if f.stateVersion != "" || f.migrateState != nil {
+			return f.runVersioned(ctx, req)
+		}
 		return f.run(ctx, req)
-	}
+	}, f.interceptors)
 }
 
-func (c *Function[I, O]) entrypoint(input I) func() dispatchproto.Response {
+func (c *Function[I, O]) entrypoint(input I, principal string) func() dispatchproto.Response {
 	return func() dispatchproto.Response {
 		// The context that gets passed as argument here should be recreated
 		// each time the coroutine is resumed, ideally inheriting from the
 		// parent context passed to the Run method. This is difficult to
 		// do right in durable mode because we shouldn't capture the parent
-		// context in the coroutine state.
-		output, err := c.fn(context.TODO(), input)
+		// context in the coroutine state. The context only carries
+		// values that are safe to serialize (see Secret).
+		output, err := c.fn(c.context(principal), input)
 		if err != nil {
 			// TODO: include output if not nil
-			return dispatchproto.NewResponseError(err)
+			return newResponseError(err)
 		}
-		boxedOutput, err := dispatchproto.Marshal(output)
+		boxedOutput, err := c.marshalOutput(c.context(principal), output)
 		if err != nil {
 			return dispatchproto.NewResponseErrorf("%w: invalid output %v: %v", ErrInvalidResponse, output, err)
 		}
+		c.shadowCall(input, output)
 		return dispatchproto.NewResponse(dispatchproto.StatusOf(output), boxedOutput)
 	}
 }
 
+// Close stops the suspended volatile coroutine instances of the
+// function. Calls that are suspended cannot be resumed afterwards.
+//
+// It's called when an endpoint the function is registered on shuts
+// down (see Dispatch.Shutdown).
+func (f *Function[I, O]) Close() error {
+	return f.instances.Close()
+}
+
 // Await calls the function and awaits a result.
 //
 // Await should only be called within a Dispatch Function (created via Func).
@@ -222,21 +414,90 @@ func (f *Function[I, O]) Await(input I, opts ...dispatchproto.CallOption) (O, er
 	return results[0], nil
 }
 
+// TailCall returns an error that, when returned by a Dispatch function,
+// exits the current call and continues with a call to this function
+// with the specified input, e.g.
+//
+//	return "", loop.TailCall(next)
+//
+// The current call is replaced rather than suspended: the tail call
+// starts with fresh state, and its result becomes the result of the
+// current call. It's useful to implement infinite loops and long-lived
+// workflows without growing the state of the coroutine. The function
+// should have the same output type as the caller.
+//
+// If the call cannot be built (e.g. the input cannot be serialized),
+// the error is returned instead.
+func (f *Function[I, O]) TailCall(input I, opts ...dispatchproto.CallOption) error {
+	call, err := f.BuildCall(input, opts...)
+	if err != nil {
+		return err
+	}
+	return &tailCallError{call: call}
+}
+
 // Gather makes many concurrent calls to the function and awaits the results.
 //
 // Gather should only be called within a Dispatch Function (created via Func).
 func (f *Function[I, O]) Gather(inputs []I, opts ...dispatchproto.CallOption) ([]O, error) {
-	calls := make([]dispatchproto.Call, len(inputs))
-	for i, input := range inputs {
-		call, err := f.BuildCall(input, opts...)
-		if err != nil {
-			return nil, err
-		}
-		calls[i] = call
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return nil, err
 	}
 	return dispatchcoro.Gather[O](calls...)
 }
 
+// GatherN makes many calls to the function and awaits the results,
+// keeping at most maxConcurrent calls in flight (see dispatchcoro.AwaitN).
+//
+// GatherN should only be called within a Dispatch Function (created via Func).
+func (f *Function[I, O]) GatherN(inputs []I, maxConcurrent int, opts ...dispatchproto.CallOption) ([]O, error) {
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dispatchcoro.GatherN[O](maxConcurrent, calls...)
+}
+
+// GatherQuorum makes many concurrent calls to the function and awaits
+// the results until n of them succeed (see dispatchcoro.GatherQuorum).
+//
+// GatherQuorum should only be called within a Dispatch Function (created via Func).
+func (f *Function[I, O]) GatherQuorum(n int, inputs []I, opts ...dispatchproto.CallOption) (dispatchcoro.Quorum[O], error) {
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return dispatchcoro.Quorum[O]{}, err
+	}
+	return dispatchcoro.GatherQuorum[O](n, calls...)
+}
+
+// GatherDetailed makes many concurrent calls to the function and awaits
+// the results of all of them, returning the output, error and final
+// status of each call (see dispatchcoro.GatherDetailed).
+//
+// GatherDetailed should only be called within a Dispatch Function (created via Func).
+func (f *Function[I, O]) GatherDetailed(inputs []I, opts ...dispatchproto.CallOption) ([]dispatchcoro.CallDetail[O], error) {
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return dispatchcoro.GatherDetailed[O](calls...)
+}
+
+// Race makes many concurrent calls to the function and awaits the
+// first one that succeeds. It returns the output of the call, and the
+// index of its input (see dispatchcoro.Race).
+//
+// Race should only be called within a Dispatch Function (created via Func).
+func (f *Function[I, O]) Race(inputs []I, opts ...dispatchproto.CallOption) (O, int, error) {
+	var zero O
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return zero, -1, err
+	}
+	return dispatchcoro.Race[O](calls...)
+}
+
 func (f *Function[I, O]) configureDispatch(d *Dispatch) {
 	d.Register(f)
 }
@@ -0,0 +1,85 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Function0 is a Dispatch Function that takes no input.
+//
+// Its Dispatch, Await and Gather methods don't take inputs. Calls to
+// the function carry a nil input (see dispatchproto.Nil), and any input
+// the function is called with is ignored.
+type Function0[O any] struct {
+	*Function[dispatchproto.Any, O]
+}
+
+// Func0 creates a Function0.
+func Func0[O any](name string, fn func(context.Context) (O, error)) *Function0[O] {
+	return &Function0[O]{Func(name, func(ctx context.Context, _ dispatchproto.Any) (O, error) {
+		return fn(ctx)
+	})}
+}
+
+// BuildCall creates (but does not dispatch) a Call for the function.
+func (f *Function0[O]) BuildCall(opts ...dispatchproto.CallOption) (dispatchproto.Call, error) {
+	return f.Function.BuildCall(dispatchproto.Nil(), opts...)
+}
+
+// Dispatch dispatches a Call to the function.
+func (f *Function0[O]) Dispatch(ctx context.Context, opts ...dispatchproto.CallOption) (dispatchproto.ID, error) {
+	return f.Function.Dispatch(ctx, dispatchproto.Nil(), opts...)
+}
+
+// Await calls the function and awaits a result.
+//
+// Await should only be called within a Dispatch Function (created via Func).
+func (f *Function0[O]) Await(opts ...dispatchproto.CallOption) (O, error) {
+	return f.Function.Await(dispatchproto.Nil(), opts...)
+}
+
+// Gather makes n concurrent calls to the function and awaits the results.
+//
+// Gather should only be called within a Dispatch Function (created via Func).
+func (f *Function0[O]) Gather(n int, opts ...dispatchproto.CallOption) ([]O, error) {
+	inputs := make([]dispatchproto.Any, n)
+	for i := range inputs {
+		inputs[i] = dispatchproto.Nil()
+	}
+	return f.Function.Gather(inputs, opts...)
+}
+
+// FunctionVoid is a Dispatch Function that returns no output.
+//
+// Its Await and Gather methods only return an error. The function
+// returns a nil output (see dispatchproto.Nil).
+type FunctionVoid[I any] struct {
+	*Function[I, dispatchproto.Any]
+}
+
+// FuncVoid creates a FunctionVoid.
+func FuncVoid[I any](name string, fn func(context.Context, I) error) *FunctionVoid[I] {
+	return &FunctionVoid[I]{Func(name, func(ctx context.Context, input I) (dispatchproto.Any, error) {
+		return dispatchproto.Nil(), fn(ctx, input)
+	})}
+}
+
+// Await calls the function and waits for it to complete.
+//
+// Await should only be called within a Dispatch Function (created via Func).
+func (f *FunctionVoid[I]) Await(input I, opts ...dispatchproto.CallOption) error {
+	_, err := f.Function.Await(input, opts...)
+	return err
+}
+
+// Gather makes many concurrent calls to the function and waits for them
+// to complete.
+//
+// Gather should only be called within a Dispatch Function (created via Func).
+func (f *FunctionVoid[I]) Gather(inputs []I, opts ...dispatchproto.CallOption) error {
+	_, err := f.Function.Gather(inputs, opts...)
+	return err
+}
@@ -0,0 +1,80 @@
+package dispatch_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestFunc0(t *testing.T) {
+	logMode(t)
+
+	answer := dispatch.Func0("answer", func(ctx context.Context) (int, error) {
+		return 42, nil
+	})
+
+	sum := dispatch.Func("sum", func(ctx context.Context, n int) (int, error) {
+		single, err := answer.Await()
+		if err != nil {
+			return 0, err
+		}
+		many, err := answer.Gather(n)
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range many {
+			single += v
+		}
+		return single, nil
+	})
+
+	runner := dispatchtest.NewRunner(answer, sum)
+
+	output, err := dispatchtest.Call(runner, sum, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if output != 126 {
+		t.Errorf("unexpected output: got %d, want 126", output)
+	}
+}
+
+func TestFuncVoid(t *testing.T) {
+	logMode(t)
+
+	check := dispatch.FuncVoid("check", func(ctx context.Context, n int) error {
+		if n < 0 {
+			return errors.New("negative")
+		}
+		return nil
+	})
+
+	checkAll := dispatch.Func("check_all", func(ctx context.Context, inputs []int) (bool, error) {
+		if err := check.Await(inputs[0]); err != nil {
+			return false, err
+		}
+		if err := check.Gather(inputs); err != nil {
+			return false, nil
+		}
+		return true, nil
+	})
+
+	runner := dispatchtest.NewRunner(check, checkAll)
+
+	for _, test := range []struct {
+		inputs []int
+		want   bool
+	}{
+		{[]int{1, 2, 3}, true},
+		{[]int{1, -2, 3}, false},
+	} {
+		output, err := dispatchtest.Call(runner, checkAll, test.inputs)
+		if err != nil {
+			t.Fatal(err)
+		} else if output != test.want {
+			t.Errorf("unexpected output for %v: got %v, want %v", test.inputs, output, test.want)
+		}
+	}
+}
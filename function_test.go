@@ -1,7 +1,9 @@
 package dispatch_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand/v2"
 	"strconv"
@@ -51,6 +53,232 @@ func TestCoroutineReturn(t *testing.T) {
 	}
 }
 
+func TestCoroutineStructs(t *testing.T) {
+	logMode(t)
+
+	type order struct {
+		Item     string `json:"item"`
+		Quantity int    `json:"quantity"`
+	}
+	type receipt struct {
+		Order order   `json:"order"`
+		Total float64 `json:"total"`
+	}
+
+	checkout := dispatch.Func("checkout", func(ctx context.Context, in order) (receipt, error) {
+		return receipt{Order: in, Total: 2.5 * float64(in.Quantity)}, nil
+	})
+
+	runner := dispatchtest.NewRunner(checkout)
+
+	output, err := dispatchtest.Call(runner, checkout, order{Item: "apple", Quantity: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := receipt{Order: order{Item: "apple", Quantity: 3}, Total: 7.5}
+	if output != want {
+		t.Errorf("unexpected output: got %+v, want %+v", output, want)
+	}
+}
+
+type shipping string
+
+const (
+	standard shipping = "standard"
+	express  shipping = "express"
+)
+
+func (shipping) EnumValues() []any { return []any{standard, express} }
+
+func TestCoroutineEnums(t *testing.T) {
+	logMode(t)
+
+	quote := dispatch.Func("quote", func(ctx context.Context, s shipping) (float64, error) {
+		if s == express {
+			return 10, nil
+		}
+		return 5, nil
+	})
+
+	runner := dispatchtest.NewRunner(quote)
+
+	output, err := dispatchtest.Call(runner, quote, express)
+	if err != nil {
+		t.Fatal(err)
+	} else if output != 10 {
+		t.Errorf("unexpected output: %v", output)
+	}
+
+	// Unknown values are rejected.
+	res := runner.Run(dispatchproto.NewRequest("quote", dispatchproto.String("overnight")))
+	dispatchtest.AssertError(t, res, dispatchproto.InvalidArgumentStatus, `invalid dispatch_test.shipping value "overnight"`)
+}
+
+func TestCoroutineCodec(t *testing.T) {
+	logMode(t)
+
+	type pair struct {
+		Key   string `json:"key"`
+		Value int    `json:"value"`
+	}
+
+	swap := dispatch.Func("swap", func(ctx context.Context, in pair) (pair, error) {
+		return pair{Key: strconv.Itoa(in.Value), Value: len(in.Key)}, nil
+	}).WithCodec(dispatchproto.JSONCodec)
+
+	call, err := swap.BuildCall(pair{Key: "abc", Value: 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if codec := call.Input().Codec(); codec != dispatchproto.JSONCodec {
+		t.Errorf("unexpected input codec: %v", codec)
+	}
+
+	runner := dispatchtest.NewRunner(swap)
+	res := runner.Run(call.Request())
+	output, ok := res.Output()
+	if !ok {
+		t.Fatalf("unexpected response: %s", res)
+	}
+	if codec := output.Codec(); codec != dispatchproto.JSONCodec {
+		t.Errorf("unexpected output codec: %v", codec)
+	}
+	var got pair
+	if err := output.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if want := (pair{Key: "11", Value: 3}); got != want {
+		t.Errorf("unexpected output: got %+v, want %+v", got, want)
+	}
+}
+
+func TestCoroutineDeterministicMarshaling(t *testing.T) {
+	keys := dispatch.Func("keys", func(ctx context.Context, in map[string]int) (int, error) {
+		return len(in), nil
+	}).WithDeterministicMarshaling()
+
+	input := map[string]int{}
+	for i := 0; i < 32; i++ {
+		input["key"+strconv.Itoa(i)] = i
+	}
+
+	want, err := keys.BuildCall(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		got, err := keys.BuildCall(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Input().Value(), want.Input().Value()) {
+			t.Fatal("inputs are not marshaled deterministically")
+		}
+	}
+
+	runner := dispatchtest.NewRunner(keys)
+	dispatchtest.AssertExit(t, runner.Run(want.Request()), 32)
+}
+
+func TestCoroutineTailCall(t *testing.T) {
+	logMode(t)
+
+	var calls int
+	var countdown *dispatch.Function[int, string]
+	countdown = dispatch.Func("countdown", func(ctx context.Context, n int) (string, error) {
+		calls++
+		if n == 0 {
+			return "liftoff", nil
+		}
+		return "", countdown.TailCall(n - 1)
+	})
+
+	runner := dispatchtest.NewRunner(countdown)
+
+	// The function exits with a tail call.
+	res := runner.RoundTrip(dispatchproto.NewRequest("countdown", dispatchproto.Int(3)))
+	if !res.OK() {
+		t.Errorf("unexpected response status: %v", res.Status())
+	}
+	exit, ok := res.Exit()
+	if !ok {
+		t.Fatalf("expected exit response, got %s", res)
+	}
+	tailCall, ok := exit.TailCall()
+	if !ok {
+		t.Fatalf("expected tail call, got %s", exit)
+	}
+	var input int
+	if err := tailCall.Input().Unmarshal(&input); err != nil {
+		t.Fatal(err)
+	} else if tailCall.Function() != "countdown" || input != 2 {
+		t.Errorf("unexpected tail call: %s", tailCall)
+	}
+
+	// The runner follows tail calls.
+	calls = 0
+	output, err := dispatchtest.Call(runner, countdown, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if output != "liftoff" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if calls != 4 {
+		t.Errorf("unexpected number of calls: got %d, want 4", calls)
+	}
+}
+
+func TestCoroutineStateVersion(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	newWorkflow := func(version string) *dispatch.Function[int, int] {
+		return dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+			return double.Await(n)
+		}).WithStateVersion(version)
+	}
+
+	_, v1 := newWorkflow("v1").Register(nil)
+	res := v1(context.Background(), dispatchproto.NewRequest("workflow", dispatchproto.Int(2)))
+	poll := dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+	if typeURL := poll.CoroutineState().TypeURL(); typeURL != dispatch.VersionedStateTypeURL {
+		t.Fatalf("unexpected coroutine state type: %s", typeURL)
+	}
+	resume := dispatchproto.NewRequest("workflow", poll.Result())
+
+	// State of another version can't be resumed without a migration.
+	_, v2 := newWorkflow("v2").Register(nil)
+	res = v2(context.Background(), resume)
+	dispatchtest.AssertError(t, res, dispatchproto.IncompatibleStateStatus, `state version "v1" doesn't match version "v2"`)
+
+	// Migrations that fail make the call fail.
+	_, v2 = newWorkflow("v2").WithStateMigration(func(from, to string, state []byte) ([]byte, error) {
+		return nil, errors.New("unsupported")
+	}).Register(nil)
+	res = v2(context.Background(), resume)
+	dispatchtest.AssertError(t, res, dispatchproto.IncompatibleStateStatus, `cannot migrate state from version "v1" to "v2": unsupported`)
+
+	// Migrations can restart the call from its input.
+	var migrations []string
+	_, v2 = newWorkflow("v2").WithStateMigration(func(from, to string, state []byte) ([]byte, error) {
+		migrations = append(migrations, from+" -> "+to)
+		return nil, dispatch.ErrRestart
+	}).Register(nil)
+	res = v2(context.Background(), resume)
+	poll = dispatchtest.AssertPollCalls(t, res, dispatchtest.MatchCall("double", 2))
+	if len(migrations) != 1 || migrations[0] != "v1 -> v2" {
+		t.Errorf("unexpected migrations: %v", migrations)
+	}
+
+	// The restarted call carries on with state of the new version.
+	res = v2(context.Background(), dispatchproto.NewRequest("workflow", poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(dispatchproto.Int(4), dispatchproto.CorrelationID(poll.Calls()[0].CorrelationID())),
+	))))
+	dispatchtest.AssertExit(t, res, 4)
+	if len(migrations) != 1 {
+		t.Errorf("unexpected migrations: %v", migrations)
+	}
+}
+
 func TestCoroutineExit(t *testing.T) {
 	logMode(t)
 
@@ -458,6 +686,510 @@ func TestCoroutineGatherSlow(t *testing.T) {
 	}
 }
 
+func TestCoroutineGatherN(t *testing.T) {
+	logMode(t)
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	sum := dispatch.Func("sum", func(ctx context.Context, n int) (int, error) {
+		inputs := make([]int, n)
+		for i := range inputs {
+			inputs[i] = i
+		}
+		outputs, err := double.GatherN(inputs, 4)
+		if err != nil {
+			return 0, err
+		}
+		var sum int
+		for _, output := range outputs {
+			sum += output
+		}
+		return sum, nil
+	})
+
+	runner := dispatchtest.NewRunner(sum)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("sum", dispatchproto.Int(10)))
+
+	var inflight []dispatchproto.Call
+	var submitted int
+	for {
+		poll, ok := res.Poll()
+		if !ok {
+			break
+		}
+		inflight = append(inflight, poll.Calls()...)
+		submitted += len(poll.Calls())
+		if len(inflight) > 4 {
+			t.Fatalf("too many calls in flight: %d", len(inflight))
+		}
+		minResults := int(poll.MinResults())
+		if want := min(len(inflight), 2); minResults != want {
+			t.Errorf("unexpected min results: got %d, want %d", minResults, want)
+		}
+
+		// Complete the minimum number of calls.
+		var results []dispatchproto.CallResult
+		for _, call := range inflight[:minResults] {
+			var n int
+			if err := call.Input().Unmarshal(&n); err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, dispatchproto.NewCallResult(
+				dispatchproto.Int(int64(n*2)),
+				dispatchproto.CorrelationID(call.CorrelationID())))
+		}
+		inflight = inflight[minResults:]
+
+		res = runner.RoundTrip(dispatchproto.NewRequest("sum", poll.Result().With(dispatchproto.CallResults(results...))))
+	}
+	if submitted != 10 {
+		t.Errorf("unexpected number of calls: got %d, want 10", submitted)
+	}
+
+	dispatchtest.AssertExit(t, res, 90)
+}
+
+func TestCoroutineGatherDetailed(t *testing.T) {
+	logMode(t)
+
+	identity := dispatch.Func("identity", func(ctx context.Context, x string) (string, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	report := dispatch.Func("report", func(ctx context.Context, _ string) (string, error) {
+		details, err := identity.GatherDetailed([]string{"a", "b", "c"})
+		if err != nil {
+			return "", err
+		}
+		var lines []string
+		for _, detail := range details {
+			lines = append(lines, fmt.Sprintf("%s:%s:%v:%s", detail.Output, detail.Status, detail.Err, detail.DispatchID))
+		}
+		return strings.Join(lines, " "), nil
+	})
+
+	runner := dispatchtest.NewRunner(report)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("report", dispatchproto.String("")))
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 poll calls, got %s", poll)
+	}
+
+	// A failure doesn't stop GatherDetailed from waiting for the other
+	// results.
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			dispatchproto.NewError(errors.New("unavailable")),
+			dispatchproto.CorrelationID(calls[1].CorrelationID()),
+			dispatchproto.DispatchID("2")),
+		dispatchproto.NewCallResult(
+			calls[0].Input(),
+			dispatchproto.CorrelationID(calls[0].CorrelationID()),
+			dispatchproto.DispatchID("1")),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("report", pollResult))
+	poll, ok = res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+
+	pollResult = poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			calls[2].Input(),
+			dispatchproto.CorrelationID(calls[2].CorrelationID()),
+			dispatchproto.DispatchID("3")),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("report", pollResult))
+
+	dispatchtest.AssertExit(t, res, "a:OK:<nil>:1 :PermanentError:errorString: unavailable:2 c:OK:<nil>:3")
+}
+
+func TestCoroutineRace(t *testing.T) {
+	logMode(t)
+
+	identity := dispatch.Func("identity", func(ctx context.Context, x string) (string, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	race := dispatch.Func("race", func(ctx context.Context, mirrors []string) (string, error) {
+		output, i, err := identity.Race(mirrors)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s from mirror %d", output, i), nil
+	})
+
+	runner := dispatchtest.NewRunner(race)
+
+	mirrors, err := dispatchproto.Marshal([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := runner.RoundTrip(dispatchproto.NewRequest("race", mirrors))
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 poll calls, got %s", poll)
+	}
+	if poll.MinResults() != 1 {
+		t.Errorf("unexpected min results: got %d, want 1", poll.MinResults())
+	}
+
+	// Deliver a failure; the race isn't won yet.
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			dispatchproto.NewError(errors.New("mirror unavailable")),
+			dispatchproto.CorrelationID(calls[0].CorrelationID())),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("race", pollResult))
+	poll, ok = res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+
+	// Deliver a success, which wins the race.
+	pollResult = poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			calls[2].Input(),
+			dispatchproto.CorrelationID(calls[2].CorrelationID())),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("race", pollResult))
+
+	dispatchtest.AssertExit(t, res, "c from mirror 2")
+}
+
+func TestCoroutineGatherQuorum(t *testing.T) {
+	logMode(t)
+
+	identity := dispatch.Func("identity", func(ctx context.Context, x string) (string, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	replicas := dispatch.Func("replicas", func(ctx context.Context, n int) (string, error) {
+		quorum, err := identity.GatherQuorum(n, []string{"a", "b", "c", "d"})
+		if err != nil {
+			return "", err
+		}
+		outputs := make([]string, len(quorum.Succeeded))
+		for i, j := range quorum.Succeeded {
+			outputs[i] = quorum.Outputs[j]
+		}
+		return fmt.Sprintf("%s abandoned=%v", strings.Join(outputs, ","), quorum.Abandoned), nil
+	})
+
+	runner := dispatchtest.NewRunner(replicas)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("replicas", dispatchproto.Int(2)))
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 poll calls, got %s", poll)
+	}
+	if poll.MinResults() != 2 {
+		t.Errorf("unexpected min results: got %d, want 2", poll.MinResults())
+	}
+
+	// Deliver a failure and a success; the quorum isn't reached yet.
+	pollResult := poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			dispatchproto.NewError(errors.New("replica unavailable")),
+			dispatchproto.CorrelationID(calls[0].CorrelationID())),
+		dispatchproto.NewCallResult(
+			calls[2].Input(),
+			dispatchproto.CorrelationID(calls[2].CorrelationID())),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("replicas", pollResult))
+	poll, ok = res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	if poll.MinResults() != 1 {
+		t.Errorf("unexpected min results: got %d, want 1", poll.MinResults())
+	}
+
+	// Deliver a second success, which reaches the quorum.
+	pollResult = poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			calls[3].Input(),
+			dispatchproto.CorrelationID(calls[3].CorrelationID())),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("replicas", pollResult))
+
+	dispatchtest.AssertExit(t, res, "c,d abandoned=[1]")
+
+	// The quorum cannot be reached once too many calls fail.
+	res = runner.RoundTrip(dispatchproto.NewRequest("replicas", dispatchproto.Int(4)))
+	poll, ok = res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls = poll.Calls()
+	pollResult = poll.Result().With(dispatchproto.CallResults(
+		dispatchproto.NewCallResult(
+			dispatchproto.NewError(errors.New("replica unavailable")),
+			dispatchproto.CorrelationID(calls[1].CorrelationID())),
+	))
+	res = runner.RoundTrip(dispatchproto.NewRequest("replicas", pollResult))
+	dispatchtest.AssertError(t, res, dispatchproto.PermanentErrorStatus,
+		"quorum of 4 cannot be reached after 1 failure(s): errorString: replica unavailable")
+}
+
+func TestCoroutineScheduler(t *testing.T) {
+	logMode(t)
+
+	identity := dispatch.Func("identity", func(ctx context.Context, x string) (string, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	interleave := dispatch.Func("interleave", func(ctx context.Context, _ int) (string, error) {
+		buildCalls := func(inputs ...string) []dispatchproto.Call {
+			calls := make([]dispatchproto.Call, len(inputs))
+			for i, input := range inputs {
+				call, err := identity.BuildCall(input)
+				if err != nil {
+					panic(err)
+				}
+				calls[i] = call
+			}
+			return calls
+		}
+
+		scheduler := dispatchcoro.NewScheduler()
+		first := scheduler.Await(dispatchcoro.AwaitAll, buildCalls("a", "b")...)
+		second := scheduler.Await(dispatchcoro.AwaitAll, buildCalls("c")...)
+
+		var completed []string
+		for {
+			future, err := scheduler.Next()
+			if err != nil {
+				return "", err
+			} else if future == nil {
+				break
+			}
+			outputs, err := dispatchcoro.Outputs[string](future)
+			if err != nil {
+				return "", err
+			}
+			completed = append(completed, strings.Join(outputs, ""))
+
+			// Chain another await operation once the second completes,
+			// while the first is still in flight.
+			if future == second {
+				if first.Done() {
+					return "", errors.New("unexpected completion of first await operation")
+				}
+				scheduler.Await(dispatchcoro.AwaitAll, buildCalls("d")...)
+			}
+		}
+		return strings.Join(completed, ","), nil
+	})
+
+	runner := dispatchtest.NewRunner(interleave)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("interleave", dispatchproto.Int(0)))
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("expected calls of both await operations in one poll, got %s", poll)
+	}
+	resultOf := func(call dispatchproto.Call) dispatchproto.CallResult {
+		return dispatchproto.NewCallResult(call.Input(), dispatchproto.CorrelationID(call.CorrelationID()))
+	}
+
+	// Complete the second await operation.
+	res = runner.RoundTrip(dispatchproto.NewRequest("interleave", poll.Result().With(dispatchproto.CallResults(resultOf(calls[2])))))
+	poll, ok = res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	chained := poll.Calls()
+	if len(chained) != 1 {
+		t.Fatalf("expected the chained call in the next poll, got %s", poll)
+	}
+
+	// Complete the chained and first await operations.
+	res = runner.RoundTrip(dispatchproto.NewRequest("interleave", poll.Result().With(dispatchproto.CallResults(
+		resultOf(chained[0]),
+		resultOf(calls[0]),
+		resultOf(calls[1]),
+	))))
+
+	dispatchtest.AssertExit(t, res, "c,ab,d")
+}
+
+func TestCoroutineGraph(t *testing.T) {
+	logMode(t)
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+
+	var graph dispatchcoro.Graph
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (int, error) {
+		call, err := double.BuildCall(n)
+		if err != nil {
+			return 0, err
+		}
+		first, results, err := graph.Await(dispatchcoro.AwaitAll, nil, call, call)
+		if err != nil {
+			return 0, err
+		}
+		var doubled int
+		if output, ok := results[0].Output(); !ok {
+			return 0, fmt.Errorf("missing output")
+		} else if err := output.Unmarshal(&doubled); err != nil {
+			return 0, err
+		}
+
+		call, err = double.BuildCall(doubled)
+		if err != nil {
+			return 0, err
+		}
+		_, results, err = graph.Await(dispatchcoro.AwaitAll, []dispatchcoro.Step{first}, call)
+		if err != nil {
+			return 0, err
+		}
+		var quadrupled int
+		if output, ok := results[0].Output(); !ok {
+			return 0, fmt.Errorf("missing output")
+		} else if err := output.Unmarshal(&quadrupled); err != nil {
+			return 0, err
+		}
+		return quadrupled, nil
+	})
+
+	runner := dispatchtest.NewRunner(double, workflow)
+
+	output, err := dispatchtest.Call(runner, workflow, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if output != 12 {
+		t.Errorf("unexpected output: got %d, want 12", output)
+	}
+
+	steps := graph.Steps()
+	if len(steps) != 2 {
+		t.Fatalf("unexpected steps: %v", steps)
+	}
+	if got := steps[0].Functions; len(got) != 2 || got[0] != "double" || got[1] != "double" {
+		t.Errorf("unexpected functions of step 0: %v", got)
+	}
+	if got := steps[1].DependsOn; len(got) != 1 || got[0] != 0 {
+		t.Errorf("unexpected dependencies of step 1: %v", got)
+	}
+	if path, _ := graph.CriticalPath(); len(path) != 2 || path[0] != 0 || path[1] != 1 {
+		t.Errorf("unexpected critical path: %v", path)
+	}
+
+	var dot strings.Builder
+	if err := graph.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(dot.String(), "step0 -> step1;") {
+		t.Errorf("unexpected DOT output: %s", dot.String())
+	}
+}
+
+func TestFunctionPanic(t *testing.T) {
+	logMode(t)
+
+	identity := dispatch.Func("identity", func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	})
+	fn := dispatch.Func("panics", func(ctx context.Context, n int) (int, error) {
+		if n > 0 {
+			if _, err := identity.Await(n); err != nil {
+				return 0, err
+			}
+		}
+		panic("oops")
+	})
+
+	runner := dispatchtest.NewRunner(identity, fn)
+
+	// Panics before and after the coroutine is suspended are recovered.
+	for _, n := range []int{0, 1} {
+		res := runner.Run(dispatchproto.NewRequest("panics", dispatchproto.Int(int64(n))))
+		if res.Status() != dispatchproto.PermanentErrorStatus {
+			t.Errorf("unexpected status: %s", res.Status())
+		}
+		err, ok := res.Error()
+		if !ok {
+			t.Fatalf("expected error, got %s", res)
+		}
+		if err.Type() != "panic" || err.Message() != "function panics panicked: oops" {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if !strings.Contains(string(err.Traceback()), "TestFunctionPanic") {
+			t.Errorf("unexpected traceback: %s", err.Traceback())
+		}
+	}
+
+	// Other functions can still be called.
+	if output, err := dispatchtest.Call(runner, identity, 2); err != nil || output != 2 {
+		t.Errorf("unexpected result: %v, %v", output, err)
+	}
+}
+
+func TestPrimitiveFunctionAwaitAndGather(t *testing.T) {
+	double := dispatch.PrimitiveFunc("double", func(ctx context.Context, input dispatchproto.Any) (dispatchproto.Any, error) {
+		var n int
+		if err := input.Unmarshal(&n); err != nil {
+			return dispatchproto.Any{}, err
+		}
+		return dispatchproto.Int(int64(n * 2)), nil
+	})
+
+	orchestrate := dispatch.PrimitiveFunc("orchestrate", func(ctx context.Context, input dispatchproto.Any) (dispatchproto.Any, error) {
+		doubled, err := double.Await(input)
+		if err != nil {
+			return dispatchproto.Any{}, err
+		}
+		results, err := double.Gather([]dispatchproto.Any{doubled, doubled})
+		if err != nil {
+			return dispatchproto.Any{}, err
+		}
+		var sum int
+		for _, result := range results {
+			var n int
+			if err := result.Unmarshal(&n); err != nil {
+				return dispatchproto.Any{}, err
+			}
+			sum += n
+		}
+		return dispatchproto.Int(int64(sum)), nil
+	})
+
+	runner := dispatchtest.NewRunner(double, orchestrate)
+
+	output, err := dispatchtest.Call(runner, orchestrate, dispatchproto.Int(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	if err := output.Unmarshal(&sum); err != nil {
+		t.Fatal(err)
+	} else if sum != 24 {
+		t.Errorf("unexpected output: got %d, want 24", sum)
+	}
+}
+
 func TestFunctionNewCallAndDispatchWithoutEndpoint(t *testing.T) {
 	fn := dispatch.Func("foo", func(ctx context.Context, input string) (string, error) {
 		panic("not implemented")
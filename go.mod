@@ -9,7 +9,10 @@ require (
 	connectrpc.com/validate v0.1.0
 	github.com/aws/aws-lambda-go v1.47.0
 	github.com/dispatchrun/coroutine v0.9.1
+	github.com/google/go-cmp v0.6.0
 	github.com/offblocks/httpsig v0.8.1
+	golang.org/x/exp v0.0.0-20240613232115-7f521ea00fb8
+	golang.org/x/net v0.23.0
 	golang.org/x/sys v0.21.0
 	google.golang.org/protobuf v1.34.2
 )
@@ -20,9 +23,7 @@ require (
 	github.com/bufbuild/protovalidate-go v0.6.2 // indirect
 	github.com/dunglas/httpsfv v1.0.2 // indirect
 	github.com/google/cel-go v0.20.1 // indirect
-	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/stoewer/go-strcase v1.3.0 // indirect
-	golang.org/x/exp v0.0.0-20240613232115-7f521ea00fb8 // indirect
 	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20240617180043-68d350f18fd4 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240617180043-68d350f18fd4 // indirect
@@ -0,0 +1,85 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"net/http"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+)
+
+// DefaultHealthPath is the default path of the health endpoint
+// registered by Dispatch.Mux.
+const DefaultHealthPath = "/healthz"
+
+// MuxOption configures the HTTP handler returned by Dispatch.Mux.
+type MuxOption func(*muxConfig)
+
+type muxConfig struct {
+	healthPath        string
+	introspectionPath string
+}
+
+// HealthPath sets the path of the health endpoint registered by
+// Dispatch.Mux (DefaultHealthPath by default). The health endpoint
+// isn't registered if the path is empty.
+func HealthPath(path string) MuxOption {
+	return func(c *muxConfig) { c.healthPath = path }
+}
+
+// IntrospectionPath sets the path of an endpoint registered by
+// Dispatch.Mux that lists the functions of the endpoint as JSON (see
+// Dispatch.IntrospectionHandler). It isn't registered by default.
+func IntrospectionPath(path string) MuxOption {
+	return func(c *muxConfig) { c.introspectionPath = path }
+}
+
+// Handler returns an HTTP handler for Dispatch, along with the path
+// that the handler should be registered at.
+func (d *Dispatch) Handler() (string, http.Handler) {
+	return d.path, d.handler
+}
+
+// Mux returns an HTTP handler that serves the handler of the endpoint
+// (see Handler), alongside a health endpoint (see HealthPath) and
+// optionally an introspection endpoint (see IntrospectionPath), e.g. to
+// mount the endpoint in an existing HTTP server. The handler is wrapped
+// by the middleware set with ServeMiddleware, like the server used by
+// ListenAndServe.
+//
+// The health endpoint responds with 200 OK, or with 503 Service
+// Unavailable once the endpoint is shutting down (see Shutdown), so
+// that load balancers stop routing requests to it.
+func (d *Dispatch) Mux(opts ...MuxOption) http.Handler {
+	c := muxConfig{healthPath: DefaultHealthPath}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(d.Handler())
+	if c.healthPath != "" {
+		mux.HandleFunc(c.healthPath, d.serveHealth)
+	}
+	if c.introspectionPath != "" {
+		mux.Handle(c.introspectionPath, d.IntrospectionHandler())
+	}
+	return d.wrapMiddleware(mux)
+}
+
+// wrapMiddleware wraps a handler with the middleware set with
+// ServeMiddleware.
+func (d *Dispatch) wrapMiddleware(handler http.Handler) http.Handler {
+	for i := len(d.middleware) - 1; i >= 0; i-- {
+		handler = d.middleware[i](handler)
+	}
+	return handler
+}
+
+func (d *Dispatch) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if dispatchcoro.ShuttingDown(dispatchcoro.WithShutdownScope(r.Context(), d.shutdownScope)) {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,36 @@
+//go:build !durable
+
+package dispatch
+
+import "github.com/dispatchrun/dispatch-go/dispatchproto"
+
+// Interceptor wraps the execution of a function, e.g. to add logging,
+// metrics or panic recovery. It returns a function that calls next.
+type Interceptor func(next dispatchproto.Function) dispatchproto.Function
+
+// Interceptors sets interceptors that wrap the execution of all
+// functions registered on the endpoint, including primitive functions.
+//
+// Interceptors are applied in order: the first interceptor is the
+// outermost one. They run around the interceptors of each function
+// (see Function.WithInterceptors).
+func Interceptors(interceptors ...Interceptor) Option {
+	return optionFunc(func(d *Dispatch) { d.interceptors = append(d.interceptors, interceptors...) })
+}
+
+// WithInterceptors sets interceptors that wrap the execution of the
+// function, and returns the function.
+//
+// Interceptors are applied in order: the first interceptor is the
+// outermost one.
+func (f *Function[I, O]) WithInterceptors(interceptors ...Interceptor) *Function[I, O] {
+	f.interceptors = append(f.interceptors, interceptors...)
+	return f
+}
+
+func intercept(fn dispatchproto.Function, interceptors []Interceptor) dispatchproto.Function {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		fn = interceptors[i](fn)
+	}
+	return fn
+}
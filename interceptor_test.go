@@ -0,0 +1,57 @@
+package dispatch_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestInterceptors(t *testing.T) {
+	var calls []string
+	record := func(name string) dispatch.Interceptor {
+		return func(next dispatchproto.Function) dispatchproto.Function {
+			return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+				calls = append(calls, name+":"+req.Function())
+				return next(ctx, req)
+			}
+		}
+	}
+
+	endpoint, server, err := dispatchtest.NewEndpoint(dispatch.Interceptors(record("global1"), record("global2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	fn := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		calls = append(calls, "double")
+		return n * 2, nil
+	}).WithInterceptors(record("local"))
+	endpoint.Register(fn)
+	endpoint.RegisterPrimitive("primitive", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		calls = append(calls, "primitive")
+		return dispatchproto.NewResponse(dispatchproto.OKStatus)
+	})
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"double", "primitive"} {
+		if _, err := client.Run(context.Background(), dispatchproto.NewRequest(name, dispatchproto.Int(1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"global1:double", "global2:double", "local:double", "double",
+		"global1:primitive", "global2:primitive", "primitive",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("unexpected calls:\n got %v\nwant %v", calls, want)
+	}
+}
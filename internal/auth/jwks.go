@@ -0,0 +1,83 @@
+//go:build !durable
+
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxJWKSSize is the maximum size of a JSON Web Key Set fetched by
+// FetchJWKS.
+const maxJWKSSize = 1 << 20
+
+// ParseJWKS parses the ed25519 keys of a JSON Web Key Set (RFC 7517),
+// i.e. its keys of type "OKP" on curve "Ed25519" (RFC 8037). The ID of
+// each key ("kid") is the ID of the VerificationKey. Other keys, and
+// keys not meant for signatures, are ignored.
+//
+// It returns an error if the set has no ed25519 key.
+func ParseJWKS(data []byte) ([]VerificationKey, error) {
+	var jwks jsonWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JSON Web Key Set: %w", err)
+	}
+	var keys []VerificationKey
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key %q in JSON Web Key Set", jwk.Kid)
+		}
+		keys = append(keys, VerificationKey{KeyID: jwk.Kid, Key: ed25519.PublicKey(key)})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JSON Web Key Set has no ed25519 key")
+	}
+	return keys, nil
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+}
+
+// FetchJWKS fetches a JSON Web Key Set from a URL, and parses its
+// ed25519 keys (see ParseJWKS).
+func FetchJWKS(ctx context.Context, client *http.Client, url string) ([]VerificationKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching JSON Web Key Set: %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > maxJWKSSize {
+		return nil, errors.New("JSON Web Key Set is too large")
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func TestParseJWKS(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(publicKey)
+
+	keys, err := ParseJWKS([]byte(fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa", "n": "AQAB", "e": "AQAB"},
+		{"kty": "OKP", "crv": "Ed25519", "kid": "enc", "use": "enc", "x": %q},
+		{"kty": "OKP", "crv": "Ed25519", "kid": "key-1", "use": "sig", "x": %q}
+	]}`, x, x)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].KeyID != "key-1" || !keys[0].Key.Equal(publicKey) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	for _, data := range []string{
+		`not json`,
+		`{"keys": []}`,
+		`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`,
+		`{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AAAA"}]}`,
+	} {
+		if _, err := ParseJWKS([]byte(data)); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+}
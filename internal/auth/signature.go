@@ -4,12 +4,20 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"connectrpc.com/connect"
@@ -18,25 +26,99 @@ import (
 
 var digestor = httpsig.NewDigestor(httpsig.WithDigestAlgorithms(httpsig.DigestAlgorithmSha512))
 
+// DefaultKeyID is the key ID of the signatures of requests made by
+// Dispatch.
+const DefaultKeyID = "default"
+
 // Signer signs HTTP requests.
 type Signer struct {
 	signer *httpsig.Signer
+
+	// key is the Signer's own copy of the signing key, which is zeroed
+	// when the Signer is closed. It's nil if the key is held by an
+	// external signer.
+	key      ed25519.PrivateKey
+	external crypto.Signer
+
+	mu     sync.RWMutex
+	closed bool
 }
 
+// ErrSignerClosed is returned when signing a request with a Signer that
+// has been closed.
+var ErrSignerClosed = errors.New("signer is closed")
+
 // NewSigner creates a Signer that signs HTTP requests using the specified
 // signing key, in the same way that Dispatch would sign requests.
+//
+// The Signer holds a copy of the key, which is zeroed when the Signer is
+// closed. The caller is responsible for zeroing its own copy.
 func NewSigner(signingKey ed25519.PrivateKey) *Signer {
-	return &Signer{
-		signer: httpsig.NewSigner(
-			httpsig.WithSignName("dispatch"),
-			httpsig.WithSignEd25519("default", signingKey),
-			httpsig.WithSignFields("@method", "@path", "@authority", "content-type", "content-digest"),
-		),
+	return NewSignerWithKeyID(DefaultKeyID, signingKey)
+}
+
+// NewSignerWithKeyID is like NewSigner, but the signatures carry the
+// key ID, so that a Verifier holding multiple keys can tell which key
+// to verify them with (see VerificationKey.KeyID).
+func NewSignerWithKeyID(keyID string, signingKey ed25519.PrivateKey) *Signer {
+	key := slices.Clone(signingKey)
+	return &Signer{signer: newHTTPSigner(keyID, key), key: key}
+}
+
+// NewExternalSigner creates a Signer that signs HTTP requests with an
+// ed25519 key held by an external signer, e.g. a hardware security
+// module or a key management service, so that the signing key never
+// lives in process memory.
+//
+// The signer is called with the signature base of each request, and
+// crypto.Hash(0) as options, as for ed25519.PrivateKey.Sign.
+func NewExternalSigner(signer crypto.Signer) (*Signer, error) {
+	return NewExternalSignerWithKeyID(DefaultKeyID, signer)
+}
+
+// NewExternalSignerWithKeyID is like NewExternalSigner, but the
+// signatures carry the key ID (see NewSignerWithKeyID).
+func NewExternalSignerWithKeyID(keyID string, signer crypto.Signer) (*Signer, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("external signer must hold an ed25519 key, not %T", signer.Public())
 	}
+	return &Signer{signer: newHTTPSigner(keyID, placeholderKey), external: signer}, nil
+}
+
+// placeholderKey is the key that requests are first signed with when
+// the signing key is held by an external signer (see signExternal).
+var placeholderKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+func newHTTPSigner(keyID string, key ed25519.PrivateKey) *httpsig.Signer {
+	return httpsig.NewSigner(
+		httpsig.WithSignName("dispatch"),
+		httpsig.WithSignEd25519(keyID, key),
+		httpsig.WithSignFields("@method", "@path", "@authority", "content-type", "content-digest"),
+	)
+}
+
+// Close zeroes the signing key held by the Signer. Requests can't be
+// signed once the Signer is closed.
+//
+// External signers are not closed.
+func (s *Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.key)
+	s.closed = true
+	return nil
 }
 
 // Sign signs a request.
 func (s *Signer) Sign(req *http.Request) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrSignerClosed
+	}
+
 	body, err := io.ReadAll(req.Body)
 	_ = req.Body.Close()
 	if err != nil {
@@ -59,9 +141,51 @@ func (s *Signer) Sign(req *http.Request) error {
 		return fmt.Errorf("failed to sign request: %w", err)
 	}
 	req.Header = headers
+
+	if s.external != nil {
+		if err := s.signExternal(req); err != nil {
+			return fmt.Errorf("failed to sign request: %w", err)
+		}
+	}
 	return nil
 }
 
+// signExternal replaces the signature of a request signed with the
+// placeholder key by one made with the external signer.
+//
+// The httpsig library can only sign with keys held in memory, and
+// doesn't expose the signature base it signs. The base is recovered by
+// verifying the request with a key that records it instead.
+func (s *Signer) signExternal(req *http.Request) error {
+	var recorder baseRecorder
+	verifier := httpsig.NewVerifier(httpsig.WithVerifyingKeyResolver(&recorder))
+	if err := verifier.Verify(httpsig.MessageFromRequest(req)); err != nil {
+		return err
+	} else if recorder.base == nil {
+		return errors.New("signature base not found")
+	}
+	signature, err := s.external.Sign(rand.Reader, recorder.base, crypto.Hash(0))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(httpsig.SignatureHeader, "dispatch=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+type baseRecorder struct{ base []byte }
+
+func (r *baseRecorder) Resolve(ctx context.Context, keyID string) (httpsig.VerifyingKey, error) {
+	return r, nil
+}
+
+func (r *baseRecorder) Verify(data, signature []byte) error {
+	r.base = slices.Clone(data)
+	return nil
+}
+
+func (r *baseRecorder) GetKeyID() string                { return DefaultKeyID }
+func (r *baseRecorder) GetAlgorithm() httpsig.Algorithm { return httpsig.AlgorithmEd25519 }
+
 // Client wraps an HTTP client to automatically sign requests.
 func (s *Signer) Client(client connect.HTTPClient) *SigningClient {
 	return &SigningClient{client, s}
@@ -81,67 +205,301 @@ func (c *SigningClient) Do(req *http.Request) (*http.Response, error) {
 	return c.client.Do(req)
 }
 
+// Default limits on the age of request signatures.
+const (
+	// DefaultMaxAge is the default maximum age of a signature.
+	DefaultMaxAge = 5 * time.Minute
+
+	// DefaultTolerance is the default clock skew tolerated when
+	// checking the creation time of a signature.
+	DefaultTolerance = 5 * time.Second
+)
+
+// Reasons for rejecting a request, reported by VerificationError.
+const (
+	ReasonUnreadableBody     = "unreadable_body"
+	ReasonMissingDigest      = "missing_digest"
+	ReasonInvalidDigest      = "invalid_digest"
+	ReasonMissingSignature   = "missing_signature"
+	ReasonMalformedSignature = "malformed_signature"
+	ReasonExpiredSignature   = "expired_signature"
+	ReasonUnknownKey         = "unknown_key"
+	ReasonInvalidSignature   = "invalid_signature"
+)
+
+// VerificationError is the error returned when a request signature
+// cannot be verified.
+type VerificationError struct {
+	// Reason is the reason the request was rejected (see the Reason*
+	// constants).
+	Reason string
+
+	err error
+}
+
+func (e *VerificationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.err
+}
+
 // Verifier verifies that requests were signed by Dispatch.
 type Verifier struct {
+	keys atomic.Pointer[verifierKeys]
+
+	maxAge    time.Duration
+	tolerance time.Duration
+	logger    *slog.Logger
+
+	mu         sync.Mutex
+	rejections map[string]int64
+}
+
+// VerifierOption configures a Verifier.
+type VerifierOption func(*Verifier)
+
+// MaxAge sets the maximum age of a signature. It defaults to
+// DefaultMaxAge.
+func MaxAge(maxAge time.Duration) VerifierOption {
+	return func(v *Verifier) { v.maxAge = maxAge }
+}
+
+// Tolerance sets the clock skew tolerated when checking the creation
+// time of a signature. It defaults to DefaultTolerance.
+func Tolerance(tolerance time.Duration) VerifierOption {
+	return func(v *Verifier) { v.tolerance = tolerance }
+}
+
+// Logger sets the logger that rejected requests are logged to. It
+// defaults to slog.Default().
+func Logger(logger *slog.Logger) VerifierOption {
+	return func(v *Verifier) { v.logger = logger }
+}
+
+type principalKey struct {
+	principal string
 	verifier  *httpsig.Verifier
-	base64Key string
+}
+
+type verifierKeys struct {
+	keys       []principalKey
+	base64Keys []string
+}
+
+// VerificationKey is a public key that a Verifier verifies request
+// signatures with.
+type VerificationKey struct {
+	// Principal is the name of the principal that the key identifies,
+	// if any.
+	Principal string
+
+	// KeyID is the key ID carried by the signatures made with the key.
+	// It defaults to DefaultKeyID, the key ID of Dispatch.
+	//
+	// Giving keys distinct IDs lets the Verifier pick the right key when
+	// keys are rotated: signatures made with the old and new keys are
+	// both accepted until the old key is removed.
+	KeyID string
+
+	// Key is the ed25519 public key.
+	Key ed25519.PublicKey
 }
 
 // NewVerifier creates a Verifier that verifies that requests were
 // signed by Dispatch using the private key associated with this
 // public verification key.
-func NewVerifier(verificationKey ed25519.PublicKey) *Verifier {
-	verifier := httpsig.NewVerifier(
-		httpsig.WithVerifyEd25519("default", verificationKey),
-		httpsig.WithVerifyAll(true),
-		httpsig.WithVerifyMaxAge(5*time.Minute),
-		httpsig.WithVerifyTolerance(5*time.Second),
-		httpsig.WithVerifyRequiredParams("created"),
-		// The httpsig library checks the strings below against marshaled
-		// httpsfv items, hence the double quoting.
-		httpsig.WithVerifyRequiredFields(`"@method"`, `"@path"`, `"@authority"`, `"content-type"`, `"content-digest"`),
-	)
-	return &Verifier{
-		verifier:  verifier,
-		base64Key: base64.StdEncoding.EncodeToString(verificationKey[:]),
+func NewVerifier(verificationKey ed25519.PublicKey, opts ...VerifierOption) *Verifier {
+	return NewPrincipalVerifier(map[string]ed25519.PublicKey{"": verificationKey}, opts...)
+}
+
+// NewPrincipalVerifier creates a Verifier that verifies that requests
+// were signed using the private key associated with any of the public
+// verification keys, each of which identifies a named principal.
+//
+// Keys are tried in order of principal name.
+func NewPrincipalVerifier(verificationKeys map[string]ed25519.PublicKey, opts ...VerifierOption) *Verifier {
+	principals := make([]string, 0, len(verificationKeys))
+	for principal := range verificationKeys {
+		principals = append(principals, principal)
+	}
+	slices.Sort(principals)
+	keys := make([]VerificationKey, len(principals))
+	for i, principal := range principals {
+		keys[i] = VerificationKey{Principal: principal, Key: verificationKeys[principal]}
+	}
+	return NewKeysVerifier(keys, opts...)
+}
+
+// NewKeysVerifier creates a Verifier that verifies that requests were
+// signed using the private key associated with any of the public
+// verification keys.
+//
+// Keys are tried in order.
+func NewKeysVerifier(verificationKeys []VerificationKey, opts ...VerifierOption) *Verifier {
+	v := &Verifier{
+		maxAge:     DefaultMaxAge,
+		tolerance:  DefaultTolerance,
+		rejections: map[string]int64{},
 	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	v.SetKeys(verificationKeys)
+	return v
+}
+
+// SetKeys replaces the verification keys of the Verifier, e.g. when
+// keys are rotated. Requests that are being verified are verified with
+// the previous keys.
+//
+// Keys are tried in order.
+func (v *Verifier) SetKeys(verificationKeys []VerificationKey) {
+	keys := &verifierKeys{}
+	for _, key := range verificationKeys {
+		keyID := key.KeyID
+		if keyID == "" {
+			keyID = DefaultKeyID
+		}
+		keys.base64Keys = append(keys.base64Keys, base64.StdEncoding.EncodeToString(key.Key[:]))
+		keys.keys = append(keys.keys, principalKey{
+			principal: key.Principal,
+			verifier: httpsig.NewVerifier(
+				httpsig.WithVerifyEd25519(keyID, key.Key),
+				httpsig.WithVerifyAll(true),
+				httpsig.WithVerifyMaxAge(v.maxAge),
+				httpsig.WithVerifyTolerance(v.tolerance),
+				httpsig.WithVerifyRequiredParams("created"),
+				// The httpsig library checks the strings below against marshaled
+				// httpsfv items, hence the double quoting.
+				httpsig.WithVerifyRequiredFields(`"@method"`, `"@path"`, `"@authority"`, `"content-type"`, `"content-digest"`),
+			),
+		})
+	}
+	v.keys.Store(keys)
 }
 
 // Verify verifies that a request was signed by Dispatch.
+//
+// Errors are of type *VerificationError.
 func (v *Verifier) Verify(r *http.Request) error {
+	_, err := v.VerifyPrincipal(r)
+	return err
+}
+
+// VerifyPrincipal verifies that a request was signed by Dispatch, and
+// returns the principal whose key the request was signed with.
+//
+// Errors are of type *VerificationError.
+func (v *Verifier) VerifyPrincipal(r *http.Request) (string, error) {
 	var body []byte
 	if r.Body != nil {
 		var err error
 		body, err = io.ReadAll(r.Body)
 		_ = r.Body.Close()
 		if err != nil {
-			return fmt.Errorf("failed to read request body: %w", err)
+			return "", &VerificationError{ReasonUnreadableBody, fmt.Errorf("failed to read request body: %w", err)}
 		}
 		r.Body = io.NopCloser(bytes.NewReader(body))
 	}
 
 	// Verify the Content-Digest header.
 	if _, ok := r.Header[httpsig.ContentDigestHeader]; !ok {
-		return fmt.Errorf("missing Content-Digest header")
+		return "", &VerificationError{ReasonMissingDigest, fmt.Errorf("missing Content-Digest header")}
 	} else if err := digestor.Verify(body, r.Header); err != nil {
-		return fmt.Errorf("invalid Content-Digest header: %w", err)
+		return "", &VerificationError{ReasonInvalidDigest, fmt.Errorf("invalid Content-Digest header: %w", err)}
 	}
 
-	// Verify the signature.
-	if err := v.verifier.Verify(httpsig.MessageFromRequest(r)); err != nil {
-		return fmt.Errorf("missing or invalid signature: %w", err)
+	// Verify the signature, with each key in turn. All the keys are
+	// tried, so that the time it takes doesn't reveal which key the
+	// request was signed with. The error reported is the one of the
+	// first key with the key ID of the signature, or of the first key
+	// if none has it.
+	var principal string
+	var verified bool
+	var firstErr error
+	for _, key := range v.keys.Load().keys {
+		err := key.verifier.Verify(httpsig.MessageFromRequest(r))
+		if err == nil && !verified {
+			principal, verified = key.principal, true
+		} else if err != nil && (firstErr == nil || signatureErrorReason(firstErr) == ReasonUnknownKey && signatureErrorReason(err) != ReasonUnknownKey) {
+			firstErr = err
+		}
 	}
-	return nil
+	if verified {
+		return principal, nil
+	} else if firstErr == nil {
+		return "", &VerificationError{ReasonUnknownKey, errors.New("missing or invalid signature: no verification keys")}
+	}
+	return "", &VerificationError{signatureErrorReason(firstErr), fmt.Errorf("missing or invalid signature: %w", firstErr)}
+}
+
+// signatureErrorReason classifies errors from httpsig.Verifier.Verify.
+// The library doesn't export its errors, so they are matched by message.
+func signatureErrorReason(err error) string {
+	switch err.Error() {
+	case "signature headers not found":
+		return ReasonMissingSignature
+	case "signature expired":
+		return ReasonExpiredSignature
+	case "unknown key id", "algorithm mismatch for key id":
+		return ReasonUnknownKey
+	case "invalid signature":
+		return ReasonInvalidSignature
+	default:
+		return ReasonMalformedSignature
+	}
+}
+
+// Rejections returns the number of requests rejected by Middleware,
+// for each reason.
+func (v *Verifier) Rejections() map[string]int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return maps.Clone(v.rejections)
 }
 
 // Middleware wraps an HTTP handler in order to validate request signatures.
 func (v *Verifier) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := v.Verify(r); err != nil {
-			slog.Warn("Dispatch request signature was missing or invalid", "error", err, "verification_key", v.base64Key)
+		principal, err := v.VerifyPrincipal(r)
+		if err != nil {
+			reason := ReasonMalformedSignature
+			if verr, ok := err.(*VerificationError); ok {
+				reason = verr.Reason
+			}
+			v.mu.Lock()
+			v.rejections[reason]++
+			v.mu.Unlock()
+
+			logger := v.logger
+			if logger == nil {
+				logger = slog.Default()
+			}
+			logger.Warn("Dispatch request signature was missing or invalid", "error", err, "reason", reason, "verification_keys", v.keys.Load().base64Keys)
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
+		if principal != "" {
+			r = r.WithContext(WithPrincipal(r.Context(), principal))
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a context that carries the name of the
+// principal that signed a request.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFrom returns the name of the principal that signed a request
+// from a context returned by WithPrincipal.
+func PrincipalFrom(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(string)
+	return principal, ok
+}
@@ -2,7 +2,13 @@ package auth
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -28,7 +34,8 @@ func TestVerify(t *testing.T) {
 		signFields      []string
 		omitDigest      bool
 		omitCreated     bool
-		error           bool
+		opts            []VerifierOption
+		reason          string
 	}{
 		{
 			name:            "ok",
@@ -40,25 +47,25 @@ func TestVerify(t *testing.T) {
 			signingKey:      privateKey,
 			verificationKey: publicKey,
 			omitDigest:      true, // don't include Content-Digest header
-			error:           true,
+			reason:          ReasonMissingDigest,
 		},
 		{
 			name:            "missing signature",
 			signingKey:      nil,
 			verificationKey: publicKey,
-			error:           true,
+			reason:          ReasonMissingSignature,
 		},
 		{
 			name:            "key mismatch (1)",
 			signingKey:      altPrivateKey,
 			verificationKey: publicKey,
-			error:           true,
+			reason:          ReasonInvalidSignature,
 		},
 		{
 			name:            "key mismatch (2)",
 			signingKey:      privateKey,
 			verificationKey: altPublicKey,
-			error:           true,
+			reason:          ReasonInvalidSignature,
 		},
 		{
 			name:            "missing signature fields",
@@ -66,14 +73,14 @@ func TestVerify(t *testing.T) {
 			verificationKey: publicKey,
 			// missing the required content-digest field
 			signFields: []string{"@method", "@path", "@authority", "content-type"},
-			error:      true,
+			reason:     ReasonMalformedSignature,
 		},
 		{
 			name:            "missing 'created' signature param",
 			signingKey:      privateKey,
 			verificationKey: publicKey,
 			omitCreated:     true,
-			error:           true,
+			reason:          ReasonMalformedSignature,
 		},
 		{
 			name:            "created in the future (below tolerance)",
@@ -86,7 +93,7 @@ func TestVerify(t *testing.T) {
 			signingKey:      privateKey,
 			verificationKey: publicKey,
 			created:         time.Now().Add(1 * time.Minute),
-			error:           true,
+			reason:          ReasonExpiredSignature,
 		},
 		{
 			name:            "created in the past (within max_age)",
@@ -99,7 +106,22 @@ func TestVerify(t *testing.T) {
 			signingKey:      privateKey,
 			verificationKey: publicKey,
 			created:         time.Now().Add(-10 * time.Minute),
-			error:           true,
+			reason:          ReasonExpiredSignature,
+		},
+		{
+			name:            "created in the future (within custom tolerance)",
+			signingKey:      privateKey,
+			verificationKey: publicKey,
+			created:         time.Now().Add(1 * time.Minute),
+			opts:            []VerifierOption{Tolerance(2 * time.Minute)},
+		},
+		{
+			name:            "created in the past (outside of custom max_age)",
+			signingKey:      privateKey,
+			verificationKey: publicKey,
+			created:         time.Now().Add(-2 * time.Minute),
+			opts:            []VerifierOption{MaxAge(1 * time.Minute)},
+			reason:          ReasonExpiredSignature,
 		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
@@ -145,12 +167,169 @@ func TestVerify(t *testing.T) {
 				req.Header.Del(httpsig.ContentDigestHeader)
 			}
 
-			verifier := NewVerifier(test.verificationKey)
-			if err := verifier.Verify(req); !test.error && err != nil {
-				t.Fatal(err)
-			} else if test.error && err == nil {
-				t.Fatal("expected an error")
+			verifier := NewVerifier(test.verificationKey, test.opts...)
+			err = verifier.Verify(req)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			verr, ok := err.(*VerificationError)
+			if !ok {
+				t.Fatalf("expected a *VerificationError, got %v", err)
+			} else if verr.Reason != test.reason {
+				t.Errorf("unexpected reason: got %q, want %q (%v)", verr.Reason, test.reason, err)
 			}
 		})
 	}
 }
+
+func TestSigner(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewVerifier(publicKey)
+
+	signer := NewSigner(privateKey)
+	req := newUnsignedRequest(t)
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err)
+	} else if err := verifier.Verify(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the signer zeroes its copy of the key, but not the
+	// caller's.
+	if err := signer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signer.key, make([]byte, ed25519.PrivateKeySize)) {
+		t.Error("signing key was not zeroed")
+	}
+	if bytes.Equal(privateKey, make([]byte, ed25519.PrivateKeySize)) {
+		t.Error("caller's signing key was zeroed")
+	}
+	if err := signer.Sign(newUnsignedRequest(t)); !errors.Is(err, ErrSignerClosed) {
+		t.Errorf("expected ErrSignerClosed, got %v", err)
+	}
+}
+
+func TestExternalSigner(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	external := &countingSigner{Signer: privateKey}
+	signer, err := NewExternalSigner(external)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newUnsignedRequest(t)
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if external.calls != 1 {
+		t.Errorf("unexpected number of calls to the external signer: %d", external.calls)
+	}
+	if err := NewVerifier(publicKey).Verify(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// The placeholder key doesn't produce valid signatures.
+	placeholder := NewVerifier(placeholderKey.Public().(ed25519.PublicKey))
+	if err := placeholder.Verify(req); err == nil {
+		t.Error("expected the signature to be invalid for the placeholder key")
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewExternalSigner(ecdsaKey); err == nil {
+		t.Error("expected an error for a non-ed25519 signer")
+	}
+
+	// The signatures carry the key ID.
+	signer, err = NewExternalSignerWithKeyID("key-1", external)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = newUnsignedRequest(t)
+	if err := signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewKeysVerifier([]VerificationKey{{KeyID: "key-1", Key: publicKey}}).Verify(req); err != nil {
+		t.Error(err)
+	}
+	if err := NewVerifier(publicKey).Verify(req); err == nil {
+		t.Error("expected the signature to carry the key ID")
+	}
+}
+
+func TestKeyIDs(t *testing.T) {
+	oldPublicKey, oldPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPublicKey, newPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewKeysVerifier([]VerificationKey{
+		{Principal: "old", KeyID: "key-1", Key: oldPublicKey},
+		{Principal: "new", KeyID: "key-2", Key: newPublicKey},
+	})
+
+	for _, test := range []struct {
+		signer    *Signer
+		principal string
+		reason    string
+	}{
+		{signer: NewSignerWithKeyID("key-1", oldPrivateKey), principal: "old"},
+		{signer: NewSignerWithKeyID("key-2", newPrivateKey), principal: "new"},
+		{signer: NewSignerWithKeyID("key-1", newPrivateKey), reason: ReasonInvalidSignature},
+		{signer: NewSignerWithKeyID("key-3", newPrivateKey), reason: ReasonUnknownKey},
+		{signer: NewSigner(oldPrivateKey), reason: ReasonUnknownKey},
+	} {
+		req := newUnsignedRequest(t)
+		if err := test.signer.Sign(req); err != nil {
+			t.Fatal(err)
+		}
+		principal, err := verifier.VerifyPrincipal(req)
+		if test.reason == "" {
+			if err != nil {
+				t.Error(err)
+			} else if principal != test.principal {
+				t.Errorf("unexpected principal: got %q, want %q", principal, test.principal)
+			}
+			continue
+		}
+		if verr, ok := err.(*VerificationError); !ok {
+			t.Errorf("expected a *VerificationError, got %v", err)
+		} else if verr.Reason != test.reason {
+			t.Errorf("unexpected reason: got %q, want %q (%v)", verr.Reason, test.reason, err)
+		}
+	}
+}
+
+type countingSigner struct {
+	crypto.Signer
+	calls int
+}
+
+func (s *countingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func newUnsignedRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest("POST", "http://example.com/function", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	return req
+}
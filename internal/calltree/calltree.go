@@ -0,0 +1,121 @@
+//go:build !durable
+
+// Package calltree tracks the trees of function calls in flight, to
+// limit their depth.
+package calltree
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// DefaultTTL is the default time after which calls that were not seen
+// again are considered to be no longer in flight.
+const DefaultTTL = time.Hour
+
+// Tracker tracks function calls in flight, and the calls that made
+// them.
+//
+// Calls that suspend may be resumed by another process, or never be
+// resumed, so they're forgotten if they're not seen again (see Enter)
+// within the TTL.
+type Tracker struct {
+	// TTL is the time after which calls that were not seen again are
+	// forgotten (DefaultTTL if zero).
+	TTL time.Duration
+
+	mu    sync.Mutex
+	calls map[dispatchproto.ID]call
+	swept time.Time
+}
+
+type call struct {
+	function string
+	parent   dispatchproto.ID
+	seen     time.Time
+}
+
+// Enter records that a call to a function is in flight at the
+// specified time, and returns the chain of functions from the root of
+// the call tree to the call. Calls are entered again when they resume,
+// to extend their TTL.
+//
+// Calls with an unknown parent (e.g. made by another process) are
+// considered to be the root of a call tree.
+func (t *Tracker) Enter(id, parent dispatchproto.ID, function string, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.calls == nil {
+		t.calls = map[dispatchproto.ID]call{}
+	}
+	t.sweep(now)
+	if id != "" {
+		t.calls[id] = call{function: function, parent: parent, seen: now}
+	}
+
+	chain := []string{function}
+	for parent != "" && len(chain) <= len(t.calls) {
+		c, ok := t.calls[parent]
+		if !ok {
+			break
+		}
+		chain = append(chain, c.function)
+		parent = c.parent
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// sweep forgets the calls that were not seen within the TTL, at most
+// once per minute.
+func (t *Tracker) sweep(now time.Time) {
+	if now.Sub(t.swept) < time.Minute {
+		return
+	}
+	t.swept = now
+	ttl := t.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	for id, c := range t.calls {
+		if now.Sub(c.seen) >= ttl {
+			delete(t.calls, id)
+		}
+	}
+}
+
+// Len returns the number of calls being tracked.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.calls)
+}
+
+// Exit records that a call is no longer in flight.
+func (t *Tracker) Exit(id dispatchproto.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.calls, id)
+}
+
+// Check returns an error if a chain of functions returned by Enter
+// exceeds the maximum depth.
+func Check(chain []string, maxDepth int) error {
+	if len(chain) <= maxDepth {
+		return nil
+	}
+	path := strings.Join(chain, " -> ")
+	function := chain[len(chain)-1]
+	if slices.Contains(chain[:len(chain)-1], function) {
+		return fmt.Errorf("maximum call depth of %d exceeded by recursive calls to %s: %s", maxDepth, function, path)
+	}
+	return fmt.Errorf("maximum call depth of %d exceeded: %s", maxDepth, path)
+}
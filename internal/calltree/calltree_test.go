@@ -0,0 +1,33 @@
+package calltree_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/internal/calltree"
+)
+
+func TestTrackerTTL(t *testing.T) {
+	tracker := &calltree.Tracker{TTL: 10 * time.Minute}
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracker.Enter("1", "", "parent", now)
+	tracker.Enter("2", "1", "child", now)
+
+	// The parent suspends, and resumes within the TTL.
+	if chain := tracker.Enter("1", "", "parent", now.Add(9*time.Minute)); !slices.Equal(chain, []string{"parent"}) {
+		t.Errorf("unexpected chain: %v", chain)
+	}
+	if chain := tracker.Enter("3", "1", "child", now.Add(15*time.Minute)); !slices.Equal(chain, []string{"parent", "child"}) {
+		t.Errorf("unexpected chain: %v", chain)
+	}
+
+	// Calls that are not seen again within the TTL are forgotten.
+	if chain := tracker.Enter("4", "1", "child", now.Add(20*time.Minute)); !slices.Equal(chain, []string{"child"}) {
+		t.Errorf("unexpected chain: %v", chain)
+	}
+	if n := tracker.Len(); n != 2 { // calls 3 and 4
+		t.Errorf("unexpected number of calls: %d", n)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !durable && !dispatchlite
+
+package validator
+
+import (
+	"connectrpc.com/connect"
+	"connectrpc.com/validate"
+)
+
+// Enabled is true if the messages of the Dispatch protocol are
+// validated against the constraints of their schema.
+const Enabled = true
+
+// Interceptors returns the interceptors that validate the messages
+// of the Dispatch protocol.
+func Interceptors() ([]connect.Interceptor, error) {
+	validator, err := validate.NewInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	return []connect.Interceptor{validator}, nil
+}
@@ -0,0 +1,19 @@
+//go:build !durable && dispatchlite
+
+package validator
+
+import "connectrpc.com/connect"
+
+// Enabled is true if the messages of the Dispatch protocol are
+// validated against the constraints of their schema.
+//
+// Validation is disabled in lite mode, since the validator (and the
+// CEL runtime it depends on) accounts for a large share of the binary
+// size and initialization time.
+const Enabled = false
+
+// Interceptors returns the interceptors that validate the messages
+// of the Dispatch protocol. There are none in lite mode.
+func Interceptors() ([]connect.Interceptor, error) {
+	return nil, nil
+}
@@ -0,0 +1,11 @@
+//go:build dispatchlite
+
+package validator
+
+import "testing"
+
+func TestLiteMode(t *testing.T) {
+	if Enabled {
+		t.Error("expected validation to be disabled in lite mode")
+	}
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	interceptors, err := Interceptors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	if Enabled {
+		want = 1
+	}
+	if len(interceptors) != want {
+		t.Errorf("unexpected interceptors: got %d, want %d", len(interceptors), want)
+	}
+}
+
+// TestLiteDependencies checks that the packages stripped in lite mode
+// aren't linked into programs by other means, and that the optional
+// subsystems are only linked into programs that import them.
+func TestLiteDependencies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	for _, pkg := range []string{
+		"github.com/dispatchrun/dispatch-go",
+		"github.com/dispatchrun/dispatch-go/dispatchclient",
+		"github.com/dispatchrun/dispatch-go/dispatchserver",
+	} {
+		out, err := exec.Command(goTool, "list", "-deps", "-tags", "dispatchlite", pkg).Output()
+		if err != nil {
+			t.Fatalf("go list %s: %v", pkg, err)
+		}
+		for _, dep := range strings.Fields(string(out)) {
+			for _, stripped := range []string{
+				"connectrpc.com/validate",
+				"github.com/bufbuild/protovalidate-go",
+				"github.com/google/cel-go",
+				"github.com/dispatchrun/dispatch-go/dispatchai",
+				"github.com/dispatchrun/dispatch-go/dispatchbackfill",
+				"github.com/dispatchrun/dispatch-go/dispatchcron",
+				"github.com/dispatchrun/dispatch-go/dispatchhttp",
+				"github.com/dispatchrun/dispatch-go/dispatchlambda",
+				"github.com/dispatchrun/dispatch-go/dispatchtest",
+			} {
+				if dep == stripped || strings.HasPrefix(dep, stripped+"/") {
+					t.Errorf("package %s depends on %s in lite mode", pkg, dep)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !durable && go1.23
+
+package dispatch
+
+import (
+	"iter"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// GatherSeq makes many concurrent calls to the function, and yields the
+// results in the order the calls complete (see dispatchcoro.GatherSeq).
+//
+// GatherSeq should only be called within a Dispatch Function (created via Func).
+func (f *Function[I, O]) GatherSeq(inputs []I, opts ...dispatchproto.CallOption) iter.Seq2[O, error] {
+	return f.GatherSeqN(inputs, 0, opts...)
+}
+
+// GatherSeqN is like GatherSeq, but keeps at most maxConcurrent calls in
+// flight (see dispatchcoro.GatherSeqN). It's useful for very large
+// fan-outs, whose outputs shouldn't all be held in memory at once.
+//
+// GatherSeqN should only be called within a Dispatch Function (created via Func).
+func (f *Function[I, O]) GatherSeqN(inputs []I, maxConcurrent int, opts ...dispatchproto.CallOption) iter.Seq2[O, error] {
+	calls, err := f.buildCalls(inputs, opts)
+	if err != nil {
+		return func(yield func(O, error) bool) {
+			var zero O
+			yield(zero, err)
+		}
+	}
+	return dispatchcoro.GatherSeqN[O](maxConcurrent, calls...)
+}
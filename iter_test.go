@@ -0,0 +1,119 @@
+//go:build go1.23
+
+package dispatch_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestCoroutineGatherSeq(t *testing.T) {
+	logMode(t)
+
+	identity := dispatch.Func("identity", func(ctx context.Context, x string) (string, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	firstTwo := dispatch.Func("first_two", func(ctx context.Context, _ int) (string, error) {
+		var outputs []string
+		for output, err := range identity.GatherSeq([]string{"a", "b", "c"}) {
+			if err != nil {
+				return "", err
+			}
+			outputs = append(outputs, output)
+			if len(outputs) == 2 {
+				break
+			}
+		}
+		return strings.Join(outputs, ""), nil
+	})
+
+	runner := dispatchtest.NewRunner(firstTwo)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("first_two", dispatchproto.Int(0)))
+	poll := dispatchtest.AssertPollCalls(t, res,
+		dispatchtest.MatchCall("identity", "a"),
+		dispatchtest.MatchCall("identity", "b"),
+		dispatchtest.MatchCall("identity", "c"),
+	)
+	calls := poll.Calls()
+
+	// Deliver results out of order, one at a time.
+	for _, i := range []int{2, 0} {
+		pollResult := poll.Result().With(dispatchproto.CallResults(
+			dispatchproto.NewCallResult(calls[i].Input(), dispatchproto.CorrelationID(calls[i].CorrelationID()))))
+		res = runner.RoundTrip(dispatchproto.NewRequest("first_two", pollResult))
+	}
+
+	dispatchtest.AssertExit(t, res, "ca")
+}
+
+func TestCoroutineGatherSeqN(t *testing.T) {
+	logMode(t)
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		panic("not implemented") // this is a mock only
+	})
+
+	sum := dispatch.Func("sum", func(ctx context.Context, n int) (int, error) {
+		inputs := make([]int, n)
+		for i := range inputs {
+			inputs[i] = i
+		}
+		var sum int
+		for output, err := range double.GatherSeqN(inputs, 3) {
+			if err != nil {
+				return 0, err
+			}
+			sum += output
+		}
+		return sum, nil
+	})
+
+	runner := dispatchtest.NewRunner(sum)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("sum", dispatchproto.Int(10)))
+
+	var inflight []dispatchproto.Call
+	var submitted int
+	for {
+		poll, ok := res.Poll()
+		if !ok {
+			break
+		}
+		inflight = append(inflight, poll.Calls()...)
+		submitted += len(poll.Calls())
+		if len(inflight) > 3 {
+			t.Fatalf("too many calls in flight: %d", len(inflight))
+		}
+		if minResults := poll.MinResults(); minResults != 1 {
+			t.Errorf("unexpected min results: got %d, want 1", minResults)
+		}
+		if maxResults := int(poll.MaxResults()); maxResults != len(inflight) {
+			t.Errorf("unexpected max results: got %d, want %d", maxResults, len(inflight))
+		}
+
+		// Complete the oldest call in flight.
+		call := inflight[0]
+		inflight = inflight[1:]
+		var n int
+		if err := call.Input().Unmarshal(&n); err != nil {
+			t.Fatal(err)
+		}
+		result := dispatchproto.NewCallResult(
+			dispatchproto.Int(int64(n*2)),
+			dispatchproto.CorrelationID(call.CorrelationID()))
+
+		res = runner.RoundTrip(dispatchproto.NewRequest("sum", poll.Result().With(dispatchproto.CallResults(result))))
+	}
+	if submitted != 10 {
+		t.Errorf("unexpected number of calls: got %d, want 10", submitted)
+	}
+
+	dispatchtest.AssertExit(t, res, 90)
+}
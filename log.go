@@ -0,0 +1,88 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Logger sets the logger of the Dispatch endpoint, and of the client it
+// creates to dispatch calls (see Client).
+//
+// Events are logged with structured attributes, e.g. the name of the
+// function and the dispatch ID of requests, so that the handler of the
+// logger can route and format them. Requests, responses and coroutines
+// that suspend or resume are logged at the Debug level, and responses
+// that carry an error at the Warn level.
+//
+// It defaults to slog.Default().
+func Logger(logger *slog.Logger) Option {
+	return optionFunc(func(d *Dispatch) { d.logger = logger })
+}
+
+// log returns the logger of the endpoint. It's safe to call on a nil
+// endpoint, e.g. from functions that aren't registered on one.
+func (d *Dispatch) log() *slog.Logger {
+	if d == nil || d.logger == nil {
+		return slog.Default()
+	}
+	return d.logger
+}
+
+// requestAttrs returns the attributes that identify a request in logs.
+func requestAttrs(req dispatchproto.Request) []any {
+	attrs := []any{"function", req.Function()}
+	if id := req.DispatchID(); id != "" {
+		attrs = append(attrs, "dispatch_id", id)
+	}
+	if id := req.ParentID(); id != "" {
+		attrs = append(attrs, "parent_id", id)
+	}
+	if id := req.RootID(); id != "" {
+		attrs = append(attrs, "root_id", id)
+	}
+	return attrs
+}
+
+// debugRequest logs an event related to a request at the Debug level.
+func debugRequest(ctx context.Context, logger *slog.Logger, msg string, req dispatchproto.Request, attrs ...any) {
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		logger.DebugContext(ctx, msg, append(requestAttrs(req), attrs...)...)
+	}
+}
+
+func (d *Dispatch) logRequest(ctx context.Context, req dispatchproto.Request) {
+	if pollResult, ok := req.PollResult(); ok {
+		debugRequest(ctx, d.log(), "Dispatch request received", req, "directive", "poll_result", "call_results", len(pollResult.Results()))
+	} else {
+		debugRequest(ctx, d.log(), "Dispatch request received", req, "directive", "input")
+	}
+}
+
+func (d *Dispatch) logResponse(ctx context.Context, req dispatchproto.Request, res dispatchproto.Response, duration time.Duration) {
+	level := slog.LevelDebug
+	if _, ok := res.Error(); ok {
+		level = slog.LevelWarn
+	}
+	logger := d.log()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := append(requestAttrs(req), "status", res.Status().String(), "duration", duration)
+	if poll, ok := res.Poll(); ok {
+		attrs = append(attrs, "directive", "poll", "calls", len(poll.Calls()))
+	} else if exit, ok := res.Exit(); ok {
+		attrs = append(attrs, "directive", "exit")
+		if call, ok := exit.TailCall(); ok {
+			attrs = append(attrs, "tail_call", call.Function())
+		}
+	}
+	if err, ok := res.Error(); ok {
+		attrs = append(attrs, "error_type", err.Type(), "error", err.Message())
+	}
+	logger.Log(ctx, level, "Dispatch response sent", attrs...)
+}
@@ -0,0 +1,165 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// SnapshotTypeURL is the type URL of the state of functions created
+// with MachineFunc, which is distinct from the type URL of the state of
+// durable coroutines (see dispatchcoro.StateTypeURL).
+const SnapshotTypeURL = "buf.build/dispatchrun/dispatch-go/dispatch.Snapshot"
+
+// Snapshotter is implemented by state that is carried across
+// suspensions of a function created with MachineFunc.
+type Snapshotter interface {
+	// Snapshot serializes the state.
+	Snapshot() ([]byte, error)
+
+	// Restore restores the state from a snapshot.
+	Restore(snapshot []byte) error
+}
+
+// Machine is a function implemented as an explicit state machine.
+//
+// Functions created with Func are suspended and resumed as coroutines,
+// which requires compiling them with coroc to run in durable mode. A
+// Machine carries its own state instead: it's snapshotted when it
+// waits for the results of calls, and restored when the results are
+// available. This suits simple workflows, without the cost of durable
+// compilation.
+type Machine[I, O any] interface {
+	Snapshotter
+
+	// Start starts the machine with the input of the function.
+	Start(ctx context.Context, input I) (Transition[O], error)
+
+	// Resume resumes the machine with the results of the calls it
+	// awaits. Results may be a subset of the calls, e.g. after a
+	// timeout; their correlation IDs identify the calls they're for.
+	Resume(ctx context.Context, results []dispatchproto.CallResult) (Transition[O], error)
+}
+
+// Transition is the outcome of a step of a Machine: it either awaits
+// the results of calls, or returns the output of the function.
+type Transition[O any] struct {
+	calls  []dispatchproto.Call
+	output O
+	done   bool
+
+	minResults    int
+	hasMinResults bool
+	maxWait       time.Duration
+	hasMaxWait    bool
+}
+
+// defaultMachineMaxWait is the default max wait of the polls of a
+// Machine (see Transition.WithMaxWait).
+const defaultMachineMaxWait = 5 * time.Minute
+
+// AwaitCalls makes calls and suspends the Machine until their results
+// are available.
+//
+// Without calls, the Machine is resumed right away with the results of
+// calls made previously that are available, if any. Use WithMinResults
+// to wait for some of them instead.
+func AwaitCalls[O any](calls ...dispatchproto.Call) Transition[O] {
+	return Transition[O]{calls: calls}
+}
+
+// WithMinResults sets the number of results that the Machine waits for
+// before it's resumed, which may include the results of calls made
+// previously. It defaults to the number of calls made by the transition.
+func (t Transition[O]) WithMinResults(n int) Transition[O] {
+	t.minResults, t.hasMinResults = max(n, 0), true
+	return t
+}
+
+// WithMaxWait sets the maximum time that the Machine waits for the min
+// results before it's resumed with the results available. It defaults
+// to 5 minutes.
+func (t Transition[O]) WithMaxWait(maxWait time.Duration) Transition[O] {
+	t.maxWait, t.hasMaxWait = maxWait, true
+	return t
+}
+
+// poll creates the Poll directive of a transition that awaits calls.
+func (t Transition[O]) poll(state dispatchproto.Any) dispatchproto.Poll {
+	minResults := len(t.calls)
+	if t.hasMinResults {
+		minResults = t.minResults
+	}
+	maxWait := defaultMachineMaxWait
+	if t.hasMaxWait {
+		maxWait = t.maxWait
+	}
+	maxResults := max(len(t.calls), minResults, 1)
+	return dispatchproto.NewPoll(minResults, maxResults, maxWait,
+		dispatchproto.Calls(t.calls...),
+		dispatchproto.CoroutineState(state))
+}
+
+// Return returns the output of a Machine.
+func Return[O any](output O) Transition[O] {
+	return Transition[O]{output: output, done: true}
+}
+
+// MachineFunc creates a Function implemented by a Machine. A new
+// Machine is created with newMachine for each step of each call.
+func MachineFunc[I, O any](name string, newMachine func() Machine[I, O]) *Function[I, O] {
+	return &Function[I, O]{name: name, machine: newMachine}
+}
+
+func (f *Function[I, O]) runMachine(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+	principal, _ := Principal(ctx)
+	fnctx := f.context(principal)
+	m := f.machine()
+
+	var transition Transition[O]
+	var err error
+	if pollResult, ok := req.PollResult(); ok {
+		if err, ok := pollResult.Error(); ok {
+			return dispatchproto.NewResponseErrorf("poll error: %w", err)
+		}
+		state := pollResult.CoroutineState()
+		if state.TypeURL() != SnapshotTypeURL {
+			return dispatchproto.NewResponseErrorf("%w: cannot restore snapshot: unexpected type URL %q", ErrIncompatibleState, state.TypeURL())
+		}
+		if err := m.Restore(anyProto(state).GetValue()); err != nil {
+			return dispatchproto.NewResponseErrorf("%w: cannot restore snapshot: %v", ErrIncompatibleState, err)
+		}
+		transition, err = m.Resume(fnctx, pollResult.Results())
+	} else if boxedInput, ok := req.Input(); ok {
+		var input I
+		if err := f.unmarshalInput(ctx, boxedInput, &input); err != nil {
+			return dispatchproto.NewResponseErrorf("%w: invalid input of type %s (%d bytes): %v", ErrInvalidArgument, boxedInput.TypeURL(), len(boxedInput.Value()), err)
+		}
+		transition, err = m.Start(fnctx, input)
+	} else {
+		return dispatchproto.NewResponseErrorf("%w: unsupported request: %v", ErrInvalidArgument, req)
+	}
+	if err != nil {
+		return newResponseError(err)
+	}
+
+	if transition.done {
+		boxedOutput, err := f.marshalOutput(ctx, transition.output)
+		if err != nil {
+			return dispatchproto.NewResponseErrorf("%w: invalid output %v: %v", ErrInvalidResponse, transition.output, err)
+		}
+		return dispatchproto.NewResponse(dispatchproto.StatusOf(transition.output), boxedOutput)
+	}
+
+	snapshot, err := m.Snapshot()
+	if err != nil {
+		return dispatchproto.NewResponseErrorf("%w: cannot snapshot state: %v", ErrPermanent, err)
+	}
+	state := newProtoAny(&anypb.Any{TypeUrl: SnapshotTypeURL, Value: snapshot})
+
+	return dispatchproto.NewResponse(transition.poll(state))
+}
@@ -0,0 +1,167 @@
+package dispatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+type sumMachine struct {
+	double *dispatch.Function[int, int]
+
+	Pending int `json:"pending"`
+	Sum     int `json:"sum"`
+}
+
+func (m *sumMachine) Snapshot() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func (m *sumMachine) Restore(snapshot []byte) error {
+	return json.Unmarshal(snapshot, m)
+}
+
+func (m *sumMachine) Start(ctx context.Context, n int) (dispatch.Transition[int], error) {
+	calls := make([]dispatchproto.Call, n)
+	for i := range calls {
+		call, err := m.double.BuildCall(i + 1)
+		if err != nil {
+			return dispatch.Transition[int]{}, err
+		}
+		calls[i] = call
+	}
+	m.Pending = n
+	return dispatch.AwaitCalls[int](calls...), nil
+}
+
+func (m *sumMachine) Resume(ctx context.Context, results []dispatchproto.CallResult) (dispatch.Transition[int], error) {
+	for _, result := range results {
+		if err, ok := result.Error(); ok {
+			return dispatch.Transition[int]{}, err
+		}
+		var output int
+		if boxedOutput, ok := result.Output(); ok {
+			if err := boxedOutput.Unmarshal(&output); err != nil {
+				return dispatch.Transition[int]{}, err
+			}
+		}
+		m.Sum += output
+		m.Pending--
+	}
+	if m.Pending > 0 {
+		return dispatch.AwaitCalls[int]().WithMinResults(m.Pending), nil
+	}
+	return dispatch.Return(m.Sum), nil
+}
+
+func TestMachineFunc(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	sum := dispatch.MachineFunc("sum", func() dispatch.Machine[int, int] {
+		return &sumMachine{double: double}
+	})
+
+	runner := dispatchtest.NewRunner(double, sum)
+
+	res := runner.RoundTrip(dispatchproto.NewRequest("sum", dispatchproto.Int(3)))
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	} else if got := poll.CoroutineState().TypeURL(); got != dispatch.SnapshotTypeURL {
+		t.Errorf("unexpected state type URL: %q", got)
+	} else if len(poll.Calls()) != 3 {
+		t.Errorf("unexpected calls: %s", poll)
+	}
+
+	output, err := dispatchtest.Call(runner, sum, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if output != 12 {
+		t.Errorf("unexpected output: got %d, want 12", output)
+	}
+}
+
+func TestMachinePoll(t *testing.T) {
+	call := dispatchproto.NewCall("", "double", dispatchproto.Int(1))
+
+	for _, test := range []struct {
+		name       string
+		transition dispatch.Transition[int]
+		minResults int
+		maxResults int
+		maxWait    time.Duration
+	}{
+		{
+			name:       "calls",
+			transition: dispatch.AwaitCalls[int](call, call),
+			minResults: 2,
+			maxResults: 2,
+			maxWait:    5 * time.Minute,
+		},
+		{
+			name:       "no calls",
+			transition: dispatch.AwaitCalls[int](),
+			minResults: 0,
+			maxResults: 1,
+			maxWait:    5 * time.Minute,
+		},
+		{
+			name:       "min results",
+			transition: dispatch.AwaitCalls[int](call).WithMinResults(3),
+			minResults: 3,
+			maxResults: 3,
+			maxWait:    5 * time.Minute,
+		},
+		{
+			name:       "max wait",
+			transition: dispatch.AwaitCalls[int](call).WithMaxWait(time.Second),
+			minResults: 1,
+			maxResults: 1,
+			maxWait:    time.Second,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			machine := dispatch.MachineFunc("machine", func() dispatch.Machine[int, int] {
+				return &transitionMachine{transition: test.transition}
+			})
+			runner := dispatchtest.NewRunner(machine)
+
+			res := runner.RoundTrip(dispatchproto.NewRequest("machine", dispatchproto.Int(0)))
+			poll, ok := res.Poll()
+			if !ok {
+				t.Fatalf("expected poll response, got %s", res)
+			}
+			if got := int(poll.MinResults()); got != test.minResults {
+				t.Errorf("unexpected min results: got %d, want %d", got, test.minResults)
+			}
+			if got := int(poll.MaxResults()); got != test.maxResults {
+				t.Errorf("unexpected max results: got %d, want %d", got, test.maxResults)
+			}
+			if got := poll.MaxWait(); got != test.maxWait {
+				t.Errorf("unexpected max wait: got %v, want %v", got, test.maxWait)
+			}
+		})
+	}
+}
+
+type transitionMachine struct {
+	transition dispatch.Transition[int]
+}
+
+func (m *transitionMachine) Snapshot() ([]byte, error) { return nil, nil }
+
+func (m *transitionMachine) Restore(snapshot []byte) error { return nil }
+
+func (m *transitionMachine) Start(ctx context.Context, n int) (dispatch.Transition[int], error) {
+	return m.transition, nil
+}
+
+func (m *transitionMachine) Resume(ctx context.Context, results []dispatchproto.CallResult) (dispatch.Transition[int], error) {
+	return dispatch.Return(0), nil
+}
@@ -0,0 +1,36 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+
+	"github.com/dispatchrun/dispatch-go/internal/auth"
+)
+
+// VerificationPrincipal adds a verification key that identifies a named
+// principal, e.g. to tell apart requests signed by a sandbox and a
+// production environment of Dispatch in a single endpoint. The option
+// can be repeated to add multiple principals.
+//
+// The key should be a PEM or base64-encoded ed25519 public key. Requests
+// signed with the key of any principal (or with the key set with
+// VerificationKey) are accepted. The name of the principal is available
+// to functions via Principal.
+func VerificationPrincipal(name, verificationKey string) Option {
+	return optionFunc(func(d *Dispatch) {
+		if d.principals == nil {
+			d.principals = map[string]string{}
+		}
+		d.principals[name] = verificationKey
+	})
+}
+
+// Principal returns the name of the principal that signed the request
+// that started the function call (see VerificationPrincipal).
+//
+// The boolean is false if the request was not signed with the key of
+// a principal, or if ctx isn't the context passed to a function.
+func Principal(ctx context.Context) (string, bool) {
+	return auth.PrincipalFrom(ctx)
+}
@@ -0,0 +1,180 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Profile is the latency breakdown of a single run of a function, from
+// the moment it's called or resumed to the moment it returns, exits or
+// suspends.
+type Profile struct {
+	// Function is the name of the function.
+	Function string
+
+	// Step identifies the logical step of the function that ran. It
+	// describes the directive the run ended with: "exit" if the function
+	// returned or failed, "tail call: <function>" if it exited with a
+	// tail call, and "await: <function>[, <function>...]" if it
+	// suspended to wait for the results of calls to the listed
+	// functions ("await" if it only waits for calls made by prior
+	// steps).
+	Step string
+
+	// Deserialize is the time spent unmarshaling the input of the
+	// function, or restoring the state of a suspended coroutine.
+	Deserialize time.Duration
+
+	// Run is the time spent running user code, until the coroutine
+	// returned or yielded.
+	Run time.Duration
+
+	// Serialize is the time spent serializing the state of the
+	// coroutine before it suspended.
+	Serialize time.Duration
+
+	// Total is the time spent running the function, including the
+	// interceptors that run inside the profiling interceptor.
+	Total time.Duration
+}
+
+// ProfileStep identifies a logical step of a function (see Profile).
+type ProfileStep struct {
+	Function string
+	Step     string
+}
+
+// ProfileStats are the latencies of a logical step of a function,
+// aggregated over all its runs.
+type ProfileStats struct {
+	// Count is the number of runs of the step.
+	Count int64
+
+	// Deserialize, Run, Serialize and Total are the sums of the
+	// corresponding durations of each run (see Profile). The mean
+	// latency of the step is Total / Count.
+	Deserialize time.Duration
+	Run         time.Duration
+	Serialize   time.Duration
+	Total       time.Duration
+
+	// MaxTotal is the largest Total of a single run.
+	MaxTotal time.Duration
+}
+
+// Profiling measures the latency of each run of the functions of the
+// Dispatch endpoint, broken down into the time spent deserializing
+// inputs and coroutine state, running user code and serializing
+// coroutine state.
+//
+// The hook, if not nil, is called with the Profile of each run, e.g. to
+// export latencies to a metrics system. Profiles are also aggregated per
+// logical step, and can be queried with Dispatch.ProfileStats to find
+// the steps that dominate the end-to-end latency of a workflow.
+//
+// The breakdown is only recorded for coroutine functions. The Total of
+// primitive functions and state machines is recorded, but the other
+// durations are zero. Timings can't be attached to the responses sent
+// to Dispatch, since the protocol has no field to carry them.
+func Profiling(hook func(context.Context, Profile)) Option {
+	return optionFunc(func(d *Dispatch) {
+		profiler := &profiler{steps: map[ProfileStep]*ProfileStats{}}
+		d.profiler = profiler
+		d.interceptors = append(d.interceptors, func(next dispatchproto.Function) dispatchproto.Function {
+			return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+				p := &Profile{Function: req.Function()}
+				start := time.Now()
+				res := next(context.WithValue(ctx, profileKey{}, p), req)
+				p.Total = time.Since(start)
+				p.Step = profileStep(res)
+
+				profiler.record(*p)
+				if hook != nil {
+					hook(ctx, *p)
+				}
+				return res
+			}
+		})
+	})
+}
+
+// ProfileStats returns the latencies of each logical step of the
+// functions that have run on the endpoint.
+//
+// It returns nil if profiling has not been enabled (see Profiling).
+func (d *Dispatch) ProfileStats() map[ProfileStep]ProfileStats {
+	if d.profiler == nil {
+		return nil
+	}
+	return d.profiler.stats()
+}
+
+type profileKey struct{}
+
+// profileOf returns the Profile of the run of a function, or nil if
+// profiling is not enabled.
+func profileOf(ctx context.Context) *Profile {
+	p, _ := ctx.Value(profileKey{}).(*Profile)
+	return p
+}
+
+func profileStep(res dispatchproto.Response) string {
+	if poll, ok := res.Poll(); ok {
+		var functions []string
+		for _, call := range poll.Calls() {
+			functions = append(functions, call.Function())
+		}
+		if len(functions) == 0 {
+			return "await"
+		}
+		slices.Sort(functions)
+		return "await: " + strings.Join(slices.Compact(functions), ", ")
+	}
+	if exit, ok := res.Exit(); ok {
+		if call, ok := exit.TailCall(); ok {
+			return "tail call: " + call.Function()
+		}
+	}
+	return "exit"
+}
+
+type profiler struct {
+	mu    sync.Mutex
+	steps map[ProfileStep]*ProfileStats
+}
+
+func (p *profiler) record(profile Profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	step := ProfileStep{Function: profile.Function, Step: profile.Step}
+	stats, ok := p.steps[step]
+	if !ok {
+		stats = new(ProfileStats)
+		p.steps[step] = stats
+	}
+	stats.Count++
+	stats.Deserialize += profile.Deserialize
+	stats.Run += profile.Run
+	stats.Serialize += profile.Serialize
+	stats.Total += profile.Total
+	stats.MaxTotal = max(stats.MaxTotal, profile.Total)
+}
+
+func (p *profiler) stats() map[ProfileStep]ProfileStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make(map[ProfileStep]ProfileStats, len(p.steps))
+	for step, s := range p.steps {
+		stats[step] = *s
+	}
+	return stats
+}
@@ -0,0 +1,98 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Protocol is an RPC protocol that the Dispatch endpoint can be served
+// with (see Protocols).
+type Protocol string
+
+const (
+	// ProtocolConnect is the Connect protocol, over HTTP/1.1 or HTTP/2.
+	ProtocolConnect Protocol = "connect"
+
+	// ProtocolGRPC is the gRPC protocol, which requires HTTP/2 (see
+	// H2C to serve it without TLS).
+	ProtocolGRPC Protocol = "grpc"
+
+	// ProtocolGRPCWeb is the gRPC-Web protocol, over HTTP/1.1 or
+	// HTTP/2.
+	ProtocolGRPCWeb Protocol = "grpcweb"
+)
+
+// Protocols sets the RPC protocols that the Dispatch endpoint is served
+// with, e.g. to only accept gRPC behind an ingress that speaks gRPC.
+// Requests made with other protocols are rejected with an HTTP 415
+// Unsupported Media Type error.
+//
+// By default the endpoint is served with all the protocols.
+func Protocols(protocols ...Protocol) Option {
+	return optionFunc(func(d *Dispatch) { d.protocols = append(d.protocols, protocols...) })
+}
+
+// H2C enables cleartext HTTP/2 (h2c) on the HTTP server used by
+// ListenAndServe, so that clients can use HTTP/2 (and therefore gRPC)
+// without TLS, e.g. behind an ingress that terminates TLS. HTTP/1.1
+// requests are still served.
+//
+// HTTP/2 is always enabled when the endpoint is served over TLS (see
+// TLSConfig).
+func H2C() Option {
+	return optionFunc(func(d *Dispatch) { d.h2c = true })
+}
+
+// ConnectOptions sets options of the connect handler that serves the
+// Dispatch endpoint, e.g. to set limits (connect.WithReadMaxBytes) or
+// compression (connect.WithCompressMinBytes).
+func ConnectOptions(opts ...connect.HandlerOption) Option {
+	return optionFunc(func(d *Dispatch) { d.connectOpts = append(d.connectOpts, opts...) })
+}
+
+func (d *Dispatch) checkProtocols() error {
+	for _, protocol := range d.protocols {
+		switch protocol {
+		case ProtocolConnect, ProtocolGRPC, ProtocolGRPCWeb:
+		default:
+			return fmt.Errorf("invalid protocol provided via Protocols(..): %q", protocol)
+		}
+	}
+	return nil
+}
+
+// protocolHandler rejects requests made with protocols that the
+// endpoint isn't served with.
+func protocolHandler(protocols []Protocol, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(protocols, requestProtocol(r)) {
+			http.Error(w, "unsupported protocol", http.StatusUnsupportedMediaType)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func requestProtocol(r *http.Request) Protocol {
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/grpc-web"):
+		return ProtocolGRPCWeb
+	case strings.HasPrefix(contentType, "application/grpc"):
+		return ProtocolGRPC
+	default:
+		return ProtocolConnect
+	}
+}
+
+func h2cHandler(handler http.Handler) http.Handler {
+	return h2c.NewHandler(handler, &http2.Server{})
+}
@@ -0,0 +1,165 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Quota configures execution quotas for a Dispatch endpoint.
+//
+// Requests that exceed a quota are rejected with ThrottledStatus,
+// which instructs Dispatch to retry them later.
+type Quota struct {
+	// RequestsPerMinute is the maximum number of requests to run
+	// functions that are accepted per minute, per tenant. Zero means
+	// there's no limit.
+	RequestsPerMinute int
+
+	// MaxConcurrent is the maximum number of requests to run functions
+	// that are processed concurrently, per tenant. Zero means there's
+	// no limit.
+	MaxConcurrent int
+
+	// Tenant identifies the tenant that a request belongs to, given the
+	// context of the request. Quotas are enforced separately for each
+	// tenant.
+	//
+	// By default, requests belong to the principal that signed them
+	// (see Principal), or to the tenant "" if they were not signed with
+	// the key of a principal. Tenants should be derived from verified
+	// information with a bounded number of values, rather than from
+	// arbitrary request headers, since the endpoint tracks the requests
+	// of each tenant.
+	Tenant func(context.Context) string
+}
+
+// quotaIdleTimeout is the time after which the counters of tenants that
+// have no requests in flight are discarded.
+const quotaIdleTimeout = 5 * time.Minute
+
+// ExecutionQuota sets execution quotas for the Dispatch endpoint.
+//
+// The counters used to enforce quotas can be queried with
+// Dispatch.QuotaStats.
+func ExecutionQuota(quota Quota) Option {
+	return optionFunc(func(d *Dispatch) { d.quota = newQuotas(quota) })
+}
+
+// QuotaStats are counters for the requests of a tenant.
+type QuotaStats struct {
+	// Accepted is the number of requests that were accepted.
+	Accepted int64
+
+	// Throttled is the number of requests that were rejected because
+	// they exceeded a quota.
+	Throttled int64
+
+	// Concurrent is the number of requests currently being processed.
+	Concurrent int
+}
+
+// QuotaStats returns the quota counters of each tenant that has
+// sent requests to the endpoint. The counters of tenants that have
+// been idle for a few minutes are discarded.
+//
+// It returns nil if quotas have not been configured (see ExecutionQuota).
+func (d *Dispatch) QuotaStats() map[string]QuotaStats {
+	if d.quota == nil {
+		return nil
+	}
+	return d.quota.stats()
+}
+
+type quotas struct {
+	Quota
+
+	mu      sync.Mutex
+	tenants map[string]*tenantQuota
+	evicted time.Time
+}
+
+type tenantQuota struct {
+	QuotaStats
+
+	window      time.Time
+	windowCount int
+	lastSeen    time.Time
+}
+
+func newQuotas(quota Quota) *quotas {
+	return &quotas{Quota: quota, tenants: map[string]*tenantQuota{}}
+}
+
+func (q *quotas) tenant(ctx context.Context) string {
+	if q.Tenant == nil {
+		principal, _ := Principal(ctx)
+		return principal
+	}
+	return q.Tenant(ctx)
+}
+
+// acquire attempts to admit a request for a tenant. If the request is
+// admitted, the returned function must be called once it has been
+// processed.
+func (q *quotas) acquire(tenant string, now time.Time) (release func(), ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.evict(now)
+	t, ok := q.tenants[tenant]
+	if !ok {
+		t = &tenantQuota{}
+		q.tenants[tenant] = t
+	}
+	t.lastSeen = now
+
+	if window := now.Truncate(time.Minute); !window.Equal(t.window) {
+		t.window = window
+		t.windowCount = 0
+	}
+	if (q.RequestsPerMinute > 0 && t.windowCount >= q.RequestsPerMinute) ||
+		(q.MaxConcurrent > 0 && t.Concurrent >= q.MaxConcurrent) {
+		t.Throttled++
+		return nil, false
+	}
+	t.windowCount++
+	t.Accepted++
+	t.Concurrent++
+
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		t.Concurrent--
+	}, true
+}
+
+// evict discards the counters of tenants that have been idle for
+// quotaIdleTimeout, at most once per minute. The quotas of a tenant are
+// reset each minute, so that evicting it doesn't change whether its
+// requests are admitted.
+func (q *quotas) evict(now time.Time) {
+	if now.Sub(q.evicted) < time.Minute {
+		return
+	}
+	q.evicted = now
+	for tenant, t := range q.tenants {
+		if t.Concurrent == 0 && now.Sub(t.lastSeen) >= quotaIdleTimeout {
+			delete(q.tenants, tenant)
+		}
+	}
+}
+
+func (q *quotas) stats() map[string]QuotaStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make(map[string]QuotaStats, len(q.tenants))
+	for tenant, t := range q.tenants {
+		stats[tenant] = t.QuotaStats
+	}
+	return stats
+}
@@ -0,0 +1,106 @@
+package dispatch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/internal/auth"
+)
+
+func TestQuotaRequestsPerMinute(t *testing.T) {
+	q := newQuotas(Quota{RequestsPerMinute: 2})
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		release, ok := q.acquire("a", now.Add(time.Duration(i)*time.Second))
+		if ok != want {
+			t.Fatalf("request %d: unexpected admission: got %v, want %v", i, ok, want)
+		}
+		if ok {
+			release()
+		}
+	}
+
+	// Other tenants have their own quota.
+	if _, ok := q.acquire("b", now); !ok {
+		t.Fatal("expected request for another tenant to be admitted")
+	}
+
+	// The quota is reset each minute.
+	if _, ok := q.acquire("a", now.Add(time.Minute)); !ok {
+		t.Fatal("expected request in the next minute to be admitted")
+	}
+
+	stats := q.stats()
+	if got, want := stats["a"], (QuotaStats{Accepted: 3, Throttled: 1, Concurrent: 1}); got != want {
+		t.Errorf("unexpected stats for tenant a: got %+v, want %+v", got, want)
+	}
+	if got, want := stats["b"], (QuotaStats{Accepted: 1, Concurrent: 1}); got != want {
+		t.Errorf("unexpected stats for tenant b: got %+v, want %+v", got, want)
+	}
+}
+
+func TestQuotaMaxConcurrent(t *testing.T) {
+	q := newQuotas(Quota{MaxConcurrent: 1})
+
+	now := time.Now()
+	release, ok := q.acquire("", now)
+	if !ok {
+		t.Fatal("expected first request to be admitted")
+	}
+	if _, ok := q.acquire("", now); ok {
+		t.Fatal("expected concurrent request to be throttled")
+	}
+	release()
+	if _, ok := q.acquire("", now); !ok {
+		t.Fatal("expected request to be admitted after release")
+	}
+}
+
+func TestQuotaTenant(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), "sandbox")
+
+	q := newQuotas(Quota{})
+	if got := q.tenant(context.Background()); got != "" {
+		t.Errorf("unexpected default tenant: %q", got)
+	}
+	if got := q.tenant(ctx); got != "sandbox" {
+		t.Errorf("unexpected tenant: %q", got)
+	}
+
+	q = newQuotas(Quota{Tenant: func(ctx context.Context) string { return "custom" }})
+	if got := q.tenant(ctx); got != "custom" {
+		t.Errorf("unexpected tenant: %q", got)
+	}
+}
+
+func TestQuotaEviction(t *testing.T) {
+	q := newQuotas(Quota{RequestsPerMinute: 1})
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if _, ok := q.acquire("a", now); !ok {
+		t.Fatal("expected request to be admitted")
+	}
+	release, ok := q.acquire("b", now)
+	if !ok {
+		t.Fatal("expected request to be admitted")
+	}
+
+	// Idle tenants are evicted, but not those with requests in flight.
+	later := now.Add(quotaIdleTimeout)
+	q.acquire("c", later)
+	if _, ok := q.stats()["a"]; !ok {
+		t.Error("expected tenant a to be kept, since it has a request in flight")
+	}
+	release()
+
+	q.acquire("c", later.Add(time.Minute))
+	stats := q.stats()
+	if _, ok := stats["b"]; ok {
+		t.Error("expected idle tenant b to be evicted")
+	}
+	if len(stats) != 2 {
+		t.Errorf("unexpected tenants: %v", stats)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// FunctionInfo describes a function registered on a Dispatch endpoint
+// (see Dispatch.Functions).
+type FunctionInfo struct {
+	// Name is the name of the function.
+	Name string
+
+	// Primitive is true if the function takes and returns untyped
+	// dispatchproto.Any values, i.e. if it was registered with
+	// RegisterPrimitive or created with PrimitiveFunc.
+	Primitive bool
+
+	// Input and Output are the Go types of the input and output of the
+	// function. They're nil if the function was registered with
+	// RegisterPrimitive.
+	Input  reflect.Type
+	Output reflect.Type
+
+	// Symbol is the Go symbol of the function, if it was created with
+	// FuncOf.
+	Symbol string
+
+	// Registered is the time the function was registered.
+	Registered time.Time
+}
+
+// Functions returns the functions registered on the endpoint, sorted
+// by name, e.g. for tooling to discover the functions the endpoint
+// serves (see also IntrospectionHandler).
+func (d *Dispatch) Functions() []FunctionInfo {
+	var functions []FunctionInfo
+	d.infos.Range(func(_, info any) bool {
+		functions = append(functions, info.(FunctionInfo))
+		return true
+	})
+	slices.SortFunc(functions, func(a, b FunctionInfo) int { return strings.Compare(a.Name, b.Name) })
+	return functions
+}
+
+// IntrospectionHandler returns an HTTP handler that serves the
+// functions registered on the endpoint (see Functions) as JSON, e.g.
+//
+//	{"functions":[{"name":"greet","primitive":false,"input":"string","output":"string","registered":"2024-06-01T12:00:00Z"}]}
+//
+// The handler doesn't verify the signature of requests, since they
+// aren't sent by Dispatch; it can be wrapped with middleware that
+// restricts access to it if needed. It can be served by
+// Dispatch.Mux (see IntrospectionPath).
+func (d *Dispatch) IntrospectionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		functions := d.Functions()
+		res := introspection{Functions: make([]functionJSON, len(functions))}
+		for i, fn := range functions {
+			res.Functions[i] = functionJSON{
+				Name:       fn.Name,
+				Primitive:  fn.Primitive,
+				Input:      typeName(fn.Input),
+				Output:     typeName(fn.Output),
+				Symbol:     fn.Symbol,
+				Registered: fn.Registered,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+type introspection struct {
+	Functions []functionJSON `json:"functions"`
+}
+
+type functionJSON struct {
+	Name       string    `json:"name"`
+	Primitive  bool      `json:"primitive"`
+	Input      string    `json:"input,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	Symbol     string    `json:"symbol,omitempty"`
+	Registered time.Time `json:"registered"`
+}
+
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+func (d *Dispatch) registerInfo(name string, fn AnyFunction) {
+	info := FunctionInfo{Name: name, Primitive: true, Registered: time.Now()}
+	if f, ok := fn.(interface {
+		types() (reflect.Type, reflect.Type)
+	}); ok {
+		info.Input, info.Output = f.types()
+		anyType := reflect.TypeFor[dispatchproto.Any]()
+		info.Primitive = info.Input == anyType && info.Output == anyType
+		info.Symbol = functionSymbol(fn)
+	}
+	d.infos.Store(name, info)
+}
+
+func (f *Function[I, O]) types() (input, output reflect.Type) {
+	return reflect.TypeFor[I](), reflect.TypeFor[O]()
+}
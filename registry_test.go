@@ -0,0 +1,85 @@
+package dispatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+func TestDispatchFunctions(t *testing.T) {
+	endpoint, err := dispatch.New(dispatch.EndpointUrl("http://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	endpoint.Register(dispatch.Func("greet", func(ctx context.Context, name string) (string, error) {
+		return "hello " + name, nil
+	}))
+	endpoint.Register(dispatch.Identity("identity"))
+	endpoint.Register(dispatch.Func0("now", func(ctx context.Context) (time.Time, error) {
+		return time.Now(), nil
+	}))
+	endpoint.RegisterPrimitive("raw", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		return dispatchproto.NewResponse(dispatchproto.OKStatus)
+	})
+
+	anyType := reflect.TypeFor[dispatchproto.Any]()
+	want := []dispatch.FunctionInfo{
+		{Name: "greet", Input: reflect.TypeFor[string](), Output: reflect.TypeFor[string]()},
+		{Name: "identity", Primitive: true, Input: anyType, Output: anyType},
+		{Name: "now", Input: anyType, Output: reflect.TypeFor[time.Time]()},
+		{Name: "raw", Primitive: true},
+	}
+	functions := endpoint.Functions()
+	if len(functions) != len(want) {
+		t.Fatalf("unexpected functions: %+v", functions)
+	}
+	for i, fn := range functions {
+		if fn.Registered.Before(before) {
+			t.Errorf("unexpected registration time of %s: %v", fn.Name, fn.Registered)
+		}
+		fn.Registered = time.Time{}
+		if fn != want[i] {
+			t.Errorf("unexpected function %d: got %+v, want %+v", i, fn, want[i])
+		}
+	}
+
+	server := httptest.NewServer(endpoint.Mux(dispatch.IntrospectionPath("/functions")))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/functions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", res.Status)
+	}
+	var body struct {
+		Functions []struct {
+			Name      string `json:"name"`
+			Primitive bool   `json:"primitive"`
+			Input     string `json:"input"`
+			Output    string `json:"output"`
+		} `json:"functions"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Functions) != 4 {
+		t.Fatalf("unexpected functions: %+v", body.Functions)
+	}
+	if got := body.Functions[0]; got.Name != "greet" || got.Primitive || got.Input != "string" || got.Output != "string" {
+		t.Errorf("unexpected function: %+v", got)
+	}
+	if got := body.Functions[3]; got.Name != "raw" || !got.Primitive || got.Input != "" || got.Output != "" {
+		t.Errorf("unexpected function: %+v", got)
+	}
+}
@@ -0,0 +1,182 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/internal/auth"
+	"github.com/dispatchrun/dispatch-go/internal/env"
+)
+
+// SecretResolver resolves the values of secrets bound to functions
+// (see Function.WithSecrets).
+type SecretResolver interface {
+	ResolveSecret(ctx context.Context, name string) (string, error)
+}
+
+// SecretResolverFunc is a SecretResolver implemented by a function.
+type SecretResolverFunc func(ctx context.Context, name string) (string, error)
+
+// ResolveSecret calls f(ctx, name).
+func (f SecretResolverFunc) ResolveSecret(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// Secrets sets the resolver for secrets bound to the functions
+// registered on the endpoint.
+//
+// By default, secrets are resolved from the environment variables of
+// the endpoint (see Env), by name.
+func Secrets(resolver SecretResolver) Option {
+	return optionFunc(func(d *Dispatch) { d.secretResolver = resolver })
+}
+
+// WithSecrets binds named secrets to the function, and returns the
+// function.
+//
+// Secrets are resolved once, when the function is registered on an
+// endpoint (see Secrets), and can be retrieved from the context passed
+// to the function with Secret. Since secrets are held by the endpoint,
+// and are never part of the coroutine state, functions see the values
+// of the host they're currently running on, even after being resumed
+// elsewhere in durable mode.
+//
+// If a secret cannot be resolved, the function cannot be registered:
+// New returns an error, and Register panics.
+//
+// Functions that aren't registered on an endpoint (e.g. those run by a
+// dispatchtest.Runner) resolve secrets from the environment variables
+// of the process, and calls fail if a secret cannot be resolved.
+func (f *Function[I, O]) WithSecrets(names ...string) *Function[I, O] {
+	f.secrets = append(f.secrets, names...)
+	return f
+}
+
+// Secret returns the value of a secret bound to the function that is
+// running (see Function.WithSecrets).
+//
+// The boolean is false if the secret isn't bound to the function, or if
+// ctx isn't the context passed to a function.
+func Secret(ctx context.Context, name string) (string, bool) {
+	scope, ok := ctx.Value(secretScopeKey{}).(secretScope)
+	if !ok || !slices.Contains(scope.names, name) {
+		return "", false
+	}
+	values, err := scope.endpoint.secrets(scope.function, scope.names)
+	if err != nil {
+		return "", false
+	}
+	return values[name], true
+}
+
+type secretScopeKey struct{}
+
+// secretScope identifies the secrets of a function in the context passed
+// to it. Only the names are stored in the context, so that values never
+// end up in durable coroutine state.
+type secretScope struct {
+	endpoint *Dispatch
+	function string
+	names    []string
+}
+
+// registerSecrets resolves the secrets of a function that's registered
+// on the endpoint, replacing those of a function previously registered
+// with the same name.
+func (d *Dispatch) registerSecrets(fn AnyFunction) error {
+	f, ok := fn.(interface{ secretNames() (string, []string) })
+	if !ok {
+		return nil
+	}
+	function, names := f.secretNames()
+	if len(names) == 0 {
+		d.secretValues.Delete(function)
+		return nil
+	}
+	values, err := resolveSecrets(d.resolver(), function, names)
+	if err != nil {
+		return err
+	}
+	d.secretValues.Store(function, values)
+	return nil
+}
+
+// secrets returns the values of the secrets of a function. Secrets are
+// resolved when they're first needed if the function wasn't registered
+// on the endpoint, e.g. in durable mode, when the endpoint was restored
+// with the state of a coroutine. Functions that aren't registered on an
+// endpoint (d is nil) resolve them from the environment.
+func (d *Dispatch) secrets(function string, names []string) (map[string]string, error) {
+	if d == nil {
+		return resolveSecrets(envSecretResolver(os.Environ()), function, names)
+	}
+	if values, ok := d.secretValues.Load(function); ok {
+		return values.(map[string]string), nil
+	}
+	values, err := resolveSecrets(d.resolver(), function, names)
+	if err != nil {
+		return nil, err
+	}
+	d.secretValues.Store(function, values)
+	return values, nil
+}
+
+func (d *Dispatch) resolver() SecretResolver {
+	if d.secretResolver != nil {
+		return d.secretResolver
+	}
+	return envSecretResolver(d.env)
+}
+
+func resolveSecrets(resolver SecretResolver, function string, names []string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		value, err := resolver.ResolveSecret(context.Background(), name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve secret %q of function %q: %w", name, function, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+func (f *Function[I, O]) secretNames() (string, []string) {
+	return f.name, f.secrets
+}
+
+func (f *Function[I, O]) secretsError() error {
+	if len(f.secrets) == 0 {
+		return nil
+	}
+	_, err := f.endpoint.secrets(f.name, f.secrets)
+	return err
+}
+
+func (f *Function[I, O]) context(principal string) context.Context {
+	ctx := context.TODO()
+	if principal != "" {
+		ctx = auth.WithPrincipal(ctx, principal)
+	}
+	if len(f.secrets) > 0 {
+		ctx = context.WithValue(ctx, secretScopeKey{}, secretScope{endpoint: f.endpoint, function: f.name, names: f.secrets})
+	}
+	if f.endpoint != nil {
+		ctx = dispatchcoro.WithShutdownScope(ctx, f.endpoint.shutdownScope)
+		ctx = context.WithValue(ctx, endpointURLKey{}, f.endpoint.URL())
+	}
+	return ctx
+}
+
+func envSecretResolver(environ []string) SecretResolver {
+	return SecretResolverFunc(func(ctx context.Context, name string) (string, error) {
+		if value := env.Get(environ, name); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("%w: environment variable %s is not set", ErrNotFound, name)
+	})
+}
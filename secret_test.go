@@ -0,0 +1,137 @@
+package dispatch_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestSecrets(t *testing.T) {
+	fn := dispatch.Func("secret", func(ctx context.Context, name string) (string, error) {
+		value, ok := dispatch.Secret(ctx, name)
+		if !ok {
+			return "", errors.New("secret not found")
+		}
+		return value, nil
+	}).WithSecrets("API_TOKEN")
+
+	resolver := dispatch.SecretResolverFunc(func(ctx context.Context, name string) (string, error) {
+		return "resolved-" + name, nil
+	})
+
+	// Functions can be passed to New ahead of the resolver.
+	_, server, err := dispatchtest.NewEndpoint(fn, dispatch.Secrets(resolver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		output string
+		ok     bool
+	}{
+		{name: "API_TOKEN", output: "resolved-API_TOKEN", ok: true},
+		{name: "OTHER", ok: false},
+	} {
+		res, err := client.Run(context.Background(), dispatchproto.NewRequest("secret", dispatchproto.String(test.name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !test.ok {
+			if res.OK() {
+				t.Errorf("%s: expected an error, got %s", test.name, res)
+			}
+			continue
+		}
+		var output string
+		if boxed, ok := res.Output(); !ok {
+			t.Fatalf("%s: unexpected response: %s", test.name, res)
+		} else if err := boxed.Unmarshal(&output); err != nil {
+			t.Fatal(err)
+		} else if output != test.output {
+			t.Errorf("%s: unexpected output: got %q, want %q", test.name, output, test.output)
+		}
+	}
+
+	if _, ok := dispatch.Secret(context.Background(), "API_TOKEN"); ok {
+		t.Error("unexpected secret outside of a function")
+	}
+}
+
+func TestSecretsFromEnv(t *testing.T) {
+	fn := dispatch.Func("secret", func(ctx context.Context, _ int) (string, error) {
+		value, _ := dispatch.Secret(ctx, "API_TOKEN")
+		return value, nil
+	}).WithSecrets("API_TOKEN")
+
+	_, server, err := dispatchtest.NewEndpoint(fn, dispatch.Env("API_TOKEN=foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Run(context.Background(), dispatchproto.NewRequest("secret", dispatchproto.Int(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output string
+	if boxed, ok := res.Output(); !ok {
+		t.Fatalf("unexpected response: %s", res)
+	} else if err := boxed.Unmarshal(&output); err != nil {
+		t.Fatal(err)
+	} else if output != "foo" {
+		t.Errorf("unexpected output: got %q, want %q", output, "foo")
+	}
+}
+
+func TestSecretsUnresolved(t *testing.T) {
+	fn := dispatch.Func("secret", func(ctx context.Context, _ int) (string, error) {
+		return "", nil
+	}).WithSecrets("MISSING_SECRET")
+
+	// Endpoints can't be created with functions whose secrets can't be
+	// resolved.
+	_, err := dispatch.New(dispatch.Env("DISPATCH_ENDPOINT_URL=http://example.com/unresolved"), fn)
+	if err == nil || !strings.Contains(err.Error(), `cannot resolve secret "MISSING_SECRET"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Such functions can't be registered either.
+	endpoint, err := dispatch.New(dispatch.Env("DISPATCH_ENDPOINT_URL=http://example.com/unresolved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), `cannot resolve secret "MISSING_SECRET"`) {
+				t.Errorf("unexpected panic: %v", r)
+			}
+		}()
+		endpoint.Register(fn)
+	}()
+	if functions := endpoint.Functions(); len(functions) != 0 {
+		t.Errorf("unexpected functions: %v", functions)
+	}
+
+	// Calls to functions that aren't registered on an endpoint fail.
+	runner := dispatchtest.NewRunner(fn)
+	if _, err := dispatchtest.Call(runner, fn, 0); err == nil || !strings.Contains(err.Error(), `cannot resolve secret "MISSING_SECRET"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
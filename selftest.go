@@ -0,0 +1,128 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// SelfTestResult is the result of testing a function with
+// Dispatch.SelfTest.
+type SelfTestResult struct {
+	// Function is the name of the function.
+	Function string
+
+	// Input is the input the function was called with, if it could
+	// be serialized.
+	Input dispatchproto.Any
+
+	// Suspended is true if the function suspended itself (e.g. to
+	// await the results of other calls) rather than returning.
+	Suspended bool
+
+	// Err is the reason the function failed, or nil if it didn't.
+	Err error
+}
+
+// SelfTest runs each function registered on the endpoint locally, to
+// catch wiring mistakes before the endpoint is deployed.
+//
+// Functions are called with the sample input from the samples map, if
+// present, or with the zero value of their input type otherwise (if
+// the input type is a pointer, the pointer to a zero value is used).
+// Functions registered with RegisterPrimitive receive a nil input if
+// no sample is provided.
+//
+// A function fails the test if its input cannot be serialized, if it
+// returns an error or a response with a non-OK status, or if its output
+// cannot be serialized. Functions that suspend, e.g. to await the
+// results of other calls, pass the test; they are then cancelled rather
+// than being run to completion. Functions created with Func that panic
+// fail the test with the "panic" error they respond with; panics in
+// primitives registered with RegisterPrimitive are not recovered.
+//
+// Samples for functions that aren't registered are reported as
+// failures. The results are sorted by function name.
+func (d *Dispatch) SelfTest(ctx context.Context, samples map[string]any) []SelfTestResult {
+	functions := d.functions.Load()
+
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	for name := range samples {
+		if _, ok := functions[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	results := make([]SelfTestResult, len(names))
+	for i, name := range names {
+		results[i] = d.selfTest(ctx, name, functions[name], samples)
+	}
+	return results
+}
+
+func (d *Dispatch) selfTest(ctx context.Context, name string, fn dispatchproto.Function, samples map[string]any) SelfTestResult {
+	result := SelfTestResult{Function: name}
+	if fn == nil {
+		result.Err = fmt.Errorf("%w: function %q is not registered", ErrNotFound, name)
+		return result
+	}
+
+	var err error
+	if sample, ok := samples[name]; ok {
+		result.Input, err = dispatchproto.Marshal(sample)
+	} else if zero, ok := d.zeroInputs.Load(name); ok {
+		result.Input, err = zero.(func() (dispatchproto.Any, error))()
+	} else {
+		result.Input = dispatchproto.Nil()
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("cannot serialize input: %w", err)
+		return result
+	}
+
+	req := dispatchproto.NewRequest(name, result.Input)
+	res := fn(ctx, req)
+
+	if poll, ok := res.Poll(); ok {
+		result.Suspended = true
+		// Cancel the suspended function, so that it doesn't linger.
+		fn(ctx, req.With(poll.Result().With(dispatchproto.Errorf("self test complete"))))
+		return result
+	}
+	if err, ok := res.Error(); ok {
+		result.Err = err
+	} else if !res.OK() {
+		result.Err = dispatchproto.StatusError(res.Status())
+	}
+	return result
+}
+
+// SelfTestError returns an error that combines the failures in the
+// results of Dispatch.SelfTest, or nil if all functions passed.
+func SelfTestError(results []SelfTestResult) error {
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("function %q: %w", result.Function, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Function[I, O]) zeroInput() (dispatchproto.Any, error) {
+	var zero I
+	if t := reflect.TypeFor[I](); t.Kind() == reflect.Pointer {
+		zero = reflect.New(t.Elem()).Interface().(I)
+	}
+	return f.marshal(zero)
+}
@@ -0,0 +1,118 @@
+package dispatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+type selfTestInput struct {
+	Name     string
+	Callback func() // cannot be serialized
+}
+
+type selfTestRequest struct{ Name string }
+
+func (r *selfTestRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Name)
+}
+
+func (r *selfTestRequest) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &r.Name)
+}
+
+func TestDispatchSelfTest(t *testing.T) {
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpoint.Register(dispatch.Func("ok", func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	}))
+	endpoint.Register(dispatch.Func("positive", func(ctx context.Context, n int) (int, error) {
+		if n <= 0 {
+			return 0, errors.New("not positive")
+		}
+		return n, nil
+	}))
+	endpoint.Register(dispatch.Func("pointer", func(ctx context.Context, r *selfTestRequest) (string, error) {
+		return r.Name, nil // the zero input is a pointer to a zero value, not nil
+	}))
+	endpoint.Register(dispatch.Func("bad-output", func(ctx context.Context, n int) (chan int, error) {
+		return nil, nil
+	}))
+	endpoint.Register(dispatch.Func("bad-input", func(ctx context.Context, in selfTestInput) (string, error) {
+		return in.Name, nil
+	}))
+	endpoint.Register(dispatch.Func("await", func(ctx context.Context, n int) (int, error) {
+		return dispatch.Func("other", func(ctx context.Context, n int) (int, error) {
+			panic("not implemented")
+		}).Await(n)
+	}))
+	endpoint.Register(dispatch.Func("panic", func(ctx context.Context, n int) (int, error) {
+		panic("oops")
+	}))
+	endpoint.RegisterPrimitive("primitive", func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		return dispatchproto.NewResponse(dispatchproto.Int(1))
+	})
+
+	results := endpoint.SelfTest(context.Background(), map[string]any{
+		"positive": 1,
+		"missing":  1,
+	})
+
+	var names []string
+	failed := map[string]bool{}
+	for _, result := range results {
+		names = append(names, result.Function)
+		failed[result.Function] = result.Err != nil
+		if result.Function == "await" && !result.Suspended {
+			t.Errorf("expected function %q to be suspended", result.Function)
+		}
+	}
+	want := []string{"await", "bad-input", "bad-output", "missing", "ok", "panic", "pointer", "positive", "primitive"}
+	if len(names) != len(want) {
+		t.Fatalf("unexpected results: got %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("unexpected results: got %v, want %v", names, want)
+		}
+	}
+
+	wantFailed := map[string]bool{
+		"await":      false,
+		"bad-input":  true,
+		"bad-output": true,
+		"missing":    true,
+		"ok":         false,
+		"panic":      true,
+		"pointer":    false,
+		"positive":   false,
+		"primitive":  false,
+	}
+	for name, want := range wantFailed {
+		if failed[name] != want {
+			t.Errorf("unexpected result for function %q: failed=%v, want %v", name, failed[name], want)
+		}
+	}
+
+	if err := dispatch.SelfTestError(results); err == nil {
+		t.Error("expected an error")
+	}
+	if err := dispatch.SelfTestError(results[4:5]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The self test does not freeze the set of registered functions.
+	endpoint.Register(dispatch.Func("late", func(ctx context.Context, n int) (int, error) {
+		return n, nil
+	}))
+}
@@ -26,7 +26,7 @@ func dispatchSerializer(s *types.Serializer, d *Dispatch) error {
 		}
 		opts = append(opts, opt)
 	}
-	types.SerializeT(s, serializedDispatch{opts, d.functions})
+	types.SerializeT(s, serializedDispatch{opts, d.functions.Load()})
 	return nil
 }
 
@@ -38,7 +38,7 @@ func dispatchDeserializer(d *types.Deserializer, c *Dispatch) error {
 	if err != nil {
 		return err
 	}
-	dispatch.functions = sd.functions
-	*c = *dispatch //nolint
+	dispatch.functions.Store(sd.functions)
+	*c = *dispatch
 	return nil
 }
@@ -0,0 +1,175 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ShadowCompare is called with the input of a call that was shadowed
+// (see Function.WithShadow), the output of the primary function, and
+// the output of the shadow function or the error it returned.
+type ShadowCompare[I, O any] func(ctx context.Context, input I, primary, shadow O, err error)
+
+// WithShadow sets a shadow function, e.g. a rewritten version of the
+// function, and returns the function.
+//
+// Once the function returns successfully, a call with the same input
+// is dispatched to the shadow function for the specified percentage
+// of calls (between 0 and 100). This allows validating the shadow
+// function on production traffic without affecting callers. The shadow
+// function must be registered on an endpoint.
+//
+// If compare is nil, the results of the shadow function are discarded.
+// Otherwise, the call to the shadow function is made by a function
+// that is registered on the endpoint alongside this function (named
+// after it, with a ".shadow" suffix), which calls compare with the
+// outputs of both functions.
+//
+// Shadow calls are dispatched asynchronously with the client of the
+// endpoint that the function is registered on (see Client), so that
+// they don't delay the response of the function. They're best effort:
+// they're skipped if the function isn't registered on an endpoint, and
+// calls that can't be dispatched within a few seconds are dropped and
+// logged rather than affecting the function.
+func (f *Function[I, O]) WithShadow(shadow *Function[I, O], percent float64, compare ShadowCompare[I, O]) *Function[I, O] {
+	f.shadow = &functionShadow[I, O]{
+		function: shadow,
+		percent:  percent,
+		compare:  compare,
+	}
+	return f
+}
+
+type functionShadow[I, O any] struct {
+	function *Function[I, O]
+	percent  float64
+	compare  ShadowCompare[I, O]
+}
+
+func (f *Function[I, O]) shadowComparator() AnyFunction {
+	if f.shadow == nil || f.shadow.compare == nil {
+		return nil
+	}
+	shadow, compare := f.shadow.function, f.shadow.compare
+	return Func(f.name+".shadow", func(ctx context.Context, call *shadowInput) (dispatchproto.Any, error) {
+		var input I
+		if err := call.Input.Unmarshal(&input); err != nil {
+			return dispatchproto.Any{}, fmt.Errorf("%w: invalid input: %v", ErrInvalidArgument, err)
+		}
+		var primary O
+		if err := call.Output.Unmarshal(&primary); err != nil {
+			return dispatchproto.Any{}, fmt.Errorf("%w: invalid output: %v", ErrInvalidArgument, err)
+		}
+		output, err := shadow.Await(input)
+		compare(ctx, input, primary, output, err)
+		return dispatchproto.Nil(), nil
+	})
+}
+
+// shadowTimeout is the time limit to dispatch a shadow call, after
+// which the call is dropped.
+const shadowTimeout = 5 * time.Second
+
+// shadowCall dispatches a call to the shadow function (or to its
+// comparator) for a sample of the calls that returned successfully.
+// The call is built before returning, since it captures the input and
+// output, and dispatched in the background.
+func (f *Function[I, O]) shadowCall(input I, output O) {
+	if f.shadow == nil || f.endpoint == nil || rand.Float64()*100 >= f.shadow.percent {
+		return
+	}
+	call, err := f.buildShadowCall(input, output)
+	if err != nil {
+		f.warnShadow(err)
+		return
+	}
+	go func() {
+		if err := f.dispatchShadow(call); err != nil {
+			f.warnShadow(err)
+		}
+	}()
+}
+
+func (f *Function[I, O]) dispatchShadow(call dispatchproto.Call) error {
+	client, err := f.endpoint.Client()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+	_, err = client.Dispatch(ctx, call)
+	return err
+}
+
+func (f *Function[I, O]) warnShadow(err error) {
+	f.endpoint.log().Warn("cannot dispatch shadow call", "function", f.name, "shadow", f.shadow.function.Name(), "error", err)
+}
+
+func (f *Function[I, O]) buildShadowCall(input I, output O) (dispatchproto.Call, error) {
+	if f.shadow.compare == nil {
+		return f.shadow.function.BuildCall(input)
+	}
+	boxedInput, err := dispatchproto.Marshal(input)
+	if err != nil {
+		return dispatchproto.Call{}, fmt.Errorf("cannot serialize input: %v", err)
+	}
+	boxedOutput, err := dispatchproto.Marshal(output)
+	if err != nil {
+		return dispatchproto.Call{}, fmt.Errorf("cannot serialize output: %v", err)
+	}
+	boxedCall, err := dispatchproto.Marshal(&shadowInput{Input: boxedInput, Output: boxedOutput})
+	if err != nil {
+		return dispatchproto.Call{}, err
+	}
+	return dispatchproto.NewCall(f.endpoint.URL(), f.name+".shadow", boxedCall), nil
+}
+
+// shadowInput is the input of a shadow comparator.
+type shadowInput struct {
+	Input  dispatchproto.Any
+	Output dispatchproto.Any
+}
+
+func (c *shadowInput) MarshalJSON() ([]byte, error) {
+	input, err := protojson.Marshal(anyProto(c.Input))
+	if err != nil {
+		return nil, err
+	}
+	output, err := protojson.Marshal(anyProto(c.Output))
+	if err != nil {
+		return nil, err
+	}
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonShadowInput{Input: input, Output: output})
+}
+
+func (c *shadowInput) UnmarshalJSON(b []byte) error {
+	var jc jsonShadowInput
+	if err := json.Unmarshal(b, &jc); err != nil {
+		return err
+	}
+	var input, output anypb.Any
+	if err := protojson.Unmarshal(jc.Input, &input); err != nil {
+		return err
+	}
+	if err := protojson.Unmarshal(jc.Output, &output); err != nil {
+		return err
+	}
+	c.Input = newProtoAny(&input)
+	c.Output = newProtoAny(&output)
+	return nil
+}
+
+type jsonShadowInput struct {
+	Input  json.RawMessage `json:"input"`
+	Output json.RawMessage `json:"output"`
+}
@@ -0,0 +1,125 @@
+package dispatch_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchclient"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchserver"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestShadow(t *testing.T) {
+	dispatched := make(chan dispatchproto.Call, 10)
+	api := dispatchtest.NewServer(dispatchserver.HandlerFunc(func(ctx context.Context, header http.Header, calls []dispatchproto.Call) ([]dispatchproto.ID, error) {
+		for _, call := range calls {
+			dispatched <- call
+		}
+		return make([]dispatchproto.ID, len(calls)), nil
+	}))
+	defer api.Close()
+
+	client, err := dispatchclient.New(dispatchclient.APIKey("foobar"), dispatchclient.APIUrl(api.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type comparison struct {
+		input, primary, shadow int
+	}
+	var comparisons []comparison
+
+	doubleV2 := dispatch.Func("double_v2", func(ctx context.Context, n int) (int, error) {
+		return n + n + 1, nil // a bug
+	})
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	}).WithShadow(doubleV2, 100, func(ctx context.Context, input, primary, shadow int, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		comparisons = append(comparisons, comparison{input, primary, shadow})
+	})
+	triple := dispatch.Func("triple", func(ctx context.Context, n int) (int, error) {
+		return n * 3, nil
+	}).WithShadow(doubleV2, 0, nil)
+
+	_, server, err := dispatchtest.NewEndpoint(dispatch.Client(client), double, doubleV2, triple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	endpointClient, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls to the primary function return its output, and dispatch a
+	// call to the comparator.
+	call, err := double.BuildCall(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := endpointClient.Run(context.Background(), call.Request())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output int
+	if boxed, ok := res.Output(); !ok {
+		t.Fatalf("unexpected response: %s", res)
+	} else if err := boxed.Unmarshal(&output); err != nil {
+		t.Fatal(err)
+	} else if output != 8 {
+		t.Errorf("unexpected output: got %d, want 8", output)
+	}
+
+	// Functions with a sampling percentage of zero are never shadowed.
+	call, err = triple.BuildCall(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := endpointClient.Run(context.Background(), call.Request()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shadow calls are dispatched in the background.
+	var shadowCall dispatchproto.Call
+	select {
+	case shadowCall = <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow call was not dispatched")
+	}
+	if got := shadowCall.Function(); got != "double.shadow" {
+		t.Fatalf("unexpected shadow call: %s", shadowCall)
+	}
+	select {
+	case call := <-dispatched:
+		t.Fatalf("unexpected shadow call: %s", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Run the comparator, which calls the shadow function.
+	runner := dispatchtest.NewRunner()
+	for _, name := range []string{"double.shadow", "double_v2"} {
+		runner.RegisterPrimitive(name, func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+			res, err := endpointClient.Run(ctx, req)
+			if err != nil {
+				return dispatchproto.NewResponseError(err)
+			}
+			return res
+		})
+	}
+	if res := runner.Run(shadowCall.Request()); !res.OK() {
+		t.Fatalf("unexpected comparator response: %s", res)
+	}
+
+	want := []comparison{{input: 4, primary: 8, shadow: 9}}
+	if len(comparisons) != 1 || comparisons[0] != want[0] {
+		t.Errorf("unexpected comparisons: got %v, want %v", comparisons, want)
+	}
+}
@@ -0,0 +1,209 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// SLO configures a service level objective for the functions of a
+// Dispatch endpoint. The ratio of calls that succeed is tracked per
+// function, over a sliding window.
+//
+// Calls are counted once they return, exit or fail. A call that
+// fails with a temporary error and is retried by Dispatch is counted
+// once per attempt. Calls that suspend are counted when they complete.
+type SLO struct {
+	// Objective is the target ratio of calls that succeed, e.g. 0.999.
+	// The error budget of a function is the ratio of calls that are
+	// allowed to fail (1 - Objective). It defaults to 0.99.
+	Objective float64
+
+	// Window is the duration of the sliding window over which success
+	// ratios are computed. It defaults to one hour.
+	Window time.Duration
+
+	// MinCalls is the minimum number of calls in the window before the
+	// error budget of a function can be exhausted, so that a handful
+	// of early failures doesn't exhaust it.
+	MinCalls int
+
+	// KillSwitch, if true, rejects new calls to functions whose error
+	// budget is exhausted with ThrottledStatus, which instructs Dispatch
+	// to retry them later. Calls that are already in flight are resumed.
+	// Since rejected calls aren't counted, the budget is replenished as
+	// failures fall out of the window.
+	KillSwitch bool
+}
+
+// ServiceLevelObjective tracks the success ratio of the functions of
+// the Dispatch endpoint against an objective.
+//
+// The success ratio and error budget of each function can be queried
+// with Dispatch.SLOStats.
+func ServiceLevelObjective(slo SLO) Option {
+	return optionFunc(func(d *Dispatch) {
+		tracker := newSLOTracker(slo)
+		d.slo = tracker
+		d.interceptors = append(d.interceptors, func(next dispatchproto.Function) dispatchproto.Function {
+			return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+				name := req.Function()
+				if _, ok := req.Input(); ok && tracker.KillSwitch {
+					if stats := tracker.stats(name, time.Now()); stats.Exhausted {
+						return dispatchproto.NewResponseErrorf("%w: function %q exhausted its error budget", ErrThrottled, name)
+					}
+				}
+				res := next(ctx, req)
+				if _, ok := res.Exit(); ok {
+					tracker.record(name, res.OK(), time.Now())
+				}
+				return res
+			}
+		})
+	})
+}
+
+// SLOStats are the counters of a function tracked against a service
+// level objective, over the sliding window (see SLO).
+type SLOStats struct {
+	// Succeeded is the number of calls that succeeded in the window.
+	Succeeded int64
+
+	// Failed is the number of calls that failed in the window.
+	Failed int64
+
+	// SuccessRatio is the ratio of calls that succeeded in the window.
+	// It's 1 if there were no calls.
+	SuccessRatio float64
+
+	// BudgetRemaining is the ratio of the error budget that remains,
+	// between 0 (exhausted) and 1 (no failures).
+	BudgetRemaining float64
+
+	// Exhausted is true if the error budget is exhausted.
+	Exhausted bool
+}
+
+// SLOStats returns the service level counters of each function that
+// has been called on the endpoint.
+//
+// It returns nil if a service level objective has not been configured
+// (see ServiceLevelObjective).
+func (d *Dispatch) SLOStats() map[string]SLOStats {
+	if d.slo == nil {
+		return nil
+	}
+	return d.slo.allStats(time.Now())
+}
+
+// sloBuckets is the number of buckets that the sliding window is
+// split into. Calls expire from the window one bucket at a time.
+const sloBuckets = 60
+
+type sloTracker struct {
+	SLO
+
+	mu        sync.Mutex
+	functions map[string]*sloWindow
+}
+
+type sloWindow [sloBuckets]sloBucket
+
+type sloBucket struct {
+	start     time.Time
+	succeeded int64
+	failed    int64
+}
+
+func newSLOTracker(slo SLO) *sloTracker {
+	if slo.Objective <= 0 {
+		slo.Objective = 0.99
+	}
+	if slo.Window <= 0 {
+		slo.Window = time.Hour
+	}
+	return &sloTracker{SLO: slo, functions: map[string]*sloWindow{}}
+}
+
+func (t *sloTracker) bucketWidth() time.Duration {
+	return max(t.Window/sloBuckets, 1)
+}
+
+func (t *sloTracker) record(function string, ok bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, found := t.functions[function]
+	if !found {
+		w = new(sloWindow)
+		t.functions[function] = w
+	}
+
+	width := t.bucketWidth()
+	start := now.Truncate(width)
+	b := &w[(start.UnixNano()/int64(width))%sloBuckets]
+	if !b.start.Equal(start) {
+		*b = sloBucket{start: start}
+	}
+	if ok {
+		b.succeeded++
+	} else {
+		b.failed++
+	}
+}
+
+func (t *sloTracker) stats(function string, now time.Time) SLOStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.windowStats(t.functions[function], now)
+}
+
+func (t *sloTracker) allStats(now time.Time) map[string]SLOStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]SLOStats, len(t.functions))
+	for function, w := range t.functions {
+		stats[function] = t.windowStats(w, now)
+	}
+	return stats
+}
+
+func (t *sloTracker) windowStats(w *sloWindow, now time.Time) SLOStats {
+	var stats SLOStats
+	if w != nil {
+		oldest := now.Truncate(t.bucketWidth()).Add(-t.Window)
+		for _, b := range w {
+			if b.start.After(oldest) && !b.start.After(now) {
+				stats.Succeeded += b.succeeded
+				stats.Failed += b.failed
+			}
+		}
+	}
+
+	total := stats.Succeeded + stats.Failed
+	if total == 0 {
+		stats.SuccessRatio = 1
+		stats.BudgetRemaining = 1
+		return stats
+	}
+	stats.SuccessRatio = float64(stats.Succeeded) / float64(total)
+
+	// The budget is the number of calls in the window that may fail.
+	budget := (1 - t.Objective) * float64(total)
+	switch {
+	case stats.Failed == 0:
+		stats.BudgetRemaining = 1
+	case float64(stats.Failed) >= budget:
+		stats.BudgetRemaining = 0
+	default:
+		stats.BudgetRemaining = 1 - float64(stats.Failed)/budget
+	}
+	stats.Exhausted = stats.BudgetRemaining == 0 && total >= int64(t.MinCalls)
+	return stats
+}
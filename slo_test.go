@@ -0,0 +1,64 @@
+package dispatch
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSLOTracker(t *testing.T) {
+	tracker := newSLOTracker(SLO{Objective: 0.9, Window: time.Minute, MinCalls: 10})
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 18; i++ {
+		tracker.record("f", true, now)
+	}
+	tracker.record("f", false, now)
+
+	stats := tracker.stats("f", now)
+	if got, want := stats, (SLOStats{Succeeded: 18, Failed: 1, SuccessRatio: 18.0 / 19, BudgetRemaining: 1 - 1/1.9}); !approxEqual(got, want) {
+		t.Errorf("unexpected stats: got %+v, want %+v", got, want)
+	}
+
+	// The budget is exhausted once the ratio of failures reaches 1 - Objective.
+	tracker.record("f", false, now.Add(time.Second))
+	stats = tracker.stats("f", now.Add(time.Second))
+	if !stats.Exhausted || stats.BudgetRemaining != 0 {
+		t.Errorf("expected error budget to be exhausted: %+v", stats)
+	}
+
+	// Other functions have their own budget.
+	if stats := tracker.stats("g", now); stats.Exhausted || stats.SuccessRatio != 1 {
+		t.Errorf("unexpected stats for function g: %+v", stats)
+	}
+
+	// Calls fall out of the sliding window.
+	later := now.Add(time.Minute)
+	if got, want := tracker.stats("f", later), (SLOStats{Failed: 1, BudgetRemaining: 0}); got != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", got, want)
+	}
+	if got, want := tracker.allStats(later.Add(time.Second)), (SLOStats{SuccessRatio: 1, BudgetRemaining: 1}); got["f"] != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", got["f"], want)
+	}
+}
+
+func TestSLOTrackerMinCalls(t *testing.T) {
+	tracker := newSLOTracker(SLO{MinCalls: 3})
+
+	now := time.Now()
+	tracker.record("f", false, now)
+	tracker.record("f", false, now)
+	if stats := tracker.stats("f", now); stats.Exhausted {
+		t.Errorf("expected error budget not to be exhausted before MinCalls: %+v", stats)
+	}
+	tracker.record("f", false, now)
+	if stats := tracker.stats("f", now); !stats.Exhausted {
+		t.Errorf("expected error budget to be exhausted: %+v", stats)
+	}
+}
+
+func approxEqual(a, b SLOStats) bool {
+	const epsilon = 1e-9
+	return a.Succeeded == b.Succeeded && a.Failed == b.Failed && a.Exhausted == b.Exhausted &&
+		math.Abs(a.SuccessRatio-b.SuccessRatio) < epsilon && math.Abs(a.BudgetRemaining-b.BudgetRemaining) < epsilon
+}
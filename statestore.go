@@ -0,0 +1,130 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// StoredStateTypeURL is the type URL of references to coroutine state
+// held in a dispatchcoro.StateStore (see StateStorage).
+const StoredStateTypeURL = "buf.build/dispatchrun/dispatch-go/dispatch.StoredState"
+
+// StateStorage stores the state of suspended functions in a
+// dispatchcoro.StateStore, so that only a reference to the state is
+// sent to Dispatch, rather than the state itself. It reduces the size
+// of requests and responses when functions hold large states.
+//
+// The reference only holds the key of the current state of the call.
+// The previous state is deleted once the state of the next suspension
+// is stored, and the last state is deleted when the call exits without
+// being retried (e.g. it returns, or fails with a permanent error).
+// Results that Dispatch delivers again for a state that was replaced
+// fail with ErrIncompatibleState. States of calls that never exit (e.g.
+// that expire) must be expired by the store.
+//
+// State that was encrypted (see StateEncryption) is stored encrypted.
+// State that isn't a reference, e.g. of calls that suspended before the
+// store was configured, is passed through as is.
+func StateStorage(store dispatchcoro.StateStore) Option {
+	return optionFunc(func(d *Dispatch) { d.stateStore = store })
+}
+
+type stateStorage struct {
+	store  dispatchcoro.StateStore
+	logger *slog.Logger
+}
+
+// terminal reports whether Dispatch won't retry a call that exits with
+// the status, in which case its states can be deleted.
+func terminal(status dispatchproto.Status) bool {
+	switch status {
+	case dispatchproto.OKStatus,
+		dispatchproto.InvalidArgumentStatus,
+		dispatchproto.InvalidResponseStatus,
+		dispatchproto.PermanentErrorStatus,
+		dispatchproto.IncompatibleStateStatus,
+		dispatchproto.NotFoundStatus:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s stateStorage) interceptor(next dispatchproto.Function) dispatchproto.Function {
+	return func(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+		// The key of the state that the call resumes from, if any.
+		var key string
+		if pollResult, ok := req.PollResult(); ok && pollResult.CoroutineState().TypeURL() == StoredStateTypeURL {
+			key = string(pollResult.CoroutineState().Value())
+			state, err := s.load(ctx, key)
+			if err != nil {
+				if errors.Is(err, dispatchcoro.ErrStateNotFound) {
+					return dispatchproto.NewResponseErrorf("%w: cannot load state: %v", ErrIncompatibleState, err)
+				}
+				return dispatchproto.NewResponseErrorf("%w: cannot load state: %v", ErrTemporary, err)
+			}
+			req = req.With(pollResult.With(dispatchproto.CoroutineState(state)))
+		}
+
+		res := next(ctx, req)
+
+		if poll, ok := res.Poll(); ok && anyProto(poll.CoroutineState()) != nil {
+			newKey, err := s.save(ctx, poll.CoroutineState())
+			if err != nil {
+				return dispatchproto.NewResponseErrorf("%w: cannot store state: %v", ErrTemporary, err)
+			}
+			s.delete(ctx, req, key)
+			ref := newProtoAny(&anypb.Any{TypeUrl: StoredStateTypeURL, Value: []byte(newKey)})
+			return res.With(dispatchproto.CoroutineState(ref))
+		}
+
+		if _, ok := res.Exit(); ok && terminal(res.Status()) {
+			s.delete(ctx, req, key)
+		}
+		return res
+	}
+}
+
+func (s stateStorage) delete(ctx context.Context, req dispatchproto.Request, key string) {
+	if key == "" {
+		return
+	}
+	if err := s.store.Delete(ctx, key); err != nil {
+		s.logger.WarnContext(ctx, "cannot delete coroutine state", append(requestAttrs(req), "key", key, "error", err)...)
+	}
+}
+
+func (s stateStorage) load(ctx context.Context, key string) (dispatchproto.Any, error) {
+	b, err := s.store.Get(ctx, key)
+	if err != nil {
+		return dispatchproto.Any{}, err
+	}
+	state := new(anypb.Any)
+	if err := proto.Unmarshal(b, state); err != nil {
+		return dispatchproto.Any{}, err
+	}
+	return newProtoAny(state), nil
+}
+
+func (s stateStorage) save(ctx context.Context, state dispatchproto.Any) (string, error) {
+	b, err := proto.Marshal(anyProto(state))
+	if err != nil {
+		return "", err
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(id[:])
+	return key, s.store.Put(ctx, key, b)
+}
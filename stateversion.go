@@ -0,0 +1,173 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// VersionedStateTypeURL is the type URL of the state of functions that
+// declare a state version (see Function.WithStateVersion).
+const VersionedStateTypeURL = "buf.build/dispatchrun/dispatch-go/dispatch.VersionedState"
+
+// ErrRestart can be returned by a state migration (see
+// Function.WithStateMigration) to restart the call from its input,
+// discarding the state of the suspended function.
+var ErrRestart = errors.New("restart call")
+
+// WithStateVersion sets the version of the state of the function, and
+// returns the function.
+//
+// The state of suspended calls is tagged with the version. When a call
+// resumes with state of another version, e.g. after a deployment that
+// changed the code of the function in a way that makes its state
+// incompatible, the state is upgraded with the migration of the
+// function (see WithStateMigration), rather than failing with
+// IncompatibleStateStatus.
+//
+// The input of the call is kept with its state, so that migrations can
+// restart calls from scratch.
+func (f *Function[I, O]) WithStateVersion(version string) *Function[I, O] {
+	f.stateVersion = version
+	return f
+}
+
+// WithStateMigration sets a function that migrates the state of
+// suspended calls from one version to another (see WithStateVersion),
+// and returns the function. The from version is empty if the state was
+// saved before the function declared a version.
+//
+// The migration receives the serialized state of the coroutine, and
+// returns the upgraded state. It can return ErrRestart to restart the
+// call from its input instead, or any other error to fail the call with
+// IncompatibleStateStatus.
+func (f *Function[I, O]) WithStateMigration(migrate func(from, to string, state []byte) ([]byte, error)) *Function[I, O] {
+	f.migrateState = migrate
+	return f
+}
+
+// runVersioned runs the function, unwrapping and migrating its state
+// before it resumes, and wrapping its state with its version and input
+// when it suspends.
+func (f *Function[I, O]) runVersioned(ctx context.Context, req dispatchproto.Request) dispatchproto.Response {
+	input, hasInput := req.Input()
+	if pollResult, ok := req.PollResult(); ok {
+		state, err := f.upgradeState(pollResult.CoroutineState(), &input, &hasInput)
+		switch {
+		case errors.Is(err, ErrRestart) && hasInput:
+			req = req.With(input)
+		case err != nil:
+			return dispatchproto.NewResponseErrorf("%w: %v", ErrIncompatibleState, err)
+		default:
+			req = req.With(pollResult.With(dispatchproto.CoroutineState(state)))
+		}
+	}
+
+	res := f.run(ctx, req)
+
+	if poll, ok := res.Poll(); ok && anyProto(poll.CoroutineState()) != nil && hasInput {
+		state, err := marshalVersionedState(f.stateVersion, input, poll.CoroutineState())
+		if err != nil {
+			return dispatchproto.NewResponseErrorf("%w: cannot serialize versioned state: %v", ErrPermanent, err)
+		}
+		res = res.With(dispatchproto.CoroutineState(state))
+	}
+	return res
+}
+
+// upgradeState unwraps a versioned state, and migrates it if its version
+// isn't the version of the function. The input of the call is restored
+// from versioned state.
+func (f *Function[I, O]) upgradeState(state dispatchproto.Any, input *dispatchproto.Any, hasInput *bool) (dispatchproto.Any, error) {
+	var version string
+	if state.TypeURL() == VersionedStateTypeURL {
+		var err error
+		if version, *input, state, err = unmarshalVersionedState(state); err != nil {
+			return dispatchproto.Any{}, fmt.Errorf("invalid versioned state: %w", err)
+		}
+		*hasInput = true
+	}
+	if version == f.stateVersion {
+		return state, nil
+	}
+	if f.migrateState == nil {
+		return dispatchproto.Any{}, fmt.Errorf("state version %q doesn't match version %q of function %s", version, f.stateVersion, f.name)
+	}
+	value, err := f.migrateState(version, f.stateVersion, state.Value())
+	if err != nil {
+		if errors.Is(err, ErrRestart) && !*hasInput {
+			return dispatchproto.Any{}, fmt.Errorf("cannot restart call: the input of the call wasn't saved with its state")
+		}
+		return dispatchproto.Any{}, fmt.Errorf("cannot migrate state from version %q to %q: %w", version, f.stateVersion, err)
+	}
+	return newProtoAny(&anypb.Any{TypeUrl: state.TypeURL(), Value: value}), nil
+}
+
+// Versioned state is serialized as a protobuf message with the fields:
+//
+//	string version = 1;
+//	google.protobuf.Any input = 2;
+//	google.protobuf.Any state = 3;
+const (
+	versionField protowire.Number = 1
+	inputField   protowire.Number = 2
+	stateField   protowire.Number = 3
+)
+
+func marshalVersionedState(version string, input, state dispatchproto.Any) (dispatchproto.Any, error) {
+	inputBytes, err := proto.Marshal(anyProto(input))
+	if err != nil {
+		return dispatchproto.Any{}, err
+	}
+	stateBytes, err := proto.Marshal(anyProto(state))
+	if err != nil {
+		return dispatchproto.Any{}, err
+	}
+	var b []byte
+	b = protowire.AppendTag(b, versionField, protowire.BytesType)
+	b = protowire.AppendString(b, version)
+	b = protowire.AppendTag(b, inputField, protowire.BytesType)
+	b = protowire.AppendBytes(b, inputBytes)
+	b = protowire.AppendTag(b, stateField, protowire.BytesType)
+	b = protowire.AppendBytes(b, stateBytes)
+	return newProtoAny(&anypb.Any{TypeUrl: VersionedStateTypeURL, Value: b}), nil
+}
+
+func unmarshalVersionedState(versioned dispatchproto.Any) (version string, input, state dispatchproto.Any, err error) {
+	b := versioned.Value()
+	var inputProto, stateProto anypb.Any
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", input, state, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			return "", input, state, fmt.Errorf("unexpected wire type %v of field %d", typ, num)
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", input, state, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case versionField:
+			version = string(v)
+		case inputField:
+			err = proto.Unmarshal(v, &inputProto)
+		case stateField:
+			err = proto.Unmarshal(v, &stateProto)
+		}
+		if err != nil {
+			return "", input, state, err
+		}
+	}
+	return version, newProtoAny(&inputProto), newProtoAny(&stateProto), nil
+}
@@ -0,0 +1,170 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	_ "unsafe"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Template is a workflow that is defined once and can be run against
+// different deployments.
+//
+// A template is a pipeline of steps. Each step is a reference to a
+// function, by name. The input of the workflow is passed to the first
+// step, the output of each step is passed to the next step, and the
+// output of the last step is the output of the workflow.
+//
+// References are bound to functions (possibly on other endpoints)
+// when the template is instantiated, producing a Plan that can be run
+// by a function created with TemplateFunc.
+type Template struct {
+	Steps []string
+}
+
+// Binding binds a function reference in a Template to a function.
+type Binding struct {
+	// Endpoint is the URL of the endpoint that serves the function. It
+	// defaults to the URL of the endpoint that runs the plan.
+	Endpoint string
+
+	// Function is the name of the function. It defaults to the name
+	// of the reference.
+	Function string
+}
+
+// Bindings map the function references of a Template to functions.
+type Bindings map[string]Binding
+
+// Instantiate binds the function references of the template and
+// returns a Plan that runs the workflow with the specified input.
+//
+// An error is returned if a reference isn't bound, or if the input
+// cannot be serialized.
+func (t *Template) Instantiate(input any, bindings Bindings) (*Plan, error) {
+	if len(t.Steps) == 0 {
+		return nil, fmt.Errorf("%w: template has no steps", ErrInvalidArgument)
+	}
+	boxedInput, err := dispatchproto.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize input: %v", err)
+	}
+	plan := &Plan{Input: boxedInput, Steps: make([]Binding, len(t.Steps))}
+	for i, ref := range t.Steps {
+		binding, ok := bindings[ref]
+		if !ok {
+			return nil, fmt.Errorf("%w: unbound function reference %q in step %d", ErrInvalidArgument, ref, i)
+		}
+		if binding.Function == "" {
+			binding.Function = ref
+		}
+		plan.Steps[i] = binding
+	}
+	return plan, nil
+}
+
+// Plan is an instance of a Template, with bound function references
+// and an input.
+//
+// Plans are serializable, and are the input of functions created with
+// TemplateFunc.
+type Plan struct {
+	Steps []Binding
+	Input dispatchproto.Any
+}
+
+func (p *Plan) MarshalJSON() ([]byte, error) {
+	input, err := protojson.Marshal(anyProto(p.Input))
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]jsonBinding, len(p.Steps))
+	for i, step := range p.Steps {
+		steps[i] = jsonBinding(step)
+	}
+	// Indirection is required to avoid an infinite loop.
+	return json.Marshal(jsonPlan{Steps: steps, Input: input})
+}
+
+func (p *Plan) UnmarshalJSON(b []byte) error {
+	var jp jsonPlan
+	if err := json.Unmarshal(b, &jp); err != nil {
+		return err
+	}
+	var input anypb.Any
+	if err := protojson.Unmarshal(jp.Input, &input); err != nil {
+		return err
+	}
+	p.Input = newProtoAny(&input)
+	p.Steps = make([]Binding, len(jp.Steps))
+	for i, step := range jp.Steps {
+		p.Steps[i] = Binding(step)
+	}
+	return nil
+}
+
+type jsonPlan struct {
+	Steps []jsonBinding   `json:"steps"`
+	Input json.RawMessage `json:"input"`
+}
+
+type jsonBinding struct {
+	Endpoint string `json:"endpoint,omitempty"`
+	Function string `json:"function"`
+}
+
+// TemplateFunc creates a Function that runs workflow templates.
+//
+// The function takes a Plan (see Template.Instantiate) as input. It
+// calls the function bound to each step of the plan in turn, and
+// returns the output of the last step.
+//
+// The same function can run any template, so a single registration
+// on an endpoint allows plans that orchestrate functions across
+// multiple endpoints to be dispatched there.
+func TemplateFunc(name string) *Function[*Plan, dispatchproto.Any] {
+	return Func(name, func(ctx context.Context, plan *Plan) (dispatchproto.Any, error) {
+		value := plan.Input
+		for i, step := range plan.Steps {
+			endpoint := step.Endpoint
+			if endpoint == "" {
+				endpoint = endpointURL(ctx)
+			}
+			call := dispatchproto.NewCall(endpoint, step.Function, value)
+			results, err := dispatchcoro.Await(dispatchcoro.AwaitAll, call)
+			if err != nil {
+				return dispatchproto.Any{}, fmt.Errorf("step %d (%s): %w", i, step.Function, err)
+			}
+			output, ok := results[0].Output()
+			if !ok {
+				output = dispatchproto.Nil()
+			}
+			value = output
+		}
+		return value, nil
+	})
+}
+
+type endpointURLKey struct{}
+
+// endpointURL returns the URL of the endpoint running the function that
+// the context was passed to. It's empty if the function isn't registered
+// with an endpoint, in which case calls are built without an endpoint,
+// like Function.BuildCall does.
+func endpointURL(ctx context.Context) string {
+	url, _ := ctx.Value(endpointURLKey{}).(string)
+	return url
+}
+
+//go:linkname newProtoAny github.com/dispatchrun/dispatch-go/dispatchproto.newProtoAny
+func newProtoAny(*anypb.Any) dispatchproto.Any
+
+//go:linkname anyProto github.com/dispatchrun/dispatch-go/dispatchproto.anyProto
+func anyProto(dispatchproto.Any) *anypb.Any
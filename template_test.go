@@ -0,0 +1,146 @@
+package dispatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestTemplate(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	stringify := dispatch.Func("stringify", func(ctx context.Context, n int) (string, error) {
+		return strconv.Itoa(n), nil
+	})
+	workflow := dispatch.TemplateFunc("workflow")
+
+	runner := dispatchtest.NewRunner(double, stringify, workflow)
+
+	template := &dispatch.Template{Steps: []string{"scale", "scale", "format"}}
+
+	plan, err := template.Instantiate(3, dispatch.Bindings{
+		"scale":  {Endpoint: "http://service-a", Function: "double"},
+		"format": {Endpoint: "http://service-b", Function: "stringify"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check that the plan survives serialization into the call input.
+	b, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded dispatch.Plan
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Steps, plan.Steps) {
+		t.Errorf("unexpected steps: got %v, want %v", decoded.Steps, plan.Steps)
+	}
+	if !decoded.Input.Equal(plan.Input) {
+		t.Errorf("unexpected input: got %v, want %v", decoded.Input, plan.Input)
+	}
+
+	// Check that calls are made to the bound endpoints and functions.
+	call, err := workflow.BuildCall(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := runner.RoundTrip(call.Request())
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 poll call, got %s", poll)
+	}
+	if calls[0].Endpoint() != "http://service-a" || calls[0].Function() != "double" {
+		t.Errorf("unexpected call: %s", calls[0])
+	}
+
+	output, err := dispatchtest.Call(runner, workflow, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := output.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	} else if got != "12" {
+		t.Errorf("unexpected output: got %q, want %q", got, "12")
+	}
+}
+
+func TestTemplateUnboundReference(t *testing.T) {
+	template := &dispatch.Template{Steps: []string{"a", "b"}}
+
+	_, err := template.Instantiate(1, dispatch.Bindings{"a": {}})
+	if !errors.Is(err, dispatch.ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+
+	// The function name defaults to the name of the reference.
+	plan, err := template.Instantiate(1, dispatch.Bindings{"a": {}, "b": {Endpoint: "http://x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dispatch.Binding{{Function: "a"}, {Endpoint: "http://x", Function: "b"}}
+	if !reflect.DeepEqual(plan.Steps, want) {
+		t.Errorf("unexpected steps: got %v, want %v", plan.Steps, want)
+	}
+}
+
+func TestTemplateDefaultEndpoint(t *testing.T) {
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+	workflow := dispatch.TemplateFunc("workflow")
+
+	endpoint, server, err := dispatchtest.NewEndpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	endpoint.Register(double)
+	endpoint.Register(workflow)
+
+	client, err := server.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &dispatch.Template{Steps: []string{"double"}}
+	plan, err := template.Instantiate(3, dispatch.Bindings{"double": {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, err := workflow.BuildCall(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps without an endpoint call the endpoint that runs the plan.
+	res, err := client.Run(context.Background(), call.Request())
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll, ok := res.Poll()
+	if !ok {
+		t.Fatalf("expected poll response, got %s", res)
+	}
+	calls := poll.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 poll call, got %s", poll)
+	}
+	if calls[0].Endpoint() != endpoint.URL() || calls[0].Function() != "double" {
+		t.Errorf("unexpected call: %s", calls[0])
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// Identity creates a function that returns its input.
+//
+// It's useful to smoke-test an endpoint, or as a placeholder in
+// examples and tests.
+func Identity(name string) *PrimitiveFunction {
+	return PrimitiveFunc(name, func(ctx context.Context, input dispatchproto.Any) (dispatchproto.Any, error) {
+		return input, nil
+	})
+}
+
+// SleepFn creates a function that sleeps for the duration it receives
+// as input, and then returns it.
+//
+// The function sleeps locally, holding on to the request, which makes
+// it useful to test timeouts.
+func SleepFn(name string) *Function[time.Duration, time.Duration] {
+	return Func(name, func(ctx context.Context, d time.Duration) (time.Duration, error) {
+		time.Sleep(d)
+		return d, nil
+	})
+}
+
+// FailWith creates a function that always fails with an error that
+// has the specified status (see WithStatus).
+//
+// It's useful to test how callers handle errors, e.g. that temporary
+// errors are retried.
+func FailWith(name string, status dispatchproto.Status) *PrimitiveFunction {
+	return PrimitiveFunc(name, func(ctx context.Context, input dispatchproto.Any) (dispatchproto.Any, error) {
+		return dispatchproto.Any{}, WithStatus(fmt.Errorf("function %s failed with status %s", name, status), status)
+	})
+}
@@ -0,0 +1,43 @@
+package dispatch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestUtilityFunctions(t *testing.T) {
+	identity := dispatch.Identity("identity")
+	sleep := dispatch.SleepFn("sleep")
+	fail := dispatch.FailWith("fail", dispatchproto.TemporaryErrorStatus)
+
+	runner := dispatchtest.NewRunner(identity, sleep, fail)
+
+	output, err := dispatchtest.Call(runner, identity, dispatchproto.String("foo"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !output.Equal(dispatchproto.String("foo")) {
+		t.Errorf("unexpected output: %s", output)
+	}
+
+	start := time.Now()
+	d, err := dispatchtest.Call(runner, sleep, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	} else if d != 10*time.Millisecond {
+		t.Errorf("unexpected output: %s", d)
+	} else if elapsed := time.Since(start); elapsed < d {
+		t.Errorf("expected function to sleep for %s, returned after %s", d, elapsed)
+	}
+
+	res := runner.Run(dispatchproto.NewRequest("fail", dispatchproto.Nil()))
+	if res.Status() != dispatchproto.TemporaryErrorStatus {
+		t.Errorf("unexpected status: %s", res.Status())
+	}
+	if _, err := dispatchtest.Call(runner, fail, dispatchproto.Nil()); err == nil {
+		t.Error("expected an error")
+	}
+}
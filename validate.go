@@ -0,0 +1,85 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// maxClockSkew is how far in the future the creation time of a request
+// can be when requests are strictly validated.
+const maxClockSkew = 5 * time.Minute
+
+// stateTypeURLs are the type URLs of the coroutine state produced by
+// the SDK, which aren't registered in protoregistry.GlobalTypes. They
+// include the wrappers added when state is compressed, encrypted,
+// stored externally or versioned, since requests are validated before
+// the wrappers are removed.
+var stateTypeURLs = map[string]bool{
+	dispatchcoro.StateTypeURL:           true,
+	dispatchcoro.CompressedStateTypeURL: true,
+	SnapshotTypeURL:                     true,
+	EncryptedStateTypeURL:               true,
+	StoredStateTypeURL:                  true,
+	VersionedStateTypeURL:               true,
+}
+
+// StrictValidation enables strict validation of the requests received
+// by the endpoint, on top of the validation of the protocol schema.
+//
+// Requests must name a function, carry exactly one directive (an input
+// or a poll result), have a creation time that isn't in the future and
+// an expiration time that isn't before it, and carry coroutine state
+// of a known type (a type registered in protoregistry.GlobalTypes, or
+// state produced by the SDK, possibly compressed, encrypted, stored
+// externally or versioned) if any. Requests that are invalid are
+// rejected with an ErrInvalidArgument error that lists the problems,
+// before functions are called.
+func StrictValidation() Option {
+	return optionFunc(func(d *Dispatch) { d.strictValidation = true })
+}
+
+// validateRequest checks the invariants of a request (see
+// StrictValidation).
+func validateRequest(req dispatchproto.Request, now time.Time) error {
+	var problems []string
+
+	if req.Function() == "" {
+		problems = append(problems, "function name is empty")
+	}
+
+	_, hasInput := req.Input()
+	pollResult, hasPollResult := req.PollResult()
+	switch {
+	case !hasInput && !hasPollResult:
+		problems = append(problems, "request has no input or poll result")
+	case hasInput && hasPollResult:
+		problems = append(problems, "request has both an input and a poll result")
+	}
+	if hasPollResult {
+		if typeURL := pollResult.CoroutineState().TypeURL(); typeURL != "" && !stateTypeURLs[typeURL] {
+			if _, err := protoregistry.GlobalTypes.FindMessageByURL(typeURL); err != nil {
+				problems = append(problems, fmt.Sprintf("coroutine state has unknown type %q", typeURL))
+			}
+		}
+	}
+
+	creationTime, hasCreationTime := req.CreationTime()
+	if hasCreationTime && creationTime.After(now.Add(maxClockSkew)) {
+		problems = append(problems, fmt.Sprintf("creation time %s is in the future", creationTime.Format(time.RFC3339)))
+	}
+	if expirationTime, ok := req.ExpirationTime(); ok && hasCreationTime && expirationTime.Before(creationTime) {
+		problems = append(problems, fmt.Sprintf("expiration time %s is before creation time %s", expirationTime.Format(time.RFC3339), creationTime.Format(time.RFC3339)))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: invalid request: %s", ErrInvalidArgument, strings.Join(problems, "; "))
+	}
+	return nil
+}
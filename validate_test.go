@@ -0,0 +1,33 @@
+package dispatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/dispatchcoro"
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestValidateRequestStateTypes(t *testing.T) {
+	for _, typeURL := range []string{
+		dispatchcoro.StateTypeURL,
+		dispatchcoro.CompressedStateTypeURL,
+		SnapshotTypeURL,
+		EncryptedStateTypeURL,
+		StoredStateTypeURL,
+		VersionedStateTypeURL,
+	} {
+		state := newProtoAny(&anypb.Any{TypeUrl: typeURL})
+		req := dispatchproto.NewRequest("f", dispatchproto.NewPollResult(dispatchproto.CoroutineState(state)))
+		if err := validateRequest(req, time.Now()); err != nil {
+			t.Errorf("state of type %q was rejected: %v", typeURL, err)
+		}
+	}
+
+	state := newProtoAny(&anypb.Any{TypeUrl: "example.com/Unknown"})
+	req := dispatchproto.NewRequest("f", dispatchproto.NewPollResult(dispatchproto.CoroutineState(state)))
+	if err := validateRequest(req, time.Now()); err == nil {
+		t.Error("expected state of an unknown type to be rejected")
+	}
+}
@@ -0,0 +1,60 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dispatchrun/dispatch-go/dispatchproto"
+)
+
+// WithValue returns a copy of ctx that carries a workflow-scoped value
+// associated with key. The value can be retrieved with Value.
+//
+// Unlike values added with context.WithValue, workflow-scoped values are
+// serialized when they are added, and are part of the coroutine state.
+// They are restored along with the context when a function is resumed,
+// including on another host in durable mode. The value must be of a type
+// supported by dispatchproto.Marshal; later changes to the value are not
+// reflected in the context.
+func WithValue(ctx context.Context, key string, value any) (context.Context, error) {
+	boxedValue, err := dispatchproto.Marshal(value)
+	if err != nil {
+		return ctx, fmt.Errorf("cannot add value %q to context: %w", key, err)
+	}
+	parent, _ := ctx.Value(valuesContextKey{}).(*workflowValue)
+	return context.WithValue(ctx, valuesContextKey{}, &workflowValue{
+		parent: parent,
+		key:    key,
+		value:  boxedValue,
+	}), nil
+}
+
+// Value unmarshals the workflow-scoped value associated with key in ctx
+// (see WithValue) into v. The boolean is false if ctx carries no value
+// for the key.
+func Value(ctx context.Context, key string, v any) (bool, error) {
+	values, _ := ctx.Value(valuesContextKey{}).(*workflowValue)
+	for ; values != nil; values = values.parent {
+		if values.key != key {
+			continue
+		}
+		if err := values.value.Unmarshal(v); err != nil {
+			return true, fmt.Errorf("cannot get value %q from context: %w", key, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+type valuesContextKey struct{}
+
+// workflowValue is a node in the chain of workflow-scoped values carried
+// by a context. Values only hold serialized data so that the chain can
+// be serialized with the coroutine state.
+type workflowValue struct {
+	parent *workflowValue
+	key    string
+	value  dispatchproto.Any
+}
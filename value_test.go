@@ -0,0 +1,69 @@
+package dispatch_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dispatchrun/dispatch-go"
+	"github.com/dispatchrun/dispatch-go/dispatchtest"
+)
+
+func TestWithValue(t *testing.T) {
+	logMode(t)
+
+	double := dispatch.Func("double", func(ctx context.Context, n int) (int, error) {
+		return n * 2, nil
+	})
+
+	workflow := dispatch.Func("workflow", func(ctx context.Context, n int) (string, error) {
+		ctx, err := dispatch.WithValue(ctx, "tenant", "acme")
+		if err != nil {
+			return "", err
+		}
+		ctx, err = dispatch.WithValue(ctx, "multiplier", 1)
+		if err != nil {
+			return "", err
+		}
+		ctx, err = dispatch.WithValue(ctx, "multiplier", n)
+		if err != nil {
+			return "", err
+		}
+
+		// Values must be available after the function is resumed.
+		doubled, err := double.Await(n)
+		if err != nil {
+			return "", err
+		}
+
+		var tenant string
+		var multiplier int
+		if ok, err := dispatch.Value(ctx, "tenant", &tenant); err != nil || !ok {
+			return "", fmt.Errorf("missing tenant: %v", err)
+		}
+		if ok, err := dispatch.Value(ctx, "multiplier", &multiplier); err != nil || !ok {
+			return "", fmt.Errorf("missing multiplier: %v", err)
+		}
+		if ok, _ := dispatch.Value(ctx, "missing", &tenant); ok {
+			return "", errors.New("unexpected value")
+		}
+		return fmt.Sprintf("%s:%d", tenant, doubled*multiplier), nil
+	})
+
+	runner := dispatchtest.NewRunner(double, workflow)
+
+	output, err := dispatchtest.Call(runner, workflow, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if output != "acme:18" {
+		t.Errorf("unexpected output: got %q, want %q", output, "acme:18")
+	}
+}
+
+func TestWithValueUnsupported(t *testing.T) {
+	_, err := dispatch.WithValue(context.Background(), "ch", make(chan int))
+	if err == nil {
+		t.Fatal("expected error for a value that cannot be serialized")
+	}
+}
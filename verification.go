@@ -0,0 +1,183 @@
+//go:build !durable
+
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dispatchrun/dispatch-go/internal/auth"
+)
+
+// DefaultVerificationKeysRefresh is the default interval at which the
+// verification keys published at a URL are fetched again (see
+// VerificationKeysURL).
+const DefaultVerificationKeysRefresh = 10 * time.Minute
+
+// DefaultVerificationKeysTimeout is the default time limit to fetch the
+// verification keys published at a URL (see VerificationKeysTimeout).
+const DefaultVerificationKeysTimeout = 5 * time.Second
+
+// VerificationKeys adds verification keys to use when verifying
+// Dispatch request signatures, in addition to the key set with
+// VerificationKey. Requests signed with any of the keys are accepted,
+// e.g. both the old and the new key while Dispatch rotates its key.
+//
+// The keys should be PEM or base64-encoded ed25519 public keys.
+func VerificationKeys(verificationKeys ...string) Option {
+	return optionFunc(func(d *Dispatch) { d.extraKeys = append(d.extraKeys, verificationKeys...) })
+}
+
+// VerificationKeysURL sets the URL of a JSON Web Key Set (RFC 7517)
+// that publishes the ed25519 keys to use when verifying Dispatch
+// request signatures, so that signatures keep being verified across
+// key rotations without redeploying the endpoint. The ID of each key
+// ("kid") is the key ID that signatures made with the key carry (see
+// VerificationKeyID).
+//
+// The keys are fetched when the endpoint starts serving, so that
+// creating the endpoint (e.g. when a durable coroutine is resumed)
+// doesn't block on the network. ListenAndServe fails if they cannot be
+// fetched within the timeout (see VerificationKeysTimeout); endpoints
+// served through their Handler fetch them on the first request, and
+// again on the next request if that fails. The keys are then fetched
+// again at the refresh interval (DefaultVerificationKeysRefresh if
+// zero) until the endpoint shuts down (see Shutdown). If the keys
+// cannot be fetched again, the endpoint keeps using the keys it has.
+// The keys are used in addition to the keys set with other options.
+//
+// It defaults to the value of the DISPATCH_VERIFICATION_KEYS_URL
+// environment variable.
+func VerificationKeysURL(url string, refresh time.Duration) Option {
+	return optionFunc(func(d *Dispatch) {
+		d.keysUrl = url
+		d.keysRefresh = refresh
+	})
+}
+
+// VerificationKeysTimeout sets the time limit to fetch the verification
+// keys published at the URL set with VerificationKeysURL, which bounds
+// the time it takes to start serving the endpoint. It defaults to
+// DefaultVerificationKeysTimeout.
+func VerificationKeysTimeout(timeout time.Duration) Option {
+	return optionFunc(func(d *Dispatch) { d.keysTimeout = timeout })
+}
+
+func (d *Dispatch) fetchVerificationKeys() ([]auth.VerificationKey, error) {
+	timeout := d.keysTimeout
+	if timeout <= 0 {
+		timeout = DefaultVerificationKeysTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	keys, err := auth.FetchJWKS(ctx, http.DefaultClient, d.keysUrl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch verification keys from %s provided via VerificationKeysURL(..): %w", d.keysUrl, err)
+	}
+	return keys, nil
+}
+
+type keyPollerKey struct{}
+
+// keyPoller fetches the verification keys published at a URL
+// periodically. It starts when the endpoint starts serving, so that
+// endpoints that never serve neither fetch the keys nor leak its
+// goroutine, and stops when it's closed as the endpoint shuts down.
+type keyPoller struct {
+	fetch func() error
+	poll  func(stop <-chan struct{})
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (d *Dispatch) newKeyPoller(staticKeys []auth.VerificationKey) *keyPoller {
+	refresh := d.keysRefresh
+	if refresh <= 0 {
+		refresh = DefaultVerificationKeysRefresh
+	}
+	fetch := func() error {
+		keys, err := d.fetchVerificationKeys()
+		if err != nil {
+			return err
+		}
+		d.verifier.SetKeys(append(slices.Clip(staticKeys), keys...))
+		return nil
+	}
+	return &keyPoller{
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		fetch: fetch,
+		poll: func(stop <-chan struct{}) {
+			ticker := time.NewTicker(refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+				if err := fetch(); err != nil {
+					d.log().Warn("cannot refresh Dispatch verification keys", "url", d.keysUrl, "error", err)
+				}
+			}
+		},
+	}
+}
+
+// start fetches the verification keys and starts polling them, unless
+// the poller was already started or closed. The poller isn't started
+// if the keys cannot be fetched, so that the next call tries again.
+func (p *keyPoller) start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.started || p.closed {
+		return nil
+	}
+	if err := p.fetch(); err != nil {
+		return err
+	}
+	p.started = true
+	go func() {
+		defer close(p.done)
+		p.poll(p.stop)
+	}()
+	return nil
+}
+
+// keysMiddleware starts polling the verification keys before requests
+// are verified, for endpoints served through their Handler rather than
+// ListenAndServe.
+func (d *Dispatch) keysMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := d.keyPoller.start(); err != nil {
+			d.log().Warn("cannot fetch Dispatch verification keys", "url", d.keysUrl, "error", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Close stops polling the verification keys.
+func (p *keyPoller) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.started {
+		close(p.stop)
+		<-p.done
+	}
+	return nil
+}